@@ -1,16 +1,21 @@
 package main
 
 import (
-	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"dekarrin/netkarkat/internal/console"
 	"dekarrin/netkarkat/internal/driver"
+	"dekarrin/netkarkat/internal/events"
+	"dekarrin/netkarkat/internal/misc"
+	"dekarrin/netkarkat/internal/profile"
+	"dekarrin/netkarkat/internal/responder"
+	"dekarrin/netkarkat/internal/scrollback"
 	"dekarrin/netkarkat/internal/verbosity"
 
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -37,22 +42,24 @@ const (
 
 var returnCode int = ExitSuccess
 
+// eventOut is where events are written when --output json is given. It is nil
+// otherwise.
+var eventOut *events.Writer
+
+// fileLog is the log file given with --log. It is nil if there is none.
+var fileLog *verbosity.Logger
+
 func main() {
 	defer func() {
 		if panicErr := recover(); panicErr != nil {
 			// we are panicking; don't let the check stop the panic
 			panic("unrecoverable panic occured")
 		} else {
+			eventOut.Exit(returnCode)
 			os.Exit(returnCode)
 		}
 	}()
 
-	// declare options used in program
-	var remoteHost string
-	var remotePort int
-	var localAddress string
-	var localPort int
-
 	// parse cli options
 	protocolFlag := kingpin.Flag("protocol", "Which protocol to use.").Default("tcp").Short('p').Enum("tcp", "udp")
 	remoteFlag := kingpin.Flag("remote", "The remote host to connect to; can be an IP address or hostname. Must be in HOST_ADDRESS:PORT form.").Short('r').String()
@@ -60,11 +67,16 @@ func main() {
 	timeoutFlag := kingpin.Flag("timeout", "How long to wait (in seconds) for the initial connection before timing out. Always valid for TCP, but only valid for UDP when in listen-mode.").Default("30").Short('t').Int()
 	commandFlag := kingpin.Flag("command", "Byte(s) to send (or commands to execute), after which the program exits. Comes before script file execution if both set. If any send fails, this program will immediately terminate and return non-zero without executing the rest of the commands or scripts.").Short('C').Strings()
 	scriptFileFlag := kingpin.Flag("script-file", "Script(s) to execute, after which the program exits. Script files are executed in order they appear. If any command fails, this program will immediately terminate and return non-zero without executing the rest of the commands or scripts.").Short('f').ExistingFiles()
-	logFileFlag := kingpin.Flag("log", "Create a detailed system log file at the given location.").OpenFile(os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0766)
+	logFileFlag := kingpin.Flag("log", "Create a detailed system log file at the given location. Each entry is given the time it was made and the part of netkk it came from.").String()
+	logLevelFlag := kingpin.Flag("log-level", "The lowest level of message to write to the log file, regardless of how verbose the output is.").Default("trace").Enum("trace", "debug", "info", "warn", "error")
+	logFormatFlag := kingpin.Flag("log-format", "The format of the entries in the log file. Give json to write each one as a JSON object on its own line.").Default("text").Enum("text", "json")
+	logMaxSizeFlag := kingpin.Flag("log-max-size", "The size that the log file is rotated at, such as 10MiB. The old file is kept with the time it was rotated added to its name. If not given, it is not rotated because of its size.").Default("0B").Bytes()
+	logRotateFlag := kingpin.Flag("log-rotate", "How often to rotate the log file, such as 24h. If not given, it is not rotated because of time.").Duration()
+	logKeepFlag := kingpin.Flag("log-keep", "The number of rotated log files to keep; the oldest are removed first. Give 0 to keep all of them.").Default("5").Int()
 	multilineModeFlag := kingpin.Flag("multiline", "Do not send input when enter is pressed; continuing reading input until a semicolon is encountered.").Short('M').Bool()
-	quietFlag := kingpin.Flag("quiet", "Silence all output except for server results. Overrides verbose mode.").Short('q').Bool()
+	quietFlag := kingpin.Flag("quiet", "Silence all output except for server results. Overrides -v.").Short('q').Bool()
 	useTLSFlag := kingpin.Flag("tls", "Enable SSL/TLS for the connection.").Bool()
-	macrofileFlag := kingpin.Flag("macrofile", "File to load for macros instead of the default one. Will also be where they are saved to.").Short('m').ExistingFile()
+	macrofileFlag := kingpin.Flag("macrofile", "File to load for macros instead of the default one. Will also be where they are saved to. Files ending in .json or .yaml are read and written in that format; any other file is in the original line-based format, which cannot hold macro descriptions, tags, or inherited macrosets.").Short('m').ExistingFile()
 	skipVerifyFlag := kingpin.Flag("insecure-skip-verify", "Do not verify remote host server certificates when using SSL/TLS.").Bool()
 	trustChainFileFlag := kingpin.Flag("trustchain", "File to use to verify remote host server certificates when using SSL/TLS.").ExistingFile()
 	serverCertFileFlag := kingpin.Flag("server-cert", "PEM cert file to use for encrypting SSL/TLS connections as a TCP server.").ExistingFile()
@@ -74,10 +86,23 @@ func main() {
 	noPromptFlag := kingpin.Flag("no-prompt", "Disable the prompt text giving info on the connected remote host.").Bool()
 	noKeepalivesFlag := kingpin.Flag("no-keepalives", "Disable keepalives in protocols that support them (TCP).").Bool()
 	verboseFlag := kingpin.Flag("verbose", "Make output more verbose; up to 3 can be specified for increasingly verbose output.").Short('v').Counter()
-
+	verbositySpecFlag := kingpin.Flag("verbosity", "Set how verbose output is for each part of netkk, as a comma-separated list of SUBSYSTEM=LEVEL such as driver=trace,macros=debug. SUBSYSTEM is one of console, driver, macros, or persist, and LEVEL is one of silent, error, info, debug, trace, or all; warn is the same as info, and critical the same as error. A LEVEL given without a SUBSYSTEM applies to all of them. Takes precedence over -v and -q, and can be changed later with the LOGLEVEL command.").Strings()
+	scrollbackSizeFlag := kingpin.Flag("scrollback-size", "The maximum amount of sent and received data to keep in memory for the BUFFER, GREP, and SAVE commands. Once it is exceeded, the oldest data is discarded first.").Default("4MiB").Bytes()
+	sendDelayFlag := kingpin.Flag("send-delay", "How long to wait between each statement when executing commands or scripts, and before each reply to a responder rule, such as 250ms or 2s.").Duration()
+	jitterFlag := kingpin.Flag("jitter", "The maximum random amount of time to add to the wait between each statement when executing commands or scripts, and before each reply to a responder rule. A new random amount is chosen for each statement and reply.").Duration()
+	responderFileFlag := kingpin.Flag("responder", "File of rules for automatically replying to received data, with one rule per line. Each rule is given the same way as to the ON command.").ExistingFile()
+	encryptFlag := kingpin.Flag("encrypt", "Encrypt the history, macros, and other files saved in ~/.netkk (and the file given with --macrofile) with a passphrase. The passphrase is read from the NETKK_PASSPHRASE environment variable, or asked for at start if it is not set. Files that are not yet encrypted are encrypted the next time they are saved.").Bool()
+	noPersistFlag := kingpin.Flag("no-persist", "Do not save history, macros, or anything else to ~/.netkk or the file given with --macrofile. Files that were already saved are still loaded, but any changes made to them are lost on exit.").Bool()
+	sharedDirFlag := kingpin.Flag("shared-dir", "Directory of macros and other saved files to use when they are not in ~/.netkk, such as a baseline shared by a team. It is never written to; changes are saved to ~/.netkk instead, and from then on that copy is used even if the shared one changes.").Envar("NETKK_SHARED_DIR").ExistingDir()
+	globalHistoryFlag := kingpin.Flag("global-history", "Keep a single command history for every remote host and profile instead of a separate one for each.").Bool()
+	outputFlag := kingpin.Flag("output", "The format to show what happens in when executing commands or scripts. Give json to have every connection, chunk of data sent or received, command result, warning, and error shown as a JSON object on its own line.").Default("text").Enum("text", "json")
+	dryRunFlag := kingpin.Flag("dry-run", "Show the bytes that commands or scripts would send and when they would be sent without connecting to the remote host. Waits are simulated instead of actually pausing, and jobs started with EVERY or AFTER send as the simulated time passes.").Bool()
+
+	kingpin.CommandLine.Help = "Sends and receives bytes over TCP and UDP connections. Give @NAME as an argument to use the settings of the profile NAME from ~/.netkk/config or .netkk/config; flags given along with it take precedence over the profile."
 	kingpin.Version(currentVersion)
 	kingpin.CommandLine.HelpFlag.Short('h')
-	kingpin.Parse()
+	args, profileName := extractProfileArg(kingpin.CommandLine, os.Args[1:])
+	kingpin.MustParse(kingpin.CommandLine.Parse(args))
 
 	interactiveMode := true
 	if len(*commandFlag) > 0 || len(*scriptFileFlag) > 0 {
@@ -86,129 +111,261 @@ func main() {
 	}
 
 	outVerb := verbosity.ParseFromFlags(*quietFlag, *verboseFlag)
-	out := verbosity.OutputWriter{Verbosity: outVerb, AutoNewline: true, AutoCapitalize: true}
-
-	if *logFileFlag != nil {
-		out.StartLogging(*logFileFlag)
+	subVerb := &verbosity.SubsystemVerbosity{}
+	out := verbosity.OutputWriter{Verbosity: outVerb, SubsystemVerbosity: subVerb, AutoNewline: true, AutoCapitalize: true}
+	for _, spec := range *verbositySpecFlag {
+		if err := subVerb.Set(spec); err != nil {
+			handleFatalErrorWithStatusCode(fmt.Errorf("--verbosity: %v", err), ExitStatusArgumentsError)
+			return
+		}
 	}
 
-	if *listenFlag == "" && *remoteFlag == "" {
-		handleFatalErrorWithStatusCode(fmt.Errorf("at least one of -l or -r must be specified"), ExitStatusArgumentsError)
+	if *logMaxSizeFlag < 0 || *logRotateFlag < 0 || *logKeepFlag < 0 {
+		handleFatalErrorWithStatusCode(fmt.Errorf("--log-max-size, --log-rotate, and --log-keep cannot be negative"), ExitStatusArgumentsError)
 		return
 	}
-
-	if *remoteFlag != "" {
-		var err error
-		remoteHost, remotePort, err = parseSocketAddressFlag(*remoteFlag)
+	if *logFileFlag != "" {
+		logger, err := openLog(*logFileFlag, *logLevelFlag, *logFormatFlag, verbosity.RotationOptions{
+			MaxSize: int64(*logMaxSizeFlag),
+			Every:   *logRotateFlag,
+			Keep:    *logKeepFlag,
+		})
 		if err != nil {
-			handleFatalErrorWithStatusCode(fmt.Errorf("remote address: %v", err), ExitStatusArgumentsError)
+			handleFatalErrorWithStatusCode(err, ExitStatusIOError)
 			return
 		}
+		defer logger.Close()
+		out.StartLogging(logger)
+		fileLog = logger
 	}
-	if *listenFlag != "" {
-		var err error
-		localAddress, localPort, err = parseListenAddressFlag(*listenFlag)
-		if err != nil {
-			handleFatalErrorWithStatusCode(fmt.Errorf("listen/local address: %v", err), ExitStatusArgumentsError)
+
+	if *outputFlag == "json" {
+		eventOut = events.NewWriter(os.Stdout)
+		out.Redirect = eventOut.Message
+		if interactiveMode {
+			handleFatalErrorWithStatusCode(fmt.Errorf("--output json can only be used with -C or -f"), ExitStatusArgumentsError)
 			return
 		}
 	}
 
-	connConf := driver.Options{
-		TLSEnabled:              *useTLSFlag,
-		TLSSkipVerify:           *skipVerifyFlag,
-		TLSTrustChain:           *trustChainFileFlag,
-		TLSServerCertFile:       *serverCertFileFlag,
-		TLSServerKeyFile:        *serverKeyFileFlag,
-		TLSServerCertCommonName: *serverCertCnFlag,
-		TLSServerCertIPs:        *serverCertIPsFlag,
-		ConnectionTimeout:       time.Duration(*timeoutFlag) * time.Second,
-		DisableKeepalives:       *noKeepalivesFlag,
+	if interactiveMode {
+		if *dryRunFlag {
+			handleFatalErrorWithStatusCode(fmt.Errorf("--dry-run can only be used with -C or -f"), ExitStatusArgumentsError)
+			return
+		}
+		if *sendDelayFlag != 0 || *jitterFlag != 0 {
+			out.Warn("--send-delay and --jitter only apply to -C and -f; ignoring")
+		}
+	}
+	if *sendDelayFlag < 0 || *jitterFlag < 0 {
+		handleFatalErrorWithStatusCode(fmt.Errorf("--send-delay and --jitter cannot be negative"), ExitStatusArgumentsError)
+		return
 	}
 
-	if err := validateSSLOptions(&connConf, *protocolFlag, localAddress, localPort, remoteHost, remotePort, out); err != nil {
-		handleFatalErrorWithStatusCode(err, ExitStatusArgumentsError)
+	// settings from the command line; those from a profile are applied over
+	// any that were not actually given
+	flagSettings := profile.Profile{
+		Protocol:           *protocolFlag,
+		Remote:             *remoteFlag,
+		Listen:             *listenFlag,
+		Timeout:            *timeoutFlag,
+		TLS:                *useTLSFlag,
+		InsecureSkipVerify: *skipVerifyFlag,
+		TrustChain:         *trustChainFileFlag,
+		ServerCert:         *serverCertFileFlag,
+		ServerKey:          *serverKeyFileFlag,
+		CertCommonName:     *serverCertCnFlag,
+		NoKeepalives:       *noKeepalivesFlag,
+		Macrofile:          *macrofileFlag,
+		Multiline:          *multilineModeFlag,
+		NoPrompt:           *noPromptFlag,
+	}
+	for _, ip := range *serverCertIPsFlag {
+		flagSettings.CertIPs = append(flagSettings.CertIPs, ip.String())
+	}
+	givenFlags := flagsGivenIn(kingpin.CommandLine, args)
+
+	profiles, profileErr := profile.LoadDefault(*sharedDirFlag)
+	if profileErr != nil {
+		if profileName != "" {
+			handleFatalErrorWithStatusCode(fmt.Errorf("couldn't read profiles: %v", profileErr), ExitStatusIOError)
+			return
+		}
+		out.Warn("couldn't read profiles: %v", profileErr)
+	}
+	settings := flagSettings
+	if profileName != "" {
+		settings, profileErr = useProfile(profiles, profileName, flagSettings, givenFlags)
+		if profileErr != nil {
+			handleFatalErrorWithStatusCode(profileErr, ExitStatusArgumentsError)
+			return
+		}
+	}
+
+	target, targetErr := parseConnectionTarget(settings, out)
+	if targetErr != nil {
+		handleFatalErrorWithStatusCode(targetErr, ExitStatusArgumentsError)
 		return
 	}
 
 	var lastConnectionError error
-	cbs := driver.NewLoggingCallbacks(out.Trace, out.Debug, out.Warn, func(err error, format string, a ...interface{}) {
+	driverOut := out.For(verbosity.SubsystemDriver)
+	cbs := driver.NewLoggingCallbacks(driverOut.Trace, driverOut.Debug, driverOut.Warn, func(err error, format string, a ...interface{}) {
 		lastConnectionError = err
 
-		// don't print eof
+		// don't print eof, but still log it
 		if err != io.EOF {
-			out.Error(format, a...)
+			driverOut.Error(format, a...)
+		} else {
+			driverOut.Log(verbosity.Debug, format, a...)
 		}
 	})
 
-	printRemoteMessage := func(data []byte) {
-		prettyHexStr := ""
-		for _, b := range data {
-			prettyHexStr += fmt.Sprintf("0x%s ", hex.EncodeToString([]byte{b}))
-		}
-		if *noPromptFlag {
-			out.Info("> %s\n", strings.TrimSpace(prettyHexStr))
-		} else {
-			out.Info("REMOTE>> %s\n", strings.TrimSpace(prettyHexStr))
-		}
-	}
+	var conn driver.Connection
+	var err error
 
-	if (interactiveMode || out.Verbosity.Allows(verbosity.Debug)) && remoteHost != "" {
-		out.Info("Connecting to %s:%d...\n", remoteHost, remotePort)
+	// received data is handled on other goroutines, so conn is only read
+	// there while holding connMtx. It is held while connecting so that data
+	// received before the connection is returned waits for it.
+	var connMtx sync.RWMutex
+	currentConn := func() driver.Connection {
+		connMtx.RLock()
+		defer connMtx.RUnlock()
+		return conn
 	}
 
-	var conn driver.Connection
-	var err error
+	scrollbuf := &scrollback.Buffer{MaxBytes: int(*scrollbackSizeFlag)}
+	resp := &responder.Responder{}
+	if *responderFileFlag != "" {
+		// rules must be in place before the remote end can send anything, so
+		// they are loaded before connecting
+		if err := console.LoadResponderFile(*responderFileFlag, resp, out); err != nil {
+			handleFatalErrorWithStatusCode(err, ExitStatusArgumentsError)
+			return
+		}
+	}
+	printRemoteMessage := func(data []byte) {
+		recvConn := currentConn()
+		var peer string
+		if recvConn != nil {
+			peer = recvConn.GetRemoteName()
+		}
+		scrollbuf.Add(scrollback.Received, peer, data)
 
-	switch *protocolFlag {
-	case "tcp":
-		if remoteHost != "" {
-			conn, err = driver.OpenTCPClient(printRemoteMessage, cbs, remoteHost, remotePort, localPort, connConf)
+		if eventOut != nil {
+			eventOut.Received(peer, data)
 		} else {
-			showConnected := func(host string) {
-				fmt.Printf("Client connected from %v\n", host)
+			prettyHexStr := misc.FormatHexBytes(data)
+			if settings.NoPrompt {
+				out.Info("> %s\n", prettyHexStr)
+			} else {
+				out.Info("REMOTE>> %s\n", prettyHexStr)
 			}
-			conn, err = driver.OpenTCPServer(printRemoteMessage, showConnected, cbs, localAddress, localPort, connConf)
 		}
-	case "udp":
-		conn, err = driver.OpenUDPConnection(printRemoteMessage, cbs, remoteHost, remotePort, localAddress, localPort, connConf)
-	default:
-		handleFatalErrorWithStatusCode(fmt.Errorf("unknown protocol: %v", *protocolFlag), ExitStatusArgumentsError)
-		return
+
+		if resp.Len() > 0 {
+			respondToMessage(resp, data, out)
+		}
 	}
+
+	if (interactiveMode || out.Allows(verbosity.Debug)) && target.remoteHost != "" && !*dryRunFlag {
+		out.Info("Connecting to %s:%d...\n", target.remoteHost, target.remotePort)
+	}
+
+	connMtx.Lock()
+	conn, err = openConnection(target, printRemoteMessage, cbs, *dryRunFlag)
+	connMtx.Unlock()
 	if err != nil {
 		handleFatalError(err)
-		if remoteHost != "" {
-			sslSupportRequiredText := "non-SSL"
-			if connConf.TLSEnabled {
-				sslSupportRequiredText = "SSL"
-			}
-			fmt.Fprintf(os.Stderr, "Ensure the remote server is up and supports %s %v connections\n", sslSupportRequiredText, strings.ToUpper(*protocolFlag))
-		}
+		showConnectionFailureHint(target)
 		return
 	}
+	if target.remoteHost != "" {
+		eventOut.Connected(conn.GetRemoteName(), conn.GetLocalName())
+	}
 
 	var promptErr error
 	defer func() {
 		if interactiveMode && promptErr == nil {
 			out.Info("Closing connection...\n")
 		}
+		resp.CancelReplies()
 		closeErr := conn.Close()
 		if closeErr != nil {
 			out.Warn("%v", closeErr)
 		}
+		if interactiveMode || out.Allows(verbosity.Debug) {
+			out.Info("%s\n", console.StatsSummary(conn.Stats(), time.Now()))
+		}
 	}()
 
-	if interactiveMode || out.Verbosity.Allows(verbosity.Debug) {
-		if remoteHost != "" {
-			out.Info("Connection established; local side is %v\n", conn.GetLocalName())
-		} else {
-			out.Info("Listening for %v connections on %v...\n", strings.ToUpper(*protocolFlag), conn.GetLocalName())
+	if interactiveMode || out.Allows(verbosity.Debug) {
+		showConnectionEstablished(conn, target, out)
+	}
+
+	switchProfile := func(name string) (driver.Connection, profile.Profile, error) {
+		newSettings, err := useProfile(profiles, name, flagSettings, givenFlags)
+		if err != nil {
+			return nil, profile.Profile{}, err
+		}
+		newTarget, err := parseConnectionTarget(newSettings, out)
+		if err != nil {
+			return nil, profile.Profile{}, err
+		}
+
+		connMtx.Lock()
+		defer connMtx.Unlock()
+
+		// the current connection is closed first, as the new one could be
+		// listening on the same port
+		if closeErr := conn.Close(); closeErr != nil {
+			out.Warn("%v", closeErr)
+		}
+		if newTarget.remoteHost != "" && !*dryRunFlag {
+			out.Info("Connecting to %s:%d...\n", newTarget.remoteHost, newTarget.remotePort)
+		}
+		newConn, err := openConnection(newTarget, printRemoteMessage, cbs, *dryRunFlag)
+		if err != nil {
+			showConnectionFailureHint(newTarget)
+			oldConn, reopenErr := openConnection(target, printRemoteMessage, cbs, *dryRunFlag)
+			if reopenErr != nil {
+				out.Warn("couldn't reconnect with the previous settings: %v", reopenErr)
+				return nil, profile.Profile{}, err
+			}
+			conn = oldConn
+			return conn, profile.Profile{}, err
+		}
+		conn = newConn
+		settings = newSettings
+		target = newTarget
+		if target.remoteHost != "" {
+			eventOut.Connected(conn.GetRemoteName(), conn.GetLocalName())
 		}
+		showConnectionEstablished(conn, target, out)
+		return conn, settings, nil
+	}
+
+	consoleOpts := console.Options{
+		Scrollback:         scrollbuf,
+		SendDelay:          *sendDelayFlag,
+		Jitter:             *jitterFlag,
+		DryRun:             *dryRunFlag,
+		Responder:          resp,
+		EncryptPersistence: *encryptFlag,
+		NoPersist:          *noPersistFlag,
+		SharedDir:          *sharedDirFlag,
+		Macroset:           settings.Macroset,
+		DisplayFormat:      settings.Format,
+		Profiles:           profiles,
+		Profile:            profileName,
+		Target:             target.name(),
+		GlobalHistory:      *globalHistoryFlag,
+		Events:             eventOut,
+		SwitchProfile:      switchProfile,
 	}
 
 	if interactiveMode {
-		promptErr = console.StartPrompt(conn, out, currentVersion, *multilineModeFlag, !*noPromptFlag, *macrofileFlag)
+		promptErr = console.StartPrompt(conn, out, currentVersion, settings.Multiline, !settings.NoPrompt, settings.Macrofile, consoleOpts)
 		if promptErr != nil {
 			if lastConnectionError == io.EOF {
 				// it will not have been printed yet bc of our error handler given to the connection, we need to do that now
@@ -225,22 +382,21 @@ func main() {
 	} else {
 		// we have scripts or commands to execute
 		for idx, cmdArg := range *commandFlag {
-			_, err := console.ExecuteScript(strings.NewReader(cmdArg), conn, out, currentVersion, *multilineModeFlag, *macrofileFlag)
+			_, err := console.ExecuteScript(strings.NewReader(cmdArg), conn, out, currentVersion, settings.Multiline, settings.Macrofile, consoleOpts)
 			if err != nil {
 				handleFatalErrorWithStatusCode(fmt.Errorf("command #%d: %v", idx+1, err), ExitStatusScriptCommandError)
 				return
 			}
 		}
 		for _, filename := range *scriptFileFlag {
-			f, err := os.Open(filename)
-			if err != nil {
-				handleFatalErrorWithStatusCode(fmt.Errorf("problem opening %q: %v", filename, err), ExitStatusIOError)
-			}
-			defer f.Close()
-
-			lines, err := console.ExecuteScript(f, conn, out, currentVersion, !*multilineModeFlag, *macrofileFlag)
+			lines, err := console.ExecuteScriptFile(filename, conn, out, currentVersion, !settings.Multiline, settings.Macrofile, consoleOpts)
 			if err != nil {
-				handleFatalErrorWithStatusCode(fmt.Errorf("%q:%d: %v", filename, lines+1, err), ExitStatusScriptCommandError)
+				// all other errors already give the file and line they occurred on
+				if _, ok := err.(*os.PathError); ok {
+					handleFatalErrorWithStatusCode(fmt.Errorf("problem opening %q: %v", filename, err), ExitStatusIOError)
+				} else {
+					handleFatalErrorWithStatusCode(err, ExitStatusScriptCommandError)
+				}
 				return
 			}
 			out.Debug("Executed %d lines in %q", lines, filename)
@@ -248,6 +404,23 @@ func main() {
 	}
 }
 
+// openLog opens the log file at path, rotating it as given by rotation.
+func openLog(path string, level string, format string, rotation verbosity.RotationOptions) (*verbosity.Logger, error) {
+	lv, err := verbosity.ParseLevel(level)
+	if err != nil {
+		return nil, fmt.Errorf("--log-level: %v", err)
+	}
+	logFormat, err := verbosity.ParseLogFormat(format)
+	if err != nil {
+		return nil, fmt.Errorf("--log-format: %v", err)
+	}
+	f, err := verbosity.OpenRotatingFile(path, rotation)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open log file: %v", err)
+	}
+	return verbosity.NewLogger(f, lv, logFormat), nil
+}
+
 func validateSSLOptions(conf *driver.Options, protocol string, localAddress string, localPort int, remoteAddress string, remotePort int, out verbosity.OutputWriter) error {
 	// find out if we're about to connect to another host or if we will wait
 	// for someone to connect to us
@@ -310,7 +483,21 @@ func validateSSLOptions(conf *driver.Options, protocol string, localAddress stri
 	return nil
 }
 
-// does proper handling of error and then exits
+// respondToMessage checks received data against the rules in resp and, if one
+// matches, has its reply sent. Both matches and misses are logged.
+func respondToMessage(resp *responder.Responder, data []byte, out verbosity.OutputWriter) {
+	rule, ok := resp.Respond(data)
+	if !ok {
+		out.Info("No responder rule matched %s\n", misc.CountOf("received byte", "received bytes", len(data)))
+		return
+	}
+	if rule.Limit > 0 && rule.Matched >= rule.Limit {
+		out.Info("Responder rule %d matched (last match; rule removed)\n", rule.ID)
+	} else {
+		out.Info("Responder rule %d matched\n", rule.ID)
+	}
+}
+
 func handleFatalError(err error) {
 	handleFatalErrorWithStatusCode(err, ExitStatusGenericError)
 }
@@ -319,6 +506,8 @@ func handleFatalError(err error) {
 func handleFatalErrorWithStatusCode(err error, retCode int) {
 	// don't panic, ever. Just output the generic error message
 	fmt.Fprintf(os.Stderr, "%v\n", err)
+	eventOut.Error(err, retCode)
+	fileLog.Log("", verbosity.Error, err.Error())
 	returnCode = retCode
 }
 
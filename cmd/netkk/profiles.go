@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"dekarrin/netkarkat/internal/driver"
+	"dekarrin/netkarkat/internal/profile"
+	"dekarrin/netkarkat/internal/verbosity"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// connectionTarget is the parsed connection settings of a profile.
+type connectionTarget struct {
+	protocol     string
+	remoteHost   string
+	remotePort   int
+	localAddress string
+	localPort    int
+	conf         driver.Options
+}
+
+// name identifies the target. History and state are kept separately for each
+// one.
+func (target connectionTarget) name() string {
+	if target.remoteHost != "" {
+		return fmt.Sprintf("%s-%s-%d", target.protocol, target.remoteHost, target.remotePort)
+	}
+	return fmt.Sprintf("%s-listen-%d", target.protocol, target.localPort)
+}
+
+// extractProfileArg finds the `@name` argument that selects a profile and
+// gives the rest of the arguments without it. It must be removed before the
+// arguments are parsed, as kingpin would otherwise read it as a file of
+// arguments. Values of flags are never taken as the profile argument, so that
+// something like `-C @x` is left alone.
+func extractProfileArg(app *kingpin.Application, args []string) (rest []string, profileName string) {
+	takesValue := map[string]bool{}
+	for _, f := range app.Model().Flags {
+		if f.IsBoolFlag() {
+			continue
+		}
+		takesValue["--"+f.Name] = true
+		if f.Short != 0 {
+			takesValue["-"+string(f.Short)] = true
+		}
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		if takesValue[arg] && i+1 < len(args) {
+			rest = append(rest, arg, args[i+1])
+			i++
+			continue
+		}
+		if profileName == "" && len(arg) > 1 && strings.HasPrefix(arg, "@") {
+			profileName = arg[1:]
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return rest, profileName
+}
+
+// flagsGivenIn gives the names of the flags that are set in args. Flags that
+// are only set by default or from an environment variable are not included.
+func flagsGivenIn(app *kingpin.Application, args []string) map[string]bool {
+	given := map[string]bool{}
+	ctx, err := app.ParseContext(args)
+	if err != nil {
+		return given
+	}
+	for _, elem := range ctx.Elements {
+		if f, ok := elem.Clause.(*kingpin.FlagClause); ok {
+			given[f.Model().Name] = true
+		}
+	}
+	return given
+}
+
+// useProfile gives the settings of the named profile applied over base, with
+// those of base that are named in keep taking precedence.
+func useProfile(profiles profile.Config, name string, base profile.Profile, keep map[string]bool) (profile.Profile, error) {
+	p, err := profiles.Get(name)
+	if err != nil {
+		return profile.Profile{}, err
+	}
+	settings := p.ApplyTo(base, keep)
+	if err := settings.Validate(); err != nil {
+		return profile.Profile{}, fmt.Errorf("profile %q: %v", name, err)
+	}
+	return settings, nil
+}
+
+// parseConnectionTarget parses and checks the connection settings in p.
+func parseConnectionTarget(p profile.Profile, out verbosity.OutputWriter) (connectionTarget, error) {
+	target := connectionTarget{protocol: p.Protocol}
+
+	if p.Listen == "" && p.Remote == "" {
+		return target, fmt.Errorf("at least one of -l or -r must be specified")
+	}
+	if p.Remote != "" {
+		var err error
+		target.remoteHost, target.remotePort, err = parseSocketAddressFlag(p.Remote)
+		if err != nil {
+			return target, fmt.Errorf("remote address: %v", err)
+		}
+	}
+	if p.Listen != "" {
+		var err error
+		target.localAddress, target.localPort, err = parseListenAddressFlag(p.Listen)
+		if err != nil {
+			return target, fmt.Errorf("listen/local address: %v", err)
+		}
+	}
+
+	target.conf = driver.Options{
+		TLSEnabled:              p.TLS,
+		TLSSkipVerify:           p.InsecureSkipVerify,
+		TLSTrustChain:           p.TrustChain,
+		TLSServerCertFile:       p.ServerCert,
+		TLSServerKeyFile:        p.ServerKey,
+		TLSServerCertCommonName: p.CertCommonName,
+		TLSServerCertIPs:        p.ParsedCertIPs(),
+		ConnectionTimeout:       time.Duration(p.Timeout) * time.Second,
+		DisableKeepalives:       p.NoKeepalives,
+	}
+
+	if err := validateSSLOptions(&target.conf, target.protocol, target.localAddress, target.localPort, target.remoteHost, target.remotePort, out); err != nil {
+		return target, err
+	}
+	return target, nil
+}
+
+// openConnection opens a connection to the target. If dryRun is set, a
+// connection that does not use the network is opened instead.
+func openConnection(target connectionTarget, recvHandler driver.ReceiveHandler, cbs driver.LoggingCallbacks, dryRun bool) (driver.Connection, error) {
+	if dryRun {
+		remoteName := "(no remote host)"
+		if target.remoteHost != "" {
+			remoteName = fmt.Sprintf("%s:%d", target.remoteHost, target.remotePort)
+		}
+		return driver.OpenDryRunConnection(remoteName), nil
+	}
+
+	switch target.protocol {
+	case "tcp":
+		if target.remoteHost != "" {
+			conn, err := driver.OpenTCPClient(recvHandler, cbs, target.remoteHost, target.remotePort, target.localPort, target.conf)
+			if err != nil {
+				return nil, err
+			}
+			return conn, nil
+		}
+		showConnected := func(host string) {
+			if eventOut != nil {
+				eventOut.Connected(host, fmt.Sprintf("%s:%d", target.localAddress, target.localPort))
+				return
+			}
+			fmt.Printf("Client connected from %v\n", host)
+		}
+		conn, err := driver.OpenTCPServer(recvHandler, showConnected, cbs, target.localAddress, target.localPort, target.conf)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	case "udp":
+		conn, err := driver.OpenUDPConnection(recvHandler, cbs, target.remoteHost, target.remotePort, target.localAddress, target.localPort, target.conf)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	default:
+		return nil, fmt.Errorf("unknown protocol: %v", target.protocol)
+	}
+}
+
+// showConnectionFailureHint tells the user what to check after a connection
+// to target could not be opened.
+func showConnectionFailureHint(target connectionTarget) {
+	if target.remoteHost != "" {
+		sslSupportRequiredText := "non-SSL"
+		if target.conf.TLSEnabled {
+			sslSupportRequiredText = "SSL"
+		}
+		fmt.Fprintf(os.Stderr, "Ensure the remote server is up and supports %s %v connections\n", sslSupportRequiredText, strings.ToUpper(target.protocol))
+	}
+}
+
+// showConnectionEstablished tells the user that a connection to target is
+// ready for use.
+func showConnectionEstablished(conn driver.Connection, target connectionTarget, out verbosity.OutputWriter) {
+	if target.remoteHost != "" {
+		out.Info("Connection established; local side is %v\n", conn.GetLocalName())
+	} else {
+		out.Info("Listening for %v connections on %v...\n", strings.ToUpper(target.protocol), conn.GetLocalName())
+	}
+}
@@ -0,0 +1,344 @@
+// Package bytefuncs contains the built-in functions that can be called in byte
+// input to compute bytes from other bytes, such as lengths, checksums, and
+// encodings.
+//
+// Every function takes its arguments as byte sequences and returns a byte
+// sequence. Arguments that are numbers, such as the count given to repeat, are
+// given as the ASCII characters of a decimal number.
+package bytefuncs
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"dekarrin/netkarkat/internal/misc"
+)
+
+// MaxGeneratedBytes is the most bytes that a function that generates new bytes
+// from a count, such as repeat or rand, is allowed to produce.
+const MaxGeneratedBytes = 16 * 1024 * 1024
+
+// Func is a function that can be called in byte input.
+type Func struct {
+	// Name is what the function is called by. It is case-insensitive.
+	Name string
+
+	// Usage shows the arguments that the function takes.
+	Usage string
+
+	// Description is a short explanation of what the function does.
+	Description string
+
+	// MinArgs is the fewest number of arguments the function accepts.
+	MinArgs int
+
+	// MaxArgs is the most number of arguments the function accepts.
+	MaxArgs int
+
+	call func(args [][]byte) ([]byte, error)
+}
+
+// Call executes the function with the given arguments. It returns an error if
+// the wrong number of arguments are given or if any of the arguments are not
+// valid.
+func (f Func) Call(args [][]byte) ([]byte, error) {
+	if len(args) < f.MinArgs || len(args) > f.MaxArgs {
+		if f.MinArgs == f.MaxArgs {
+			return nil, fmt.Errorf("%s() takes %s but was given %d", f.Name, misc.CountOf("argument", "arguments", f.MinArgs), len(args))
+		}
+		return nil, fmt.Errorf("%s() takes %d to %d arguments but was given %d", f.Name, f.MinArgs, f.MaxArgs, len(args))
+	}
+	result, err := f.call(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s(): %v", f.Name, err)
+	}
+	return result, nil
+}
+
+var funcs = map[string]Func{}
+
+func register(f Func) {
+	funcs[strings.ToUpper(f.Name)] = f
+}
+
+// Lookup gets the function with the given name. The name is case-insensitive.
+// If there is no function with that name, ok will be false.
+func Lookup(name string) (f Func, ok bool) {
+	f, ok = funcs[strings.ToUpper(name)]
+	return f, ok
+}
+
+// All gets every function, sorted by name.
+func All() []Func {
+	var all []Func
+	for _, f := range funcs {
+		all = append(all, f)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Name < all[j].Name
+	})
+	return all
+}
+
+func init() {
+	register(Func{
+		Name:        "len",
+		Usage:       "len(data)",
+		Description: "The number of bytes in data as a single byte. Data longer than 255 bytes is an error.",
+		MinArgs:     1,
+		MaxArgs:     1,
+		call: func(args [][]byte) ([]byte, error) {
+			if len(args[0]) > 255 {
+				return nil, fmt.Errorf("length %d does not fit in a single byte", len(args[0]))
+			}
+			return []byte{byte(len(args[0]))}, nil
+		},
+	})
+	register(Func{
+		Name:        "crc16",
+		Usage:       "crc16(data)",
+		Description: "The CRC-16/CCITT-FALSE checksum of data as 2 big-endian bytes.",
+		MinArgs:     1,
+		MaxArgs:     1,
+		call: func(args [][]byte) ([]byte, error) {
+			out := make([]byte, 2)
+			binary.BigEndian.PutUint16(out, CRC16CCITT(args[0]))
+			return out, nil
+		},
+	})
+	register(Func{
+		Name:        "crc32",
+		Usage:       "crc32(data)",
+		Description: "The CRC-32 (IEEE) checksum of data as 4 big-endian bytes.",
+		MinArgs:     1,
+		MaxArgs:     1,
+		call: func(args [][]byte) ([]byte, error) {
+			out := make([]byte, 4)
+			binary.BigEndian.PutUint32(out, crc32.ChecksumIEEE(args[0]))
+			return out, nil
+		},
+	})
+	register(Func{
+		Name:        "sum8",
+		Usage:       "sum8(data)",
+		Description: "The sum of all bytes in data, modulo 256, as a single byte.",
+		MinArgs:     1,
+		MaxArgs:     1,
+		call: func(args [][]byte) ([]byte, error) {
+			var sum byte
+			for _, b := range args[0] {
+				sum += b
+			}
+			return []byte{sum}, nil
+		},
+	})
+	register(Func{
+		Name:        "xor",
+		Usage:       "xor(data[, key])",
+		Description: "With only data, the XOR of all bytes in data as a single byte. If key is given, data with each byte XORed with the key, repeating the key as needed.",
+		MinArgs:     1,
+		MaxArgs:     2,
+		call: func(args [][]byte) ([]byte, error) {
+			if len(args) == 1 {
+				var x byte
+				for _, b := range args[0] {
+					x ^= b
+				}
+				return []byte{x}, nil
+			}
+			key := args[1]
+			if len(key) < 1 {
+				return nil, fmt.Errorf("key cannot be empty")
+			}
+			out := make([]byte, len(args[0]))
+			for idx, b := range args[0] {
+				out[idx] = b ^ key[idx%len(key)]
+			}
+			return out, nil
+		},
+	})
+	register(Func{
+		Name:        "base64",
+		Usage:       "base64(data)",
+		Description: "The standard base64 encoding of data as ASCII text.",
+		MinArgs:     1,
+		MaxArgs:     1,
+		call: func(args [][]byte) ([]byte, error) {
+			return []byte(base64.StdEncoding.EncodeToString(args[0])), nil
+		},
+	})
+	register(Func{
+		Name:        "unbase64",
+		Usage:       "unbase64(text)",
+		Description: "The bytes encoded by standard base64 text.",
+		MinArgs:     1,
+		MaxArgs:     1,
+		call: func(args [][]byte) ([]byte, error) {
+			decoded, err := base64.StdEncoding.DecodeString(string(args[0]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid base64: %v", err)
+			}
+			return decoded, nil
+		},
+	})
+	register(Func{
+		Name:        "hex",
+		Usage:       "hex(data)",
+		Description: "The lowercase hexadecimal encoding of data as ASCII text.",
+		MinArgs:     1,
+		MaxArgs:     1,
+		call: func(args [][]byte) ([]byte, error) {
+			return []byte(hex.EncodeToString(args[0])), nil
+		},
+	})
+	register(Func{
+		Name:        "unhex",
+		Usage:       "unhex(text)",
+		Description: "The bytes encoded by hexadecimal text.",
+		MinArgs:     1,
+		MaxArgs:     1,
+		call: func(args [][]byte) ([]byte, error) {
+			decoded, err := hex.DecodeString(string(args[0]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid hex: %v", err)
+			}
+			return decoded, nil
+		},
+	})
+	register(Func{
+		Name:        "utf16le",
+		Usage:       "utf16le(text)",
+		Description: "The UTF-8 text encoded as little-endian UTF-16.",
+		MinArgs:     1,
+		MaxArgs:     1,
+		call: func(args [][]byte) ([]byte, error) {
+			if !utf8.Valid(args[0]) {
+				return nil, fmt.Errorf("text is not valid UTF-8")
+			}
+			units := utf16.Encode([]rune(string(args[0])))
+			out := make([]byte, len(units)*2)
+			for idx, u := range units {
+				binary.LittleEndian.PutUint16(out[idx*2:], u)
+			}
+			return out, nil
+		},
+	})
+	register(Func{
+		Name:        "repeat",
+		Usage:       "repeat(n, data)",
+		Description: "data repeated n times.",
+		MinArgs:     2,
+		MaxArgs:     2,
+		call: func(args [][]byte) ([]byte, error) {
+			n, err := parseCount(args[0])
+			if err != nil {
+				return nil, err
+			}
+			if len(args[1]) > 0 && n > MaxGeneratedBytes/len(args[1]) {
+				return nil, fmt.Errorf("result would be more than %d bytes", MaxGeneratedBytes)
+			}
+			out := make([]byte, 0, n*len(args[1]))
+			for i := 0; i < n; i++ {
+				out = append(out, args[1]...)
+			}
+			return out, nil
+		},
+	})
+	register(Func{
+		Name:        "rand",
+		Usage:       "rand(n)",
+		Description: "n cryptographically random bytes.",
+		MinArgs:     1,
+		MaxArgs:     1,
+		call: func(args [][]byte) ([]byte, error) {
+			n, err := parseCount(args[0])
+			if err != nil {
+				return nil, err
+			}
+			if n > MaxGeneratedBytes {
+				return nil, fmt.Errorf("result would be more than %d bytes", MaxGeneratedBytes)
+			}
+			out := make([]byte, n)
+			if _, err := rand.Read(out); err != nil {
+				return nil, fmt.Errorf("could not generate random bytes: %v", err)
+			}
+			return out, nil
+		},
+	})
+	register(Func{
+		Name:        "now_unix",
+		Usage:       "now_unix()",
+		Description: "The current time as seconds since the Unix epoch in 4 big-endian bytes.",
+		MinArgs:     0,
+		MaxArgs:     0,
+		call: func(args [][]byte) ([]byte, error) {
+			out := make([]byte, 4)
+			binary.BigEndian.PutUint32(out, uint32(time.Now().Unix()))
+			return out, nil
+		},
+	})
+	register(Func{
+		Name:        "pad",
+		Usage:       "pad(n, data[, fill])",
+		Description: "data followed by enough fill bytes to make it n bytes long. fill defaults to 0x00. data that is already at least n bytes long is not changed.",
+		MinArgs:     2,
+		MaxArgs:     3,
+		call: func(args [][]byte) ([]byte, error) {
+			n, err := parseCount(args[0])
+			if err != nil {
+				return nil, err
+			}
+			if n > MaxGeneratedBytes {
+				return nil, fmt.Errorf("result would be more than %d bytes", MaxGeneratedBytes)
+			}
+			fill := byte(0x00)
+			if len(args) > 2 {
+				if len(args[2]) != 1 {
+					return nil, fmt.Errorf("fill must be exactly 1 byte")
+				}
+				fill = args[2][0]
+			}
+			out := append([]byte(nil), args[1]...)
+			for len(out) < n {
+				out = append(out, fill)
+			}
+			return out, nil
+		},
+	})
+}
+
+// CRC16CCITT computes the CRC-16/CCITT-FALSE checksum of data; that is, the
+// CRC-16 with polynomial 0x1021 and an initial value of 0xFFFF, with no
+// reflection or final XOR.
+func CRC16CCITT(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func parseCount(arg []byte) (int, error) {
+	n, err := strconv.Atoi(string(arg))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a valid count; give it as decimal digits", string(arg))
+	}
+	return n, nil
+}
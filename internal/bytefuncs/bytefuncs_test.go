@@ -0,0 +1,91 @@
+package bytefuncs
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func Test_Func_Call(t *testing.T) {
+	testCases := []struct {
+		name      string
+		fn        string
+		args      []string
+		expected  []byte
+		expectErr bool
+	}{
+		{name: "len", fn: "len", args: []string{"abc"}, expected: []byte{0x03}},
+		{name: "len of nothing", fn: "len", args: []string{""}, expected: []byte{0x00}},
+		{name: "len too long", fn: "len", args: []string{string(make([]byte, 256))}, expectErr: true},
+		{name: "len with no args", fn: "len", args: nil, expectErr: true},
+		{name: "crc16 check value", fn: "crc16", args: []string{"123456789"}, expected: []byte{0x29, 0xb1}},
+		{name: "crc32 check value", fn: "crc32", args: []string{"123456789"}, expected: []byte{0xcb, 0xf4, 0x39, 0x26}},
+		{name: "sum8 wraps", fn: "sum8", args: []string{"\xff\x02"}, expected: []byte{0x01}},
+		{name: "xor checksum", fn: "xor", args: []string{"\x0f\xf0\x01"}, expected: []byte{0xfe}},
+		{name: "xor with key", fn: "xor", args: []string{"\x00\x01\x02", "\xff\x00"}, expected: []byte{0xff, 0x01, 0xfd}},
+		{name: "xor with empty key", fn: "xor", args: []string{"\x00", ""}, expectErr: true},
+		{name: "base64", fn: "base64", args: []string{"hi!"}, expected: []byte("aGkh")},
+		{name: "unbase64", fn: "unbase64", args: []string{"aGkh"}, expected: []byte("hi!")},
+		{name: "unbase64 invalid", fn: "unbase64", args: []string{"a"}, expectErr: true},
+		{name: "hex", fn: "hex", args: []string{"\x01\xab"}, expected: []byte("01ab")},
+		{name: "unhex", fn: "unhex", args: []string{"01AB"}, expected: []byte{0x01, 0xab}},
+		{name: "unhex odd length", fn: "unhex", args: []string{"abc"}, expectErr: true},
+		{name: "utf16le", fn: "utf16le", args: []string{"Aé"}, expected: []byte{0x41, 0x00, 0xe9, 0x00}},
+		{name: "repeat", fn: "repeat", args: []string{"3", "ab"}, expected: []byte("ababab")},
+		{name: "repeat zero times", fn: "repeat", args: []string{"0", "ab"}, expected: []byte{}},
+		{name: "repeat non-number", fn: "repeat", args: []string{"x", "ab"}, expectErr: true},
+		{name: "repeat too many times", fn: "repeat", args: []string{"9223372036854775807", "ab"}, expectErr: true},
+		{name: "pad", fn: "pad", args: []string{"4", "ab"}, expected: []byte{0x61, 0x62, 0x00, 0x00}},
+		{name: "pad with fill", fn: "pad", args: []string{"3", "a", " "}, expected: []byte("a  ")},
+		{name: "pad already long enough", fn: "pad", args: []string{"1", "abc"}, expected: []byte("abc")},
+		{name: "pad with long fill", fn: "pad", args: []string{"3", "a", "xy"}, expectErr: true},
+		{name: "name is case-insensitive", fn: "CRC16", args: []string{"123456789"}, expected: []byte{0x29, 0xb1}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sut, ok := Lookup(tc.fn)
+			if !ok {
+				t.Fatalf("no function named %q", tc.fn)
+			}
+			var args [][]byte
+			for _, a := range tc.args {
+				args = append(args, []byte(a))
+			}
+
+			actual, err := sut.Call(args)
+
+			// check for error
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				t.Fatalf("expected an error but nil error was returned")
+			}
+
+			// check the value
+			if bytes.Compare(tc.expected, actual) != 0 {
+				t.Errorf("expected %s but got: %s", hex.EncodeToString(tc.expected), hex.EncodeToString(actual))
+			}
+		})
+	}
+}
+
+func Test_Func_Call_generated(t *testing.T) {
+	randFunc, _ := Lookup("rand")
+	data, err := randFunc.Call([][]byte{[]byte("16")})
+	if err != nil {
+		t.Fatalf("rand returned an error: %v", err)
+	}
+	if len(data) != 16 {
+		t.Errorf("rand(16) gave %d bytes", len(data))
+	}
+
+	nowFunc, _ := Lookup("now_unix")
+	data, err = nowFunc.Call(nil)
+	if err != nil {
+		t.Fatalf("now_unix returned an error: %v", err)
+	}
+	if len(data) != 4 {
+		t.Errorf("now_unix() gave %d bytes", len(data))
+	}
+}
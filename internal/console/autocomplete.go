@@ -8,6 +8,16 @@ import (
 	"github.com/google/shlex"
 )
 
+// fileArgCommands is the commands whose first argument is completed as a
+// filename.
+var fileArgCommands = map[string]bool{
+	"IMPORT":     true,
+	"EXPORT":     true,
+	"SENDFILE":   true,
+	"RECVFILE":   true,
+	"CAPTURE-TO": true,
+}
+
 func autoComplete(state *consoleState, line string) (candidates []string) {
 	candidates = autoCompleteFilename(line)
 	if candidates != nil {
@@ -65,11 +75,11 @@ func autoCompleteMacros(state *consoleState, line string) []string {
 
 func autoCompleteFilename(line string) []string {
 	var candidates []string
-	// check for import/export, do filesystem completions in that case
+	// check for commands that take a file, do filesystem completions in that case
 	parts, err := shlex.Split(line)
 	if err == nil {
 		if len(parts) == 2 {
-			if strings.ToUpper(parts[0]) == "IMPORT" || strings.ToUpper(parts[0]) == "EXPORT" {
+			if fileArgCommands[strings.ToUpper(parts[0])] {
 				// okay we're on the second item, try to do a filesystem completion.
 				path := parts[1]
 				fsDir := filepath.Dir(path)
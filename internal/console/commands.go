@@ -1,6 +1,8 @@
 package console
 
 import (
+	"bytes"
+	"dekarrin/netkarkat/internal/macros"
 	"dekarrin/netkarkat/internal/misc"
 	"fmt"
 	"os"
@@ -121,7 +123,7 @@ func (cl commandList) names() []string {
 var commands = commandList{
 	"CLEARHIST": command{
 		interactiveOnly: true,
-		helpDesc:        "Clear the command history.",
+		helpDesc:        "Clear the command history of the current profile or target, or all of it if netkk was started with --global-history.",
 		argsExec:        executeCommandClearhist,
 	},
 	"EXIT": command{
@@ -143,9 +145,97 @@ var commands = commandList{
 		helpDesc:   "Sends bytes. This command is assumed when no other command is given. It can be used to send literal bytes that would be otherwise interpreted as a command, such as `SEND LIST` to send the literal bytes that make up L, I, S, and T. It can also be used to explicitly instruct the console to perform a send of 0 bytes on the connection; whether this results in actual network traffic depends on the underlying driver.",
 		lineExec:   executeCommandSend,
 	},
+	"SENDFILE": command{
+		helpInvoke: "[-o offset] [-l length] [-c chunk-size] [-d delay] [-q] file",
+		helpDesc:   "Sends the contents of a file. By default the entire file is sent in a single send, or in sends of 16 MiB each if it is larger than that; to send only part of it, give the byte offset to start at with -o and the number of bytes to send with -l. To split the send into multiple sends, give the maximum number of bytes in each one with -c (up to 16 MiB), and optionally give -d with a duration such as 250ms to wait that long between each send. Sizes can be given as a plain number of bytes or with a unit such as KiB or MB. When a file is sent in more than one chunk, progress is shown as it is sent; give -q to suppress this. To include the contents of a file in other byte input, such as in a macro definition or along with other bytes, use @file:path, optionally followed by [offset:length] to only include part of the file, which can be at most 16 MiB; surround the path with double quotes if it contains spaces.",
+		argsExec:   executeCommandSendfile,
+	},
+	"RECVFILE": command{
+		helpInvoke: "[-a] [-q] [-n count] [-t idle-timeout] file [bytes...]",
+		helpDesc:   "Saves data received from the remote host to a file, exactly as it was received. Data that was received after the last data was sent but before RECVFILE was run is saved first, unless CAPTURE or another RECVFILE already used it. Saving stops when the connection is closed, when -n is given and that many bytes have been saved, when -t is given with a duration such as 5s and no data is received for that long, or when bytes are given after the filename and they are received; the terminating bytes are included in the file. By default the file is overwritten; give -a to append to it instead. Progress is shown while data is being received; give -q to suppress this.",
+		lineExec:   executeCommandRecvfile,
+	},
+	"CAPTURE-TO": command{
+		aliasFor: "RECVFILE",
+	},
+	"SLEEP": command{
+		helpInvoke: "duration",
+		helpDesc:   "Waits for the given duration before continuing, such as 250ms or 2s. This is typically used in scripts to give the remote host time to respond before the next statement is executed.",
+		argsExec:   executeCommandSleep,
+	},
+	"EVERY": command{
+		helpInvoke: "[-n count] interval bytes...",
+		helpDesc:   "Starts a background job that sends bytes repeatedly, waiting for the interval between each send. The interval is given as a duration such as 500ms, 10s, or 1m. If -n is given, the job stops after sending that many times; otherwise it continues until it is canceled with CANCEL. Macros in the bytes are replaced when the job is started. Running jobs are shown with JOBS, and all jobs stop when the connection closes or netkk exits.",
+		lineExec:   executeCommandEvery,
+	},
+	"AFTER": command{
+		helpInvoke: "delay bytes...",
+		helpDesc:   "Starts a background job that sends bytes once after the delay has passed. The delay is given as a duration such as 500ms, 10s, or 1m. Macros in the bytes are replaced when the job is started. The job can be canceled before it sends with CANCEL, and it is stopped if the connection closes or netkk exits before the delay has passed.",
+		lineExec:   executeCommandAfter,
+	},
+	"JOBS": command{
+		helpInvoke: "",
+		helpDesc:   "Shows all background jobs started by EVERY and AFTER that are still running, along with the number that can be given to CANCEL to stop them.",
+		argsExec:   executeCommandJobs,
+	},
+	"SET": command{
+		helpInvoke: "name bytes",
+		helpDesc:   "Sets a variable to the given bytes. The bytes are parsed when SET is run, so they can contain macros, function calls, and other variables, and later changes to those do not affect the variable. Once set, the variable can be used in any context that takes bytes by giving its name in the form ${name}. Variable names are case-insensitive and can only contain letters, numbers, and underscores. To give a literal ${ in bytes, use \\x24{.",
+		lineExec:   executeCommandSet,
+	},
+	"UNSET": command{
+		helpInvoke: "[-a] [name]",
+		helpDesc:   "Removes the variable with the given name. Give -a instead of a name to remove all variables.",
+		argsExec:   executeCommandUnset,
+	},
+	"VARS": command{
+		helpInvoke: "",
+		helpDesc:   "Shows all variables set with SET or CAPTURE along with their values.",
+		argsExec:   executeCommandVars,
+	},
+	"CAPTURE": command{
+		helpInvoke: "[-t timeout] [-o offset] [-l length] [-r [-g group] | -d [-f field]] name [pattern]",
+		helpDesc:   "Waits for the next received message and sets the variable with the given name to bytes taken from it. A message that was received after the last data was sent but before CAPTURE was run counts as the next message. By default the whole message is captured; give -o and -l to only capture the given number of bytes starting at the given offset. Give -r to capture what matches the regular expression given as pattern; if it has any groups the first group is captured, or give -g with the number of the group to capture, where 0 is the entire match. Messages that do not match the regular expression are skipped. Give -d to split the message on the delimiter bytes given as pattern and capture a field, by default the first; give -f to capture a different one, starting from 1. If -o or -l are given with -r or -d, only that part of the message is searched. CAPTURE waits for up to 5 seconds by default; give -t with a duration such as 10s to change this, or 0 to wait until the connection closes.",
+		lineExec:   executeCommandCapture,
+	},
+	"ON": command{
+		helpInvoke: "[-r] [-1 | -n count] [-d delay] pattern REPLY bytes",
+		helpDesc:   "Adds a rule that automatically replies to received data. Whenever received data contains the pattern bytes, the bytes after REPLY are sent back. Give -r to make the pattern a regular expression instead. Give -d with a duration such as 250ms to wait that long before replying. Give -1 to remove the rule after it matches once, or -n to remove it after it matches the given number of times. When data is received, rules are checked in the order they were added and only the first one that matches replies. The pattern and reply are parsed when the rule is added; if the pattern starts with a '-', give -- before it. Rules can also be loaded at startup from a file given with --responder, with one rule per line in the same form.",
+		lineExec:   executeCommandOn,
+	},
+	"RULES": command{
+		helpInvoke: "",
+		helpDesc:   "Shows all rules added with ON or loaded with --responder, along with the number that can be given to OFF to remove them and how many times they have matched.",
+		argsExec:   executeCommandRules,
+	},
+	"OFF": command{
+		helpInvoke: "[-a] [rule]",
+		helpDesc:   "Removes the auto-reply rule with the given number. Give -a instead of a rule number to remove all rules.",
+		argsExec:   executeCommandOff,
+	},
+	"EXPAND": command{
+		helpInvoke: "bytes",
+		helpDesc:   "Shows what the given bytes would be if they were sent, without sending them. Each macro that is used is shown with the bytes it produced, with the macros that it uses beneath it, followed by all of the bytes and the total length. Bytes are shown in the format set with FORMAT.",
+		lineExec:   executeCommandExpand,
+	},
+	"FORMAT": command{
+		helpInvoke: "[hex|text|dump]",
+		helpDesc:   "Sets the format that bytes are shown in by EXPAND, VARS, and dry-run sends. hex (the default) shows each byte in hex, text shows printable characters as themselves and all other bytes as \\x escapes, and dump shows a hex dump with 16 bytes per line. If no format is given, the current one is shown.",
+		argsExec:   executeCommandFormat,
+	},
+	"FUNCS": command{
+		helpInvoke: "[name]",
+		helpDesc:   "Shows the built-in functions that can be called in any context that takes bytes, or only the one with the given name. A function is called by giving its name followed by its arguments in parentheses, such as `\\x7e len(payload) payload crc16(payload)`. Each argument is itself a sequence of bytes that can contain macros and other function calls; arguments are separated by commas, so use \\x2c and \\x29 to give a literal comma or closing parenthesis. Arguments that are numbers are given as decimal digits. Functions are called after all macros have been replaced.",
+		argsExec:   executeCommandFuncs,
+	},
+	"CANCEL": command{
+		helpInvoke: "[-a] [job]",
+		helpDesc:   "Stops the background job with the given number. Give -a instead of a job number to stop all running jobs.",
+		argsExec:   executeCommandCancel,
+	},
 	"DEFINE": command{
-		helpInvoke: "macro bytes...",
-		helpDesc:   "Create a macro that can be typed instead of a sequence of bytes; after DEFINE is used, the supplied name will be interpreted to be the supplied bytes in any context that takes bytes. Macros can also be used in other macro definitions, and will update the macro they are in when their own contents change. Macro names are case-insensitive.",
+		helpInvoke: "[-d description] [-t tags] macro[(params...)] bytes...",
+		helpDesc:   "Create a macro that can be typed instead of a sequence of bytes; after DEFINE is used, the supplied name will be interpreted to be the supplied bytes in any context that takes bytes. Macros can also be used in other macro definitions, and will update the macro they are in when their own contents change. Macro names are case-insensitive. To make a macro that takes arguments, give a comma-separated list of parameter names in parentheses after the name, such as `DEFINE login(user, pass) \\x01 user \\x00 pass`. It is then called with one argument for each parameter, such as `login(alice, \"s3cret\")`, and each parameter in its contents is replaced with the matching argument. Arguments can be surrounded with double quotes to include commas or parentheses. Give -d with a description in double quotes to document what the macro is for, and -t with a comma-separated list of tags to make it easier to find with FIND; these are saved along with the macro and are kept if the macro is later redefined without them.",
 		lineExec:   executeCommandDefine,
 	},
 	"UNDEFINE": command{
@@ -155,14 +245,19 @@ var commands = commandList{
 	},
 	"LIST": command{
 		helpInvoke: "[-a] [-s macroset]",
-		helpDesc:   "List all currently-defined macros in the current macroset. If -s is given, that macroset is shown in the output. -s can be given multiple times. -a includes all macrosets.",
+		helpDesc:   "List all currently-defined macros in the current macroset along with their descriptions and tags. Macros inherited from another macroset are included and show which macroset they are from. If -s is given, that macroset is shown in the output. -s can be given multiple times. -a includes all macrosets.",
 		argsExec:   executeCommandList,
 	},
 	"SHOW": command{
 		helpInvoke: "macro",
-		helpDesc:   "Show the contents of a macro in the current macroset. Macro names are case-insensitive.",
+		helpDesc:   "Show the contents of a macro in the current macroset, along with its description and tags if it has them. Macro names are case-insensitive.",
 		argsExec:   executeCommandShow,
 	},
+	"FIND": command{
+		helpInvoke: "[-b] text",
+		helpDesc:   "Searches the macros in all macrosets for ones whose name, description, tags, or contents contain the given text, ignoring case. Each macro found is shown along with its macroset and what matched. If -b is given, the text is instead parsed as bytes and macros are found whose fully-expanded bytes contain them; macros with parameters are not searched this way.",
+		lineExec:   executeCommandFind,
+	},
 	"MACROSET": {
 		helpInvoke: "[-d] [name]",
 		helpDesc:   "Without arguments, gives the name of the current macroset. If a name is given, switches the current macroset to the given one, which makes all DEFINE calls made while that macroset was active also go inactive. All further DEFINES will then apply to the switched-to macroset. If the macroset did not already exist, it is created. If -d is given instead of a macroset name, the current macroset switches to the default one. Macroset names are case-insensitive.",
@@ -173,25 +268,78 @@ var commands = commandList{
 		helpDesc:   "Renames the item referred to by old name to new name. The old name must be either a macro created with DEFINE or a macroset created with MACROSET, or -d to specify the default macroset. If old name is the name of both a macro and a macroset, either -m must be given to specify the DEFINE-created macro or -s must be given to specify the MACROSET-created macroset. If a macro is being renamed and -r is given, its usage will be replaced with its new name in all other macros that refer to it.",
 		argsExec:   executeCommandRename,
 	},
+	"UNDO": command{
+		helpInvoke: "",
+		helpDesc:   "Reverts the most recent change to macros or macrosets made by DEFINE, UNDEFINE, RENAME, INHERIT, or IMPORT, including every other macro that the change rewrote, such as with UNDEFINE -r. Changes are undone one at a time starting from the most recent; each one undone can be made again with REDO until a new change is made. Changes are saved along with the macros, so they can be undone in later sessions as well.",
+		argsExec:   executeCommandUndo,
+	},
+	"REDO": command{
+		helpInvoke: "",
+		helpDesc:   "Makes the most recent change undone with UNDO again.",
+		argsExec:   executeCommandRedo,
+	},
+	"HISTORY-MACROS": command{
+		helpInvoke: "[-a] [-n count]",
+		helpDesc:   "Shows the changes that have been made to macros and macrosets, most recent first, along with the old and new contents of every macro that each one changed. Changes that have been undone with UNDO are marked as such, and can be made again with REDO. The last 10 changes are shown; give -n to change how many, or -a to show all of them.",
+		argsExec:   executeCommandHistoryMacros,
+	},
+	"INHERIT": command{
+		helpInvoke: "[-c] [macroset...]",
+		helpDesc:   "Makes the current macroset inherit the macros of the given macrosets. Inherited macros can be used as though they were defined in the current macroset, unless a macro with the same name is defined in it; the macrosets are searched in the order given, along with the macrosets that each of them inherits from. Macros used within an inherited macro are also looked up starting from the current macroset, so it can override them. Giving new macrosets replaces the old ones; give -c to stop inheriting from any macroset, or give no arguments to show the macrosets currently inherited from. Separately from inheritance, a macro in any other macroset can be used by giving the name of the macroset, a dot, and the name of the macro, such as `common.HEADER`; macros used within it are looked up starting from that macroset.",
+		argsExec:   executeCommandInherit,
+	},
+	"PROFILE": command{
+		helpInvoke: "[name]",
+		helpDesc:   "Without arguments, gives the name of the current profile. If a name is given, switches to that profile: the current connection is closed and a new one is opened with the settings in the profile, and its macros file, macroset, and display settings are put into effect. Settings given as flags when netkk was started still take precedence over those in the profile. Profiles are defined in ~/.netkk/config and in .netkk/config of the directory netkk was started in.",
+		argsExec:   executeCommandProfile,
+	},
+	"LISTPROFILES": command{
+		helpDesc: "Gives a list of all defined profiles, along with what each one connects to and its description. The current profile is marked with an asterisk.",
+		argsExec: executeCommandListprofiles,
+	},
+	"LOGLEVEL": command{
+		helpInvoke: "[subsystem=level...]",
+		helpDesc:   "Sets how verbose output is for each part of netkk, and then shows the level of each one. Each subsystem is one of console, driver, macros, or persist, and each level is one of silent, error, info, debug, trace, or all; a subsystem and level can also be given as two arguments, such as `LOGLEVEL driver trace`. A level given without a subsystem applies to all of them, and a level of default makes a subsystem go back to the verbosity netkk was started with. Without arguments, shows the level of each subsystem.",
+		argsExec:   executeCommandLoglevel,
+	},
+	"STATS": command{
+		helpDesc: "Shows the activity on the connection so far: how long ago it was established and how long that took, how long ago data was last sent or received, the number of bytes and messages sent and received, and the number of sends that failed. The time from each send to the next data received is shown as a rough measure of latency. When listening for connections, the number of clients accepted and rejected is also shown, and the rest includes every client that has connected. A summary is also shown when netkk exits.",
+		argsExec: executeCommandStats,
+	},
 	"LISTSETS": {
 		helpDesc: "Gives a list of all currently-loaded macrosets. Macrosets that do not currently contain any macro definitions will not be shown.",
 		argsExec: executeCommandListsets,
 	},
 	"EXPORT": command{
 		helpInvoke: "[-c] [-s macroset] file",
-		helpDesc:   "Exports the current macro definitions to the given filename, to be loaded via a later call to IMPORT or by giving the definitions file to use when launching netkk with --macrofile. By default the macros in all macrosets are included; this can be changed by giving any combination of -c and one or more -s options. Giving -c specifies the current macroset, and -m followed by the name of a macroset specifies that macroset.",
+		helpDesc:   "Exports the current macro definitions to the given filename, to be loaded via a later call to IMPORT or by giving the definitions file to use when launching netkk with --macrofile. By default the macros in all macrosets are included; this can be changed by giving any combination of -c and one or more -s options. Giving -c specifies the current macroset, and -m followed by the name of a macroset specifies that macroset. The format is chosen by the extension of the filename: .json writes JSON and .yaml or .yml writes YAML, which can hold macros whose contents have line breaks and record the version of their structure so that files from older versions of netkk can still be read. Any other extension writes the original line-based format.",
 		argsExec:   executeCommandExport,
 	},
+	"BUFFER": command{
+		helpInvoke: "[-a] [-c] [-n count] [first [last]]",
+		helpDesc:   "Show data that has been sent and received on the connection. Every chunk of data is numbered in the order it was sent or received; if first is given, only the chunks numbered from first to last (or only first if last is not given) are shown. Otherwise, the last 10 are shown, which can be changed with -n. Give -a to show all of them, or -c to clear the buffer instead of showing it. The amount of data kept is limited by the --scrollback-size option at launch.",
+		argsExec:   executeCommandBuffer,
+	},
+	"GREP": command{
+		helpInvoke: "[-r] [-d sent|recv] pattern",
+		helpDesc:   "Search the data that has been sent and received for the given bytes, and show each chunk that contains them. The pattern is given the same as it would be to SEND, so macros and escaped bytes can be used. If -r is given, the pattern is instead a regular expression that is matched against the raw bytes of each chunk. Giving -d limits the search to only sent or only received chunks.",
+		lineExec:   executeCommandGrep,
+	},
+	"SAVE": command{
+		helpInvoke: "[-f raw|hex|transcript] [-d sent|recv] file [first [last]]",
+		helpDesc:   "Save data that has been sent and received to a file. By default all chunks in the buffer are saved; if first is given, only the chunks numbered from first to last (or only first if last is not given) are saved. The format is set with -f; raw (the default) writes only the bytes themselves, hex writes one line of hex per chunk, and transcript writes one line per chunk with the number, time, direction, remote host, and hex of the chunk separated by tabs. Giving -d limits the save to only sent or only received chunks.",
+		argsExec:   executeCommandSave,
+	},
 	"IMPORT": command{
-		helpInvoke: "[-r] file",
-		helpDesc:   "Imports macro definitions in the given file. By default they extend the ones already defined; if -r is given, all macrosets are cleared and removed before using the ones in the file.",
+		helpInvoke: "[-r] [-n] [-m overwrite|keep|rename|ask] [-x suffix] file",
+		helpDesc:   "Imports macro definitions in the given file. By default they extend the ones already defined; if -r is given, all macrosets are cleared and removed before using the ones in the file. When a macro in the file is already defined with different contents, -m sets what is done: overwrite (the default) replaces it with the one in the file, keep keeps the existing one, rename imports the one in the file with a suffix added to its name, and ask shows both and asks which to do for each one. The suffix for rename is _imported unless another is given with -x; other macros in the file that use the renamed macro are not changed. The inherited macrosets of a macroset are handled the same way, except that they cannot be renamed and so are kept. Give -n to show what would be added, changed, and in conflict in each macroset without importing anything. Nothing is imported if the file would cause a loop in any macro. The format of the file is chosen by its extension the same as with EXPORT.",
 		argsExec:   executeCommandImport,
 	},
 }
 
 // called by init() function
 func initCommands() {
-	// have to add this afterwards else we get into an initialization loop
+	// have to add these afterwards else we get into an initialization loop
 	commands["HELP"] = command{
 		interactiveOnly: true,
 		helpInvoke:      " [command]",
@@ -203,6 +351,12 @@ func initCommands() {
 			return showHelp(""), nil
 		},
 	}
+	commands["HISTORY"] = command{
+		interactiveOnly: true,
+		helpInvoke:      "[-a] [-n count] [-s text] [-r number]",
+		helpDesc:        "Shows the commands entered for the current profile, or for the current remote host if no profile is used, oldest first and numbered. The last 20 are shown; give -n to change how many, or -a to show all of them. If -s is given, only commands that contain the given text, ignoring case, are shown. If -r is given, the command with the given number is executed again instead. History is kept for all profiles and hosts together if netkk was started with --global-history.",
+		argsExec:        executeCommandHistory,
+	}
 }
 
 func executeCommandClearhist(state *consoleState, args []string) (output string, err error) {
@@ -210,7 +364,7 @@ func executeCommandClearhist(state *consoleState, args []string) (output string,
 		return "", fmt.Errorf("%s command only available in interactive mode", args[0])
 	}
 	state.prompt.ClearHistory()
-	state.writeHistFile()
+	state.clearHistFile()
 	output = state.out.InfoSprintf("Command history has been cleared")
 	return output, nil
 }
@@ -229,22 +383,78 @@ func executeCommandSend(state *consoleState, line string, cmdName string) (outpu
 			}
 		}
 	}
-	return "", state.connection.Send(data)
+	return "", state.send(data)
 }
 
 func executeCommandDefine(state *consoleState, line string, cmdName string) (string, error) {
-	parts := strings.Split(strings.TrimSpace(misc.CollapseWhitespace(line)), " ")
-	if len(parts) < 2 {
+	var description string
+	var tags []string
+	var setDescription, setTags bool
+
+	argv, rest := splitLineOptions(strings.TrimSpace(misc.CollapseWhitespace(line)), "dt")
+	_, err := parseCommandFlags(
+		argv,
+		flagActions{
+			'd': func(i *int, argv []string) error {
+				if *i+1 >= len(argv) {
+					return fmt.Errorf("-d requires an argument")
+				}
+				*i++
+				description = argv[*i]
+				setDescription = true
+				return nil
+			},
+			't': func(i *int, argv []string) error {
+				if *i+1 >= len(argv) {
+					return fmt.Errorf("-t requires an argument")
+				}
+				*i++
+				tags = macros.ParseTags(argv[*i])
+				setTags = true
+				return nil
+			},
+		},
+		nil,
+	)
+	if err != nil {
+		return "", err
+	}
+	if rest == "" {
 		return "", fmt.Errorf("need to give name of macro to define")
 	}
-	if len(parts) < 3 {
+	signature, content := macros.SplitDefinition(rest)
+	if content == "" {
 		return "", fmt.Errorf("empty macros are not allowed; give contents of macro after name")
 	}
-	macroName := parts[1]
+	macroName := strings.SplitN(signature, "(", 2)[0]
+	if description != "" || len(tags) > 0 {
+		if err := state.checkMacrosFileHolds("macro descriptions and tags"); err != nil {
+			return "", err
+		}
+	}
 
 	// done checking args
 	alreadyExists := state.macros.IsDefined(macroName)
-	if err := state.macros.Define(macroName, strings.Join(parts[2:], " ")); err != nil {
+
+	// the definition and its info are undone together
+	action := "define " + signature
+	if curSet := state.macros.GetCurrentMacroset(); curSet != "" {
+		action = "define " + curSet + "." + signature
+	}
+	endChange := state.macros.BeginChange(action)
+	err = state.macros.Define(signature, content)
+	if err == nil && (setDescription || setTags) {
+		info := state.macros.GetInfo(macroName)
+		if setDescription {
+			info.Description = description
+		}
+		if setTags {
+			info.Tags = tags
+		}
+		err = state.macros.SetInfo(macroName, info)
+	}
+	endChange()
+	if err != nil {
 		return "", err
 	}
 	if state.usingUserPersistenceFiles {
@@ -328,31 +538,37 @@ func executeCommandList(state *consoleState, argv []string) (output string, err
 	if len(includeSet) > 0 {
 		for _, setName := range includeSet {
 			if setName == "" {
-				sb.WriteString("(default macroset):\n")
+				sb.WriteString("(default macroset)")
 			} else {
 				sb.WriteString("MACROSET ")
 				sb.WriteString(setName)
-				sb.WriteString(":\n")
 			}
-			names := state.macros.GetNamesIn(setName)
+			if parents := state.macros.GetParents(setName); len(parents) > 0 {
+				sb.WriteString(" (inherits ")
+				sb.WriteString(strings.Join(parents, ", "))
+				sb.WriteRune(')')
+			}
+			sb.WriteString(":\n")
+			names, from := state.macros.GetVisibleNamesIn(setName)
 			if len(names) < 1 {
 				sb.WriteString("  (none defined)\n")
 			} else {
-				for _, macro := range names {
+				for idx, macro := range names {
 					sb.WriteString("  ")
-					sb.WriteString(macro)
+					sb.WriteString(formatVisibleMacroListing(state, setName, macro, from[idx]))
 					sb.WriteRune('\n')
 				}
 			}
 			sb.WriteRune('\n')
 		}
 	} else {
-		names := state.macros.GetNames()
+		curSet := state.macros.GetCurrentMacroset()
+		names, from := state.macros.GetVisibleNamesIn(curSet)
 		if len(names) < 1 {
 			sb.WriteString("(none defined)")
 		} else {
-			for _, mName := range names {
-				sb.WriteString(mName)
+			for idx, mName := range names {
+				sb.WriteString(formatVisibleMacroListing(state, curSet, mName, from[idx]))
 				sb.WriteRune('\n')
 			}
 		}
@@ -361,14 +577,127 @@ func executeCommandList(state *consoleState, argv []string) (output string, err
 	return sb.String(), nil
 }
 
+// formatVisibleMacroListing gives the line that shows a macro that can be used
+// in a macroset in LIST. If the macro is inherited, the macroset it comes from
+// is included.
+func formatVisibleMacroListing(state *consoleState, setName, macro, from string) string {
+	listing := formatMacroListing(state.macros.GetSignatureIn(from, macro), state.macros.GetInfoIn(from, macro))
+	if !strings.EqualFold(setName, from) {
+		listing += " (from " + from + ")"
+	}
+	return listing
+}
+
 func executeCommandShow(state *consoleState, argv []string) (output string, err error) {
 	if len(argv) < 2 {
 		return "", fmt.Errorf("need to give name of macro to show")
 	}
-	if !state.macros.IsDefined(argv[1]) {
+	setName, macroName, ok := state.macros.Resolve(argv[1])
+	if !ok {
 		return "", fmt.Errorf("%q is not a defined macro", argv[1])
 	}
-	return state.macros.Get(argv[1]), nil
+	output = state.macros.GetIn(setName, macroName)
+	if !strings.EqualFold(setName, state.macros.GetCurrentMacroset()) {
+		output += "\nFrom: " + setName
+	}
+	info := state.macros.GetInfoIn(setName, macroName)
+	if info.Description != "" {
+		output += "\nDescription: " + info.Description
+	}
+	if len(info.Tags) > 0 {
+		output += "\nTags: " + strings.Join(info.Tags, ", ")
+	}
+	return output, nil
+}
+
+// formatMacroListing gives the line that shows a macro in LIST.
+func formatMacroListing(signature string, info macros.Info) string {
+	listing := signature
+	if info.Description != "" {
+		listing += " - " + info.Description
+	}
+	if len(info.Tags) > 0 {
+		listing += " [" + strings.Join(info.Tags, ", ") + "]"
+	}
+	return listing
+}
+
+func executeCommandFind(state *consoleState, line string, cmdName string) (output string, err error) {
+	var byBytes bool
+	argv, query := splitLineOptions(line, "")
+	_, err = parseCommandFlags(
+		argv,
+		flagActions{
+			'b': func(i *int, argv []string) error {
+				byBytes = true
+				return nil
+			},
+		},
+		nil,
+	)
+	if err != nil {
+		return "", err
+	}
+	if query == "" {
+		return "", fmt.Errorf("need to give text to search for")
+	}
+
+	var results []macros.SearchResult
+	if byBytes {
+		results, err = state.findMacrosByBytes(query)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		results = state.macros.Search(query)
+	}
+	if len(results) < 1 {
+		return "(no macros found)", nil
+	}
+
+	var lines []string
+	for _, r := range results {
+		setDesc := "default macroset"
+		if r.Set != "" {
+			setDesc = "macroset " + r.Set
+		}
+		lines = append(lines, fmt.Sprintf("%s (%s): %s", state.macros.GetSignatureIn(r.Set, r.Name), setDesc, strings.Join(r.Fields, ", ")))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// findMacrosByBytes finds every macro in every macroset whose fully expanded
+// bytes contain the bytes that query is parsed to. Macros with parameters
+// cannot be expanded without arguments and so are never found.
+func (state *consoleState) findMacrosByBytes(query string) ([]macros.SearchResult, error) {
+	target, err := state.parseLineToBytes(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(target) < 1 {
+		return nil, fmt.Errorf("need to give at least one byte to search for")
+	}
+
+	var results []macros.SearchResult
+	for _, setName := range state.macros.GetSetNames() {
+		for _, name := range state.macros.GetNamesIn(setName) {
+			if state.macros.GetSignatureIn(setName, name) != name {
+				continue
+			}
+			expanded, err := state.macros.ApplyIn(setName, name)
+			if err != nil {
+				continue
+			}
+			data, err := state.parseExpandedToBytes(expanded)
+			if err != nil {
+				continue
+			}
+			if bytes.Contains(data, target) {
+				results = append(results, macros.SearchResult{Set: setName, Name: name, Fields: []string{"bytes"}})
+			}
+		}
+	}
+	return results, nil
 }
 
 func executeCommandMacroset(state *consoleState, argv []string) (output string, err error) {
@@ -407,11 +736,13 @@ func executeCommandMacroset(state *consoleState, argv []string) (output string,
 		if err := state.macros.SetCurrentMacroset(""); err != nil {
 			return "", err
 		}
+		state.writeStateFile()
 		return state.out.InfoSprintf("Switched current macroset to the default one."), nil
 	} else if swapTo != "" {
 		if err := state.macros.SetCurrentMacroset(swapTo); err != nil {
 			return "", err
 		}
+		state.writeStateFile()
 		return state.out.InfoSprintf("Switched current macroset to %q.", swapTo), nil
 	}
 
@@ -425,6 +756,73 @@ func executeCommandMacroset(state *consoleState, argv []string) (output string,
 	return curSetName, nil
 }
 
+func executeCommandInherit(state *consoleState, argv []string) (output string, err error) {
+	var clear bool
+	var parents []string
+	_, err = parseCommandFlags(
+		argv,
+		flagActions{
+			'c': func(i *int, argv []string) error {
+				clear = true
+				return nil
+			},
+		},
+		posArgActions{
+			{
+				// takes all remaining arguments
+				parse: func(i *int, argv []string) error {
+					for ; *i < len(argv); *i++ {
+						parents = append(parents, argv[*i])
+					}
+					return nil
+				},
+				optional: true,
+			},
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	curSetName := state.macros.GetCurrentMacroset()
+	curSetDesc := "the default macroset"
+	if curSetName != "" {
+		curSetDesc = fmt.Sprintf("%q", curSetName)
+	}
+
+	if clear && len(parents) > 0 {
+		return "", fmt.Errorf("both -c and macroset names were given; only one is allowed")
+	}
+	if !clear && len(parents) < 1 {
+		cur := state.macros.GetParents(curSetName)
+		if len(cur) < 1 {
+			return "(no parent macrosets)", nil
+		}
+		return strings.Join(cur, "\n"), nil
+	}
+
+	for _, p := range parents {
+		if !state.macros.IsDefinedMacroset(p) {
+			return "", fmt.Errorf("no macroset named %q has any macros defined", p)
+		}
+	}
+	if len(parents) > 0 {
+		if err := state.checkMacrosFileHolds("inherited macrosets"); err != nil {
+			return "", err
+		}
+	}
+	if err := state.macros.SetParents(curSetName, parents); err != nil {
+		return "", err
+	}
+	if state.usingUserPersistenceFiles {
+		state.writeMacrosFile()
+	}
+	if clear {
+		return state.out.InfoSprintf("Removed all parent macrosets from %s", curSetDesc), nil
+	}
+	return state.out.InfoSprintf("%s now inherits from %s", strings.ToUpper(curSetDesc[:1])+curSetDesc[1:], strings.Join(parents, ", ")), nil
+}
+
 func executeCommandRename(state *consoleState, argv []string) (output string, err error) {
 	// "[-m OR -s] <old_name OR -d> <new_name>"
 
@@ -574,7 +972,9 @@ func executeCommandListsets(state *consoleState, argv []string) (output string,
 
 func executeCommandImport(state *consoleState, argv []string) (output string, err error) {
 	var importFile *os.File
-	var doReplace bool
+	var format macros.FileFormat
+	var doReplace, dryRun bool
+	opts := macros.ImportOptions{Mode: macros.MergeOverwrite}
 	argv, err = parseCommandFlags(
 		argv,
 		flagActions{
@@ -582,6 +982,30 @@ func executeCommandImport(state *consoleState, argv []string) (output string, er
 				doReplace = true
 				return nil
 			},
+			'n': func(i *int, argv []string) error {
+				dryRun = true
+				return nil
+			},
+			'm': func(i *int, argv []string) error {
+				if *i+1 >= len(argv) {
+					return fmt.Errorf("-m requires an argument")
+				}
+				*i++
+				mode, err := macros.ParseMergeMode(argv[*i])
+				if err != nil {
+					return err
+				}
+				opts.Mode = mode
+				return nil
+			},
+			'x': func(i *int, argv []string) error {
+				if *i+1 >= len(argv) {
+					return fmt.Errorf("-x requires an argument")
+				}
+				*i++
+				opts.Suffix = argv[*i]
+				return nil
+			},
 		},
 		posArgActions{
 			{
@@ -591,6 +1015,7 @@ func executeCommandImport(state *consoleState, argv []string) (output string, er
 						return fmt.Errorf("could not import file: %v", err)
 					}
 					importFile = f
+					format = macros.FileFormatOf(argv[*i])
 					return nil
 				},
 			},
@@ -602,17 +1027,71 @@ func executeCommandImport(state *consoleState, argv []string) (output string, er
 	if err != nil {
 		return "", err
 	}
+	if opts.Mode == macros.MergeAsk && !dryRun {
+		if !state.interactive {
+			return "", fmt.Errorf("-m ask is only available in interactive mode")
+		}
+		opts.Ask = state.askImportConflict
+	}
+
+	// when replacing, the file is compared against nothing since everything
+	// currently defined is about to be removed
+	target := &state.macros
+	if doReplace {
+		target = &macros.MacroCollection{MinLength: state.macros.MinLength}
+	}
+	plan, err := target.PlanImport(importFile, format)
+	if err != nil {
+		return "", err
+	}
+	if dryRun {
+		opts.DryRun = true
+		items, err := target.ApplyImport(plan, opts)
+		if err != nil {
+			return "", err
+		}
+		return formatImportPreview(items, doReplace), nil
+	}
 
+	var items []macros.ImportItem
 	successFmt := "Loaded %d total macro%s in %d macroset%s"
 	if doReplace {
+		// make sure the import will work before clearing anything
+		opts.DryRun = true
+		if _, err := target.ApplyImport(plan, opts); err != nil {
+			return "", err
+		}
+		opts.DryRun = false
+
+		endChange := state.macros.BeginChange("import macros, replacing all")
 		state.macros.Clear()
+		items, err = state.macros.ApplyImport(plan, opts)
+		endChange()
 		successFmt = "Replaced all macros with %d total macro%s in %d macroset%s"
+	} else {
+		items, err = state.macros.ApplyImport(plan, opts)
 	}
-	setCount, macroCount, err := state.macros.Import(importFile)
 	if err != nil {
 		return "", err
 	}
 
+	sets := map[string]bool{}
+	macroCount := 0
+	var lines []string
+	for _, item := range items {
+		if !item.Imported() {
+			continue
+		}
+		sets[strings.ToUpper(item.Set)] = true
+		if item.Macro != "" {
+			macroCount++
+		}
+		if item.ImportedAs != "" {
+			lines = append(lines, state.out.InfoSprintf("Imported %s as %s", macros.QualifiedName(item.Set, item.Macro), macros.QualifiedName(item.Set, item.ImportedAs)))
+		}
+	}
+	setCount := len(sets)
+
 	setS := "s"
 	macroS := "s"
 	if setCount == 1 {
@@ -626,13 +1105,18 @@ func executeCommandImport(state *consoleState, argv []string) (output string, er
 		state.writeMacrosFile()
 	}
 
-	return state.out.InfoSprintf(successFmt, macroCount, macroS, setCount, setS), nil
+	lines = append([]string{state.out.InfoSprintf(successFmt, macroCount, macroS, setCount, setS)}, lines...)
+	if kept := countImportItems(items, importKept); kept > 0 {
+		lines = append(lines, state.out.InfoSprintf("Kept the existing %s", misc.CountOf("conflicting item", "conflicting items", kept)))
+	}
+	return strings.Join(lines, "\n"), nil
 }
 
 func executeCommandExport(state *consoleState, argv []string) (output string, err error) {
 	//"<filename> [-c] [-s macroset1 [... -s macrosetN]]",
 
 	var exportFile *os.File
+	var format macros.FileFormat
 	includeSet := make(map[string]bool)
 	argv, err = parseCommandFlags(
 		argv,
@@ -661,6 +1145,7 @@ func executeCommandExport(state *consoleState, argv []string) (output string, er
 						return fmt.Errorf("could not import file: %v", err)
 					}
 					exportFile = f
+					format = macros.FileFormatOf(argv[*i])
 					return nil
 				},
 			},
@@ -673,30 +1158,15 @@ func executeCommandExport(state *consoleState, argv []string) (output string, er
 		return "", err
 	}
 
-	var totalSets, totalMacros int
-	if len(includeSet) > 0 {
-		includedMacrosets := []string{}
-		for k := range includeSet {
-			includedMacrosets = append(includedMacrosets, k)
-		}
-		sort.Strings(includedMacrosets)
+	includedMacrosets := []string{}
+	for k := range includeSet {
+		includedMacrosets = append(includedMacrosets, k)
+	}
+	sort.Strings(includedMacrosets)
 
-		for _, macrosetName := range includedMacrosets {
-			if state.macros.IsDefinedMacroset(macrosetName) {
-				setCount, macroCount, err := state.macros.ExportSet(macrosetName, exportFile)
-				if err != nil {
-					return "", err
-				}
-				totalSets += setCount
-				totalMacros += macroCount
-			}
-		}
-	} else {
-		var err error
-		totalSets, totalMacros, err = state.macros.Export(exportFile)
-		if err != nil {
-			return "", err
-		}
+	totalSets, totalMacros, err := state.macros.ExportFormat(exportFile, format, includedMacrosets...)
+	if err != nil {
+		return "", err
 	}
 
 	macroS := "s"
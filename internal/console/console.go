@@ -1,20 +1,26 @@
 package console
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"math/rand"
 	"os"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 	"unicode/utf8"
 
+	"dekarrin/netkarkat/internal/bytefuncs"
 	"dekarrin/netkarkat/internal/driver"
+	"dekarrin/netkarkat/internal/events"
 	"dekarrin/netkarkat/internal/macros"
 	"dekarrin/netkarkat/internal/persist"
+	"dekarrin/netkarkat/internal/profile"
+	"dekarrin/netkarkat/internal/responder"
+	"dekarrin/netkarkat/internal/scrollback"
 	"dekarrin/netkarkat/internal/verbosity"
 
 	"github.com/peterh/liner"
@@ -38,6 +44,93 @@ func init() {
 	initCommands()
 }
 
+// Options is options to a console session.
+type Options struct {
+
+	// Scrollback is where all data sent and received on the connection is
+	// recorded. It should be the same Buffer that the connection's
+	// ReceiveHandler records received data in. If nil, a new Buffer is created
+	// for the session that will only contain sent data.
+	Scrollback *scrollback.Buffer
+
+	// SendDelay is how long to wait between each statement when executing a
+	// script.
+	SendDelay time.Duration
+
+	// Jitter is the maximum random amount of time that is added to SendDelay
+	// for each statement. Each statement's wait is chosen separately.
+	Jitter time.Duration
+
+	// DryRun makes data be shown instead of being sent, and makes all waits
+	// be simulated instead of actually pausing execution. The data is still
+	// passed to the connection's Send, so DryRun is typically used with a
+	// connection that does not use the network.
+	DryRun bool
+
+	// Responder holds the rules used to automatically reply to received data.
+	// It should be the same Responder that the connection's ReceiveHandler
+	// checks received data against. If nil, a new Responder is created for
+	// the session, and rules added to it will never match.
+	Responder *responder.Responder
+
+	// EncryptPersistence makes the history, macros, and other files that are
+	// saved between interactive sessions be encrypted with a passphrase. The
+	// passphrase is taken from the NETKK_PASSPHRASE environment variable, or
+	// asked for at the start of the session if it is not set.
+	EncryptPersistence bool
+
+	// NoPersist makes the history, macros, and other files that are saved
+	// between interactive sessions be read but never written; all changes to
+	// them are lost when the session ends.
+	NoPersist bool
+
+	// SharedDir is a directory that the files saved between interactive
+	// sessions are read from if they are not in the user's own directory. It
+	// is never written to, so it can be used to give a baseline of macros to
+	// several users.
+	SharedDir string
+
+	// Macroset is the macroset to make current at the start of the session,
+	// in place of the one that was current at the end of the last one. If
+	// empty, the saved one is used.
+	Macroset string
+
+	// DisplayFormat is the format that bytes are displayed in at the start of
+	// the session; one of "hex", "text", or "dump". If empty, "hex" is used.
+	DisplayFormat string
+
+	// Profiles is the profiles that can be listed and switched to during the
+	// session.
+	Profiles profile.Config
+
+	// Profile is the name of the profile the session was started with, if
+	// any. History and the current macroset and display settings are kept
+	// separately for each profile.
+	Profile string
+
+	// Target identifies what the session is connected to. If no profile is
+	// used, history and the current macroset and display settings are kept
+	// separately for each target.
+	Target string
+
+	// GlobalHistory makes the same history be used for every profile and
+	// target.
+	GlobalHistory bool
+
+	// Events, if set, is where an event is written for every chunk of data
+	// sent and for the result of every command executed by a script. The
+	// output of commands is written only as events.
+	Events *events.Writer
+
+	// SwitchProfile is called to switch to the profile with the given name.
+	// It must open a connection with the settings of the profile, close the
+	// current connection, and return the new connection along with the
+	// settings that were used. If the switch fails after the current
+	// connection was closed, the connection to use in its place is returned
+	// along with the error. If nil, profiles cannot be switched.
+	SwitchProfile func(name string) (driver.Connection, profile.Profile, error)
+}
+
 type consoleState struct {
 	connection           driver.Connection
 	running              bool          // only valid if in interactive mode
@@ -49,6 +142,88 @@ type consoleState struct {
 	delimitWithSemicolon bool
 	macrofile            string
 	macros               macros.MacroCollection
+	scrollback           *scrollback.Buffer
+	jobs                 *jobList
+	sendDelay            time.Duration
+	jitter               time.Duration
+	rand                 *rand.Rand
+	dryRun               bool
+	dryRunElapsed        time.Duration // only valid if in dry-run mode
+	sendMtx              *sync.Mutex   // guards sends, which jobs make from other goroutines
+	variables            *variableSet
+	captureMark          int // index of the last entry in scrollback considered by CAPTURE
+	responder            *responder.Responder
+	displayFormat        string
+	showPromptText       bool // only valid if in interactive mode
+	profiles             profile.Config
+	profileName          string
+	switchProfile        func(name string) (driver.Connection, profile.Profile, error)
+	target               string
+	globalHistory        bool
+	startMacroset        string // made current once the macros are first loaded
+	startDisplayFormat   string // made current once the saved state is loaded
+	events               *events.Writer
+
+	// persistence state; only valid if in interactive mode
+	usingUserPersistenceFiles bool
+	encryptPersistence        bool
+	noPersist                 bool
+	sharedDir                 string
+	passphrase                string
+	keys                      *persist.KeyCache // keys derived from passphrase
+	macrosBase                []byte            // macros file as last read or written
+	histUnsaved               []string          // history entries not yet written to the history file
+}
+
+func newConsoleState(conn driver.Connection, out verbosity.OutputWriter, version string, interactive bool, delimitWithSemicolon bool, macrofile string, opts Options) *consoleState {
+	state := &consoleState{
+		connection:           conn,
+		out:                  out.For(verbosity.SubsystemConsole),
+		version:              version,
+		interactive:          interactive,
+		delimitWithSemicolon: delimitWithSemicolon,
+		macrofile:            macrofile,
+		scrollback:           opts.Scrollback,
+		jobs:                 &jobList{},
+		sendMtx:              &sync.Mutex{},
+		keys:                 &persist.KeyCache{},
+		sendDelay:            opts.SendDelay,
+		jitter:               opts.Jitter,
+		rand:                 newRand(),
+		dryRun:               opts.DryRun,
+		variables:            &variableSet{},
+		responder:            opts.Responder,
+		displayFormat:        displayHex,
+		profiles:             opts.Profiles,
+		profileName:          opts.Profile,
+		switchProfile:        opts.SwitchProfile,
+		target:               opts.Target,
+		globalHistory:        opts.GlobalHistory,
+		startMacroset:        opts.Macroset,
+		startDisplayFormat:   opts.DisplayFormat,
+		events:               opts.Events,
+
+		usingUserPersistenceFiles: interactive,
+		encryptPersistence:        opts.EncryptPersistence,
+		noPersist:                 opts.NoPersist,
+		sharedDir:                 opts.SharedDir,
+	}
+	if state.out.SubsystemVerbosity == nil {
+		state.out.SubsystemVerbosity = &verbosity.SubsystemVerbosity{}
+	}
+	if state.scrollback == nil {
+		state.scrollback = &scrollback.Buffer{}
+	}
+	if state.responder == nil {
+		state.responder = &responder.Responder{}
+	}
+	if opts.DisplayFormat != "" {
+		state.displayFormat = opts.DisplayFormat
+	}
+
+	// replaced with the saved journal when persistence files are loaded
+	state.macros.StartJournal()
+	return state
 }
 
 func promptWithConnectionMonitor(state *consoleState, prefix string) (string, error) {
@@ -138,20 +313,93 @@ func (state consoleState) parseLineToBytes(line string) (data []byte, err error)
 		return nil, err
 	}
 
-	runes := []rune(line)
+	// then replace variables, which may have been used in macros
+	return state.parseExpandedToBytes(line)
+}
 
+// parseArgListToBytes parses a comma-separated list of byte inputs in
+// parentheses, such as the arguments given to CALL. Macros and variables are
+// replaced first, as they are in parseLineToBytes.
+func (state consoleState) parseArgListToBytes(text string) (args [][]byte, err error) {
+	text, err = state.macros.Apply(text)
+	if err != nil {
+		return nil, err
+	}
+	text, err = state.variables.expand(text)
+	if err != nil {
+		return nil, err
+	}
+
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) < 1 || runes[0] != '(' {
+		return nil, fmt.Errorf("arguments must be given in parentheses")
+	}
+	args, end, err := parseByteArgs(runes, 0)
+	if err != nil {
+		return nil, err
+	}
+	if end >= len(runes) {
+		return nil, fmt.Errorf("argument list is missing closing parenthesis")
+	}
+	if strings.TrimSpace(string(runes[end+1:])) != "" {
+		return nil, fmt.Errorf("unexpected %q after argument list", string(runes[end+1:]))
+	}
+	return args, nil
+}
+
+// parseByteInput parses byte input in runes starting at runes[start]. If inCall
+// is true, the input is an argument to a byte function and parsing stops at the
+// first ',' or ')' that is not a part of a nested call. end is the index that
+// parsing stopped at, which is len(runes) if the end of input was reached.
+func parseByteInput(runes []rune, start int, inCall bool) (data []byte, end int, err error) {
 	buf := make([]byte, 128) // 128 bytes should be plenty for every character in existence. utf8 says max is 4 but have a bigger buffer bc we can and it may handle weird cases
 
 	// manual iteration instead of for-range so we control
 	// which char we are on
-	for i := 0; i < len(runes); i++ {
+	for i := start; i < len(runes); i++ {
 		ch := runes[i]
 		if unicode.IsSpace(ch) {
 			continue
 		}
+		if inCall && (ch == ',' || ch == ')') {
+			return data, i, nil
+		}
+		if ch == '@' && hasRunePrefix(runes, i, fileTokenPrefix) {
+			fileData, end, err := readFileToken(runes, i, inCall)
+			if err != nil {
+				return nil, 0, err
+			}
+			data = append(data, fileData...)
+			i = end
+			continue
+		}
+		if isFuncNameRune(ch) {
+			nameEnd := i
+			for nameEnd < len(runes) && isFuncNameRune(runes[nameEnd]) {
+				nameEnd++
+			}
+			if nameEnd < len(runes) && runes[nameEnd] == '(' {
+				if f, ok := bytefuncs.Lookup(string(runes[i:nameEnd])); ok {
+					result, end, err := callByteFunc(f, runes, i, nameEnd)
+					if err != nil {
+						return nil, 0, err
+					}
+					data = append(data, result...)
+					i = end
+					continue
+				}
+			}
+			// not a function call, so the whole word is just text
+			for ; i < nameEnd; i++ {
+				count := utf8.EncodeRune(buf, runes[i])
+				data = append(data, buf[:count]...)
+			}
+			i--
+			continue
+		}
 		if ch == '\\' {
 			if i+1 >= len(runes) {
-				return nil, fmt.Errorf("unterminated backslash at char index %d", i)
+				return nil, 0, fmt.Errorf("unterminated backslash at char index %d", i)
 			}
 			if runes[i+1] == '\\' {
 				count := utf8.EncodeRune(buf, runes[i+1])
@@ -161,18 +409,18 @@ func (state consoleState) parseLineToBytes(line string) (data []byte, err error)
 			} else if runes[i+1] == 'x' {
 				// byte sequence
 				if i+3 >= len(runes) {
-					return nil, fmt.Errorf("unterminated byte sequence at char index %d", i)
+					return nil, 0, fmt.Errorf("unterminated byte sequence at char index %d", i)
 				}
 				hexStr := string(runes[i+2 : i+4])
 				b, err := hex.DecodeString(hexStr)
 				if err != nil {
-					return nil, fmt.Errorf("malformed byte sequence at char index %d: %v", i, err)
+					return nil, 0, fmt.Errorf("malformed byte sequence at char index %d: %v", i, err)
 				}
 				data = append(data, b[0])
 				i += 3
 				continue
 			} else {
-				return nil, fmt.Errorf("unknown escaped character: %v", runes[i+1])
+				return nil, 0, fmt.Errorf("unknown escaped character: %v", runes[i+1])
 			}
 		} else {
 			count := utf8.EncodeRune(buf, ch)
@@ -180,7 +428,91 @@ func (state consoleState) parseLineToBytes(line string) (data []byte, err error)
 		}
 	}
 
-	return data, nil
+	return data, len(runes), nil
+}
+
+// callByteFunc parses the arguments of the call to f whose name starts at
+// runes[nameStart] and whose argument list opens at runes[open], then calls f
+// with them. Each argument is itself byte input, so calls can be nested. end is
+// the index of the closing parenthesis of the call.
+func callByteFunc(f bytefuncs.Func, runes []rune, nameStart int, open int) (result []byte, end int, err error) {
+	args, end, err := parseByteArgs(runes, open)
+	if err != nil {
+		return nil, 0, err
+	}
+	if end >= len(runes) {
+		return nil, 0, fmt.Errorf("call to %s() at char index %d is missing closing parenthesis", f.Name, nameStart)
+	}
+
+	result, err = f.Call(args)
+	if err != nil {
+		return nil, 0, err
+	}
+	return result, end, nil
+}
+
+// parseByteArgs parses the comma-separated list of byte inputs in parentheses
+// whose opening parenthesis is at runes[open]. end is the index of the closing
+// parenthesis, or len(runes) if there is none.
+func parseByteArgs(runes []rune, open int) (args [][]byte, end int, err error) {
+	i := open + 1
+	for i < len(runes) && unicode.IsSpace(runes[i]) {
+		i++
+	}
+	if i < len(runes) && runes[i] == ')' {
+		return nil, i, nil
+	}
+	for {
+		arg, argEnd, err := parseByteInput(runes, i, true)
+		if err != nil {
+			return nil, 0, err
+		}
+		if argEnd >= len(runes) {
+			return nil, argEnd, nil
+		}
+		args = append(args, arg)
+		if runes[argEnd] == ')' {
+			return args, argEnd, nil
+		}
+		i = argEnd + 1
+	}
+}
+
+// isFuncNameRune returns whether r can be a part of the name of a byte
+// function.
+func isFuncNameRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// hasRunePrefix returns whether the runes starting at runes[start] begin with
+// prefix.
+func hasRunePrefix(runes []rune, start int, prefix string) bool {
+	i := start
+	for _, r := range prefix {
+		if i >= len(runes) || runes[i] != r {
+			return false
+		}
+		i++
+	}
+	return true
+}
+
+// send sends data on the connection and records it in the scrollback. In
+// dry-run mode, the data is also shown. It is safe to call from jobs; the
+// connection, dryRunElapsed, and displayFormat must only be changed while
+// holding sendMtx.
+func (state *consoleState) send(data []byte) error {
+	state.sendMtx.Lock()
+	defer state.sendMtx.Unlock()
+	if state.dryRun {
+		state.showDryRunSend(data)
+	}
+	if err := state.connection.Send(data); err != nil {
+		return err
+	}
+	state.scrollback.Add(scrollback.Sent, state.connection.GetRemoteName(), data)
+	state.events.Sent(state.connection.GetRemoteName(), data)
+	return nil
 }
 
 // isLocalCommand indicates whether the line was processed as a command to the shell as opposed to sent to the remote end.
@@ -220,83 +552,19 @@ func executeLine(state *consoleState, line string) (cmdOutput string, err error)
 	return "", nil
 }
 
-// ExecuteScript executes script input from the given reader.
-// It ignores comments and considers a semicolon to denote the end of a statement.
-//
-// Returns the number of lines processed successfully.
-//
-// If an error is encountered, the number of lines that were executed is returned along
-// with the error that was encountered.
-//
-// Each statement's result is output as an INFO-level message in the given OutputWriter.
-//
-// For each statement, the following is done:
-//
-// If it is a command to netkk, that command is exectued and the output is returned. Otherwise, it is forwarded
-// to the connected server and that output is returned.
-//
-// Everything after a "#" or a "//" is ignored.
-// If the provided line is empty after removing comments and trimming, no action is taken and the empty string
-// is returned.
-func ExecuteScript(f io.Reader, conn driver.Connection, out verbosity.OutputWriter, version string, delimitWithSemicolon bool, macrofile string) (lines int, err error) {
-	state := &consoleState{connection: conn, version: version, out: out, interactive: false, delimitWithSemicolon: delimitWithSemicolon, macrofile: macrofile}
-	state.loadMacrosFile()
-	scanner := bufio.NewScanner(f)
-	lineNum := 0
-	numLinesRead := 0
-	moreInputRequired := true
-	cmd := ""
-
-	for scanner.Scan() {
-		lineNum++
-		partialCmd := scanner.Text()
-		normalPartial := normalizeLine(partialCmd)
-		if normalPartial == "" {
-			continue
-		}
-		cmd += normalPartial
-		moreInputRequired = state.delimitWithSemicolon && !isTerminatedStatement(state, cmd, ";")
-		if moreInputRequired {
-			cmd += "\n"
-		} else {
-			cmdOutput, err := executeLine(state, cmd)
-			if err != nil {
-				return numLinesRead, err
-			}
-			showScriptLineOutput(out, cmdOutput)
-			numLinesRead = lineNum
-			cmd = ""
-		}
-	}
-	if err = scanner.Err(); err != nil {
-		return numLinesRead, err
-	}
-
-	// need to execute last command in case it did not end with a semi:
-	if cmd != "" {
-		cmdOutput, err := executeLine(state, cmd)
-		if err != nil {
-			return numLinesRead, err
-		}
-		showScriptLineOutput(out, cmdOutput)
-		numLinesRead = lineNum
-		cmd = ""
-	}
-	return numLinesRead, nil
-}
-
 // StartPrompt makes a prompt and starts it
-func StartPrompt(conn driver.Connection, out verbosity.OutputWriter, version string, delimitWithSemicolon bool, showPromptText bool, macrofile string) (err error) {
+func StartPrompt(conn driver.Connection, out verbosity.OutputWriter, version string, delimitWithSemicolon bool, showPromptText bool, macrofile string, opts Options) (err error) {
 
-	state := consoleState{
-		running:              true,
-		connection:           conn,
-		out:                  out,
-		version:              version,
-		interactive:          true,
-		delimitWithSemicolon: delimitWithSemicolon,
-		macrofile:            macrofile,
-	}
+	state := newConsoleState(conn, out, version, true, delimitWithSemicolon, macrofile, opts)
+	defer state.jobs.cancelAll()
+	state.running = true
+	state.showPromptText = showPromptText
+	state.openUserStore()
+	state.loadPassphrase()
+
+	// replies must be sent as soon as the remote end can send anything, so
+	// this is done before waiting for the connection to be ready
+	state.startResponding()
 
 	// sleep until ready
 	for !state.connection.Ready() {
@@ -324,14 +592,14 @@ func StartPrompt(conn driver.Connection, out verbosity.OutputWriter, version str
 			}
 		}
 
-		if showPromptText {
-			prefix = fmt.Sprintf("netkk@%s> ", conn.GetRemoteName())
+		if state.showPromptText {
+			prefix = fmt.Sprintf("netkk@%s> ", state.connection.GetRemoteName())
 		}
 
 		// histCmd is same as cmd but with spaces instead of newlines for multiline input.
 		// this is because peterh/liner cannot currently track the cursor position
 		// if multiline strings are put into its history.
-		cmd, histCmd, err := promptUntilFullStatement(&state, prefix)
+		cmd, histCmd, err := promptUntilFullStatement(state, prefix)
 		if isErrCloseDuringPrompt(err) {
 			errClose := err.(errCloseDuringPrompt)
 			if errClose.afterPrefix {
@@ -362,9 +630,10 @@ func StartPrompt(conn driver.Connection, out verbosity.OutputWriter, version str
 		}
 
 		state.prompt.AppendHistory(histCmd)
+		state.histUnsaved = append(state.histUnsaved, histCmd)
 		state.writeHistFile()
 
-		cmdOutput, err := executeLine(&state, cmd)
+		cmdOutput, err := executeLine(state, cmd)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
 			if state.connection.IsClosed() {
@@ -3,6 +3,9 @@ package console
 import (
 	"bytes"
 	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -43,3 +46,148 @@ func Test_parseLineToBytes(t *testing.T) {
 		})
 	}
 }
+
+func Test_parseLineToBytes_fileToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netkk-test")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	plainFile := filepath.Join(dir, "data.bin")
+	spacedFile := filepath.Join(dir, "my data.bin")
+	for _, name := range []string{plainFile, spacedFile} {
+		if err := ioutil.WriteFile(name, []byte("abcdef"), 0644); err != nil {
+			t.Fatalf("could not create temp file: %v", err)
+		}
+	}
+	largeFile := filepath.Join(dir, "large.bin")
+	if err := writeLargeTestFile(largeFile, maxFileReadBytes+1); err != nil {
+		t.Fatalf("could not create temp file: %v", err)
+	}
+
+	testCases := []struct {
+		name      string
+		input     string
+		expected  []byte
+		expectErr bool
+	}{
+		{name: "whole file", input: "@file:" + plainFile, expected: []byte("abcdef")},
+		{name: "quoted path", input: "@file:\"" + spacedFile + "\"", expected: []byte("abcdef")},
+		{name: "surrounded by bytes", input: "x@file:" + plainFile + " \\x7a", expected: []byte("xabcdefz")},
+		{name: "offset and length", input: "@file:" + plainFile + "[1:3]", expected: []byte("bcd")},
+		{name: "offset only", input: "@file:" + plainFile + "[4:]", expected: []byte("ef")},
+		{name: "length only", input: "@file:" + plainFile + "[:2]", expected: []byte("ab")},
+		{name: "length past end", input: "@file:" + plainFile + "[4:100]", expected: []byte("ef")},
+		{name: "offset past end", input: "@file:" + plainFile + "[7:]", expectErr: true},
+		{name: "missing file", input: "@file:" + filepath.Join(dir, "nope.bin"), expectErr: true},
+		{name: "missing path", input: "@file:", expectErr: true},
+		{name: "unterminated window", input: "@file:" + plainFile + "[1:3", expectErr: true},
+		{name: "file too large", input: "@file:" + largeFile, expectErr: true},
+		{name: "window of file too large", input: "@file:" + largeFile + "[:2]", expected: []byte{0, 0}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			sut := consoleState{}
+			actual, err := sut.parseLineToBytes(tc.input)
+
+			// check for error
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				t.Fatalf("expected an error but nil error was returned")
+			}
+
+			// check the value
+			if bytes.Compare(tc.expected, actual) != 0 {
+				t.Errorf("expected %s but got: %s", hex.EncodeToString(tc.expected), hex.EncodeToString(actual))
+			}
+		})
+	}
+}
+
+func Test_parseLineToBytes_funcCall(t *testing.T) {
+	testCases := []struct {
+		name      string
+		input     string
+		expected  []byte
+		expectErr bool
+	}{
+		{name: "single call", input: "len(abc)", expected: []byte{0x03}},
+		{name: "call among bytes", input: "\\x7e len(ab) ab", expected: []byte{0x7e, 0x02, 0x61, 0x62}},
+		{name: "nested calls", input: "len(hex(\\x01\\x02))", expected: []byte{0x04}},
+		{name: "multiple args", input: "pad(4, ab, \\xff)", expected: []byte{0x61, 0x62, 0xff, 0xff}},
+		{name: "whitespace in args", input: "repeat( 2 , a b )", expected: []byte("abab")},
+		{name: "no args", input: "len(now_unix())", expected: []byte{0x04}},
+		{name: "escaped comma in arg", input: "len(a\\x2cb)", expected: []byte{0x03}},
+		{name: "parens outside call are text", input: "(a,b)", expected: []byte("(a,b)")},
+		{name: "unknown function is text", input: "foo(a)", expected: []byte("foo(a)")},
+		{name: "function name inside word is text", input: "xlen(a)", expected: []byte("xlen(a)")},
+		{name: "function name without parens is text", input: "len", expected: []byte("len")},
+		{name: "missing closing paren", input: "len(abc", expectErr: true},
+		{name: "wrong arg count", input: "len(a, b)", expectErr: true},
+		{name: "error in arg", input: "len(\\x4)", expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			sut := consoleState{}
+			actual, err := sut.parseLineToBytes(tc.input)
+
+			// check for error
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				t.Fatalf("expected an error but nil error was returned")
+			}
+
+			// check the value
+			if bytes.Compare(tc.expected, actual) != 0 {
+				t.Errorf("expected %s but got: %s", hex.EncodeToString(tc.expected), hex.EncodeToString(actual))
+			}
+		})
+	}
+}
+
+func Test_parseLineToBytes_variables(t *testing.T) {
+	testCases := []struct {
+		name      string
+		input     string
+		expected  []byte
+		expectErr bool
+	}{
+		{name: "variable alone", input: "${tok}", expected: []byte{0x00, 0x5c, 0x2c}},
+		{name: "name is case-insensitive", input: "${TOK}", expected: []byte{0x00, 0x5c, 0x2c}},
+		{name: "variable among bytes", input: "a${tok}b", expected: []byte{0x61, 0x00, 0x5c, 0x2c, 0x62}},
+		{name: "variable in function", input: "len(${tok})", expected: []byte{0x03}},
+		{name: "escaped dollar sign", input: "\\x24{tok}", expected: []byte("${tok}")},
+		{name: "dollar sign without brace", input: "$tok", expected: []byte("$tok")},
+		{name: "unset variable", input: "${nope}", expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			sut := consoleState{variables: &variableSet{}}
+			if err := sut.variables.set("tok", []byte{0x00, 0x5c, 0x2c}); err != nil {
+				t.Fatalf("could not set variable: %v", err)
+			}
+			actual, err := sut.parseLineToBytes(tc.input)
+
+			// check for error
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				t.Fatalf("expected an error but nil error was returned")
+			}
+
+			// check the value
+			if bytes.Compare(tc.expected, actual) != 0 {
+				t.Errorf("expected %s but got: %s", hex.EncodeToString(tc.expected), hex.EncodeToString(actual))
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package console
+
+import (
+	"fmt"
+	"strings"
+
+	"dekarrin/netkarkat/internal/macros"
+	"dekarrin/netkarkat/internal/misc"
+)
+
+// the formats that bytes can be displayed in, selected with FORMAT.
+const (
+	displayHex  = "hex"
+	displayText = "text"
+	displayDump = "dump"
+)
+
+// formatBytes gives the display of the bytes in the current display format.
+func (state consoleState) formatBytes(data []byte) string {
+	switch state.displayFormat {
+	case displayText:
+		return misc.FormatTextBytes(data)
+	case displayDump:
+		return misc.FormatDumpBytes(data)
+	default:
+		return misc.FormatHexBytes(data)
+	}
+}
+
+// labelBytes gives the label followed by the display of the bytes in the
+// current display format. Because a dump takes up multiple lines, in that
+// format the bytes begin on the line after the label.
+func (state consoleState) labelBytes(label string, data []byte) string {
+	if len(data) < 1 {
+		return label
+	}
+	if state.displayFormat == displayDump {
+		return label + "\n" + state.formatBytes(data)
+	}
+	return label + " " + state.formatBytes(data)
+}
+
+// setDisplayFormat changes the format that bytes are displayed in.
+func (state *consoleState) setDisplayFormat(format string) {
+	state.sendMtx.Lock()
+	defer state.sendMtx.Unlock()
+	state.displayFormat = format
+}
+
+func executeCommandFormat(state *consoleState, argv []string) (output string, err error) {
+	var format string
+	_, err = parseCommandFlags(
+		argv,
+		nil,
+		posArgActions{
+			{
+				parse: func(i *int, argv []string) error {
+					format = strings.ToLower(argv[*i])
+					if format != displayHex && format != displayText && format != displayDump {
+						return fmt.Errorf("format must be one of %s, %s, or %s", displayHex, displayText, displayDump)
+					}
+					return nil
+				},
+				optional: true,
+			},
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if format == "" {
+		cur := state.displayFormat
+		if cur == "" {
+			cur = displayHex
+		}
+		return fmt.Sprintf("Bytes are displayed as %s", cur), nil
+	}
+	state.setDisplayFormat(format)
+	state.writeStateFile()
+	return state.out.InfoSprintf("Bytes will now be displayed as %s", format), nil
+}
+
+func executeCommandExpand(state *consoleState, line string, cmdName string) (output string, err error) {
+	_, input := nextToken(line)
+	input = strings.TrimSpace(input)
+
+	expansions, err := state.macros.Explain(input)
+	if err != nil {
+		return "", err
+	}
+	data, err := state.parseLineToBytes(input)
+	if err != nil {
+		return "", err
+	}
+
+	var lines []string
+	lines = append(lines, fmt.Sprintf("Input: %s", input))
+	if len(expansions) < 1 {
+		lines = append(lines, "(no macros used)")
+	} else {
+		lines = append(lines, "Macros:")
+		lines = state.appendExpansionTree(lines, expansions, "  ")
+	}
+	lines = append(lines, state.labelBytes("Bytes:", data))
+	lines = append(lines, fmt.Sprintf("Length: %s", misc.CountOf("byte", "bytes", len(data))))
+	return strings.Join(lines, "\n"), nil
+}
+
+// appendExpansionTree adds a line for each expansion to lines, with the
+// expansions of the macros each used indented beneath it. Each line shows the
+// bytes that the macro produced. A macro whose result cannot be parsed as
+// bytes on its own, such as one that only gives part of a function call, is
+// shown as the text it produced instead.
+func (state consoleState) appendExpansionTree(lines []string, expansions []macros.Expansion, indent string) []string {
+	for _, exp := range expansions {
+		label := fmt.Sprintf("%s%s: %s", indent, exp.Name, exp.Call)
+		data, err := state.parseExpandedToBytes(exp.Result)
+		if err != nil {
+			lines = append(lines, fmt.Sprintf("%s -> %q (not bytes on its own: %v)", label, exp.Result, err))
+		} else {
+			label = fmt.Sprintf("%s (%s) ->", label, misc.CountOf("byte", "bytes", len(data)))
+			lines = append(lines, strings.ReplaceAll(state.labelBytes(label, data), "\n", "\n"+indent+"    "))
+		}
+		lines = state.appendExpansionTree(lines, exp.Children, indent+"  ")
+	}
+	return lines
+}
+
+// parseExpandedToBytes parses text that has already had all macros replaced
+// into bytes.
+func (state consoleState) parseExpandedToBytes(text string) ([]byte, error) {
+	text, err := state.variables.expand(text)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := parseByteInput([]rune(text), 0, false)
+	return data, err
+}
@@ -0,0 +1,46 @@
+package console
+
+import (
+	"fmt"
+	"strings"
+
+	"dekarrin/netkarkat/internal/bytefuncs"
+)
+
+func executeCommandFuncs(state *consoleState, argv []string) (output string, err error) {
+	var name string
+	_, err = parseCommandFlags(
+		argv,
+		nil,
+		posArgActions{
+			{
+				parse: func(i *int, argv []string) error {
+					name = argv[*i]
+					return nil
+				},
+				optional: true,
+			},
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	funcs := bytefuncs.All()
+	if name != "" {
+		f, ok := bytefuncs.Lookup(strings.TrimSuffix(name, "()"))
+		if !ok {
+			return "", fmt.Errorf("no function named %q; use FUNCS to see all functions", name)
+		}
+		funcs = []bytefuncs.Func{f}
+	}
+
+	var sb strings.Builder
+	for idx, f := range funcs {
+		sb.WriteString(fmt.Sprintf("%s - %s", f.Usage, f.Description))
+		if idx+1 < len(funcs) {
+			sb.WriteRune('\n')
+		}
+	}
+	return sb.String(), nil
+}
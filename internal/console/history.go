@@ -0,0 +1,131 @@
+package console
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const defaultHistoryShowCount = 20
+
+func executeCommandHistory(state *consoleState, argv []string) (output string, err error) {
+	if !state.interactive {
+		return "", fmt.Errorf("%s command only available in interactive mode", argv[0])
+	}
+
+	var showAll bool
+	var search string
+	var rerun int
+	count := defaultHistoryShowCount
+
+	_, err = parseCommandFlags(
+		argv,
+		flagActions{
+			'a': func(i *int, argv []string) error {
+				showAll = true
+				return nil
+			},
+			'n': func(i *int, argv []string) error {
+				if *i+1 >= len(argv) {
+					return fmt.Errorf("-n requires an argument")
+				}
+				*i++
+				n, err := strconv.Atoi(argv[*i])
+				if err != nil || n < 1 {
+					return fmt.Errorf("-n must be given a number greater than 0")
+				}
+				count = n
+				return nil
+			},
+			's': func(i *int, argv []string) error {
+				if *i+1 >= len(argv) {
+					return fmt.Errorf("-s requires an argument")
+				}
+				*i++
+				search = argv[*i]
+				return nil
+			},
+			'r': func(i *int, argv []string) error {
+				if *i+1 >= len(argv) {
+					return fmt.Errorf("-r requires an argument")
+				}
+				*i++
+				n, err := strconv.Atoi(argv[*i])
+				if err != nil || n < 1 {
+					return fmt.Errorf("-r must be given the number of a history entry")
+				}
+				rerun = n
+				return nil
+			},
+		},
+		nil,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	entries, err := state.historyEntries()
+	if err != nil {
+		return "", err
+	}
+
+	if rerun > 0 {
+		if search != "" || showAll {
+			return "", fmt.Errorf("-r cannot be given with -s or -a")
+		}
+		if rerun > len(entries) {
+			return "", fmt.Errorf("there is no history entry #%d", rerun)
+		}
+		line := entries[rerun-1]
+		if cmdName, _ := nextToken(line); strings.ToUpper(cmdName) == argv[0] {
+			return "", fmt.Errorf("a %s command cannot be re-run", argv[0])
+		}
+		state.out.Info("%s\n", line)
+		return executeLine(state, line)
+	}
+
+	// entries are numbered from the oldest, which is what -r takes
+	type numbered struct {
+		num  int
+		line string
+	}
+	var matches []numbered
+	for idx, e := range entries {
+		if search == "" || strings.Contains(strings.ToLower(e), strings.ToLower(search)) {
+			matches = append(matches, numbered{num: idx + 1, line: e})
+		}
+	}
+	if len(matches) < 1 {
+		if search != "" {
+			return fmt.Sprintf("(no history entries contain %q)", search), nil
+		}
+		return "(no history)", nil
+	}
+	if !showAll && len(matches) > count {
+		matches = matches[len(matches)-count:]
+	}
+
+	width := len(strconv.Itoa(matches[len(matches)-1].num))
+	var lines []string
+	for _, m := range matches {
+		lines = append(lines, fmt.Sprintf("%*d  %s", width, m.num, m.line))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// historyEntries gives the entries in the history of the current profile or
+// target, oldest first.
+func (state *consoleState) historyEntries() ([]string, error) {
+	var buf bytes.Buffer
+	if _, err := state.prompt.WriteHistory(&buf); err != nil {
+		return nil, fmt.Errorf("couldn't get history: %v", err)
+	}
+	var entries []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if line != "" {
+			entries = append(entries, line)
+		}
+	}
+	return entries, nil
+}
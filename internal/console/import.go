@@ -0,0 +1,148 @@
+package console
+
+import (
+	"fmt"
+	"strings"
+
+	"dekarrin/netkarkat/internal/macros"
+)
+
+// the statuses of an item being imported.
+const (
+	importAdded     = "added"
+	importChanged   = "changed"
+	importUnchanged = "unchanged"
+	importKept      = "kept"
+	importRenamed   = "renamed"
+	importUndecided = "undecided"
+)
+
+// importStatus gives what importing does with the item.
+func importStatus(item macros.ImportItem) string {
+	if !item.Conflict {
+		if item.IsNew() {
+			return importAdded
+		}
+		return importUnchanged
+	}
+	switch {
+	case item.Resolution == macros.MergeOverwrite:
+		return importChanged
+	case item.ImportedAs != "":
+		return importRenamed
+	case item.Resolution == macros.MergeAsk:
+		return importUndecided
+	default:
+		return importKept
+	}
+}
+
+func countImportItems(items []macros.ImportItem, statuses ...string) int {
+	count := 0
+	for _, item := range items {
+		for _, s := range statuses {
+			if importStatus(item) == s {
+				count++
+				break
+			}
+		}
+	}
+	return count
+}
+
+// formatImportPreview gives the lines that show what importing the items
+// would do, grouped by macroset. Unchanged items are only counted.
+func formatImportPreview(items []macros.ImportItem, replacing bool) string {
+	summary := fmt.Sprintf(
+		"%d added, %d changed, %d conflicting, %d unchanged",
+		countImportItems(items, importAdded),
+		countImportItems(items, importChanged),
+		countImportItems(items, importKept, importRenamed, importUndecided),
+		countImportItems(items, importUnchanged),
+	)
+	var lines []string
+	if replacing {
+		lines = append(lines, "Would replace all macros with: "+summary)
+	} else {
+		lines = append(lines, "Would import: "+summary)
+	}
+
+	curSet := ""
+	first := true
+	for _, item := range items {
+		status := importStatus(item)
+		if status == importUnchanged {
+			continue
+		}
+		if first || !strings.EqualFold(item.Set, curSet) {
+			if item.Set == "" {
+				lines = append(lines, "Default macroset:")
+			} else {
+				lines = append(lines, fmt.Sprintf("Macroset %s:", item.Set))
+			}
+			curSet = item.Set
+			first = false
+		}
+
+		subject := item.Macro
+		if item.Macro == "" {
+			subject = "inherited macrosets"
+		}
+		switch status {
+		case importKept:
+			lines = append(lines, fmt.Sprintf("  conflict %s (keeping existing)", subject))
+		case importRenamed:
+			lines = append(lines, fmt.Sprintf("  conflict %s (importing as %s)", subject, item.ImportedAs))
+		case importUndecided:
+			lines = append(lines, fmt.Sprintf("  conflict %s (will ask)", subject))
+		default:
+			lines = append(lines, fmt.Sprintf("  %s %s", status, subject))
+		}
+		lines = append(lines, formatImportDiff(item)...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatImportDiff gives the lines that show the existing and incoming
+// contents of an item being imported.
+func formatImportDiff(item macros.ImportItem) []string {
+	return formatJournalChange(macros.JournalChange{
+		Set:           item.Set,
+		Macro:         item.Macro,
+		Before:        item.Existing,
+		After:         item.Incoming,
+		ParentsBefore: item.ExistingParents,
+		ParentsAfter:  item.IncomingParents,
+	})
+}
+
+// askImportConflict shows a conflict found while importing and asks the user
+// how to merge it.
+func (state *consoleState) askImportConflict(item macros.ImportItem) (macros.MergeMode, error) {
+	subject := macros.QualifiedName(item.Set, item.Macro)
+	choices := "[k]eep existing, [o]verwrite, [r]ename imported, or [a]bort? "
+	if item.Macro == "" {
+		subject = "inherited macrosets of " + formatMacrosetName(item.Set)
+		choices = "[k]eep existing, [o]verwrite, or [a]bort? "
+	}
+	fmt.Printf("Conflict in %s:\n%s\n", subject, strings.Join(formatImportDiff(item), "\n"))
+
+	for {
+		answer, err := state.prompt.Prompt(choices)
+		if err != nil {
+			return 0, fmt.Errorf("import aborted; nothing was imported: %v", err)
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "k", "keep":
+			return macros.MergeKeepExisting, nil
+		case "o", "overwrite":
+			return macros.MergeOverwrite, nil
+		case "r", "rename":
+			if item.Macro != "" {
+				return macros.MergeRename, nil
+			}
+		case "a", "abort":
+			return 0, fmt.Errorf("import aborted; nothing was imported")
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package console
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"dekarrin/netkarkat/internal/misc"
+	"dekarrin/netkarkat/internal/verbosity"
+)
+
+// how often a running job checks whether the connection it sends on is still
+// up.
+const jobPollInterval = 100 * time.Millisecond
+
+// job is a send that has been scheduled to run in the background by EVERY or
+// AFTER.
+type job struct {
+	id        int
+	repeat    bool
+	interval  time.Duration
+	input     string
+	data      []byte
+	maxSends  int
+	sendCount int
+	next      time.Time
+	stop      chan struct{}
+
+	// in dry-run mode, the simulated elapsed time that the job next sends at.
+	dryRunNext time.Duration
+}
+
+// describe gives a line describing the job. untilNext is how long it is until
+// the job next sends.
+func (j *job) describe(untilNext time.Duration) string {
+	kind := "AFTER"
+	if j.repeat {
+		kind = "EVERY"
+	}
+	desc := fmt.Sprintf("%d: %s %v %s", j.id, kind, j.interval, j.input)
+	if j.repeat {
+		if j.maxSends > 0 {
+			desc += fmt.Sprintf(" (sent %d of %d times", j.sendCount, j.maxSends)
+		} else {
+			desc += fmt.Sprintf(" (sent %s", misc.CountOf("time", "times", j.sendCount))
+		}
+		desc += fmt.Sprintf(", next in %v)", untilNext.Round(time.Millisecond))
+	} else {
+		desc += fmt.Sprintf(" (sends in %v)", untilNext.Round(time.Millisecond))
+	}
+	return desc
+}
+
+// jobList holds the jobs that are currently scheduled. It is safe for
+// concurrent use.
+type jobList struct {
+	mtx    sync.Mutex
+	jobs   map[int]*job
+	lastID int
+}
+
+func (jl *jobList) add(j *job) {
+	jl.mtx.Lock()
+	defer jl.mtx.Unlock()
+	if jl.jobs == nil {
+		jl.jobs = make(map[int]*job)
+	}
+	jl.lastID++
+	j.id = jl.lastID
+	j.stop = make(chan struct{})
+	jl.jobs[j.id] = j
+}
+
+// remove removes the job with the given ID from the list and returns it. If
+// there is no job with that ID, nil is returned.
+func (jl *jobList) remove(id int) *job {
+	jl.mtx.Lock()
+	defer jl.mtx.Unlock()
+	j, ok := jl.jobs[id]
+	if !ok {
+		return nil
+	}
+	delete(jl.jobs, id)
+	return j
+}
+
+// cancel stops the job with the given ID. Returns false if there is no such
+// job.
+func (jl *jobList) cancel(id int) bool {
+	j := jl.remove(id)
+	if j == nil {
+		return false
+	}
+	close(j.stop)
+	return true
+}
+
+// cancelAll stops every job and returns the number that were stopped.
+func (jl *jobList) cancelAll() int {
+	ids := jl.ids()
+	for _, id := range ids {
+		jl.cancel(id)
+	}
+	return len(ids)
+}
+
+func (jl *jobList) ids() []int {
+	jl.mtx.Lock()
+	defer jl.mtx.Unlock()
+	var ids []int
+	for id := range jl.jobs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// describeAll gives a line describing each job, in order of ID. untilNext
+// gives how long it is until a job next sends.
+func (jl *jobList) describeAll(untilNext func(j *job) time.Duration) []string {
+	jl.mtx.Lock()
+	defer jl.mtx.Unlock()
+	var ids []int
+	for id := range jl.jobs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	var lines []string
+	for _, id := range ids {
+		j := jl.jobs[id]
+		lines = append(lines, j.describe(untilNext(j)))
+	}
+	return lines
+}
+
+// recordSend counts a send made by the job and schedules its next one. If that
+// was its last send, it is removed from the list. Returns whether it was the
+// last send.
+func (jl *jobList) recordSend(j *job) (done bool) {
+	jl.mtx.Lock()
+	j.sendCount++
+	done = !j.repeat || (j.maxSends > 0 && j.sendCount >= j.maxSends)
+	j.next = time.Now().Add(j.interval)
+	j.dryRunNext += j.interval
+	jl.mtx.Unlock()
+	if done {
+		jl.remove(j.id)
+	}
+	return done
+}
+
+// nextDryRun gives the job that sends soonest at or before the given simulated
+// elapsed time. If more than one sends at the same time, the one with the
+// lowest ID is given. If no job sends by then, nil is returned.
+func (jl *jobList) nextDryRun(until time.Duration) *job {
+	jl.mtx.Lock()
+	defer jl.mtx.Unlock()
+	var next *job
+	for _, j := range jl.jobs {
+		if j.dryRunNext > until {
+			continue
+		}
+		if next == nil || j.dryRunNext < next.dryRunNext || (j.dryRunNext == next.dryRunNext && j.id < next.id) {
+			next = j
+		}
+	}
+	return next
+}
+
+// startJob adds the job to the list and runs it on a new goroutine until it
+// completes, is canceled, or the connection is no longer usable. In dry-run
+// mode, no goroutine is started; the job instead sends as the simulated
+// elapsed time passes it, as done by runDryRunJobs.
+func (state *consoleState) startJob(j *job) {
+	j.next = time.Now().Add(j.interval)
+	if state.dryRun {
+		state.sendMtx.Lock()
+		j.dryRunNext = state.dryRunElapsed + j.interval
+		state.sendMtx.Unlock()
+		state.jobs.add(j)
+		return
+	}
+	state.jobs.add(j)
+	out := state.asyncOutput()
+
+	go func() {
+		timer := time.NewTimer(j.interval)
+		defer timer.Stop()
+		poll := time.NewTicker(jobPollInterval)
+		defer poll.Stop()
+
+		for {
+			select {
+			case <-j.stop:
+				return
+			case <-poll.C:
+				state.sendMtx.Lock()
+				usable := !state.connection.IsClosed() && state.connection.Ready()
+				state.sendMtx.Unlock()
+				if !usable {
+					if state.jobs.remove(j.id) != nil {
+						out.Debug("Job %d stopped because the connection closed", j.id)
+					}
+					return
+				}
+			case <-timer.C:
+				if err := state.send(j.data); err != nil {
+					if state.jobs.remove(j.id) != nil {
+						out.Error("Job %d stopped: %v", j.id, err)
+					}
+					return
+				}
+				out.Debug("Job %d sent %s", j.id, misc.CountOf("byte", "bytes", len(j.data)))
+
+				if state.jobs.recordSend(j) {
+					return
+				}
+				timer.Reset(j.interval)
+			}
+		}
+	}()
+}
+
+// runDryRunJobs has every job that would send by the given simulated elapsed
+// time send, in the order that they would, and sets the simulated elapsed time
+// to when each one sends. Must only be called in dry-run mode.
+func (state *consoleState) runDryRunJobs(until time.Duration) {
+	for {
+		j := state.jobs.nextDryRun(until)
+		if j == nil {
+			return
+		}
+		state.sendMtx.Lock()
+		state.dryRunElapsed = j.dryRunNext
+		state.sendMtx.Unlock()
+		if err := state.send(j.data); err != nil {
+			if state.jobs.remove(j.id) != nil {
+				state.out.Error("Job %d stopped: %v", j.id, err)
+			}
+			continue
+		}
+		state.jobs.recordSend(j)
+	}
+}
+
+// asyncOutput gives an OutputWriter for output that is produced in the
+// background while the prompt may be waiting for input. In interactive mode,
+// each message clears the current line before it is printed so it does not
+// run into the prompt; the prompt is redrawn on the next keypress.
+func (state *consoleState) asyncOutput() verbosity.OutputWriter {
+	out := state.out
+	if !state.interactive {
+		return out
+	}
+	out.StdoutTemplate = clearLineTemplate(out.StdoutTemplate, verbosity.DefaultStdoutTemplateStr)
+	out.StderrTemplate = clearLineTemplate(out.StderrTemplate, verbosity.DefaultStderrTemplateStr)
+	return out
+}
+
+func clearLineTemplate(t *template.Template, defaultStr string) *template.Template {
+	const clearLine = "\r\033[K"
+	if t == nil {
+		return template.Must(template.New("async").Parse(clearLine + defaultStr))
+	}
+	clone := template.Must(t.Clone())
+	return template.Must(clone.New("async").Parse(clearLine + `{{template "` + t.Name() + `" .}}`))
+}
+
+func executeCommandEvery(state *consoleState, line string, cmdName string) (output string, err error) {
+	maxSends := 0
+	argv, rest := splitLineOptions(line, "n")
+	_, err = parseCommandFlags(
+		argv,
+		flagActions{
+			'n': func(i *int, argv []string) error {
+				if *i+1 >= len(argv) {
+					return fmt.Errorf("-n requires an argument")
+				}
+				*i++
+				n, err := strconv.Atoi(argv[*i])
+				if err != nil || n < 1 {
+					return fmt.Errorf("-n must be given a number greater than 0")
+				}
+				maxSends = n
+				return nil
+			},
+		},
+		nil,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	j, err := parseJob(state, rest, "interval")
+	if err != nil {
+		return "", err
+	}
+	j.repeat = true
+	j.maxSends = maxSends
+	state.startJob(j)
+	return state.out.InfoSprintf("Started job %d", j.id), nil
+}
+
+func executeCommandAfter(state *consoleState, line string, cmdName string) (output string, err error) {
+	argv, rest := splitLineOptions(line, "")
+	_, err = parseCommandFlags(argv, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	j, err := parseJob(state, rest, "delay")
+	if err != nil {
+		return "", err
+	}
+	state.startJob(j)
+	return state.out.InfoSprintf("Started job %d", j.id), nil
+}
+
+// parseJob creates a job from the duration and bytes given to EVERY or AFTER.
+// The bytes are parsed immediately, so later changes to macros used in them
+// do not affect the job.
+func parseJob(state *consoleState, args string, durationName string) (*job, error) {
+	durStr, input := nextToken(args)
+	if durStr == "" {
+		return nil, fmt.Errorf("need to give %s", durationName)
+	}
+	dur, err := time.ParseDuration(durStr)
+	if err != nil || dur <= 0 {
+		return nil, fmt.Errorf("%s must be a duration such as 500ms or 10s", durationName)
+	}
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return nil, fmt.Errorf("need to give bytes to send")
+	}
+	data, err := state.parseLineToBytes(input)
+	if err != nil {
+		return nil, err
+	}
+	return &job{interval: dur, input: input, data: data}, nil
+}
+
+func executeCommandJobs(state *consoleState, argv []string) (output string, err error) {
+	_, err = parseCommandFlags(argv, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	untilNext := func(j *job) time.Duration {
+		return time.Until(j.next)
+	}
+	if state.dryRun {
+		state.sendMtx.Lock()
+		elapsed := state.dryRunElapsed
+		state.sendMtx.Unlock()
+		untilNext = func(j *job) time.Duration {
+			return j.dryRunNext - elapsed
+		}
+	}
+	lines := state.jobs.describeAll(untilNext)
+	if len(lines) < 1 {
+		return "(no jobs running)", nil
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func executeCommandCancel(state *consoleState, argv []string) (output string, err error) {
+	var all bool
+	id := -1
+	_, err = parseCommandFlags(
+		argv,
+		flagActions{
+			'a': func(i *int, argv []string) error {
+				all = true
+				return nil
+			},
+		},
+		posArgActions{
+			{
+				parse: func(i *int, argv []string) error {
+					n, err := strconv.Atoi(argv[*i])
+					if err != nil {
+						return fmt.Errorf("%q is not a valid job number", argv[*i])
+					}
+					id = n
+					return nil
+				},
+				optional: true,
+			},
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if all {
+		count := state.jobs.cancelAll()
+		return state.out.InfoSprintf("Canceled %s", misc.CountOf("job", "jobs", count)), nil
+	}
+	if id < 0 {
+		return "", fmt.Errorf("need to give job number to cancel or -a for all jobs")
+	}
+	if !state.jobs.cancel(id) {
+		return "", fmt.Errorf("no job %d is running", id)
+	}
+	return state.out.InfoSprintf("Canceled job %d", id), nil
+}
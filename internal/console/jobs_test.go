@@ -0,0 +1,238 @@
+package console
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"dekarrin/netkarkat/internal/driver"
+	"dekarrin/netkarkat/internal/scrollback"
+	"dekarrin/netkarkat/internal/verbosity"
+)
+
+func Test_jobCommands(t *testing.T) {
+	testCases := []struct {
+		name        string
+		lines       []string
+		wait        time.Duration
+		expectSent  []string
+		expectJobs  []int
+		expectErrOn int // 1-based index into lines; 0 for no error
+	}{
+		{
+			name:       "after sends once",
+			lines:      []string{"AFTER 10ms a"},
+			wait:       100 * time.Millisecond,
+			expectSent: []string{"a"},
+		},
+		{
+			name:       "every with limit",
+			lines:      []string{"EVERY -n 3 10ms a"},
+			wait:       200 * time.Millisecond,
+			expectSent: []string{"a", "a", "a"},
+		},
+		{
+			name:       "every keeps running",
+			lines:      []string{"EVERY 1h a"},
+			expectJobs: []int{1},
+		},
+		{
+			name:       "bytes are parsed when job is started",
+			lines:      []string{"AFTER 10ms \\x41\\x42"},
+			wait:       100 * time.Millisecond,
+			expectSent: []string{"AB"},
+		},
+		{
+			name:       "cancel one",
+			lines:      []string{"AFTER 50ms a", "EVERY 1h b", "CANCEL 1"},
+			wait:       100 * time.Millisecond,
+			expectJobs: []int{2},
+		},
+		{
+			name:  "cancel all",
+			lines: []string{"AFTER 50ms a", "EVERY 1h b", "CANCEL -a"},
+			wait:  100 * time.Millisecond,
+		},
+		{
+			name:        "cancel job that is not running",
+			lines:       []string{"EVERY 1h a", "CANCEL 2"},
+			expectJobs:  []int{1},
+			expectErrOn: 2,
+		},
+		{
+			name:        "cancel without job",
+			lines:       []string{"CANCEL"},
+			expectErrOn: 1,
+		},
+		{
+			name:        "every with bad interval",
+			lines:       []string{"EVERY 0s a"},
+			expectErrOn: 1,
+		},
+		{
+			name:        "after without bytes",
+			lines:       []string{"AFTER 10ms"},
+			expectErrOn: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			state := newConsoleState(driver.OpenDryRunConnection("test"), verbosity.OutputWriter{Verbosity: verbosity.Silent}, "", false, false, "", Options{})
+			defer state.jobs.cancelAll()
+
+			for i, line := range tc.lines {
+				_, err := executeLine(state, line)
+				if i+1 == tc.expectErrOn {
+					if err == nil {
+						t.Fatalf("expected an error from %q but nil error was returned", line)
+					}
+				} else if err != nil {
+					t.Fatalf("%q returned an error: %v", line, err)
+				}
+			}
+			time.Sleep(tc.wait)
+
+			// check the values
+			var actualSent []string
+			for _, e := range state.scrollback.Last(state.scrollback.Len()) {
+				if e.Direction == scrollback.Sent {
+					actualSent = append(actualSent, string(e.Data))
+				}
+			}
+			if strings.Join(actualSent, ",") != strings.Join(tc.expectSent, ",") {
+				t.Errorf("expected sends %q but got: %q", tc.expectSent, actualSent)
+			}
+			actualJobs := state.jobs.ids()
+			if len(actualJobs) != len(tc.expectJobs) {
+				t.Fatalf("expected jobs %v but got: %v", tc.expectJobs, actualJobs)
+			}
+			for i := range actualJobs {
+				if actualJobs[i] != tc.expectJobs[i] {
+					t.Errorf("expected jobs %v but got: %v", tc.expectJobs, actualJobs)
+					break
+				}
+			}
+		})
+	}
+}
+
+func Test_jobCommands_dryRun(t *testing.T) {
+	testCases := []struct {
+		name          string
+		lines         []string
+		expectSent    []string
+		expectJobs    []int
+		expectElapsed time.Duration
+	}{
+		{
+			name:          "jobs send in order as time passes",
+			lines:         []string{"AFTER 1s a", "EVERY -n 3 400ms b", "SLEEP 2s"},
+			expectSent:    []string{"b", "b", "a", "b"},
+			expectElapsed: 2 * time.Second,
+		},
+		{
+			name:          "send between sleeps",
+			lines:         []string{"EVERY 1s a", "SLEEP 1500ms", "b", "SLEEP 1s"},
+			expectSent:    []string{"a", "b", "a"},
+			expectJobs:    []int{1},
+			expectElapsed: 2500 * time.Millisecond,
+		},
+		{
+			name:          "job not due does not send",
+			lines:         []string{"AFTER 1h a", "SLEEP 1s"},
+			expectJobs:    []int{1},
+			expectElapsed: time.Second,
+		},
+		{
+			name:          "canceled job does not send",
+			lines:         []string{"AFTER 1s a", "CANCEL 1", "SLEEP 2s"},
+			expectElapsed: 2 * time.Second,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			state := newConsoleState(driver.OpenDryRunConnection("test"), verbosity.OutputWriter{Verbosity: verbosity.Silent}, "", false, false, "", Options{DryRun: true})
+			defer state.jobs.cancelAll()
+
+			for _, line := range tc.lines {
+				if _, err := executeLine(state, line); err != nil {
+					t.Fatalf("%q returned an error: %v", line, err)
+				}
+			}
+
+			// check the values
+			var actualSent []string
+			for _, e := range state.scrollback.Last(state.scrollback.Len()) {
+				if e.Direction == scrollback.Sent {
+					actualSent = append(actualSent, string(e.Data))
+				}
+			}
+			if strings.Join(actualSent, ",") != strings.Join(tc.expectSent, ",") {
+				t.Errorf("expected sends %q but got: %q", tc.expectSent, actualSent)
+			}
+			actualJobs := state.jobs.ids()
+			if len(actualJobs) != len(tc.expectJobs) {
+				t.Fatalf("expected jobs %v but got: %v", tc.expectJobs, actualJobs)
+			}
+			if state.dryRunElapsed != tc.expectElapsed {
+				t.Errorf("expected elapsed time %v but got: %v", tc.expectElapsed, state.dryRunElapsed)
+			}
+		})
+	}
+}
+
+func Test_executeCommandJobs(t *testing.T) {
+	testCases := []struct {
+		name   string
+		lines  []string
+		expect []string // prefix of each line of output
+	}{
+		{
+			name:   "no jobs",
+			expect: []string{"(no jobs running)"},
+		},
+		{
+			name:  "every and after",
+			lines: []string{"EVERY -n 2 1h a", "AFTER 1h b"},
+			expect: []string{
+				"1: EVERY 1h0m0s a (sent 0 of 2 times, next in ",
+				"2: AFTER 1h0m0s b (sends in ",
+			},
+		},
+		{
+			name:   "canceled job is not shown",
+			lines:  []string{"EVERY 1h a", "EVERY 1h b", "CANCEL 1"},
+			expect: []string{"2: EVERY 1h0m0s b (sent 0 times, next in "},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			state := newConsoleState(driver.OpenDryRunConnection("test"), verbosity.OutputWriter{Verbosity: verbosity.Silent}, "", false, false, "", Options{})
+			defer state.jobs.cancelAll()
+			for _, line := range tc.lines {
+				if _, err := executeLine(state, line); err != nil {
+					t.Fatalf("prep step: %q returned an error: %v", line, err)
+				}
+			}
+
+			actual, err := executeLine(state, "JOBS")
+			if err != nil {
+				t.Fatalf("returned an error: %v", err)
+			}
+
+			// check the value
+			actualLines := strings.Split(actual, "\n")
+			if len(actualLines) != len(tc.expect) {
+				t.Fatalf("expected %d lines but got:\n%s", len(tc.expect), actual)
+			}
+			for i := range actualLines {
+				if !strings.HasPrefix(actualLines[i], tc.expect[i]) {
+					t.Errorf("expected line %d to start with %q but got: %q", i+1, tc.expect[i], actualLines[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package console
+
+import (
+	"fmt"
+	"strings"
+
+	"dekarrin/netkarkat/internal/verbosity"
+)
+
+func executeCommandLoglevel(state *consoleState, argv []string) (output string, err error) {
+	args := argv[1:]
+	if len(args) > 0 {
+		spec := strings.Join(args, ",")
+
+		// also allow `LOGLEVEL driver trace`
+		if len(args) == 2 && !strings.Contains(spec, "=") {
+			spec = args[0] + "=" + args[1]
+		}
+		if err := state.out.SubsystemVerbosity.Set(spec); err != nil {
+			return "", err
+		}
+	}
+
+	// do not mask behind verbosity as user specifically requested this
+	var sb strings.Builder
+	for idx, sub := range verbosity.Subsystems {
+		if idx > 0 {
+			sb.WriteRune('\n')
+		}
+		subOut := state.out.For(sub)
+		sb.WriteString(fmt.Sprintf("  %-8s %s", sub, subOut.EffectiveVerbosity()))
+		if _, set := state.out.SubsystemVerbosity.Get(sub); !set {
+			sb.WriteString(" (default)")
+		}
+	}
+	return sb.String(), nil
+}
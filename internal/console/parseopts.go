@@ -1,6 +1,10 @@
 package console
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
 
 // parse the short args out of the given string. will always return none on empty or
 // none on string that contains impossible characters
@@ -129,3 +133,55 @@ func parseCommandFlags(argv []string, flags flagActions, args posArgActions) ([]
 	}
 	return parsedArgs, nil
 }
+
+// splitLineOptions splits the line given to a lineExec command into an argv
+// that has the command name followed by every option at the start of the
+// line, and the raw text that comes after them. Options whose letter is in
+// withArgs take the token after them as their argument; that argument can be
+// surrounded with double quotes if it contains spaces. Option splitting stops
+// at the first token that does not start with a '-', or after a "--" token.
+//
+// The returned argv is suitable for passing to parseCommandFlags.
+func splitLineOptions(line string, withArgs string) (argv []string, rest string) {
+	cmdName, rest := nextToken(line)
+	argv = append(argv, cmdName)
+	for {
+		token, remaining := nextToken(rest)
+		if token == "--" {
+			rest = remaining
+			break
+		}
+		opts := parseShortOpts(token)
+		if len(opts) < 1 {
+			break
+		}
+		argv = append(argv, token)
+		rest = remaining
+		for _, ch := range opts {
+			if strings.ContainsRune(withArgs, ch) {
+				var optArg string
+				optArg, rest = nextToken(rest)
+				argv = append(argv, optArg)
+				break
+			}
+		}
+	}
+	return argv, strings.TrimSpace(rest)
+}
+
+// nextToken splits the first whitespace-delimited token off of s. If the token
+// starts with a double quote, it extends to the next double quote and the
+// quotes are not included in it. No other escaping is performed.
+func nextToken(s string) (token string, remaining string) {
+	s = strings.TrimLeftFunc(s, unicode.IsSpace)
+	if strings.HasPrefix(s, "\"") {
+		if end := strings.Index(s[1:], "\""); end >= 0 {
+			return s[1 : end+1], s[end+2:]
+		}
+	}
+	end := strings.IndexFunc(s, unicode.IsSpace)
+	if end < 0 {
+		return s, ""
+	}
+	return s[:end], s[end:]
+}
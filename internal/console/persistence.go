@@ -1,117 +1,405 @@
 package console
 
 import (
-	"bufio"
+	"bytes"
+	"dekarrin/netkarkat/internal/macros"
 	"dekarrin/netkarkat/internal/persist"
+	"dekarrin/netkarkat/internal/verbosity"
 	"encoding/gob"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
-	"path/filepath"
+	"strings"
+
+	"github.com/peterh/liner"
 )
 
-// source for persistence in case we want to change it up later
-//
-// currently might seen v silly since all we have is "directory, with files",
-// but that may change in the future.
-//
-// (probs yagni but fuck it this is my house and my house shall be tidy)
-type persistSource struct {
-	dirBased string
-}
+// keys of the persistence documents in the user store.
+const (
+	histKey    = "history-nkk"
+	macrosKey  = "macros.json"
+	journalKey = "macros-journal"
+	stateKey   = "state"
 
-func (state *consoleState) loadPersistenceFiles() {
-	var err error
-	state.userStore, err = persist.NewUserHomeDirStore(".netkk", nil, nil)
+	// legacyMacrosKey is the document that macros were kept in before they
+	// were kept in JSON. It is read if there is no document at macrosKey.
+	legacyMacrosKey = "macros.m"
+)
+
+// openUserStore opens ~/.netkk as the store that persistence documents are
+// kept in. If a shared directory was given, documents that are not in ~/.netkk
+// are read from it instead. If persistence documents are not to be written,
+// they are still read from those places, but all changes to them are kept in
+// memory. If ~/.netkk can't be opened, persistence is limited to this session.
+func (state *consoleState) openUserStore() {
+	if !state.usingUserPersistenceFiles || state.userStore != nil {
+		return
+	}
+	dirPerms := os.FileMode(0755)
+	store, err := persist.NewUserHomeDirStore(".netkk", &dirPerms, nil)
 	if err != nil {
+		if state.noPersist {
+			state.userStore = persist.NewMemoryStore()
+			return
+		}
+		state.out.For(verbosity.SubsystemPersist).Warn("couldn't open ~/.netkk; persistence will be limited to this session: %v", err)
+		state.usingUserPersistenceFiles = false
+		return
+	}
+	if state.sharedDir != "" {
+		shared, err := persist.NewFilesystemStore(state.sharedDir, nil, nil)
+		if err != nil {
+			state.out.For(verbosity.SubsystemPersist).Warn("couldn't open shared directory %s; it will not be used: %v", state.sharedDir, err)
+		} else {
+			store = persist.NewOverlayStore(shared, store)
+		}
+	}
+	if state.noPersist {
+		store = persist.NewOverlayStore(store, persist.NewMemoryStore())
+	}
+	state.userStore = store
+}
 
+// loadPassphrase gets the passphrase that persistence documents are encrypted
+// with, if they are to be encrypted. It is taken from the NETKK_PASSPHRASE
+// environment variable, or asked for if that is not set. If no passphrase is
+// given, persistence is limited to this session.
+func (state *consoleState) loadPassphrase() {
+	if !state.usingUserPersistenceFiles || !state.encryptPersistence || state.passphrase != "" {
+		return
+	}
+	state.passphrase = os.Getenv("NETKK_PASSPHRASE")
+	if state.passphrase != "" {
+		return
+	}
+
+	passPrompt := liner.NewLiner()
+	passphrase, err := passPrompt.PasswordPrompt("Passphrase for saved netkk files: ")
+	passPrompt.Close()
+	if err != nil || passphrase == "" {
+		state.out.For(verbosity.SubsystemPersist).Warn("no passphrase given; persistence will be limited to this session")
+		state.usingUserPersistenceFiles = false
+		return
+	}
+	state.passphrase = passphrase
+}
+
+// persistenceCodecs gives the codecs that persistence documents are read and
+// written with. Documents that are not yet encrypted are read as they are, and
+// are encrypted the next time they are written.
+func (state *consoleState) persistenceCodecs() []persist.Codec {
+	if !state.encryptPersistence {
+		return nil
 	}
+	return []persist.Codec{&persist.AESGCMCodec{Passphrase: state.passphrase, AllowPlaintext: true, Keys: state.keys}}
+}
+
+func (state *consoleState) loadPersistenceFiles() {
 	state.loadHistFile()
-	state.loadMacrosFile()
+	state.loadJournalFile(state.loadMacrosFile())
 	state.loadStateFile()
+	state.useStartSettings()
+}
+
+// useStartSettings makes the macroset and display format given at the start
+// of the session current, in place of the saved ones. It is only done once, so
+// that loading the saved state again does not undo changes made since then.
+func (state *consoleState) useStartSettings() {
+	if state.startMacroset != "" {
+		if err := state.macros.SetCurrentMacroset(state.startMacroset); err != nil {
+			state.out.For(verbosity.SubsystemMacros).Warn("couldn't switch to macroset %q: %v", state.startMacroset, err)
+		}
+		state.startMacroset = ""
+	}
+	if state.startDisplayFormat != "" {
+		state.setDisplayFormat(state.startDisplayFormat)
+		state.startDisplayFormat = ""
+	}
+}
+
+// scopedKey gives the key of the persistence document that holds what is kept
+// separately for the current profile, or for the current target if no profile
+// is used. If there is neither, key itself is given.
+func (state *consoleState) scopedKey(key string) string {
+	var scope string
+	if state.profileName != "" {
+		scope = "profile-" + state.profileName
+	} else if state.target != "" {
+		scope = "target-" + state.target
+	} else {
+		return key
+	}
+	return key + "." + persistenceKeySafe(scope)
+}
+
+// histDocKey gives the key of the history file in use.
+func (state *consoleState) histDocKey() string {
+	if state.globalHistory {
+		return histKey
+	}
+	return state.scopedKey(histKey)
 }
 
-func (state *consoleState) loadMacrosFile() {
+// loadMacrosFile loads the macros and returns the contents of the file they
+// were loaded from. If no macros file was given and macros have not yet been
+// saved in JSON, the ones saved in the legacy format are loaded; they are
+// saved in JSON the next time they are written.
+func (state *consoleState) loadMacrosFile() (loaded []byte) {
 	if !state.usingUserPersistenceFiles {
-		return
+		return nil
 	}
-	f, err := openPersistenceFile(state.macrofile, "macros.m")
-	if err != nil && !os.IsNotExist(err) {
-		state.out.Warn("%v", err)
+	format := state.macrosFormat()
+	data, err := state.readPersistenceDoc(macrosKey, state.macrofile)
+	state.macrosBase = data
+	if err == nil && data == nil && state.macrofile == "" {
+		format = macros.FormatLegacy
+		data, err = state.readPersistenceDoc(legacyMacrosKey, "")
+	}
+	if err != nil {
+		state.out.For(verbosity.SubsystemPersist).Warn("%v", err)
 		state.usingUserPersistenceFiles = false
+		return nil
 	}
-	defer f.Close()
 	state.macros.Clear()
-	_, _, err = state.macros.Import(f)
+	if len(data) < 1 {
+		return data
+	}
+	_, _, err = state.macros.ImportFormat(bytes.NewReader(data), format)
+	if err != nil {
+		state.out.For(verbosity.SubsystemMacros).Warn("couldn't read macros file: %v\n", err)
+	}
+	return data
+}
+
+// macrosFormat gives the format that macros are saved in. Macros are kept in
+// JSON unless a macros file was given, in which case its extension gives the
+// format.
+func (state *consoleState) macrosFormat() macros.FileFormat {
+	if state.macrofile == "" {
+		return macros.FormatJSON
+	}
+	return macros.FileFormatOf(state.macrofile)
+}
+
+// checkMacrosFileHolds returns an error if the macros are saved to a file in
+// the legacy format, which cannot hold what is described by what.
+func (state *consoleState) checkMacrosFileHolds(what string) error {
+	if !state.usingUserPersistenceFiles || state.macrosFormat() != macros.FormatLegacy {
+		return nil
+	}
+	return fmt.Errorf("%s cannot be saved in %s; give a macros file ending in .json or .yaml to use them", what, state.macrofile)
+}
+
+// loadJournalFile loads the changes that can be undone. It must be called
+// after the macros file is loaded, and is given what loadMacrosFile returned;
+// the changes made by loading the macros are replaced by the ones in the file.
+// If the journal was not written along with the macros that were loaded, such
+// as when the macros file was edited by hand, a new journal is started
+// instead.
+func (state *consoleState) loadJournalFile(loadedMacros []byte) {
+	if !state.usingUserPersistenceFiles {
+		return
+	}
+	data, err := state.readPersistenceDoc(journalKey, state.journalFilePath())
 	if err != nil {
-		state.out.Warn("couldn't read macros file: %v\n", err)
+		state.out.For(verbosity.SubsystemMacros).Warn("%v; changes made before now cannot be undone", err)
+		state.macros.StartJournal()
+		return
+	}
+	if data == nil {
+		state.macros.StartJournal()
+		return
+	}
+	if err := state.macros.ImportJournal(bytes.NewReader(data), loadedMacros); err != nil {
+		state.out.For(verbosity.SubsystemMacros).Warn("couldn't read macros journal file; changes made before now cannot be undone: %v\n", err)
+		state.macros.StartJournal()
+	}
+}
+
+// journalFilePath gives the user-supplied path of the journal file, which is
+// kept next to the macros file if one was given.
+func (state *consoleState) journalFilePath() string {
+	if state.macrofile == "" {
+		return ""
 	}
+	return state.macrofile + ".journal"
+}
+
+// savedState is the contents of a state file.
+type savedState struct {
+	Macroset      string
+	DisplayFormat string
 }
 
+// loadStateFile loads the current macroset and display settings saved for the
+// current profile or target. If none were saved, those saved by versions of
+// netkk that kept a single state for everything are used.
 func (state *consoleState) loadStateFile() {
 	if !state.usingUserPersistenceFiles {
 		return
 	}
-	f, err := openPersistenceFile("", "state")
+	key := state.scopedKey(stateKey)
+	data, err := state.readPersistenceDoc(key, "")
+	if err == nil && data == nil && key != stateKey {
+		data, err = state.readPersistenceDoc(stateKey, "")
+	}
 	if err != nil {
-		state.out.Warn("%v", err)
+		state.out.For(verbosity.SubsystemPersist).Warn("%v", err)
 		state.usingUserPersistenceFiles = false
+		return
+	}
+	if len(data) < 1 {
+		return
+	}
+
+	saved, err := decodeSavedState(data)
+	if err != nil {
+		state.out.For(verbosity.SubsystemPersist).Warn("couldn't read state file: %v\n", err)
+		return
+	}
+	state.macros.SetCurrentMacroset(saved.Macroset)
+	if saved.DisplayFormat != "" {
+		state.setDisplayFormat(saved.DisplayFormat)
 	}
-	defer f.Close()
+}
 
-	dec := gob.NewDecoder(bufio.NewReader(f))
-	var curSet string
-	if err := dec.Decode(&curSet); err != nil {
-		state.out.Warn("couldn't read state file: %v\v", err)
+// decodeSavedState reads the contents of a state file. Older state files hold
+// only the name of the current macroset.
+func decodeSavedState(data []byte) (savedState, error) {
+	var saved savedState
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&saved); err == nil {
+		return saved, nil
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&saved.Macroset); err != nil {
+		return savedState{}, err
 	}
-	state.macros.SetCurrentMacroset(curSet)
+	return saved, nil
 }
 
 func (state *consoleState) loadHistFile() {
 	if !state.usingUserPersistenceFiles {
 		return
 	}
-	f, err := openPersistenceFile("", "history-nkk")
-	if err != nil && !os.IsNotExist(err) {
-		state.out.Warn("%v", err)
+	data, err := state.readPersistenceDoc(state.histDocKey(), "")
+	if err != nil {
+		state.out.For(verbosity.SubsystemPersist).Warn("%v", err)
 		state.usingUserPersistenceFiles = false
+		return
 	}
-	defer f.Close()
-	_, err = state.prompt.ReadHistory(f)
+	_, err = state.prompt.ReadHistory(bytes.NewReader(data))
 	if err != nil {
-		state.out.Warn("couldn't read history file: %v\n", err)
+		state.out.For(verbosity.SubsystemPersist).Warn("couldn't read history file: %v\n", err)
 	}
 }
 
+// writeMacrosFile saves the macros and the journal. If another netkk has
+// changed the macros file since this one last read or wrote it, the changes
+// made in each are merged first. The journal is written while the macros file
+// is held so that no other netkk can write macros in between.
 func (state *consoleState) writeMacrosFile() {
 	if !state.usingUserPersistenceFiles {
 		return
 	}
-	f, err := createPersistenceFile(state.macrofile, "macros.m")
+	format := state.macrosFormat()
+	var written []byte
+	err := persist.Update(state.userStore, macrosKey, state.macrofile, func(current []byte) ([]byte, error) {
+		if !bytes.Equal(current, state.macrosBase) {
+			if err := state.mergeMacros(current, format); err != nil {
+				state.out.For(verbosity.SubsystemMacros).Warn("couldn't merge in macros changed by another netkk; they will be overwritten: %v\n", err)
+			}
+		}
+		var buf bytes.Buffer
+		if _, _, err := state.macros.ExportFormat(&buf, format); err != nil {
+			return nil, err
+		}
+		written = buf.Bytes()
+
+		// the journal must match the macros it was written with
+		state.writeJournalFile(written)
+		return written, nil
+	}, state.persistenceCodecs()...)
 	if err != nil {
-		state.out.Warn("%v", err)
+		state.out.For(verbosity.SubsystemMacros).Warn("couldn't write macros file: %v\n", err)
 		state.usingUserPersistenceFiles = false
+		return
+	}
+	state.macrosBase = written
+}
+
+// mergeMacros merges the macros in current, which were written by another
+// netkk, with the changes made to the macros since they were last read or
+// written.
+func (state *consoleState) mergeMacros(current []byte, format macros.FileFormat) error {
+	var base, other macros.MacroCollection
+	if len(state.macrosBase) > 0 {
+		if _, _, err := base.ImportFormat(bytes.NewReader(state.macrosBase), format); err != nil {
+			return err
+		}
 	}
-	defer f.Close()
-	_, _, err = state.macros.Export(f)
+	if len(current) > 0 {
+		if _, _, err := other.ImportFormat(bytes.NewReader(current), format); err != nil {
+			return err
+		}
+	}
+	return state.macros.Rebase(&base, &other)
+}
+
+// writeJournalFile saves the journal along with exported, the export of the
+// macros that are being saved with it.
+func (state *consoleState) writeJournalFile(exported []byte) {
+	if !state.usingUserPersistenceFiles {
+		return
+	}
+	err := state.writePersistenceDoc(journalKey, state.journalFilePath(), func(w io.Writer) error {
+		return state.macros.ExportJournal(w, exported)
+	})
 	if err != nil {
-		state.out.Warn("couldn't write macros file: %v\n", err)
+		state.out.For(verbosity.SubsystemMacros).Warn("couldn't write macros journal file: %v\n", err)
 		state.usingUserPersistenceFiles = false
 	}
 }
 
+// writeHistFile adds the history entries made since it was last called to the
+// end of the history file, after any that were added by another netkk.
 func (state *consoleState) writeHistFile() {
 	if !state.usingUserPersistenceFiles {
+		state.histUnsaved = nil
 		return
 	}
-	f, err := createPersistenceFile("", "history-nkk")
+	err := persist.Update(state.userStore, state.histDocKey(), "", func(current []byte) ([]byte, error) {
+		lines := strings.SplitAfter(string(current), "\n")
+		if lines[len(lines)-1] == "" {
+			lines = lines[:len(lines)-1]
+		}
+		for _, entry := range state.histUnsaved {
+			lines = append(lines, entry+"\n")
+		}
+		if len(lines) > liner.HistoryLimit {
+			lines = lines[len(lines)-liner.HistoryLimit:]
+		}
+		return []byte(strings.Join(lines, "")), nil
+	}, state.persistenceCodecs()...)
 	if err != nil {
-		state.out.Warn("%v", err)
+		state.out.For(verbosity.SubsystemPersist).Warn("couldn't write history file: %v\n", err)
 		state.usingUserPersistenceFiles = false
+		return
+	}
+	state.histUnsaved = nil
+}
+
+// clearHistFile removes every entry from the history file, including those
+// added by other netkks.
+func (state *consoleState) clearHistFile() {
+	state.histUnsaved = nil
+	if !state.usingUserPersistenceFiles {
+		return
 	}
-	defer f.Close()
-	_, err = state.prompt.WriteHistory(f)
+	err := state.writePersistenceDoc(state.histDocKey(), "", func(w io.Writer) error {
+		return nil
+	})
 	if err != nil {
-		state.out.Warn("couldn't write history file: %v\n", err)
+		state.out.For(verbosity.SubsystemPersist).Warn("couldn't write history file: %v\n", err)
 		state.usingUserPersistenceFiles = false
 	}
 }
@@ -120,58 +408,100 @@ func (state *consoleState) writeStateFile() {
 	if !state.usingUserPersistenceFiles {
 		return
 	}
-	f, err := createPersistenceFile("", "state")
-	if err != nil {
-		state.out.Warn("%v", err)
-		state.usingUserPersistenceFiles = false
+	saved := savedState{
+		Macroset:      state.macros.GetCurrentMacroset(),
+		DisplayFormat: state.displayFormat,
 	}
-	defer f.Close()
-
-	enc := gob.NewEncoder(bufio.NewWriter(f))
-	if err := enc.Encode(state.macros.GetCurrentMacroset()); err != nil {
-		state.out.Warn("couldn't write state file: %v\v", err)
+	err := state.writePersistenceDoc(state.scopedKey(stateKey), "", func(w io.Writer) error {
+		return gob.NewEncoder(w).Encode(saved)
+	})
+	if err != nil {
+		state.out.For(verbosity.SubsystemPersist).Warn("couldn't write state file: %v\n", err)
 	}
 }
 
-func createPersistenceFile(userSupplied, defaultIfNone string) (*os.File, error) {
-	fullPath, err := getPersistencePath(userSupplied, defaultIfNone)
+// readPersistenceDoc gives the contents of the persistence document with the
+// given key, or of the file given by the user instead of it if userSupplied is
+// non-empty. If the document does not exist, nil is returned.
+func (state *consoleState) readPersistenceDoc(key, userSupplied string) ([]byte, error) {
+	doc, err := state.userStore.OpenAlt(key, userSupplied)
 	if err != nil {
-		return nil, err
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("couldn't open %s; persistence will be limited to this session: %v", persistenceDocName(key, userSupplied), err)
+	}
+	defer doc.Close()
+	if persist.BaseIsNewer(state.userStore, key, userSupplied) {
+		name := persistenceDocName(key, userSupplied)
+		state.out.For(verbosity.SubsystemPersist).Warn("%s is used instead of the copy in the shared directory, which was changed after it; remove %s to use the shared copy", name, name)
+	}
+
+	var data []byte
+	if codecs := state.persistenceCodecs(); len(codecs) > 0 {
+		for _, c := range codecs {
+			doc.UseCodec(c)
+		}
+		err = doc.Decode(&data)
+		if err == io.EOF {
+			err = nil
+		}
+	} else {
+		data, err = ioutil.ReadAll(doc)
+		if err == nil && persist.IsEncrypted(data) {
+			err = fmt.Errorf("it is encrypted; start netkk with --encrypt to use it")
+		}
 	}
-	f, err := os.Create(fullPath)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't open ~/.netkk/%s; persistence will be limited to this session: %v", filepath.Base(fullPath), err)
+		return nil, fmt.Errorf("couldn't read %s; persistence will be limited to this session: %v", persistenceDocName(key, userSupplied), err)
 	}
-	return f, nil
+	return data, nil
 }
 
-func openPersistenceFile(userSupplied, defaultIfNone string) (*os.File, error) {
-	fullPath, err := getPersistencePath(userSupplied, defaultIfNone)
+// writePersistenceDoc replaces the contents of the persistence document with
+// the given key, or of the file given by the user instead of it if
+// userSupplied is non-empty, with what write writes.
+func (state *consoleState) writePersistenceDoc(key, userSupplied string, write func(w io.Writer) error) error {
+	doc, err := state.userStore.CreateAlt(key, userSupplied)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("couldn't open %s; persistence will be limited to this session: %v", persistenceDocName(key, userSupplied), err)
+	}
+	defer doc.Close()
+
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+	if codecs := state.persistenceCodecs(); len(codecs) > 0 {
+		for _, c := range codecs {
+			doc.UseCodec(c)
+		}
+		err = doc.Encode(buf.Bytes())
+	} else {
+		_, err = doc.Write(buf.Bytes())
 	}
-	f, err := os.Open(fullPath)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't open ~/.netkk/%s; persistence will be limited to this session: %v", filepath.Base(fullPath), err)
+		return err
 	}
-	return f, nil
+	return doc.Close()
 }
 
-func getPersistencePath(userSupplied, defaultIfNone string) (string, error) {
-	var fullPath string
-	if userSupplied != "" {
-		fullPath = userSupplied
-	} else {
-		homedir, err := os.UserHomeDir()
-		if err != nil {
-			return "", fmt.Errorf("couldn't get homedir; persistence will be limited to this session: %v", err)
+// persistenceKeySafe gives name with every character that can't be used in the
+// key of a persistence document replaced.
+func persistenceKeySafe(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '.' || r == '_' {
+			return r
 		}
-		appDir := filepath.Join(homedir, ".netkk")
-		err = os.Mkdir(appDir, os.ModeDir|0755)
-		if err != nil && !os.IsExist(err) {
-			return "", fmt.Errorf("couldn't create ~/.netkk; persistence will be limited to this session: %v", err)
-		}
-		fullPath = filepath.Join(appDir, defaultIfNone)
+		return '_'
+	}, name)
+}
+
+// persistenceDocName gives the name of a persistence document as it is shown
+// to the user.
+func persistenceDocName(key, userSupplied string) string {
+	if userSupplied != "" {
+		return userSupplied
 	}
-	return fullPath, nil
+	return "~/.netkk/" + key
 }
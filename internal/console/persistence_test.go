@@ -0,0 +1,122 @@
+package console
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+
+	"dekarrin/netkarkat/internal/persist"
+	"dekarrin/netkarkat/internal/verbosity"
+)
+
+func Test_consoleState_stateFile(t *testing.T) {
+	testCases := []struct {
+		name           string
+		legacyMacroset *string
+		writeProfile   string
+		writeTarget    string
+		readProfile    string
+		readTarget     string
+		expectMacroset string
+		expectFormat   string
+	}{
+		{
+			name:           "same target",
+			writeTarget:    "tcp-10.0.0.1-502",
+			readTarget:     "tcp-10.0.0.1-502",
+			expectMacroset: "written",
+			expectFormat:   displayDump,
+		},
+		{
+			name:           "different target",
+			writeTarget:    "tcp-10.0.0.1-502",
+			readTarget:     "tcp-10.0.0.2-6379",
+			expectMacroset: "",
+			expectFormat:   displayHex,
+		},
+		{
+			name:           "profile takes precedence over target",
+			writeProfile:   "gateway",
+			writeTarget:    "tcp-10.0.0.1-502",
+			readProfile:    "gateway",
+			readTarget:     "tcp-10.0.0.2-502",
+			expectMacroset: "written",
+			expectFormat:   displayDump,
+		},
+		{
+			name:           "falls back to legacy state",
+			legacyMacroset: strPtr("legacy"),
+			writeTarget:    "tcp-10.0.0.1-502",
+			readTarget:     "tcp-10.0.0.2-6379",
+			expectMacroset: "legacy",
+			expectFormat:   displayHex,
+		},
+		{
+			name:           "legacy state is not used if target has state",
+			legacyMacroset: strPtr("legacy"),
+			writeTarget:    "tcp-10.0.0.1-502",
+			readTarget:     "tcp-10.0.0.1-502",
+			expectMacroset: "written",
+			expectFormat:   displayDump,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := persist.NewMemoryStore()
+			if tc.legacyMacroset != nil {
+				var buf bytes.Buffer
+				gob.NewEncoder(&buf).Encode(*tc.legacyMacroset)
+				doc, err := store.Create(stateKey)
+				if err != nil {
+					t.Fatalf("prep step: couldn't create legacy state: %v", err)
+				}
+				doc.Write(buf.Bytes())
+				doc.Close()
+			}
+
+			writer := newConsoleState(nil, verbosity.OutputWriter{}, "", true, false, "", Options{Profile: tc.writeProfile, Target: tc.writeTarget})
+			writer.userStore = store
+			writer.macros.SetCurrentMacroset("written")
+			writer.displayFormat = displayDump
+			writer.writeStateFile()
+
+			sut := newConsoleState(nil, verbosity.OutputWriter{}, "", true, false, "", Options{Profile: tc.readProfile, Target: tc.readTarget})
+			sut.userStore = store
+			sut.loadStateFile()
+
+			// check the values
+			if actual := sut.macros.GetCurrentMacroset(); actual != tc.expectMacroset {
+				t.Errorf("expected current macroset to be %q but got: %q", tc.expectMacroset, actual)
+			}
+			if sut.displayFormat != tc.expectFormat {
+				t.Errorf("expected display format to be %q but got: %q", tc.expectFormat, sut.displayFormat)
+			}
+		})
+	}
+}
+
+func Test_persistenceKeySafe(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{input: "target-tcp-10.0.0.1-502", expected: "target-tcp-10.0.0.1-502"},
+		{input: "target-udp-::1-53", expected: "target-udp-__1-53"},
+		{input: "profile-my/gateway", expected: "profile-my_gateway"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			actual := persistenceKeySafe(tc.input)
+
+			if actual != tc.expected {
+				t.Errorf("expected %q but got: %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
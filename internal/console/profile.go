@@ -0,0 +1,118 @@
+package console
+
+import (
+	"fmt"
+	"strings"
+
+	"dekarrin/netkarkat/internal/misc"
+)
+
+func executeCommandProfile(state *consoleState, argv []string) (output string, err error) {
+	var name string
+	_, err = parseCommandFlags(
+		argv,
+		nil,
+		posArgActions{
+			{
+				parse: func(i *int, argv []string) error {
+					name = strings.TrimPrefix(argv[*i], "@")
+					if name == "" {
+						return fmt.Errorf("blank profile name is not allowed")
+					}
+					return nil
+				},
+				optional: true,
+			},
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if name == "" {
+		// do not mask behind verbosity as user specifically requested this
+		if state.profileName == "" {
+			return "(no profile)", nil
+		}
+		return state.profileName, nil
+	}
+	return state.useProfile(name)
+}
+
+func executeCommandListprofiles(state *consoleState, argv []string) (output string, err error) {
+	names := state.profiles.Names()
+	if len(names) < 1 {
+		return state.out.InfoSprintf("No profiles are defined."), nil
+	}
+
+	var sb strings.Builder
+	for _, n := range names {
+		p := state.profiles.Profiles[n]
+		if n == state.profileName {
+			sb.WriteString("* ")
+		} else {
+			sb.WriteString("  ")
+		}
+		sb.WriteString(n)
+		if target := p.Target(); target != "" {
+			sb.WriteString(" (" + target + ")")
+		}
+		if p.Description != "" {
+			sb.WriteString(" - " + p.Description)
+		}
+		sb.WriteRune('\n')
+	}
+	return sb.String(), nil
+}
+
+// useProfile switches to the profile with the given name. A new connection is
+// opened with its settings, and its macros file, macroset, and display
+// settings are put into effect.
+func (state *consoleState) useProfile(name string) (output string, err error) {
+	if state.switchProfile == nil {
+		return "", fmt.Errorf("profiles cannot be switched in this session")
+	}
+	if _, err := state.profiles.Get(name); err != nil {
+		return "", err
+	}
+
+	// jobs and delayed replies send on the current connection, which is
+	// closed by the switch
+	if count := state.jobs.cancelAll(); count > 0 {
+		state.out.Info("Canceled %s", misc.CountOf("job", "jobs", count))
+	}
+	if count := state.responder.CancelReplies(); count > 0 {
+		state.out.Info("Canceled %s", misc.CountOf("pending reply", "pending replies", count))
+	}
+
+	conn, p, err := state.switchProfile(name)
+	if conn != nil {
+		state.sendMtx.Lock()
+		state.connection = conn
+		state.sendMtx.Unlock()
+	}
+	if err != nil {
+		return "", fmt.Errorf("couldn't switch to profile %q: %v", name, err)
+	}
+	state.profileName = name
+
+	if p.Macrofile != state.macrofile {
+		state.macrofile = p.Macrofile
+		state.loadJournalFile(state.loadMacrosFile())
+	}
+
+	// history and state are kept separately for each profile
+	if state.interactive && !state.globalHistory {
+		state.prompt.ClearHistory()
+		state.histUnsaved = nil
+		state.loadHistFile()
+	}
+	state.loadStateFile()
+	state.startMacroset = p.Macroset
+	state.startDisplayFormat = p.Format
+	state.useStartSettings()
+	state.delimitWithSemicolon = p.Multiline
+	state.showPromptText = !p.NoPrompt
+
+	return state.out.InfoSprintf("Switched to profile %q.", name), nil
+}
@@ -0,0 +1,162 @@
+package console
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+
+	"dekarrin/netkarkat/internal/misc"
+	"dekarrin/netkarkat/internal/scrollback"
+)
+
+// how often RECVFILE checks whether the connection has closed while it is
+// waiting for data.
+const recvfilePollInterval = 100 * time.Millisecond
+
+// minimum amount of time between progress messages from RECVFILE.
+const recvfileProgressInterval = 500 * time.Millisecond
+
+func executeCommandRecvfile(state *consoleState, line string, cmdName string) (output string, err error) {
+	var appendToFile, quiet bool
+	var maxBytes int64
+	var idleTimeout time.Duration
+
+	argv, rest := splitLineOptions(line, "nt")
+	_, err = parseCommandFlags(
+		argv,
+		flagActions{
+			'a': func(i *int, argv []string) error {
+				appendToFile = true
+				return nil
+			},
+			'q': func(i *int, argv []string) error {
+				quiet = true
+				return nil
+			},
+			'n': byteCountFlag("-n", &maxBytes, true),
+			't': func(i *int, argv []string) error {
+				if *i+1 >= len(argv) {
+					return fmt.Errorf("-t requires an argument")
+				}
+				*i++
+				d, err := time.ParseDuration(argv[*i])
+				if err != nil || d <= 0 {
+					return fmt.Errorf("-t must be given a duration such as 500ms or 5s")
+				}
+				idleTimeout = d
+				return nil
+			},
+		},
+		nil,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	filename, patternStr := nextToken(rest)
+	if filename == "" {
+		return "", fmt.Errorf("need to give file to save received data to")
+	}
+	var pattern []byte
+	if patternStr != "" {
+		pattern, err = state.parseLineToBytes(patternStr)
+		if err != nil {
+			return "", fmt.Errorf("bad terminating pattern: %v", err)
+		}
+	}
+
+	if state.dryRun {
+		// nothing is ever received in a dry run, so waiting for data would
+		// never end
+		state.out.Info("[+%s] RECVFILE to %q (no data is received in dry run; nothing saved)", formatElapsed(state.dryRunElapsed), filename)
+		return "", nil
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendToFile {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(filename, flags, 0644)
+	if err != nil {
+		return "", fmt.Errorf("could not open file: %v", err)
+	}
+	defer f.Close()
+
+	// data that arrived before RECVFILE was run is saved first
+	pending, sub, mark := state.pendingReceived()
+	defer sub.Close()
+	next := func() (scrollback.Entry, bool) {
+		for {
+			var e scrollback.Entry
+			if len(pending) > 0 {
+				e, pending = pending[0], pending[1:]
+			} else if next, ok := sub.Next(recvfilePollInterval); ok {
+				e = next
+			} else {
+				return e, false
+			}
+			if e.Index > mark {
+				mark = e.Index
+				state.captureMark = e.Index
+				return e, true
+			}
+		}
+	}
+
+	if !quiet {
+		state.out.Info("Saving received data to %q...", filename)
+	}
+
+	var written int64
+	var tail []byte
+	var reason string
+	lastActivity := time.Now()
+	lastProgress := time.Now()
+	for reason == "" {
+		e, ok := next()
+		if !ok {
+			if state.connection.IsClosed() {
+				reason = "connection closed"
+			} else if idleTimeout > 0 && time.Since(lastActivity) >= idleTimeout {
+				reason = fmt.Sprintf("no data for %v", idleTimeout)
+			}
+			continue
+		}
+		lastActivity = time.Now()
+
+		data := e.Data
+		if len(pattern) > 0 {
+			searched := append(tail, data...)
+			if idx := bytes.Index(searched, pattern); idx >= 0 {
+				data = data[:idx+len(pattern)-len(tail)]
+				reason = "terminating pattern received"
+			} else if len(searched) >= len(pattern) {
+				tail = append([]byte(nil), searched[len(searched)-len(pattern)+1:]...)
+			} else {
+				tail = searched
+			}
+		}
+		if maxBytes > 0 && written+int64(len(data)) >= maxBytes {
+			data = data[:maxBytes-written]
+			reason = fmt.Sprintf("reached %s", misc.CountOf("byte", "bytes", int(maxBytes)))
+		}
+
+		n, err := f.Write(data)
+		written += int64(n)
+		if err != nil {
+			return "", fmt.Errorf("could not write file after %s: %v", misc.CountOf("byte", "bytes", int(written)), err)
+		}
+
+		if !quiet && reason == "" && time.Since(lastProgress) >= recvfileProgressInterval {
+			if maxBytes > 0 {
+				state.out.Info("Received %d/%d bytes (%d%%)", written, maxBytes, written*100/maxBytes)
+			} else {
+				state.out.Info("Received %s", misc.CountOf("byte", "bytes", int(written)))
+			}
+			lastProgress = time.Now()
+		}
+	}
+
+	return state.out.InfoSprintf("Saved %s to %q; stopped because %s", misc.CountOf("byte", "bytes", int(written)), filename, reason), nil
+}
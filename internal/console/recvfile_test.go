@@ -0,0 +1,122 @@
+package console
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"dekarrin/netkarkat/internal/driver"
+	"dekarrin/netkarkat/internal/scrollback"
+	"dekarrin/netkarkat/internal/verbosity"
+)
+
+func Test_executeCommandRecvfile(t *testing.T) {
+	type entry struct {
+		dir  scrollback.Direction
+		data string
+	}
+
+	testCases := []struct {
+		name        string
+		before      []entry // in the scrollback before RECVFILE is run
+		args        string
+		pattern     string // given after the file
+		dryRun      bool
+		captureMark int
+		expected    string
+		expectFile  bool
+		expectErr   bool
+	}{
+		{
+			name:       "data received before is saved",
+			before:     []entry{{scrollback.Received, "abc"}, {scrollback.Received, "def"}},
+			args:       "-t 50ms",
+			expected:   "abcdef",
+			expectFile: true,
+		},
+		{
+			name:       "data received before last send is not saved",
+			before:     []entry{{scrollback.Received, "abc"}, {scrollback.Sent, "x"}, {scrollback.Received, "def"}},
+			args:       "-t 50ms",
+			expected:   "def",
+			expectFile: true,
+		},
+		{
+			name:        "data already captured is not saved",
+			before:      []entry{{scrollback.Received, "abc"}, {scrollback.Received, "def"}},
+			captureMark: 1,
+			args:        "-t 50ms",
+			expected:    "def",
+			expectFile:  true,
+		},
+		{
+			name:       "stops at terminating bytes",
+			before:     []entry{{scrollback.Received, "ab"}, {scrollback.Received, "cEN"}, {scrollback.Received, "Dxyz"}},
+			args:       "-t 1h",
+			pattern:    "END",
+			expected:   "abcEND",
+			expectFile: true,
+		},
+		{
+			name:       "stops at count",
+			before:     []entry{{scrollback.Received, "abc"}, {scrollback.Received, "def"}},
+			args:       "-n 4 -t 1h",
+			expected:   "abcd",
+			expectFile: true,
+		},
+		{
+			name:       "dry run does not wait",
+			before:     []entry{{scrollback.Received, "abc"}},
+			args:       "",
+			dryRun:     true,
+			expectFile: false,
+		},
+		{
+			name:      "bad idle timeout",
+			args:      "-t 0s",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "netkk-test")
+			if err != nil {
+				t.Fatalf("prep step: couldn't create temp dir: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			filename := filepath.Join(dir, "out.bin")
+
+			state := newConsoleState(driver.OpenDryRunConnection("test"), verbosity.OutputWriter{Verbosity: verbosity.Silent}, "", false, false, "", Options{DryRun: tc.dryRun})
+			for _, e := range tc.before {
+				state.scrollback.Add(e.dir, "test", []byte(e.data))
+			}
+			state.captureMark = tc.captureMark
+
+			_, err = executeCommandRecvfile(state, "RECVFILE "+tc.args+" \""+filename+"\" "+tc.pattern, "RECVFILE")
+
+			// check for error
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				t.Fatalf("expected an error but nil error was returned")
+			}
+
+			// check the value
+			actual, readErr := ioutil.ReadFile(filename)
+			if !tc.expectFile {
+				if readErr == nil {
+					t.Errorf("expected file to not be created")
+				}
+				return
+			}
+			if readErr != nil {
+				t.Fatalf("couldn't read file: %v", readErr)
+			}
+			if string(actual) != tc.expected {
+				t.Errorf("expected %q but got: %q", tc.expected, string(actual))
+			}
+		})
+	}
+}
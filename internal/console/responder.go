@@ -0,0 +1,218 @@
+package console
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"dekarrin/netkarkat/internal/misc"
+	"dekarrin/netkarkat/internal/responder"
+	"dekarrin/netkarkat/internal/verbosity"
+)
+
+// parseResponderRule parses the text given to ON into a rule. The text is the
+// options, followed by the pattern, the keyword REPLY, and the bytes to reply
+// with. The reply is parsed immediately, so later changes to macros or
+// variables used in it do not affect the rule.
+func (state *consoleState) parseResponderRule(text string) (responder.Rule, error) {
+	var rule responder.Rule
+	var useRegex bool
+
+	argv, rest := splitLineOptions("ON "+text, "dn")
+	_, err := parseCommandFlags(
+		argv,
+		flagActions{
+			'r': func(i *int, argv []string) error {
+				useRegex = true
+				return nil
+			},
+			'1': func(i *int, argv []string) error {
+				rule.Limit = 1
+				return nil
+			},
+			'n': func(i *int, argv []string) error {
+				if *i+1 >= len(argv) {
+					return fmt.Errorf("-n requires an argument")
+				}
+				*i++
+				n, err := strconv.Atoi(argv[*i])
+				if err != nil || n < 1 {
+					return fmt.Errorf("-n must be given a number greater than 0")
+				}
+				rule.Limit = n
+				return nil
+			},
+			'd': func(i *int, argv []string) error {
+				if *i+1 >= len(argv) {
+					return fmt.Errorf("-d requires an argument")
+				}
+				*i++
+				d, err := time.ParseDuration(argv[*i])
+				if err != nil || d < 0 {
+					return fmt.Errorf("-d must be given a duration such as 250ms or 1s")
+				}
+				rule.Delay = d
+				return nil
+			},
+		},
+		nil,
+	)
+	if err != nil {
+		return rule, err
+	}
+
+	patternStr, replyStr, err := responder.SplitRule(rest)
+	if err != nil {
+		return rule, err
+	}
+	if useRegex {
+		rule.Regex, err = regexp.Compile(patternStr)
+		if err != nil {
+			return rule, fmt.Errorf("bad regular expression: %v", err)
+		}
+	} else {
+		rule.Pattern, err = state.parseLineToBytes(patternStr)
+		if err != nil {
+			return rule, fmt.Errorf("bad pattern: %v", err)
+		}
+		if len(rule.Pattern) < 1 {
+			return rule, fmt.Errorf("pattern does not contain any bytes to match")
+		}
+	}
+	rule.Reply, err = state.parseLineToBytes(replyStr)
+	if err != nil {
+		return rule, fmt.Errorf("bad reply: %v", err)
+	}
+	rule.Text = strings.TrimSpace(text)
+	return rule, nil
+}
+
+// LoadResponderFile adds the rules in the file to resp. Each line that is not
+// blank or a comment is one rule, given the same way as to ON; the ON at the
+// start of the line may be left out. The rules are parsed before any session
+// is started, so they cannot use macros.
+func LoadResponderFile(filename string, resp *responder.Responder, out verbosity.OutputWriter) error {
+	state := newConsoleState(nil, out, "", false, false, "", Options{Responder: resp})
+	return state.loadResponderFile(filename)
+}
+
+func (state *consoleState) loadResponderFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("could not open responder file: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	count := 0
+	for scanner.Scan() {
+		lineNum++
+		line := normalizeLine(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if first, rest := nextToken(line); strings.EqualFold(first, "ON") {
+			line = strings.TrimSpace(rest)
+		}
+		rule, err := state.parseResponderRule(line)
+		if err != nil {
+			return fmt.Errorf("%q:%d: %v", filename, lineNum, err)
+		}
+		state.responder.Add(rule)
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%q:%d: %v", filename, lineNum+1, err)
+	}
+	state.out.Debug("Loaded %s from %q", misc.CountOf("responder rule", "responder rules", count), filename)
+	return nil
+}
+
+// startResponding makes the replies of responder rules be sent in this
+// session. They are sent the same way as other data, after the same delay and
+// jitter as the statements of a script.
+func (state *consoleState) startResponding() {
+	state.responder.SetSender(state.sendReply, state.nextStatementDelay)
+}
+
+func (state *consoleState) sendReply(r responder.Rule) {
+	out := state.asyncOutput()
+	if err := state.send(r.Reply); err != nil {
+		out.Error("Responder rule %d could not reply: %v", r.ID, err)
+		return
+	}
+	out.Debug("Responder rule %d replied with %s", r.ID, misc.CountOf("byte", "bytes", len(r.Reply)))
+}
+
+func executeCommandOn(state *consoleState, line string, cmdName string) (output string, err error) {
+	_, text := nextToken(line)
+	rule, err := state.parseResponderRule(text)
+	if err != nil {
+		return "", err
+	}
+	id := state.responder.Add(rule)
+	return state.out.InfoSprintf("Added rule %d", id), nil
+}
+
+func executeCommandRules(state *consoleState, argv []string) (output string, err error) {
+	_, err = parseCommandFlags(argv, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	rules := state.responder.Rules()
+	if len(rules) < 1 {
+		return "(no rules defined)", nil
+	}
+	var lines []string
+	for _, r := range rules {
+		lines = append(lines, r.Describe())
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func executeCommandOff(state *consoleState, argv []string) (output string, err error) {
+	var all bool
+	id := -1
+	_, err = parseCommandFlags(
+		argv,
+		flagActions{
+			'a': func(i *int, argv []string) error {
+				all = true
+				return nil
+			},
+		},
+		posArgActions{
+			{
+				parse: func(i *int, argv []string) error {
+					n, err := strconv.Atoi(argv[*i])
+					if err != nil {
+						return fmt.Errorf("%q is not a valid rule number", argv[*i])
+					}
+					id = n
+					return nil
+				},
+				optional: true,
+			},
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if all {
+		count := state.responder.Clear()
+		return state.out.InfoSprintf("Removed %s", misc.CountOf("rule", "rules", count)), nil
+	}
+	if id < 0 {
+		return "", fmt.Errorf("need to give rule number to remove or -a for all rules")
+	}
+	if !state.responder.Remove(id) {
+		return "", fmt.Errorf("no rule %d is defined", id)
+	}
+	return state.out.InfoSprintf("Removed rule %d", id), nil
+}
@@ -0,0 +1,793 @@
+package console
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"dekarrin/netkarkat/internal/bytefuncs"
+	"dekarrin/netkarkat/internal/driver"
+	"dekarrin/netkarkat/internal/verbosity"
+)
+
+// maximum number of scripts that can be nested by INCLUDE.
+const maxIncludeDepth = 64
+
+// maximum number of procedure calls that can be nested by CALL.
+const maxCallDepth = 256
+
+// scriptKeywords are the words that begin a control-flow statement in a script
+// rather than a normal line of input.
+var scriptKeywords = map[string]bool{
+	"IF":       true,
+	"ELSE":     true,
+	"END":      true,
+	"LOOP":     true,
+	"WHILE":    true,
+	"BREAK":    true,
+	"CONTINUE": true,
+	"INCLUDE":  true,
+	"PROC":     true,
+	"CALL":     true,
+	"RETURN":   true,
+}
+
+// ScriptError is an error that occurred while executing a script. It gives the
+// file and line of the statement that caused it. If the statement was in a
+// script that was included by another, File is the included script.
+type ScriptError struct {
+	// File is the name of the script file. It will be empty if the script was
+	// not read from a file.
+	File string
+
+	// Line is the line number that the statement starts on.
+	Line int
+
+	// Err is the error that occurred.
+	Err error
+}
+
+func (se ScriptError) Error() string {
+	if se.File == "" {
+		return fmt.Sprintf("line %d: %v", se.Line, se.Err)
+	}
+	return fmt.Sprintf("%q:%d: %v", se.File, se.Line, se.Err)
+}
+
+// scriptStmt is a single statement read from a script.
+type scriptStmt struct {
+	file string
+	line int
+	text string
+}
+
+func (st scriptStmt) errorf(format string, a ...interface{}) error {
+	return ScriptError{File: st.file, Line: st.line, Err: fmt.Errorf(format, a...)}
+}
+
+func (st scriptStmt) wrapError(err error) error {
+	if _, ok := err.(ScriptError); ok {
+		return err
+	}
+	return ScriptError{File: st.file, Line: st.line, Err: err}
+}
+
+// scriptNode is a statement along with any statements in the block that it
+// begins.
+type scriptNode struct {
+	stmt scriptStmt
+
+	// keyword is the control-flow keyword of the statement, or empty if it is
+	// a normal line of input.
+	keyword string
+
+	// arg is everything in the statement after the keyword.
+	arg string
+
+	// branches holds each condition and body of an IF.
+	branches []scriptBranch
+
+	// body holds the statements in a LOOP, WHILE, or PROC.
+	body []scriptNode
+}
+
+type scriptBranch struct {
+	stmt scriptStmt
+
+	// cond is the condition of the branch. It is empty for ELSE.
+	cond string
+	body []scriptNode
+}
+
+type scriptProc struct {
+	name   string
+	params []string
+	body   []scriptNode
+}
+
+type scriptFlow int
+
+const (
+	flowNext scriptFlow = iota
+	flowBreak
+	flowContinue
+	flowReturn
+)
+
+// scriptRunner executes scripts, along with any scripts they include, on a
+// single consoleState.
+type scriptRunner struct {
+	state        *consoleState
+	procs        map[string]*scriptProc
+	includeDepth int
+	callDepth    int
+	loopDepth    int
+	executed     int
+}
+
+// ExecuteScript executes script input from the given reader. INCLUDE
+// statements in it are resolved relative to the current directory.
+// It ignores comments and, if delimitWithSemicolon is set, considers a
+// semicolon to denote the end of a statement.
+//
+// Returns the number of lines processed successfully.
+//
+// If an error is encountered, the number of lines before the statement that
+// caused it is returned along with the error that was encountered.
+//
+// Each statement's result is output as an INFO-level message in the given
+// OutputWriter, or as a result event if opts.Events is set.
+//
+// For each statement, the following is done:
+//
+// If it is a command to netkk, that command is exectued and the output is returned. Otherwise, it is forwarded
+// to the connected server and that output is returned.
+//
+// Everything after a "#" or a "//" is ignored.
+// If the provided line is empty after removing comments and trimming, no action is taken and the empty string
+// is returned.
+func ExecuteScript(f io.Reader, conn driver.Connection, out verbosity.OutputWriter, version string, delimitWithSemicolon bool, macrofile string, opts Options) (lines int, err error) {
+	runner := newScriptRunner(conn, out, version, delimitWithSemicolon, macrofile, opts)
+	defer runner.state.jobs.cancelAll()
+	return runner.runScript(f, "")
+}
+
+// ExecuteScriptFile executes the script in the file with the given name the
+// same way as ExecuteScript. INCLUDE statements in it are resolved relative
+// to the directory the file is in. Errors that occur while executing the
+// script will be a ScriptError.
+func ExecuteScriptFile(filename string, conn driver.Connection, out verbosity.OutputWriter, version string, delimitWithSemicolon bool, macrofile string, opts Options) (lines int, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	runner := newScriptRunner(conn, out, version, delimitWithSemicolon, macrofile, opts)
+	defer runner.state.jobs.cancelAll()
+	return runner.runScript(f, filename)
+}
+
+// newScriptRunner creates a scriptRunner on a new non-interactive session that
+// is set up the way that scripts are run in.
+func newScriptRunner(conn driver.Connection, out verbosity.OutputWriter, version string, delimitWithSemicolon bool, macrofile string, opts Options) *scriptRunner {
+	state := newConsoleState(conn, out, version, false, delimitWithSemicolon, macrofile, opts)
+	state.loadMacrosFile()
+	state.useStartSettings()
+	state.startResponding()
+	return &scriptRunner{state: state}
+}
+
+// runScript reads all statements from r and executes them. Returns the
+// number of lines processed successfully; if an error occurs, this is the
+// number of lines before the statement that caused it.
+func (sr *scriptRunner) runScript(r io.Reader, filename string) (lines int, err error) {
+	stmts, lines, err := readScriptStatements(r, filename, sr.state.delimitWithSemicolon)
+	if err != nil {
+		return 0, err
+	}
+	nodes, end, err := parseScriptNodes(stmts, 0, false)
+	if err != nil {
+		return 0, err
+	}
+	if end < len(stmts) {
+		kw, _ := splitScriptKeyword(stmts[end].text)
+		return 0, stmts[end].errorf("%s without matching IF, LOOP, WHILE, or PROC", kw)
+	}
+
+	prevLoopDepth := sr.loopDepth
+	sr.loopDepth = 0
+	defer func() { sr.loopDepth = prevLoopDepth }()
+
+	for _, n := range nodes {
+		flow, err := sr.execNode(n)
+		if err != nil {
+			return n.stmt.line - 1, n.stmt.wrapError(err)
+		}
+		// RETURN at the top level of a script just ends that script
+		if flow == flowReturn {
+			break
+		}
+	}
+	return lines, nil
+}
+
+// readScriptStatements splits the script into statements. Comments and blank
+// lines are removed. If delimitWithSemicolon is set, each statement continues
+// until a line that ends with a semicolon, which is removed. Returns the
+// number of lines that were read.
+func readScriptStatements(r io.Reader, filename string, delimitWithSemicolon bool) (stmts []scriptStmt, lines int, err error) {
+	scanner := bufio.NewScanner(r)
+	cmd := ""
+	cmdLine := 0
+	finish := func() {
+		if delimitWithSemicolon {
+			cmd = strings.TrimSuffix(cmd, ";")
+		}
+		stmts = append(stmts, scriptStmt{file: filename, line: cmdLine, text: strings.TrimSpace(cmd)})
+		cmd = ""
+	}
+
+	for scanner.Scan() {
+		lines++
+		normalPartial := normalizeLine(scanner.Text())
+		if normalPartial == "" {
+			continue
+		}
+		if cmd == "" {
+			cmdLine = lines
+		}
+		cmd += normalPartial
+		if delimitWithSemicolon && !strings.HasSuffix(cmd, ";") {
+			cmd += "\n"
+		} else {
+			finish()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, lines, ScriptError{File: filename, Line: lines + 1, Err: err}
+	}
+
+	// need to include last statement in case it did not end with a semi:
+	if cmd != "" {
+		finish()
+	}
+	return stmts, lines, nil
+}
+
+// splitScriptKeyword gives the keyword that starts the statement, in upper
+// case, and the text after it. If the statement does not start with a keyword,
+// keyword will be empty and rest will be the entire statement.
+func splitScriptKeyword(text string) (keyword string, rest string) {
+	first, remaining := nextToken(text)
+	if scriptKeywords[strings.ToUpper(first)] {
+		return strings.ToUpper(first), strings.TrimSpace(remaining)
+	}
+	return "", text
+}
+
+// parseScriptNodes builds the nodes for the statements starting at stmts[pos].
+// If inBlock is set, parsing stops at the first END or ELSE that is not part of
+// a nested block; end is its index, or len(stmts) if there was none.
+func parseScriptNodes(stmts []scriptStmt, pos int, inBlock bool) (nodes []scriptNode, end int, err error) {
+	for pos < len(stmts) {
+		st := stmts[pos]
+		kw, arg := splitScriptKeyword(st.text)
+		switch kw {
+		case "END", "ELSE":
+			if !inBlock {
+				return nodes, pos, nil
+			}
+			if kw == "END" && arg != "" {
+				return nil, 0, st.errorf("unexpected %q after END", arg)
+			}
+			return nodes, pos, nil
+		case "IF":
+			node := scriptNode{stmt: st, keyword: kw}
+			branchStmt := st
+			cond := arg
+			if cond == "" {
+				return nil, 0, st.errorf("IF requires a condition")
+			}
+			for {
+				body, next, err := parseScriptNodes(stmts, pos+1, true)
+				if err != nil {
+					return nil, 0, err
+				}
+				node.branches = append(node.branches, scriptBranch{stmt: branchStmt, cond: cond, body: body})
+				if next >= len(stmts) {
+					return nil, 0, st.errorf("IF is missing END")
+				}
+				pos = next
+				endKw, endArg := splitScriptKeyword(stmts[next].text)
+				if endKw == "END" {
+					break
+				}
+				if cond == "" {
+					return nil, 0, stmts[next].errorf("ELSE cannot come after ELSE")
+				}
+				branchStmt = stmts[next]
+				if endArg == "" {
+					cond = ""
+				} else if ifKw, ifArg := splitScriptKeyword(endArg); ifKw == "IF" && ifArg != "" {
+					cond = ifArg
+				} else {
+					return nil, 0, stmts[next].errorf("ELSE must be followed by nothing or by IF and a condition")
+				}
+			}
+			nodes = append(nodes, node)
+		case "LOOP", "WHILE", "PROC":
+			if arg == "" {
+				if kw == "PROC" {
+					return nil, 0, st.errorf("PROC requires a name")
+				} else if kw == "LOOP" {
+					return nil, 0, st.errorf("LOOP requires a count")
+				}
+				return nil, 0, st.errorf("WHILE requires a condition")
+			}
+			body, next, err := parseScriptNodes(stmts, pos+1, true)
+			if err != nil {
+				return nil, 0, err
+			}
+			if next >= len(stmts) {
+				return nil, 0, st.errorf("%s is missing END", kw)
+			}
+			if endKw, _ := splitScriptKeyword(stmts[next].text); endKw != "END" {
+				return nil, 0, stmts[next].errorf("ELSE without matching IF")
+			}
+			pos = next
+			nodes = append(nodes, scriptNode{stmt: st, keyword: kw, arg: arg, body: body})
+		default:
+			nodes = append(nodes, scriptNode{stmt: st, keyword: kw, arg: arg})
+		}
+		pos++
+	}
+	return nodes, pos, nil
+}
+
+func (sr *scriptRunner) execNodes(nodes []scriptNode) (scriptFlow, error) {
+	for _, n := range nodes {
+		flow, err := sr.execNode(n)
+		if err != nil {
+			return flowNext, n.stmt.wrapError(err)
+		}
+		if flow != flowNext {
+			return flow, nil
+		}
+	}
+	return flowNext, nil
+}
+
+func (sr *scriptRunner) execNode(n scriptNode) (scriptFlow, error) {
+	state := sr.state
+	switch n.keyword {
+	case "":
+		if sr.executed > 0 {
+			state.statementDelay()
+		}
+		sr.executed++
+		cmdOutput, err := executeLine(state, n.stmt.text)
+		if err != nil {
+			return flowNext, err
+		}
+		if state.events != nil {
+			state.events.Result(n.stmt.text, cmdOutput)
+		} else {
+			showScriptLineOutput(state.out, cmdOutput)
+		}
+		return flowNext, nil
+	case "IF":
+		for _, b := range n.branches {
+			matched := true
+			if b.cond != "" {
+				var err error
+				matched, err = sr.evalCondition(b.cond)
+				if err != nil {
+					return flowNext, b.stmt.wrapError(err)
+				}
+			}
+			if matched {
+				return sr.execNodes(b.body)
+			}
+		}
+		return flowNext, nil
+	case "LOOP":
+		return sr.execLoop(n)
+	case "WHILE":
+		sr.loopDepth++
+		defer func() { sr.loopDepth-- }()
+		for {
+			ok, err := sr.evalCondition(n.arg)
+			if err != nil || !ok {
+				return flowNext, err
+			}
+			flow, err := sr.execNodes(n.body)
+			if err != nil {
+				return flowNext, err
+			}
+			if flow == flowBreak {
+				return flowNext, nil
+			} else if flow == flowReturn {
+				return flow, nil
+			}
+		}
+	case "BREAK", "CONTINUE":
+		if n.arg != "" {
+			return flowNext, fmt.Errorf("%s does not take any arguments", n.keyword)
+		}
+		if sr.loopDepth < 1 {
+			return flowNext, fmt.Errorf("%s is not inside of LOOP or WHILE", n.keyword)
+		}
+		if n.keyword == "BREAK" {
+			return flowBreak, nil
+		}
+		return flowContinue, nil
+	case "RETURN":
+		if n.arg != "" {
+			return flowNext, fmt.Errorf("RETURN does not take any arguments")
+		}
+		return flowReturn, nil
+	case "INCLUDE":
+		return flowNext, sr.execInclude(n)
+	case "PROC":
+		return flowNext, sr.defineProc(n)
+	case "CALL":
+		return flowNext, sr.execCall(n)
+	}
+	return flowNext, fmt.Errorf("%s without matching IF, LOOP, WHILE, or PROC", n.keyword)
+}
+
+// execLoop runs a LOOP, which takes a count and optionally the name of a
+// variable to set to the number of the current iteration, starting from 1.
+func (sr *scriptRunner) execLoop(n scriptNode) (scriptFlow, error) {
+	countStr, varName := nextToken(n.arg)
+	varName = strings.TrimSpace(varName)
+	countBytes, err := sr.state.parseLineToBytes(countStr)
+	if err != nil {
+		return flowNext, err
+	}
+	count, err := strconv.Atoi(string(countBytes))
+	if err != nil || count < 0 {
+		return flowNext, fmt.Errorf("LOOP count must be a number that is not negative")
+	}
+	if varName != "" {
+		if err := validateVariableName(varName); err != nil {
+			return flowNext, err
+		}
+	}
+
+	sr.loopDepth++
+	defer func() { sr.loopDepth-- }()
+	for i := 1; i <= count; i++ {
+		if varName != "" {
+			if err := sr.state.variables.set(varName, []byte(strconv.Itoa(i))); err != nil {
+				return flowNext, err
+			}
+		}
+		flow, err := sr.execNodes(n.body)
+		if err != nil {
+			return flowNext, err
+		}
+		if flow == flowBreak {
+			break
+		} else if flow == flowReturn {
+			return flow, nil
+		}
+	}
+	return flowNext, nil
+}
+
+func (sr *scriptRunner) execInclude(n scriptNode) error {
+	path, rest := nextToken(n.arg)
+	if path == "" {
+		return fmt.Errorf("INCLUDE requires a file")
+	}
+	if strings.TrimSpace(rest) != "" {
+		return fmt.Errorf("unexpected %q after file; surround the file with double quotes if it contains spaces", strings.TrimSpace(rest))
+	}
+	if !filepath.IsAbs(path) && n.stmt.file != "" {
+		path = filepath.Join(filepath.Dir(n.stmt.file), path)
+	}
+	if sr.includeDepth >= maxIncludeDepth {
+		return fmt.Errorf("scripts are included more than %d deep; check for a script that includes itself", maxIncludeDepth)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open included script: %v", err)
+	}
+	defer f.Close()
+
+	sr.includeDepth++
+	defer func() { sr.includeDepth-- }()
+	_, err = sr.runScript(f, path)
+	return err
+}
+
+func (sr *scriptRunner) defineProc(n scriptNode) error {
+	name, params, err := parseProcSignature(n.arg)
+	if err != nil {
+		return err
+	}
+	if sr.procs == nil {
+		sr.procs = make(map[string]*scriptProc)
+	}
+	sr.procs[strings.ToUpper(name)] = &scriptProc{name: name, params: params, body: n.body}
+	return nil
+}
+
+// parseProcSignature parses the name and parameters of a procedure given in
+// the form "name" or "name(param1, param2)".
+func parseProcSignature(sig string) (name string, params []string, err error) {
+	openIdx := strings.IndexRune(sig, '(')
+	if openIdx < 0 {
+		name = strings.TrimSpace(sig)
+	} else {
+		name = strings.TrimSpace(sig[:openIdx])
+		if !strings.HasSuffix(sig, ")") {
+			return "", nil, fmt.Errorf("parameter list for procedure %q is missing closing parenthesis", name)
+		}
+		paramList := strings.TrimSpace(sig[openIdx+1 : len(sig)-1])
+		if paramList != "" {
+			for _, p := range strings.Split(paramList, ",") {
+				p = strings.TrimSpace(p)
+				if err := validateVariableName(p); err != nil {
+					return "", nil, fmt.Errorf("procedure %q: %v", name, err)
+				}
+				params = append(params, p)
+			}
+		}
+	}
+	if err := validateVariableName(name); err != nil {
+		return "", nil, fmt.Errorf("invalid procedure name %q; names can only contain letters, numbers, and underscores", name)
+	}
+	return name, params, nil
+}
+
+// execCall runs a procedure. Each argument is parsed as bytes and given to the
+// procedure in the variable named for its parameter; once the procedure
+// returns, those variables are set back to what they were before the call.
+func (sr *scriptRunner) execCall(n scriptNode) error {
+	nameEnd := strings.IndexFunc(n.arg, func(r rune) bool {
+		return r == '(' || unicode.IsSpace(r)
+	})
+	name, argText := n.arg, ""
+	if nameEnd >= 0 {
+		name, argText = n.arg[:nameEnd], strings.TrimSpace(n.arg[nameEnd:])
+	}
+	if name == "" {
+		return fmt.Errorf("CALL requires a procedure")
+	}
+	proc, ok := sr.procs[strings.ToUpper(name)]
+	if !ok {
+		return fmt.Errorf("no procedure named %q has been defined", name)
+	}
+
+	var args [][]byte
+	if argText != "" {
+		var err error
+		args, err = sr.state.parseArgListToBytes(argText)
+		if err != nil {
+			return err
+		}
+	}
+	if len(args) != len(proc.params) {
+		return fmt.Errorf("procedure %q takes %d arguments but was given %d", proc.name, len(proc.params), len(args))
+	}
+	if sr.callDepth >= maxCallDepth {
+		return fmt.Errorf("procedure calls are nested more than %d deep", maxCallDepth)
+	}
+
+	vars := sr.state.variables
+	for idx, p := range proc.params {
+		if prev, wasSet := vars.get(p); wasSet {
+			defer vars.set(p, prev)
+		} else {
+			defer vars.unset(p)
+		}
+		if err := vars.set(p, args[idx]); err != nil {
+			return err
+		}
+	}
+
+	sr.callDepth++
+	prevLoopDepth := sr.loopDepth
+	sr.loopDepth = 0
+	defer func() {
+		sr.callDepth--
+		sr.loopDepth = prevLoopDepth
+	}()
+	_, err := sr.execNodes(proc.body)
+	return err
+}
+
+// evalCondition gives whether the condition of an IF or WHILE is true. A
+// condition is one of the following, optionally preceded by NOT:
+//
+//	DEFINED name - the variable is set.
+//	RECEIVED [-t timeout] [-r] pattern - the next received message contains
+//	the bytes, or matches the regular expression if -r is given.
+//	bytes == bytes - the two byte inputs are the same.
+//	bytes != bytes - the two byte inputs are different.
+func (sr *scriptRunner) evalCondition(cond string) (bool, error) {
+	first, rest := nextToken(cond)
+	rest = strings.TrimSpace(rest)
+	switch strings.ToUpper(first) {
+	case "NOT":
+		if rest == "" {
+			return false, fmt.Errorf("NOT requires a condition")
+		}
+		result, err := sr.evalCondition(rest)
+		return !result, err
+	case "DEFINED":
+		if rest == "" {
+			return false, fmt.Errorf("DEFINED requires the name of a variable")
+		}
+		_, ok := sr.state.variables.get(rest)
+		return ok, nil
+	case "RECEIVED":
+		return sr.evalReceived(cond)
+	}
+
+	runes := []rune(cond)
+	opIdx, negate := findComparison(runes)
+	if opIdx < 0 {
+		return false, fmt.Errorf("condition must compare bytes with == or !=, or be DEFINED or RECEIVED")
+	}
+	left, err := sr.state.parseLineToBytes(string(runes[:opIdx]))
+	if err != nil {
+		return false, err
+	}
+	right, err := sr.state.parseLineToBytes(string(runes[opIdx+2:]))
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(left, right) != negate, nil
+}
+
+// findComparison gives the index in runes of the first == or != that compares
+// two byte inputs, or -1 if there is none, along with whether it is !=. The
+// input is read the way parseByteInput reads it, so an operator in a quoted
+// span, an escape, a file token, or the arguments of a byte function, such as
+// base64 text, is not taken for the comparison.
+func findComparison(runes []rune) (idx int, negate bool) {
+	for i := 0; i < len(runes); i++ {
+		ch := runes[i]
+		switch {
+		case ch == '"':
+			i = skipQuoted(runes, i)
+		case ch == '\\':
+			i++
+		case ch == '@' && hasRunePrefix(runes, i, fileTokenPrefix):
+			i += len([]rune(fileTokenPrefix))
+			if i < len(runes) && runes[i] == '"' {
+				i = skipQuoted(runes, i)
+				continue
+			}
+			for i < len(runes) && !unicode.IsSpace(runes[i]) {
+				i++
+			}
+		case isFuncNameRune(ch):
+			nameEnd := i
+			for nameEnd < len(runes) && isFuncNameRune(runes[nameEnd]) {
+				nameEnd++
+			}
+			if nameEnd < len(runes) && runes[nameEnd] == '(' {
+				if _, ok := bytefuncs.Lookup(string(runes[i:nameEnd])); ok {
+					i = skipCallArgs(runes, nameEnd)
+					continue
+				}
+			}
+			i = nameEnd - 1
+		case (ch == '=' || ch == '!') && i+1 < len(runes) && runes[i+1] == '=':
+			return i, ch == '!'
+		}
+	}
+	return -1, false
+}
+
+// skipQuoted gives the index of the double quote that closes the one at
+// runes[open], or len(runes) if it is not closed.
+func skipQuoted(runes []rune, open int) int {
+	for i := open + 1; i < len(runes); i++ {
+		if runes[i] == '"' {
+			return i
+		}
+	}
+	return len(runes)
+}
+
+// skipCallArgs gives the index of the parenthesis that closes the argument
+// list of a byte function opened at runes[open], or len(runes) if it is not
+// closed. Nested calls and quoted spans in the arguments are skipped.
+func skipCallArgs(runes []rune, open int) int {
+	depth := 0
+	for i := open; i < len(runes); i++ {
+		switch runes[i] {
+		case '"':
+			i = skipQuoted(runes, i)
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(runes)
+}
+
+func (sr *scriptRunner) evalReceived(cond string) (bool, error) {
+	state := sr.state
+	useRegex := false
+	timeout := defaultCaptureTimeout
+	argv, pattern := splitLineOptions(cond, "t")
+	_, err := parseCommandFlags(
+		argv,
+		flagActions{
+			'r': func(i *int, argv []string) error {
+				useRegex = true
+				return nil
+			},
+			't': func(i *int, argv []string) error {
+				if *i+1 >= len(argv) {
+					return fmt.Errorf("-t requires an argument")
+				}
+				*i++
+				d, err := time.ParseDuration(argv[*i])
+				if err != nil || d < 0 {
+					return fmt.Errorf("-t must be given a duration such as 500ms or 5s")
+				}
+				timeout = d
+				return nil
+			},
+		},
+		nil,
+	)
+	if err != nil {
+		return false, err
+	}
+	if pattern == "" {
+		return false, fmt.Errorf("RECEIVED requires bytes to look for")
+	}
+
+	var match func([]byte) bool
+	if useRegex {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return false, fmt.Errorf("bad regular expression: %v", err)
+		}
+		match = re.Match
+	} else {
+		seq, err := state.parseLineToBytes(pattern)
+		if err != nil {
+			return false, err
+		}
+		match = func(data []byte) bool {
+			return bytes.Contains(data, seq)
+		}
+	}
+
+	if state.dryRun {
+		state.out.Info("[+%s] RECEIVED %s (no data is received in dry run; condition is false)", formatElapsed(state.dryRunElapsed), pattern)
+		return false, nil
+	}
+
+	// nothing arriving in time is not an error; it just means the condition
+	// is false
+	_, _, err = state.captureNext(func(data []byte) ([]byte, bool) {
+		return nil, match(data)
+	}, timeout)
+	return err == nil, nil
+}
@@ -0,0 +1,159 @@
+package console
+
+import (
+	"strings"
+	"testing"
+
+	"dekarrin/netkarkat/internal/driver"
+	"dekarrin/netkarkat/internal/verbosity"
+)
+
+func Test_parseScriptNodes(t *testing.T) {
+	testCases := []struct {
+		name            string
+		script          string
+		expectNodes     int
+		expectErrOnLine int
+	}{
+		{name: "plain statements", script: "SEND a;\nSEND b;", expectNodes: 2},
+		{name: "multi-line statement", script: "SEND a\nb;\nSEND c;", expectNodes: 2},
+		{name: "if with else if and else", script: "IF a == b;\nSEND a;\nELSE IF a != b;\nSEND b;\nELSE;\nSEND c;\nEND;", expectNodes: 1},
+		{name: "nested blocks", script: "LOOP 2;\nWHILE DEFINED x;\nIF a == a;\nBREAK;\nEND;\nEND;\nEND;", expectNodes: 1},
+		{name: "proc", script: "PROC p(a, b);\nSEND ${a};\nEND;\nCALL p(1, 2);", expectNodes: 2},
+		{name: "keywords are case-insensitive", script: "if a == a;\nend;", expectNodes: 1},
+		{name: "missing end", script: "SEND a;\nIF a == b;\nSEND b;", expectErrOnLine: 2},
+		{name: "end without block", script: "SEND a;\n\nEND;", expectErrOnLine: 3},
+		{name: "else without if", script: "LOOP 2;\nELSE;\nEND;", expectErrOnLine: 2},
+		{name: "else after else", script: "IF a == a;\nELSE;\nELSE;\nEND;", expectErrOnLine: 3},
+		{name: "if without condition", script: "IF;\nEND;", expectErrOnLine: 1},
+		{name: "loop without count", script: "SEND a;\nLOOP;\nEND;", expectErrOnLine: 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stmts, _, err := readScriptStatements(strings.NewReader(tc.script), "test.nk", true)
+			if err != nil {
+				t.Fatalf("could not read statements: %v", err)
+			}
+
+			nodes, end, err := parseScriptNodes(stmts, 0, false)
+			if err == nil && end < len(stmts) {
+				err = stmts[end].errorf("unmatched %s", stmts[end].text)
+			}
+
+			// check for error
+			if tc.expectErrOnLine > 0 {
+				if err == nil {
+					t.Fatalf("expected an error but nil error was returned")
+				}
+				se, ok := err.(ScriptError)
+				if !ok {
+					t.Fatalf("expected a ScriptError but got: %v", err)
+				}
+				if se.Line != tc.expectErrOnLine {
+					t.Errorf("expected error on line %d but got: %v", tc.expectErrOnLine, se)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("returned an error: %v", err)
+			}
+
+			// check the value
+			if len(nodes) != tc.expectNodes {
+				t.Errorf("expected %d nodes but got %d", tc.expectNodes, len(nodes))
+			}
+		})
+	}
+}
+
+func Test_scriptRunner_evalCondition(t *testing.T) {
+	testCases := []struct {
+		cond      string
+		expected  bool
+		expectErr bool
+	}{
+		{cond: "abc == abc", expected: true},
+		{cond: "abc == abd", expected: false},
+		{cond: "abc != abd", expected: true},
+		{cond: "${x} == \\x01\\x02", expected: true},
+		{cond: "len(${x}) == \\x02", expected: true},
+		{cond: "DEFINED x", expected: true},
+		{cond: "DEFINED y", expected: false},
+		{cond: "NOT DEFINED y", expected: true},
+		{cond: "NOT abc == abc", expected: false},
+		{cond: "\"a==b\" == \"a==b\"", expected: true},
+		{cond: "\"a==b\" != ${x}", expected: true},
+		{cond: "unbase64(AQI=) == ${x}", expected: true},
+		{cond: "unbase64(YQ==) != ${x}", expected: true},
+		{cond: "\\x3d\\x3d == ==", expected: true},
+		{cond: "abc", expectErr: true},
+		{cond: "${y} == abc", expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.cond, func(t *testing.T) {
+			state := &consoleState{variables: &variableSet{}}
+			if err := state.variables.set("x", []byte{0x01, 0x02}); err != nil {
+				t.Fatalf("could not set variable: %v", err)
+			}
+			sut := &scriptRunner{state: state}
+
+			actual, err := sut.evalCondition(tc.cond)
+
+			// check for error
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				t.Fatalf("expected an error but nil error was returned")
+			}
+
+			// check the value
+			if actual != tc.expected {
+				t.Errorf("expected %v but got: %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_ExecuteScript_lines(t *testing.T) {
+	testCases := []struct {
+		name            string
+		script          string
+		expected        int
+		expectErrOnLine int
+	}{
+		{name: "all lines", script: "a\n# comment\nb\n", expected: 3},
+		{name: "return ends script", script: "a\nRETURN\nb\n\n", expected: 4},
+		{name: "error on statement", script: "a\n# comment\n\nCANCEL\nb\n", expected: 3, expectErrOnLine: 4},
+		{name: "error inside block", script: "a\nLOOP 2\nb\nCANCEL\nEND\n", expected: 1, expectErrOnLine: 4},
+		{name: "error parsing script", script: "a\nEND\n", expected: 0, expectErrOnLine: 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := ExecuteScript(strings.NewReader(tc.script), driver.OpenDryRunConnection("test"), verbosity.OutputWriter{Verbosity: verbosity.Silent}, "", false, "", Options{})
+
+			// check for error
+			if tc.expectErrOnLine > 0 {
+				if err == nil {
+					t.Fatalf("expected an error but nil error was returned")
+				}
+				se, ok := err.(ScriptError)
+				if !ok {
+					t.Fatalf("expected a ScriptError but got: %v", err)
+				}
+				if se.Line != tc.expectErrOnLine {
+					t.Errorf("expected error on line %d but got: %v", tc.expectErrOnLine, se)
+				}
+			} else if err != nil {
+				t.Fatalf("returned an error: %v", err)
+			}
+
+			// check the value
+			if actual != tc.expected {
+				t.Errorf("expected %d lines but got: %d", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,319 @@
+package console
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"dekarrin/netkarkat/internal/misc"
+	"dekarrin/netkarkat/internal/scrollback"
+)
+
+// number of entries shown by BUFFER when no range or count is given.
+const defaultBufferShowCount = 10
+
+func executeCommandBuffer(state *consoleState, argv []string) (output string, err error) {
+	var showAll, doClear bool
+	var first, last int
+	count := defaultBufferShowCount
+
+	argv, err = parseCommandFlags(
+		argv,
+		flagActions{
+			'a': func(i *int, argv []string) error {
+				showAll = true
+				return nil
+			},
+			'c': func(i *int, argv []string) error {
+				doClear = true
+				return nil
+			},
+			'n': func(i *int, argv []string) error {
+				if *i+1 >= len(argv) {
+					return fmt.Errorf("-n requires an argument")
+				}
+				*i++
+				n, err := strconv.Atoi(argv[*i])
+				if err != nil || n < 1 {
+					return fmt.Errorf("-n must be given a number greater than 0")
+				}
+				count = n
+				return nil
+			},
+		},
+		scrollbackRangeArgs(&first, &last),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if doClear {
+		state.scrollback.Clear()
+		return state.out.InfoSprintf("Cleared all sent and received data from the buffer"), nil
+	}
+
+	var entries []scrollback.Entry
+	if showAll {
+		entries = state.scrollback.Range(0, math.MaxInt32)
+	} else if first > 0 {
+		entries = state.scrollback.Range(first, last)
+	} else {
+		entries = state.scrollback.Last(count)
+	}
+
+	if len(entries) < 1 {
+		return "(no data in range)", nil
+	}
+
+	var sb strings.Builder
+	for idx, e := range entries {
+		sb.WriteString(formatScrollbackEntry(e, nil))
+		if idx+1 < len(entries) {
+			sb.WriteRune('\n')
+		}
+	}
+	return sb.String(), nil
+}
+
+func executeCommandGrep(state *consoleState, line string, cmdName string) (output string, err error) {
+	var useRegex bool
+	var dirFilter *scrollback.Direction
+
+	argv, pattern := splitLineOptions(line, "d")
+	_, err = parseCommandFlags(
+		argv,
+		flagActions{
+			'r': func(i *int, argv []string) error {
+				useRegex = true
+				return nil
+			},
+			'd': scrollbackDirectionFlag(&dirFilter),
+		},
+		nil,
+	)
+	if err != nil {
+		return "", err
+	}
+	if pattern == "" {
+		return "", fmt.Errorf("need to give pattern to search for")
+	}
+
+	entries := filterScrollbackDirection(state.scrollback.Range(0, math.MaxInt32), dirFilter)
+
+	var matches []scrollback.Match
+	if useRegex {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return "", fmt.Errorf("bad regular expression: %v", err)
+		}
+		matches = scrollback.FindRegexp(entries, re)
+	} else {
+		seq, err := state.parseLineToBytes(pattern)
+		if err != nil {
+			return "", err
+		}
+		if len(seq) < 1 {
+			return "", fmt.Errorf("pattern does not contain any bytes to search for")
+		}
+		matches = scrollback.FindBytes(entries, seq)
+	}
+
+	if len(matches) < 1 {
+		return state.out.InfoSprintf("No matches found"), nil
+	}
+
+	// group the matches by entry so each entry is only shown once
+	var sb strings.Builder
+	for idx := 0; idx < len(matches); {
+		e := matches[idx].Entry
+		var inEntry []scrollback.Match
+		for idx < len(matches) && matches[idx].Entry.Index == e.Index {
+			inEntry = append(inEntry, matches[idx])
+			idx++
+		}
+		if sb.Len() > 0 {
+			sb.WriteRune('\n')
+		}
+		sb.WriteString(formatScrollbackEntry(e, inEntry))
+	}
+	return sb.String(), nil
+}
+
+func executeCommandSave(state *consoleState, argv []string) (output string, err error) {
+	var filename string
+	var first, last int
+	var dirFilter *scrollback.Direction
+	format := "raw"
+
+	posArgs := append(posArgActions{
+		{
+			parse: func(i *int, argv []string) error {
+				filename = argv[*i]
+				return nil
+			},
+		},
+	}, scrollbackRangeArgs(&first, &last)...)
+
+	argv, err = parseCommandFlags(
+		argv,
+		flagActions{
+			'f': func(i *int, argv []string) error {
+				if *i+1 >= len(argv) {
+					return fmt.Errorf("-f requires an argument")
+				}
+				*i++
+				format = strings.ToLower(argv[*i])
+				if format != "raw" && format != "hex" && format != "transcript" {
+					return fmt.Errorf("format must be one of raw, hex, or transcript")
+				}
+				return nil
+			},
+			'd': scrollbackDirectionFlag(&dirFilter),
+		},
+		posArgs,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	var entries []scrollback.Entry
+	if first > 0 {
+		entries = state.scrollback.Range(first, last)
+	} else {
+		entries = state.scrollback.Range(0, math.MaxInt32)
+	}
+	entries = filterScrollbackDirection(entries, dirFilter)
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return "", fmt.Errorf("could not create file: %v", err)
+	}
+	defer f.Close()
+
+	byteCount, err := writeScrollbackEntries(f, entries, format)
+	if err != nil {
+		return "", fmt.Errorf("could not write file: %v", err)
+	}
+
+	return state.out.InfoSprintf("Saved %s (%s) to %q", misc.CountOf("chunk", "chunks", len(entries)), misc.CountOf("byte", "bytes", byteCount), filename), nil
+}
+
+func writeScrollbackEntries(f *os.File, entries []scrollback.Entry, format string) (dataBytes int, err error) {
+	w := bufio.NewWriter(f)
+	for _, e := range entries {
+		switch format {
+		case "raw":
+			_, err = w.Write(e.Data)
+		case "hex":
+			_, err = fmt.Fprintf(w, "%s\n", hex.EncodeToString(e.Data))
+		case "transcript":
+			_, err = fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", e.Index, e.Time.Format("2006-01-02T15:04:05.000Z07:00"), e.Direction, e.Peer, hex.EncodeToString(e.Data))
+		}
+		if err != nil {
+			return dataBytes, err
+		}
+		dataBytes += len(e.Data)
+	}
+	return dataBytes, w.Flush()
+}
+
+// formatScrollbackEntry gives the one-line display of the entry. If any
+// matches are given, the matched bytes are surrounded with brackets.
+func formatScrollbackEntry(e scrollback.Entry, matches []scrollback.Match) string {
+	dataStr := misc.FormatHexBytes(e.Data)
+	if len(matches) > 0 {
+		hexBytes := strings.Split(dataStr, " ")
+		for _, m := range matches {
+			if m.Length < 1 {
+				continue
+			}
+			hexBytes[m.Offset] = "[" + hexBytes[m.Offset]
+			hexBytes[m.Offset+m.Length-1] = hexBytes[m.Offset+m.Length-1] + "]"
+		}
+		dataStr = strings.Join(hexBytes, " ")
+	}
+
+	peer := e.Peer
+	if peer == "" {
+		peer = "(unknown)"
+	}
+	return fmt.Sprintf("#%d %s %s %s (%s): %s", e.Index, e.Time.Format("15:04:05.000"), e.Direction, peer, misc.CountOf("byte", "bytes", len(e.Data)), dataStr)
+}
+
+func filterScrollbackDirection(entries []scrollback.Entry, dir *scrollback.Direction) []scrollback.Entry {
+	if dir == nil {
+		return entries
+	}
+	var filtered []scrollback.Entry
+	for _, e := range entries {
+		if e.Direction == *dir {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
+func scrollbackDirectionFlag(dir **scrollback.Direction) argParseHandler {
+	return func(i *int, argv []string) error {
+		if *i+1 >= len(argv) {
+			return fmt.Errorf("-d requires an argument")
+		}
+		*i++
+		var d scrollback.Direction
+		switch strings.ToLower(argv[*i]) {
+		case "sent", "send":
+			d = scrollback.Sent
+		case "recv", "received":
+			d = scrollback.Received
+		default:
+			return fmt.Errorf("-d must be one of sent or recv")
+		}
+		*dir = &d
+		return nil
+	}
+}
+
+// scrollbackRangeArgs gives the optional positional args for selecting a range
+// of entries. If first is given but last is not, last will be set to first.
+func scrollbackRangeArgs(first, last *int) posArgActions {
+	parseIndex := func(s string) (int, error) {
+		n, err := strconv.Atoi(strings.TrimPrefix(s, "#"))
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("%q is not a valid chunk number", s)
+		}
+		return n, nil
+	}
+	return posArgActions{
+		{
+			parse: func(i *int, argv []string) error {
+				n, err := parseIndex(argv[*i])
+				if err != nil {
+					return err
+				}
+				*first = n
+				*last = n
+				return nil
+			},
+			optional: true,
+		},
+		{
+			parse: func(i *int, argv []string) error {
+				n, err := parseIndex(argv[*i])
+				if err != nil {
+					return err
+				}
+				if n < *first {
+					return fmt.Errorf("last chunk number must not be less than the first")
+				}
+				*last = n
+				return nil
+			},
+			optional: true,
+		},
+	}
+}
@@ -0,0 +1,310 @@
+package console
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"dekarrin/netkarkat/internal/driver"
+	"dekarrin/netkarkat/internal/scrollback"
+	"dekarrin/netkarkat/internal/verbosity"
+)
+
+// scrollbackTimePattern matches the time shown in each entry, along with the
+// date and zone in transcripts, so that output can be compared without
+// depending on when the entries were added.
+var scrollbackTimePattern = regexp.MustCompile(`(\d{4}-\d\d-\d\dT)?\d\d:\d\d:\d\d\.\d\d\d(Z|[+-]\d\d:\d\d)?`)
+
+type scrollbackTestEntry struct {
+	dir  scrollback.Direction
+	data string
+}
+
+func newScrollbackTestState(entries []scrollbackTestEntry) *consoleState {
+	state := newConsoleState(driver.OpenDryRunConnection("test"), verbosity.OutputWriter{Verbosity: verbosity.Silent}, "", false, false, "", Options{})
+	for _, e := range entries {
+		state.scrollback.Add(e.dir, "test", []byte(e.data))
+	}
+	return state
+}
+
+var scrollbackTestEntries = []scrollbackTestEntry{
+	{scrollback.Sent, "abc"},
+	{scrollback.Received, "def"},
+	{scrollback.Sent, "ghi"},
+	{scrollback.Received, "abd"},
+}
+
+func Test_executeCommandBuffer(t *testing.T) {
+	testCases := []struct {
+		name        string
+		line        string
+		expect      []string // with the time of each entry replaced by TIME
+		expectClear bool
+		expectErr   bool
+	}{
+		{
+			name: "last entries by default",
+			line: "BUFFER",
+			expect: []string{
+				"#1 TIME SENT test (3 bytes): 0x61 0x62 0x63",
+				"#2 TIME RECV test (3 bytes): 0x64 0x65 0x66",
+				"#3 TIME SENT test (3 bytes): 0x67 0x68 0x69",
+				"#4 TIME RECV test (3 bytes): 0x61 0x62 0x64",
+			},
+		},
+		{
+			name: "count",
+			line: "BUFFER -n 1",
+			expect: []string{
+				"#4 TIME RECV test (3 bytes): 0x61 0x62 0x64",
+			},
+		},
+		{
+			name: "single entry",
+			line: "BUFFER 2",
+			expect: []string{
+				"#2 TIME RECV test (3 bytes): 0x64 0x65 0x66",
+			},
+		},
+		{
+			name: "range",
+			line: "BUFFER 2 3",
+			expect: []string{
+				"#2 TIME RECV test (3 bytes): 0x64 0x65 0x66",
+				"#3 TIME SENT test (3 bytes): 0x67 0x68 0x69",
+			},
+		},
+		{
+			name:   "range with no entries",
+			line:   "BUFFER 8 9",
+			expect: []string{"(no data in range)"},
+		},
+		{
+			name:        "clear",
+			line:        "BUFFER -c",
+			expect:      []string{""},
+			expectClear: true,
+		},
+		{
+			name:      "bad count",
+			line:      "BUFFER -n 0",
+			expectErr: true,
+		},
+		{
+			name:      "count without argument",
+			line:      "BUFFER -n",
+			expectErr: true,
+		},
+		{
+			name:      "last before first",
+			line:      "BUFFER 3 2",
+			expectErr: true,
+		},
+		{
+			name:      "bad chunk number",
+			line:      "BUFFER x",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			state := newScrollbackTestState(scrollbackTestEntries)
+
+			actual, err := executeLine(state, tc.line)
+
+			// check for error
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				t.Fatalf("expected an error but nil error was returned")
+			}
+			if tc.expectErr {
+				return
+			}
+
+			// check the value
+			actual = scrollbackTimePattern.ReplaceAllString(actual, "TIME")
+			expected := strings.Join(tc.expect, "\n")
+			if actual != expected {
+				t.Errorf("expected:\n%s\nbut got:\n%s", expected, actual)
+			}
+			if cleared := state.scrollback.Len() == 0; cleared != tc.expectClear {
+				t.Errorf("expected buffer being cleared to be %v but got: %v", tc.expectClear, cleared)
+			}
+		})
+	}
+}
+
+func Test_executeCommandGrep(t *testing.T) {
+	testCases := []struct {
+		name      string
+		line      string
+		expect    []string // with the time of each entry replaced by TIME
+		expectErr bool
+	}{
+		{
+			name: "bytes",
+			line: "GREP ab",
+			expect: []string{
+				"#1 TIME SENT test (3 bytes): [0x61 0x62] 0x63",
+				"#4 TIME RECV test (3 bytes): [0x61 0x62] 0x64",
+			},
+		},
+		{
+			name: "escaped bytes",
+			line: "GREP \\x65",
+			expect: []string{
+				"#2 TIME RECV test (3 bytes): 0x64 [0x65] 0x66",
+			},
+		},
+		{
+			name: "direction",
+			line: "GREP -d recv ab",
+			expect: []string{
+				"#4 TIME RECV test (3 bytes): [0x61 0x62] 0x64",
+			},
+		},
+		{
+			name: "regular expression",
+			line: "GREP -r [ch]",
+			expect: []string{
+				"#1 TIME SENT test (3 bytes): 0x61 0x62 [0x63]",
+				"#3 TIME SENT test (3 bytes): 0x67 [0x68] 0x69",
+			},
+		},
+		{
+			name:   "no matches",
+			line:   "GREP xyz",
+			expect: []string{""},
+		},
+		{
+			name:      "no pattern",
+			line:      "GREP",
+			expectErr: true,
+		},
+		{
+			name:      "bad direction",
+			line:      "GREP -d up ab",
+			expectErr: true,
+		},
+		{
+			name:      "bad regular expression",
+			line:      "GREP -r [a",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			state := newScrollbackTestState(scrollbackTestEntries)
+
+			actual, err := executeLine(state, tc.line)
+
+			// check for error
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				t.Fatalf("expected an error but nil error was returned")
+			}
+			if tc.expectErr {
+				return
+			}
+
+			// check the value
+			actual = scrollbackTimePattern.ReplaceAllString(actual, "TIME")
+			expected := strings.Join(tc.expect, "\n")
+			if actual != expected {
+				t.Errorf("expected:\n%s\nbut got:\n%s", expected, actual)
+			}
+		})
+	}
+}
+
+func Test_executeCommandSave(t *testing.T) {
+	testCases := []struct {
+		name      string
+		args      string // given before the file
+		after     string // given after the file
+		expect    string // with the time of each entry replaced by TIME
+		expectErr bool
+	}{
+		{
+			name:   "raw",
+			expect: "abcdefghiabd",
+		},
+		{
+			name:   "hex",
+			args:   "-f hex",
+			expect: "616263\n646566\n676869\n616264\n",
+		},
+		{
+			name:   "transcript",
+			args:   "-f transcript",
+			after:  "3",
+			expect: "3\tTIME\tSENT\ttest\t676869\n",
+		},
+		{
+			name:   "range and direction",
+			args:   "-d sent",
+			after:  "2 4",
+			expect: "ghi",
+		},
+		{
+			name:      "bad format",
+			args:      "-f pdf",
+			expectErr: true,
+		},
+		{
+			name:      "bad direction",
+			args:      "-d up",
+			expectErr: true,
+		},
+		{
+			name:      "bad chunk number",
+			after:     "0",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "netkk-test")
+			if err != nil {
+				t.Fatalf("prep step: couldn't create temp dir: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			filename := filepath.Join(dir, "out.bin")
+			state := newScrollbackTestState(scrollbackTestEntries)
+
+			_, err = executeLine(state, "SAVE "+tc.args+" \""+filename+"\" "+tc.after)
+
+			// check for error
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				t.Fatalf("expected an error but nil error was returned")
+			}
+			if tc.expectErr {
+				if _, statErr := os.Stat(filename); statErr == nil {
+					t.Errorf("expected file to not be created")
+				}
+				return
+			}
+
+			// check the value
+			actualBytes, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("couldn't read file: %v", err)
+			}
+			actual := scrollbackTimePattern.ReplaceAllString(string(actualBytes), "TIME")
+			if actual != tc.expect {
+				t.Errorf("expected %q but got: %q", tc.expect, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package console
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"dekarrin/netkarkat/internal/bytefuncs"
+	"dekarrin/netkarkat/internal/misc"
+
+	"github.com/alecthomas/units"
+)
+
+// fileTokenPrefix begins a token in byte input that is replaced with the
+// contents of a file.
+const fileTokenPrefix = "@file:"
+
+// maxFileReadBytes is the most bytes of a file that are read into memory at
+// once, either for a single send by SENDFILE or for an @file: token.
+const maxFileReadBytes = bytefuncs.MaxGeneratedBytes
+
+// sendfileProgressStep is the percentage of a file that must be sent between
+// progress messages from SENDFILE.
+const sendfileProgressStep = 10
+
+func executeCommandSendfile(state *consoleState, argv []string) (output string, err error) {
+	var filename string
+	var offset int64
+	var length int64 = -1
+	var chunkSize int64
+	var delay time.Duration
+	quiet := false
+
+	argv, err = parseCommandFlags(
+		argv,
+		flagActions{
+			'o': byteCountFlag("-o", &offset, false),
+			'l': byteCountFlag("-l", &length, false),
+			'c': byteCountFlag("-c", &chunkSize, true),
+			'd': func(i *int, argv []string) error {
+				if *i+1 >= len(argv) {
+					return fmt.Errorf("-d requires an argument")
+				}
+				*i++
+				d, err := time.ParseDuration(argv[*i])
+				if err != nil || d < 0 {
+					return fmt.Errorf("-d must be given a duration such as 250ms or 1s")
+				}
+				delay = d
+				return nil
+			},
+			'q': func(i *int, argv []string) error {
+				quiet = true
+				return nil
+			},
+		},
+		posArgActions{
+			{
+				parse: func(i *int, argv []string) error {
+					filename = argv[*i]
+					return nil
+				},
+			},
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", fmt.Errorf("could not open file: %v", err)
+	}
+	defer f.Close()
+
+	window, err := fileWindow(f, offset, length)
+	if err != nil {
+		return "", err
+	}
+	if chunkSize > maxFileReadBytes {
+		return "", fmt.Errorf("-c must be at most %d", maxFileReadBytes)
+	}
+	total := window.Size()
+	if chunkSize <= 0 || chunkSize > total {
+		chunkSize = total
+	}
+	if chunkSize > maxFileReadBytes {
+		chunkSize = maxFileReadBytes
+	}
+
+	showProgress := !quiet && total > chunkSize
+	nextProgress := sendfileProgressStep
+	chunkCount := 0
+	buf := make([]byte, chunkSize)
+	var sent int64
+	for sent < total || chunkCount == 0 {
+		n, err := io.ReadFull(window, buf)
+		if err != nil && err != io.ErrUnexpectedEOF && !(err == io.EOF && total == 0) {
+			return "", fmt.Errorf("could not read file: %v", err)
+		}
+		if chunkCount > 0 && delay > 0 {
+			time.Sleep(delay)
+		}
+		if err := state.send(buf[:n]); err != nil {
+			return "", fmt.Errorf("after sending %s: %v", misc.CountOf("byte", "bytes", int(sent)), err)
+		}
+		sent += int64(n)
+		chunkCount++
+
+		if showProgress {
+			pct := int(sent * 100 / total)
+			if pct >= nextProgress && sent < total {
+				state.out.Info("Sent %d/%d bytes (%d%%)", sent, total, pct)
+				for nextProgress <= pct {
+					nextProgress += sendfileProgressStep
+				}
+			}
+		}
+	}
+
+	return state.out.InfoSprintf("Sent %s from %q in %s", misc.CountOf("byte", "bytes", int(sent)), filename, misc.CountOf("chunk", "chunks", chunkCount)), nil
+}
+
+// readFileToken reads the @file: token that starts at runes[start] and
+// returns the bytes it refers to, along with the index of the last rune that
+// is a part of the token.
+//
+// The token is the prefix followed by a path, which can be surrounded by
+// double quotes if it contains spaces. The path can be followed by a window
+// of the form [offset:length] to read only part of the file; either side can
+// be omitted to use the start of the file and the rest of the file,
+// respectively. If inCall is true, the token is an argument to a byte function
+// and a path that is not quoted also ends at a ',' or ')'.
+func readFileToken(runes []rune, start int, inCall bool) (data []byte, end int, err error) {
+	i := start + len([]rune(fileTokenPrefix))
+	var path string
+	if i < len(runes) && runes[i] == '"' {
+		closing := -1
+		for j := i + 1; j < len(runes); j++ {
+			if runes[j] == '"' {
+				closing = j
+				break
+			}
+		}
+		if closing < 0 {
+			return nil, 0, fmt.Errorf("unterminated quote in file token at char index %d", start)
+		}
+		path = string(runes[i+1 : closing])
+		i = closing + 1
+	} else {
+		j := i
+		for j < len(runes) && !unicode.IsSpace(runes[j]) && runes[j] != '[' {
+			if inCall && (runes[j] == ',' || runes[j] == ')') {
+				break
+			}
+			j++
+		}
+		path = string(runes[i:j])
+		i = j
+	}
+	if path == "" {
+		return nil, 0, fmt.Errorf("missing path in file token at char index %d", start)
+	}
+
+	var offset int64
+	var length int64 = -1
+	if i < len(runes) && runes[i] == '[' {
+		closing := -1
+		for j := i + 1; j < len(runes); j++ {
+			if runes[j] == ']' {
+				closing = j
+				break
+			}
+		}
+		if closing < 0 {
+			return nil, 0, fmt.Errorf("unterminated window in file token at char index %d", start)
+		}
+		parts := strings.SplitN(string(runes[i+1:closing]), ":", 2)
+		if len(parts) != 2 {
+			return nil, 0, fmt.Errorf("file token window must be of the form [offset:length]")
+		}
+		if parts[0] != "" {
+			if offset, err = parseByteCount(parts[0]); err != nil {
+				return nil, 0, fmt.Errorf("bad offset in file token: %v", err)
+			}
+		}
+		if parts[1] != "" {
+			if length, err = parseByteCount(parts[1]); err != nil {
+				return nil, 0, fmt.Errorf("bad length in file token: %v", err)
+			}
+		}
+		i = closing + 1
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not open file: %v", err)
+	}
+	defer f.Close()
+
+	window, err := fileWindow(f, offset, length)
+	if err != nil {
+		return nil, 0, err
+	}
+	if window.Size() > maxFileReadBytes {
+		return nil, 0, fmt.Errorf("file token would read more than %d bytes; give a window to read only part of the file, or use SENDFILE to send all of it", maxFileReadBytes)
+	}
+	data = make([]byte, window.Size())
+	if _, err := io.ReadFull(window, data); err != nil {
+		return nil, 0, fmt.Errorf("could not read file: %v", err)
+	}
+	return data, i - 1, nil
+}
+
+// fileWindow gives a reader over length bytes of f starting at offset. If
+// length is less than 0, the window extends to the end of the file.
+func fileWindow(f *os.File, offset int64, length int64) (*io.SectionReader, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("could not get file info: %v", err)
+	}
+	size := info.Size()
+	if offset > size {
+		return nil, fmt.Errorf("offset %d is past the end of the %s file", offset, misc.CountOf("byte", "bytes", int(size)))
+	}
+	if length < 0 || offset+length > size {
+		length = size - offset
+	}
+	return io.NewSectionReader(f, offset, length), nil
+}
+
+// parseByteCount parses a number of bytes. It can either be a plain number or
+// a number followed by a unit such as KB or MiB.
+func parseByteCount(s string) (int64, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n < 0 {
+			return 0, fmt.Errorf("%q is negative", s)
+		}
+		return n, nil
+	}
+	n, err := units.ParseStrictBytes(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a valid number of bytes", s)
+	}
+	return n, nil
+}
+
+func byteCountFlag(name string, dest *int64, nonZero bool) argParseHandler {
+	return func(i *int, argv []string) error {
+		if *i+1 >= len(argv) {
+			return fmt.Errorf("%s requires an argument", name)
+		}
+		*i++
+		n, err := parseByteCount(argv[*i])
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if nonZero && n == 0 {
+			return fmt.Errorf("%s must be greater than 0", name)
+		}
+		*dest = n
+		return nil
+	}
+}
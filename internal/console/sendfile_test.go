@@ -0,0 +1,116 @@
+package console
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"dekarrin/netkarkat/internal/driver"
+	"dekarrin/netkarkat/internal/scrollback"
+	"dekarrin/netkarkat/internal/verbosity"
+)
+
+func Test_executeCommandSendfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netkk-test")
+	if err != nil {
+		t.Fatalf("prep step: couldn't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	smallFile := filepath.Join(dir, "small.bin")
+	if err := ioutil.WriteFile(smallFile, []byte("abcdef"), 0644); err != nil {
+		t.Fatalf("prep step: couldn't create temp file: %v", err)
+	}
+	largeFile := filepath.Join(dir, "large.bin")
+	if err := writeLargeTestFile(largeFile, maxFileReadBytes+1); err != nil {
+		t.Fatalf("prep step: couldn't create temp file: %v", err)
+	}
+
+	testCases := []struct {
+		name         string
+		args         string
+		file         string
+		expectChunks []int // size of each send
+		expectErr    bool
+	}{
+		{
+			name:         "whole file in one send",
+			file:         smallFile,
+			expectChunks: []int{6},
+		},
+		{
+			name:         "chunks",
+			args:         "-c 4",
+			file:         smallFile,
+			expectChunks: []int{4, 2},
+		},
+		{
+			name:         "offset and length",
+			args:         "-o 1 -l 3",
+			file:         smallFile,
+			expectChunks: []int{3},
+		},
+		{
+			name:         "large file is split at the limit",
+			file:         largeFile,
+			expectChunks: []int{maxFileReadBytes, 1},
+		},
+		{
+			name:      "chunk size over the limit",
+			args:      "-c 17MiB",
+			file:      smallFile,
+			expectErr: true,
+		},
+		{
+			name:      "missing file",
+			file:      filepath.Join(dir, "nope.bin"),
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			state := newConsoleState(driver.OpenDryRunConnection("test"), verbosity.OutputWriter{Verbosity: verbosity.Silent}, "", false, false, "", Options{
+				Scrollback: &scrollback.Buffer{MaxBytes: 2 * maxFileReadBytes},
+			})
+
+			_, err := executeLine(state, "SENDFILE -q "+tc.args+" \""+tc.file+"\"")
+
+			// check for error
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				t.Fatalf("expected an error but nil error was returned")
+			}
+
+			// check the values
+			var actualChunks []int
+			for _, e := range state.scrollback.Last(state.scrollback.Len()) {
+				if e.Direction == scrollback.Sent {
+					actualChunks = append(actualChunks, len(e.Data))
+				}
+			}
+			if len(actualChunks) != len(tc.expectChunks) {
+				t.Fatalf("expected sends of %v bytes but got: %v", tc.expectChunks, actualChunks)
+			}
+			for i := range actualChunks {
+				if actualChunks[i] != tc.expectChunks[i] {
+					t.Errorf("expected sends of %v bytes but got: %v", tc.expectChunks, actualChunks)
+					break
+				}
+			}
+		})
+	}
+}
+
+// writeLargeTestFile creates a file of the given size without writing all of
+// its bytes, so that it does not take up that much space on systems that
+// support sparse files.
+func writeLargeTestFile(name string, size int64) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Truncate(size)
+}
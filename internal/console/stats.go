@@ -0,0 +1,63 @@
+package console
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"dekarrin/netkarkat/internal/driver"
+	"dekarrin/netkarkat/internal/misc"
+)
+
+func executeCommandStats(state *consoleState, argv []string) (output string, err error) {
+	_, err = parseCommandFlags(argv, nil, nil)
+	if err != nil {
+		return "", err
+	}
+
+	// do not mask behind verbosity as user specifically requested this
+	return StatsSummary(state.connection.Stats(), time.Now()), nil
+}
+
+// StatsSummary gives the activity in s in a form that is suitable for showing
+// to the user, with the times since events measured up to now.
+func StatsSummary(s driver.Stats, now time.Time) string {
+	var sb strings.Builder
+	line := func(label, format string, a ...interface{}) {
+		sb.WriteString(fmt.Sprintf("%-15s", label+":"))
+		sb.WriteString(fmt.Sprintf(format, a...))
+		sb.WriteRune('\n')
+	}
+
+	if s.ConnectedAt.IsZero() {
+		line("Connected", "not yet")
+	} else {
+		line("Connected", "%s ago (took %s)", roundDuration(now.Sub(s.ConnectedAt)), roundDuration(s.ConnectDuration))
+		line("Last activity", "%s ago", roundDuration(now.Sub(s.LastActivity)))
+	}
+	line("Sent", "%s in %s", misc.CountOf("byte", "bytes", int(s.BytesSent)), misc.CountOf("message", "messages", int(s.MessagesSent)))
+	line("Received", "%s in %s", misc.CountOf("byte", "bytes", int(s.BytesReceived)), misc.CountOf("message", "messages", int(s.MessagesReceived)))
+	line("Send errors", "%d", s.SendErrors)
+	if s.Server {
+		line("Clients", "%d accepted, %d rejected", s.ClientsAccepted, s.ClientsRejected)
+	}
+	if s.LatencySamples > 0 {
+		line("Latency", "%s average, %s min, %s max (%s)", roundDuration(s.AverageLatency()), roundDuration(s.LatencyMin), roundDuration(s.LatencyMax), misc.CountOf("sample", "samples", int(s.LatencySamples)))
+	} else {
+		line("Latency", "no data has been received after a send")
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// roundDuration rounds d to a precision that is suitable for showing to the
+// user, based on how long it is.
+func roundDuration(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Minute:
+		return d.Round(time.Second)
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	default:
+		return d.Round(time.Microsecond)
+	}
+}
@@ -0,0 +1,90 @@
+package console
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"dekarrin/netkarkat/internal/misc"
+)
+
+func executeCommandSleep(state *consoleState, argv []string) (output string, err error) {
+	var dur time.Duration
+	_, err = parseCommandFlags(
+		argv,
+		nil,
+		posArgActions{
+			{
+				parse: func(i *int, argv []string) error {
+					d, err := time.ParseDuration(argv[*i])
+					if err != nil || d < 0 {
+						return fmt.Errorf("%q is not a valid duration; give one such as 250ms or 2s", argv[*i])
+					}
+					dur = d
+					return nil
+				},
+			},
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if state.dryRun {
+		state.out.Info("[+%s] SLEEP %v", formatElapsed(state.dryRunElapsed), dur)
+	}
+	state.sleep(dur)
+	return "", nil
+}
+
+// sleep pauses execution for the given duration. In dry-run mode, no actual
+// pause occurs; the duration is only added to the simulated elapsed time, and
+// any jobs that would send during it do so.
+func (state *consoleState) sleep(d time.Duration) {
+	if state.dryRun {
+		state.sendMtx.Lock()
+		until := state.dryRunElapsed + d
+		state.sendMtx.Unlock()
+		state.runDryRunJobs(until)
+		state.sendMtx.Lock()
+		state.dryRunElapsed = until
+		state.sendMtx.Unlock()
+		return
+	}
+	time.Sleep(d)
+}
+
+// statementDelay pauses for the delay that is inserted between the statements
+// of a script, plus a random amount of up to the jitter.
+func (state *consoleState) statementDelay() {
+	if d := state.nextStatementDelay(); d > 0 {
+		state.sleep(d)
+	}
+}
+
+// nextStatementDelay gives the delay that is inserted between the statements
+// of a script, plus a new random amount of up to the jitter. It is safe to call
+// from other goroutines.
+func (state *consoleState) nextStatementDelay() time.Duration {
+	d := state.sendDelay
+	if state.jitter > 0 {
+		state.sendMtx.Lock()
+		d += time.Duration(state.rand.Int63n(int64(state.jitter)))
+		state.sendMtx.Unlock()
+	}
+	return d
+}
+
+// showDryRunSend shows what would have been sent on the connection, along with
+// the time that it would have been sent at relative to the start of the script.
+func (state *consoleState) showDryRunSend(data []byte) {
+	state.out.Info("%s", state.labelBytes(fmt.Sprintf("[+%s] SEND to %s (%s):", formatElapsed(state.dryRunElapsed), state.connection.GetRemoteName(), misc.CountOf("byte", "bytes", len(data))), data))
+}
+
+func formatElapsed(d time.Duration) string {
+	return fmt.Sprintf("%.3fs", d.Seconds())
+}
+
+func newRand() *rand.Rand {
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
@@ -0,0 +1,150 @@
+package console
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"dekarrin/netkarkat/internal/macros"
+	"dekarrin/netkarkat/internal/misc"
+)
+
+const defaultMacroHistoryShowCount = 10
+
+func executeCommandUndo(state *consoleState, argv []string) (output string, err error) {
+	_, err = parseCommandFlags(argv, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	entry, err := state.macros.Undo()
+	if err != nil {
+		return "", err
+	}
+	if state.usingUserPersistenceFiles {
+		state.writeMacrosFile()
+	}
+	return state.out.InfoSprintf("Undid %s (%s)", entry.Action, misc.CountOf("change", "changes", len(entry.Changes))), nil
+}
+
+func executeCommandRedo(state *consoleState, argv []string) (output string, err error) {
+	_, err = parseCommandFlags(argv, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	entry, err := state.macros.Redo()
+	if err != nil {
+		return "", err
+	}
+	if state.usingUserPersistenceFiles {
+		state.writeMacrosFile()
+	}
+	return state.out.InfoSprintf("Redid %s (%s)", entry.Action, misc.CountOf("change", "changes", len(entry.Changes))), nil
+}
+
+func executeCommandHistoryMacros(state *consoleState, argv []string) (output string, err error) {
+	var showAll bool
+	count := defaultMacroHistoryShowCount
+
+	_, err = parseCommandFlags(
+		argv,
+		flagActions{
+			'a': func(i *int, argv []string) error {
+				showAll = true
+				return nil
+			},
+			'n': func(i *int, argv []string) error {
+				if *i+1 >= len(argv) {
+					return fmt.Errorf("-n requires an argument")
+				}
+				*i++
+				n, err := strconv.Atoi(argv[*i])
+				if err != nil || n < 1 {
+					return fmt.Errorf("-n must be given a number greater than 0")
+				}
+				count = n
+				return nil
+			},
+		},
+		nil,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	entries, applied := state.macros.History()
+	if len(entries) < 1 {
+		return "(no changes to macros have been made)", nil
+	}
+	first := 0
+	if !showAll && len(entries) > count {
+		first = len(entries) - count
+	}
+
+	// newest first, since that is the order UNDO goes in
+	var lines []string
+	for idx := len(entries) - 1; idx >= first; idx-- {
+		e := entries[idx]
+		header := fmt.Sprintf("#%d %s %s", idx+1, e.Time.Format("2006-01-02 15:04:05"), e.Action)
+		if idx >= applied {
+			header += " (undone)"
+		}
+		lines = append(lines, header)
+		for _, c := range e.Changes {
+			lines = append(lines, formatJournalChange(c)...)
+		}
+		if e.CurrentBefore != e.CurrentAfter {
+			lines = append(lines, fmt.Sprintf("    current macroset: %s -> %s", formatMacrosetName(e.CurrentBefore), formatMacrosetName(e.CurrentAfter)))
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// formatJournalChange gives the lines that show the old and new contents of a
+// change to a macro, or the old and new parents of a macroset.
+func formatJournalChange(c macros.JournalChange) []string {
+	if c.Macro == "" {
+		return []string{fmt.Sprintf("    %s inherits: %s -> %s", formatMacrosetName(c.Set), formatNameList(c.ParentsBefore), formatNameList(c.ParentsAfter))}
+	}
+
+	name := macros.QualifiedName(c.Set, c.Macro)
+	var lines []string
+	b, a := c.Before, c.After
+	if b.Signature != a.Signature || b.Content != a.Content {
+		lines = append(lines, fmt.Sprintf("    %s: %s -> %s", name, formatMacroRecord(b), formatMacroRecord(a)))
+	}
+	if b.Defined() && a.Defined() {
+		if b.Info.Description != a.Info.Description {
+			lines = append(lines, fmt.Sprintf("    %s description: %q -> %q", name, b.Info.Description, a.Info.Description))
+		}
+		if strings.Join(b.Info.Tags, ",") != strings.Join(a.Info.Tags, ",") {
+			lines = append(lines, fmt.Sprintf("    %s tags: %s -> %s", name, formatNameList(b.Info.Tags), formatNameList(a.Info.Tags)))
+		}
+	}
+	return lines
+}
+
+// formatMacroRecord gives the contents of a macro as recorded in the journal,
+// preceded by its parameters if it has any.
+func formatMacroRecord(rec macros.MacroRecord) string {
+	if !rec.Defined() {
+		return "(undefined)"
+	}
+	if idx := strings.IndexRune(rec.Signature, '('); idx >= 0 {
+		return rec.Signature[idx:] + " " + rec.Content
+	}
+	return rec.Content
+}
+
+func formatMacrosetName(name string) string {
+	if name == "" {
+		return "(default macroset)"
+	}
+	return name
+}
+
+func formatNameList(names []string) string {
+	if len(names) < 1 {
+		return "(none)"
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,449 @@
+package console
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"dekarrin/netkarkat/internal/misc"
+	"dekarrin/netkarkat/internal/scrollback"
+)
+
+// how long CAPTURE waits for a message when no timeout is given.
+const defaultCaptureTimeout = 5 * time.Second
+
+// how often CAPTURE checks whether the connection has closed while it is
+// waiting for a message.
+const capturePollInterval = 100 * time.Millisecond
+
+// variableRefRegex matches a reference to a variable in byte input.
+var variableRefRegex = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+type variable struct {
+	name  string
+	value []byte
+}
+
+// variableSet holds the variables created by SET and CAPTURE. Unlike a macro,
+// a variable holds bytes that were computed when it was set rather than input
+// that is parsed each time it is used. Variable names are case-insensitive.
+type variableSet struct {
+	vars map[string]variable
+}
+
+func validateVariableName(name string) error {
+	if name == "" {
+		return fmt.Errorf("variable name cannot be empty")
+	}
+	for idx, ch := range name {
+		if ch != '_' && !unicode.IsLetter(ch) && !unicode.IsDigit(ch) {
+			return fmt.Errorf("invalid variable name %q; names can only contain letters, numbers, and underscores", name)
+		}
+		if idx == 0 && unicode.IsDigit(ch) {
+			return fmt.Errorf("invalid variable name %q; names cannot start with a number", name)
+		}
+	}
+	return nil
+}
+
+func (vs *variableSet) set(name string, value []byte) error {
+	if err := validateVariableName(name); err != nil {
+		return err
+	}
+	if vs.vars == nil {
+		vs.vars = make(map[string]variable)
+	}
+	vs.vars[strings.ToUpper(name)] = variable{name: name, value: value}
+	return nil
+}
+
+// unset removes the variable with the given name. Returns false if it was not
+// set.
+func (vs *variableSet) unset(name string) bool {
+	if vs == nil {
+		return false
+	}
+	if _, ok := vs.vars[strings.ToUpper(name)]; !ok {
+		return false
+	}
+	delete(vs.vars, strings.ToUpper(name))
+	return true
+}
+
+// clear removes all variables and returns the number that were removed.
+func (vs *variableSet) clear() int {
+	if vs == nil {
+		return 0
+	}
+	count := len(vs.vars)
+	vs.vars = nil
+	return count
+}
+
+func (vs *variableSet) get(name string) (value []byte, ok bool) {
+	if vs == nil {
+		return nil, false
+	}
+	v, ok := vs.vars[strings.ToUpper(name)]
+	return v.value, ok
+}
+
+// all gives every variable, sorted by name.
+func (vs *variableSet) all() []variable {
+	if vs == nil {
+		return nil
+	}
+	var all []variable
+	for _, v := range vs.vars {
+		all = append(all, v)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return strings.ToUpper(all[i].name) < strings.ToUpper(all[j].name)
+	})
+	return all
+}
+
+// expand replaces every ${name} in the byte input with the value of the
+// variable, written as \x escapes so that it is parsed as exactly those bytes.
+func (vs *variableSet) expand(line string) (string, error) {
+	var err error
+	expanded := variableRefRegex.ReplaceAllStringFunc(line, func(ref string) string {
+		name := ref[2 : len(ref)-1]
+		value, ok := vs.get(name)
+		if !ok {
+			if err == nil {
+				err = fmt.Errorf("variable %q is not set", name)
+			}
+			return ref
+		}
+		var sb strings.Builder
+		for _, b := range value {
+			sb.WriteString(fmt.Sprintf("\\x%02x", b))
+		}
+		return sb.String()
+	})
+	if err != nil {
+		return "", err
+	}
+	return expanded, nil
+}
+
+func executeCommandSet(state *consoleState, line string, cmdName string) (output string, err error) {
+	argv, rest := splitLineOptions(line, "")
+	_, err = parseCommandFlags(argv, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	name, input := nextToken(rest)
+	if name == "" {
+		return "", fmt.Errorf("need to give name of variable to set")
+	}
+	if err := validateVariableName(name); err != nil {
+		return "", err
+	}
+	value, err := state.parseLineToBytes(strings.TrimSpace(input))
+	if err != nil {
+		return "", err
+	}
+	if err := state.variables.set(name, value); err != nil {
+		return "", err
+	}
+	return state.out.InfoSprintf("Set %s to %s", name, misc.CountOf("byte", "bytes", len(value))), nil
+}
+
+func executeCommandUnset(state *consoleState, argv []string) (output string, err error) {
+	var all bool
+	var name string
+	_, err = parseCommandFlags(
+		argv,
+		flagActions{
+			'a': func(i *int, argv []string) error {
+				all = true
+				return nil
+			},
+		},
+		posArgActions{
+			{
+				parse: func(i *int, argv []string) error {
+					name = argv[*i]
+					return nil
+				},
+				optional: true,
+			},
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if all {
+		count := state.variables.clear()
+		return state.out.InfoSprintf("Unset %s", misc.CountOf("variable", "variables", count)), nil
+	}
+	if name == "" {
+		return "", fmt.Errorf("need to give name of variable to unset or -a for all variables")
+	}
+	if !state.variables.unset(name) {
+		return "", fmt.Errorf("variable %q is not set", name)
+	}
+	return state.out.InfoSprintf("Unset %s", name), nil
+}
+
+func executeCommandVars(state *consoleState, argv []string) (output string, err error) {
+	_, err = parseCommandFlags(argv, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	vars := state.variables.all()
+	if len(vars) < 1 {
+		return "(no variables set)", nil
+	}
+	var sb strings.Builder
+	for idx, v := range vars {
+		sb.WriteString(state.labelBytes(fmt.Sprintf("%s (%s):", v.name, misc.CountOf("byte", "bytes", len(v.value))), v.value))
+		if idx+1 < len(vars) {
+			sb.WriteRune('\n')
+		}
+	}
+	return sb.String(), nil
+}
+
+func executeCommandCapture(state *consoleState, line string, cmdName string) (output string, err error) {
+	var useRegex, useDelim bool
+	var offset int64
+	var length int64 = -1
+	group := -1
+	field := 1
+	timeout := defaultCaptureTimeout
+
+	intFlag := func(name string, dest *int, min int) argParseHandler {
+		return func(i *int, argv []string) error {
+			if *i+1 >= len(argv) {
+				return fmt.Errorf("%s requires an argument", name)
+			}
+			*i++
+			n, err := strconv.Atoi(argv[*i])
+			if err != nil || n < min {
+				return fmt.Errorf("%s must be given a number of at least %d", name, min)
+			}
+			*dest = n
+			return nil
+		}
+	}
+
+	argv, rest := splitLineOptions(line, "tolgf")
+	_, err = parseCommandFlags(
+		argv,
+		flagActions{
+			'r': func(i *int, argv []string) error {
+				useRegex = true
+				return nil
+			},
+			'd': func(i *int, argv []string) error {
+				useDelim = true
+				return nil
+			},
+			'o': byteCountFlag("-o", &offset, false),
+			'l': byteCountFlag("-l", &length, false),
+			'g': intFlag("-g", &group, 0),
+			'f': intFlag("-f", &field, 1),
+			't': func(i *int, argv []string) error {
+				if *i+1 >= len(argv) {
+					return fmt.Errorf("-t requires an argument")
+				}
+				*i++
+				d, err := time.ParseDuration(argv[*i])
+				if err != nil || d < 0 {
+					return fmt.Errorf("-t must be given a duration such as 500ms or 5s")
+				}
+				timeout = d
+				return nil
+			},
+		},
+		nil,
+	)
+	if err != nil {
+		return "", err
+	}
+	if useRegex && useDelim {
+		return "", fmt.Errorf("-r and -d cannot be given together")
+	}
+
+	name, pattern := nextToken(rest)
+	pattern = strings.TrimSpace(pattern)
+	if name == "" {
+		return "", fmt.Errorf("need to give name of variable to capture into")
+	}
+	if err := validateVariableName(name); err != nil {
+		return "", err
+	}
+
+	var re *regexp.Regexp
+	var delim []byte
+	if useRegex {
+		if pattern == "" {
+			return "", fmt.Errorf("need to give regular expression to match with -r")
+		}
+		re, err = regexp.Compile(pattern)
+		if err != nil {
+			return "", fmt.Errorf("bad regular expression: %v", err)
+		}
+		if group < 0 {
+			group = 0
+			if re.NumSubexp() > 0 {
+				group = 1
+			}
+		}
+		if group > re.NumSubexp() {
+			return "", fmt.Errorf("regular expression only has %s", misc.CountOf("group", "groups", re.NumSubexp()))
+		}
+	} else if useDelim {
+		if pattern == "" {
+			return "", fmt.Errorf("need to give delimiter bytes with -d")
+		}
+		delim, err = state.parseLineToBytes(pattern)
+		if err != nil {
+			return "", fmt.Errorf("bad delimiter: %v", err)
+		}
+		if len(delim) < 1 {
+			return "", fmt.Errorf("delimiter must contain at least one byte")
+		}
+	} else if pattern != "" {
+		return "", fmt.Errorf("unexpected %q after variable name; give -r or -d to use a pattern", pattern)
+	}
+
+	extract := func(data []byte) ([]byte, bool) {
+		if offset > int64(len(data)) {
+			return nil, false
+		}
+		data = data[offset:]
+		if length >= 0 && length < int64(len(data)) {
+			data = data[:length]
+		}
+		if re != nil {
+			m := re.FindSubmatchIndex(data)
+			if m == nil || m[group*2] < 0 {
+				return nil, false
+			}
+			return data[m[group*2]:m[group*2+1]], true
+		}
+		if delim != nil {
+			fields := bytes.Split(data, delim)
+			if field > len(fields) {
+				return nil, false
+			}
+			return fields[field-1], true
+		}
+		return data, true
+	}
+
+	if state.dryRun {
+		// nothing is ever received in a dry run, so the variable is set to
+		// nothing so that later uses of it still work
+		state.out.Info("[+%s] CAPTURE into %s (no data is received in dry run; set to 0 bytes)", formatElapsed(state.dryRunElapsed), name)
+		return "", state.variables.set(name, []byte{})
+	}
+
+	e, value, err := state.captureNext(extract, timeout)
+	if err != nil {
+		return "", err
+	}
+	if err := state.variables.set(name, append([]byte(nil), value...)); err != nil {
+		return "", err
+	}
+	return state.out.InfoSprintf("Captured %s from #%d into %s", misc.CountOf("byte", "bytes", len(value)), e.Index, name), nil
+}
+
+// captureNext waits for the next received message that extract accepts and
+// returns it along with the bytes extract gave for it. Messages that have not
+// yet been consumed are considered first, so a reply that arrives before
+// CAPTURE is run is not missed. A timeout of 0 waits until the connection
+// closes.
+func (state *consoleState) captureNext(extract func([]byte) ([]byte, bool), timeout time.Duration) (e scrollback.Entry, value []byte, err error) {
+	entries, sub, mark := state.pendingReceived()
+	defer sub.Close()
+
+	skipped := 0
+	consider := func(e scrollback.Entry) ([]byte, bool) {
+		if e.Index <= mark {
+			return nil, false
+		}
+		mark = e.Index
+		state.captureMark = e.Index
+		value, ok := extract(e.Data)
+		if !ok {
+			skipped++
+		}
+		return value, ok
+	}
+
+	for _, e := range entries {
+		if value, ok := consider(e); ok {
+			return e, value, nil
+		}
+	}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		next, ok := sub.Next(capturePollInterval)
+		if ok {
+			if value, ok := consider(next); ok {
+				return next, value, nil
+			}
+			continue
+		}
+		var reason string
+		if state.connection == nil || state.connection.IsClosed() {
+			reason = "connection closed"
+		} else if !deadline.IsZero() && time.Now().After(deadline) {
+			reason = fmt.Sprintf("nothing received within %v", timeout)
+		} else {
+			continue
+		}
+		if skipped > 0 {
+			return scrollback.Entry{}, nil, fmt.Errorf("nothing captured; %s and %s did not match", reason, misc.CountOf("message", "messages", skipped))
+		}
+		return scrollback.Entry{}, nil, fmt.Errorf("nothing captured; %s", reason)
+	}
+}
+
+// pendingReceived gives the received messages in the scrollback that have not
+// yet been consumed by CAPTURE or RECVFILE, which are the ones that arrived
+// after both the last data that was sent and the last message that was
+// consumed. It also gives a Subscription for messages received after that,
+// which must be closed when no longer needed; it may deliver some of the
+// same messages again, which can be skipped by only using ones with an Index
+// after mark.
+func (state *consoleState) pendingReceived() (entries []scrollback.Entry, sub *scrollback.Subscription, mark int) {
+	// subscribe before checking the buffer so no message can arrive between
+	// the two without being seen
+	sub = state.scrollback.Subscribe(scrollback.Received)
+
+	mark = state.captureMark
+	all := state.scrollback.Range(0, math.MaxInt32)
+	start := 0
+	for idx := len(all) - 1; idx >= 0; idx-- {
+		if all[idx].Direction == scrollback.Sent {
+			if all[idx].Index > mark {
+				mark = all[idx].Index
+			}
+			start = idx + 1
+			break
+		}
+	}
+	for _, e := range all[start:] {
+		if e.Index > mark {
+			entries = append(entries, e)
+		}
+	}
+	return entries, sub, mark
+}
@@ -93,6 +93,10 @@ type Connection interface {
 	// CloseActive shuts down the active connection. For server modes in stateful connections (i.e. TCP), this will terminate
 	// the active connection with a client and put it back in the listening state. For others, it is equivalent to Close().
 	CloseActive() error
+
+	// Stats gives a snapshot of the activity on the connection so far. For server modes, this includes every client that
+	// has connected.
+	Stats() Stats
 }
 
 // LogFormatter is a string format function that is used in
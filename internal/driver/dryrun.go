@@ -0,0 +1,71 @@
+package driver
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type dryRunConnection struct {
+	remoteName string
+	closed     bool
+	mtx        sync.Mutex
+	stats      *statsRecorder
+}
+
+// OpenDryRunConnection creates a Connection that does not use the network.
+// All data sent on it is discarded, it never receives any data, and it is
+// ready immediately. It can be used to run scripts without contacting the
+// remote host.
+func OpenDryRunConnection(remoteName string) Connection {
+	conn := &dryRunConnection{remoteName: remoteName, stats: newStatsRecorder(false)}
+	conn.stats.connected(time.Now())
+	return conn
+}
+
+func (conn *dryRunConnection) IsClosed() bool {
+	conn.mtx.Lock()
+	defer conn.mtx.Unlock()
+	return conn.closed
+}
+
+func (conn *dryRunConnection) Close() error {
+	conn.mtx.Lock()
+	defer conn.mtx.Unlock()
+	conn.closed = true
+	return nil
+}
+
+func (conn *dryRunConnection) Send(data []byte) error {
+	if conn.IsClosed() {
+		err := fmt.Errorf("this connection has been closed and can no longer be used to send")
+		conn.stats.sent(0, err)
+		return err
+	}
+	conn.stats.sent(len(data), nil)
+	return nil
+}
+
+func (conn *dryRunConnection) GetRemoteName() string {
+	return conn.remoteName
+}
+
+func (conn *dryRunConnection) GetLocalName() string {
+	return "(dry run)"
+}
+
+func (conn *dryRunConnection) Ready() bool {
+	return true
+}
+
+func (conn *dryRunConnection) GotTimeout() bool {
+	return false
+}
+
+func (conn *dryRunConnection) CloseActive() error {
+	return conn.Close()
+}
+
+func (conn *dryRunConnection) Stats() Stats {
+	return conn.stats.snapshot()
+}
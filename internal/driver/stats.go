@@ -0,0 +1,162 @@
+package driver
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats is a snapshot of the activity on a Connection.
+type Stats struct {
+	// Server is whether the Connection listens for clients to connect to it.
+	// ClientsAccepted and ClientsRejected are only counted if it does.
+	Server bool
+
+	// ConnectedAt is when the connection to the remote host was established.
+	// For a server, this is when the current or most recent client connected.
+	// It is the zero time if no connection has been established yet.
+	ConnectedAt time.Time
+
+	// ConnectDuration is how long it took to establish the connection. For a
+	// server, this only includes setting up the client's connection, such as
+	// the TLS handshake, and not the time spent waiting for it.
+	ConnectDuration time.Duration
+
+	// LastActivity is when data was last sent or received, or when the
+	// connection was established if there has not been any yet.
+	LastActivity time.Time
+
+	BytesSent        int64
+	BytesReceived    int64
+	MessagesSent     int64
+	MessagesReceived int64
+
+	// SendErrors is the number of calls to Send that failed.
+	SendErrors int64
+
+	// ClientsAccepted is the number of clients that a server has connected
+	// with.
+	ClientsAccepted int64
+
+	// ClientsRejected is the number of clients that a server refused because
+	// it was already connected with another, or whose connection could not be
+	// set up.
+	ClientsRejected int64
+
+	// LatencySamples is the number of sends that were followed by received
+	// data. Each one gives the time from the send to the next data that was
+	// received as a rough measure of latency.
+	LatencySamples int64
+	LatencyTotal   time.Duration
+	LatencyMin     time.Duration
+	LatencyMax     time.Duration
+}
+
+// AverageLatency gives the mean of the latency samples, or 0 if there are
+// none.
+func (s Stats) AverageLatency() time.Duration {
+	if s.LatencySamples < 1 {
+		return 0
+	}
+	return s.LatencyTotal / time.Duration(s.LatencySamples)
+}
+
+// statsRecorder keeps the Stats of a Connection as it is used. It is safe to
+// use from multiple goroutines.
+type statsRecorder struct {
+	mtx   sync.Mutex
+	stats Stats
+
+	// sends that have not yet been followed by received data. Only the first
+	// and last time and the sum of the offsets from the first are kept, which
+	// is enough to find the latency of each of them.
+	pendingCount     int64
+	pendingFirst     time.Time
+	pendingLast      time.Time
+	pendingOffsetSum time.Duration
+
+	// now gives the current time; it is replaced in tests.
+	now func() time.Time
+}
+
+func newStatsRecorder(server bool) *statsRecorder {
+	return &statsRecorder{stats: Stats{Server: server}, now: time.Now}
+}
+
+// connected records that a connection was established after starting to
+// connect at started. Sends made on a previous connection are no longer
+// counted towards the latency.
+func (sr *statsRecorder) connected(started time.Time) {
+	sr.mtx.Lock()
+	defer sr.mtx.Unlock()
+	now := sr.now()
+	sr.stats.ConnectedAt = now
+	sr.stats.ConnectDuration = now.Sub(started)
+	sr.stats.LastActivity = now
+	sr.pendingCount = 0
+}
+
+// sent records a call to Send that sent n bytes and returned err.
+func (sr *statsRecorder) sent(n int, err error) {
+	sr.mtx.Lock()
+	defer sr.mtx.Unlock()
+	if err != nil {
+		sr.stats.SendErrors++
+		return
+	}
+	now := sr.now()
+	sr.stats.BytesSent += int64(n)
+	sr.stats.MessagesSent++
+	sr.stats.LastActivity = now
+
+	if sr.pendingCount == 0 {
+		sr.pendingFirst = now
+		sr.pendingOffsetSum = 0
+	} else {
+		sr.pendingOffsetSum += now.Sub(sr.pendingFirst)
+	}
+	sr.pendingLast = now
+	sr.pendingCount++
+}
+
+// received records that n bytes were received.
+func (sr *statsRecorder) received(n int) {
+	sr.mtx.Lock()
+	defer sr.mtx.Unlock()
+	now := sr.now()
+	sr.stats.BytesReceived += int64(n)
+	sr.stats.MessagesReceived++
+	sr.stats.LastActivity = now
+
+	if sr.pendingCount == 0 {
+		return
+	}
+	shortest := now.Sub(sr.pendingLast)
+	longest := now.Sub(sr.pendingFirst)
+	if sr.stats.LatencySamples == 0 || shortest < sr.stats.LatencyMin {
+		sr.stats.LatencyMin = shortest
+	}
+	if longest > sr.stats.LatencyMax {
+		sr.stats.LatencyMax = longest
+	}
+	sr.stats.LatencyTotal += time.Duration(sr.pendingCount)*longest - sr.pendingOffsetSum
+	sr.stats.LatencySamples += sr.pendingCount
+	sr.pendingCount = 0
+}
+
+func (sr *statsRecorder) clientAccepted() {
+	sr.mtx.Lock()
+	defer sr.mtx.Unlock()
+	sr.stats.ClientsAccepted++
+}
+
+func (sr *statsRecorder) clientRejected() {
+	sr.mtx.Lock()
+	defer sr.mtx.Unlock()
+	sr.stats.ClientsRejected++
+}
+
+func (sr *statsRecorder) snapshot() Stats {
+	sr.mtx.Lock()
+	defer sr.mtx.Unlock()
+	return sr.stats
+}
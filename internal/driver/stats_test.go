@@ -0,0 +1,128 @@
+package driver
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_statsRecorder(t *testing.T) {
+	// each event is given as the number of milliseconds since the start, and
+	// then either "send", "fail", "recv", or "connect".
+	type event struct {
+		at   int
+		kind string
+	}
+
+	testCases := []struct {
+		name   string
+		events []event
+		expect Stats
+	}{
+		{
+			name:   "nothing",
+			expect: Stats{},
+		},
+		{
+			name:   "send and receive",
+			events: []event{{0, "connect"}, {10, "send"}, {30, "recv"}},
+			expect: Stats{
+				ConnectedAt:      at(0),
+				LastActivity:     at(30),
+				BytesSent:        4,
+				BytesReceived:    4,
+				MessagesSent:     1,
+				MessagesReceived: 1,
+				LatencySamples:   1,
+				LatencyTotal:     20 * time.Millisecond,
+				LatencyMin:       20 * time.Millisecond,
+				LatencyMax:       20 * time.Millisecond,
+			},
+		},
+		{
+			name:   "several sends before receive",
+			events: []event{{0, "connect"}, {10, "send"}, {20, "send"}, {40, "send"}, {50, "recv"}, {60, "recv"}},
+			expect: Stats{
+				ConnectedAt:      at(0),
+				LastActivity:     at(60),
+				BytesSent:        12,
+				BytesReceived:    8,
+				MessagesSent:     3,
+				MessagesReceived: 2,
+				LatencySamples:   3,
+				LatencyTotal:     (40 + 30 + 10) * time.Millisecond,
+				LatencyMin:       10 * time.Millisecond,
+				LatencyMax:       40 * time.Millisecond,
+			},
+		},
+		{
+			name:   "failed sends are not counted towards latency",
+			events: []event{{0, "connect"}, {10, "fail"}, {20, "send"}, {25, "fail"}, {50, "recv"}},
+			expect: Stats{
+				ConnectedAt:      at(0),
+				LastActivity:     at(50),
+				BytesSent:        4,
+				BytesReceived:    4,
+				MessagesSent:     1,
+				MessagesReceived: 1,
+				SendErrors:       2,
+				LatencySamples:   1,
+				LatencyTotal:     30 * time.Millisecond,
+				LatencyMin:       30 * time.Millisecond,
+				LatencyMax:       30 * time.Millisecond,
+			},
+		},
+		{
+			name:   "reconnect drops pending sends",
+			events: []event{{0, "connect"}, {10, "send"}, {20, "connect"}, {30, "send"}, {35, "recv"}},
+			expect: Stats{
+				ConnectedAt:      at(20),
+				ConnectDuration:  20 * time.Millisecond,
+				LastActivity:     at(35),
+				BytesSent:        8,
+				BytesReceived:    4,
+				MessagesSent:     2,
+				MessagesReceived: 1,
+				LatencySamples:   1,
+				LatencyTotal:     5 * time.Millisecond,
+				LatencyMin:       5 * time.Millisecond,
+				LatencyMax:       5 * time.Millisecond,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var now time.Time
+			sut := newStatsRecorder(false)
+			sut.now = func() time.Time { return now }
+
+			for _, e := range tc.events {
+				now = at(e.at)
+				switch e.kind {
+				case "connect":
+					// connecting always starts at time 0
+					sut.connected(at(0))
+				case "send":
+					sut.sent(4, nil)
+				case "fail":
+					sut.sent(0, fmt.Errorf("send failed"))
+				case "recv":
+					sut.received(4)
+				default:
+					t.Fatalf("bad test case; unknown event %q", e.kind)
+				}
+			}
+
+			actual := sut.snapshot()
+
+			if actual != tc.expect {
+				t.Errorf("expected:\n%+v\nbut got:\n%+v", tc.expect, actual)
+			}
+		})
+	}
+}
+
+func at(ms int) time.Time {
+	return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC).Add(time.Duration(ms) * time.Millisecond)
+}
@@ -25,6 +25,7 @@ type TCPConnection struct {
 	recvHandler  ReceiveHandler
 	timedOut     bool
 	onInvalidate func() error
+	stats        *statsRecorder
 }
 
 // OpenTCPClient opens a new TCP connection to a server, optionally with SSL enabled.
@@ -46,6 +47,7 @@ func OpenTCPClient(recvHandler ReceiveHandler, logCBs LoggingCallbacks, remoteHo
 		hname:        hostSocketAddr,
 		recvHandler:  recvHandler,
 		onInvalidate: func() error { return nil },
+		stats:        newStatsRecorder(false),
 	}
 
 	dialer := &net.Dialer{}
@@ -84,6 +86,7 @@ func OpenTCPClient(recvHandler ReceiveHandler, logCBs LoggingCallbacks, remoteHo
 			tlsConf.RootCAs = rootCAs
 		}
 
+		started := time.Now()
 		var err error
 		conn.socket, err = tls.DialWithDialer(dialer, "tcp", hostSocketAddr, tlsConf)
 		if err != nil {
@@ -92,7 +95,9 @@ func OpenTCPClient(recvHandler ReceiveHandler, logCBs LoggingCallbacks, remoteHo
 			}
 			return conn, err
 		}
+		conn.stats.connected(started)
 	} else {
+		started := time.Now()
 		var err error
 		conn.socket, err = dialer.Dial("tcp", hostSocketAddr)
 		if err != nil {
@@ -101,6 +106,7 @@ func OpenTCPClient(recvHandler ReceiveHandler, logCBs LoggingCallbacks, remoteHo
 			}
 			return conn, err
 		}
+		conn.stats.connected(started)
 	}
 
 	conn.startReaderThread()
@@ -122,8 +128,9 @@ func OpenTCPClient(recvHandler ReceiveHandler, logCBs LoggingCallbacks, remoteHo
 	return conn, nil
 }
 
-func newTCPConnectionFromAccept(recvHandler ReceiveHandler, logCBs LoggingCallbacks, keepalive bool, tlsConf *tls.Config, tlsHandshakeDeadline time.Time, tcpConn *net.TCPConn, onInvalidate func() error) (*TCPConnection, error) {
+func newTCPConnectionFromAccept(recvHandler ReceiveHandler, logCBs LoggingCallbacks, keepalive bool, tlsConf *tls.Config, tlsHandshakeDeadline time.Time, tcpConn *net.TCPConn, onInvalidate func() error, stats *statsRecorder) (*TCPConnection, error) {
 	// can skip a lot of checks because this is only called internally after a TCP server establishes a connection with a client.
+	started := time.Now()
 
 	if !keepalive {
 		tcpConn.SetKeepAlive(false)
@@ -159,7 +166,9 @@ func newTCPConnectionFromAccept(recvHandler ReceiveHandler, logCBs LoggingCallba
 		hname:        "",
 		recvHandler:  recvHandler,
 		onInvalidate: onInvalidate,
+		stats:        stats,
 	}
+	stats.connected(started)
 
 	conn.startReaderThread()
 
@@ -225,9 +234,12 @@ func (conn *TCPConnection) CloseActive() error {
 // ACK in response to a client PSH.)
 func (conn *TCPConnection) Send(data []byte) error {
 	if conn.closed {
-		return fmt.Errorf("this connection has been closed and can no longer be used to send")
+		err := fmt.Errorf("this connection has been closed and can no longer be used to send")
+		conn.stats.sent(0, err)
+		return err
 	}
 	n, err := conn.socket.Write(data)
+	conn.stats.sent(n, err)
 	if err != nil {
 		go conn.Close()
 		conn.onInvalidate()
@@ -257,6 +269,11 @@ func (conn *TCPConnection) GotTimeout() bool {
 	return conn.timedOut
 }
 
+// Stats returns a snapshot of the activity on the connection.
+func (conn *TCPConnection) Stats() Stats {
+	return conn.stats.snapshot()
+}
+
 func (conn *TCPConnection) startReaderThread() {
 	go func() {
 		defer close(conn.doneSignal)
@@ -268,6 +285,7 @@ func (conn *TCPConnection) startReaderThread() {
 			n, err := conn.socket.Read(buf)
 
 			if n > 0 {
+				conn.stats.received(n)
 				dataBytes := make([]byte, n)
 				copy(dataBytes, buf[:n])
 
@@ -41,6 +41,9 @@ type TCPServerConnection struct {
 	tlsConf    *tls.Config
 	onRecv     ReceiveHandler
 	onConnect  ClientConnectedHandler
+
+	// shared with each client connection so that it includes all of them
+	stats *statsRecorder
 }
 
 // OpenTCPServer opens a new TCP server listening on the given port, bound to the given address. It will accept one and only one connection,
@@ -83,6 +86,7 @@ func OpenTCPServer(recvHandler ReceiveHandler, newClientHandler ClientConnectedH
 		onConnect:  newClientHandler,
 		keepAlives: !opts.DisableKeepalives,
 		timeout:    opts.ConnectionTimeout,
+		stats:      newStatsRecorder(true),
 	}
 
 	if opts.TLSEnabled {
@@ -209,15 +213,19 @@ func (conn *TCPServerConnection) Close() (closeErr error) {
 func (conn *TCPServerConnection) Send(data []byte) error {
 	errNoClient := fmt.Errorf("this server connection doesn't currently have a client to communicate with")
 	if !conn.Ready() {
+		conn.stats.sent(0, errNoClient)
 		return errNoClient
 	}
 	if conn.IsClosed() {
-		return fmt.Errorf("this connection has been closed and can no longer be used to send")
+		err := fmt.Errorf("this connection has been closed and can no longer be used to send")
+		conn.stats.sent(0, err)
+		return err
 	}
 
 	conn.estabMutex.Lock()
 	defer conn.estabMutex.Unlock()
 	if conn.estab == nil {
+		conn.stats.sent(0, errNoClient)
 		return errNoClient
 	}
 	return conn.estab.Send(data)
@@ -253,6 +261,12 @@ func (conn *TCPServerConnection) GotTimeout() bool {
 	return conn.timedOut
 }
 
+// Stats returns a snapshot of the activity on the connection with every client
+// that has connected.
+func (conn *TCPServerConnection) Stats() Stats {
+	return conn.stats.snapshot()
+}
+
 func (conn *TCPServerConnection) startListening() {
 	go func() {
 		defer close(conn.doneSignal)
@@ -331,6 +345,7 @@ func (conn *TCPServerConnection) startListening() {
 			if conn.synchedClientIsConnected() {
 				// nope, this is an interactive console and we cant have more than one
 				conn.log.traceCb("rejected connection from client at %v due to already being in active communication with another", clientSock.RemoteAddr().String())
+				conn.stats.clientRejected()
 				continue
 			}
 
@@ -373,8 +388,9 @@ func (conn *TCPServerConnection) synchedHandleAccept(clientSock *net.TCPConn, tl
 	var err error
 	conn.estabMutex.Lock()
 	defer conn.estabMutex.Unlock()
-	conn.estab, err = newTCPConnectionFromAccept(conn.onRecv, conn.log, conn.keepAlives, conn.tlsConf, tlsHandshakeDeadline, clientSock, conn.synchedInvalidateEstab)
+	conn.estab, err = newTCPConnectionFromAccept(conn.onRecv, conn.log, conn.keepAlives, conn.tlsConf, tlsHandshakeDeadline, clientSock, conn.synchedInvalidateEstab, conn.stats)
 	if err != nil {
+		conn.stats.clientRejected()
 		if errors.Is(err, os.ErrDeadlineExceeded) {
 			conn.log.debugCb("abandoning connection; client did not send TLS hello within handshake timeout period")
 		} else {
@@ -383,6 +399,7 @@ func (conn *TCPServerConnection) synchedHandleAccept(clientSock *net.TCPConn, tl
 		return
 	}
 	conn.estabClientAddr = clientSock.RemoteAddr()
+	conn.stats.clientAccepted()
 	// do it in a go routine so it breaking doesn't blow up the accept loop
 	go conn.onConnect(clientSock.RemoteAddr().String())
 }
@@ -26,6 +26,7 @@ type UDPConnection struct {
 
 	log         LoggingCallbacks
 	recvHandler ReceiveHandler
+	stats       *statsRecorder
 }
 
 // OpenUDPConnection opens a new UDP connection. SSL (DTLS) is not supported at this time.
@@ -66,6 +67,7 @@ func OpenUDPConnection(recvHandler ReceiveHandler, logCBs LoggingCallbacks, remo
 		log:         logCBs,
 		recvHandler: recvHandler,
 		timeout:     opts.ConnectionTimeout,
+		stats:       newStatsRecorder(false),
 	}
 
 	var err error
@@ -93,10 +95,12 @@ func OpenUDPConnection(recvHandler ReceiveHandler, logCBs LoggingCallbacks, remo
 			dialer.Timeout = opts.ConnectionTimeout
 		}
 
+		started := time.Now()
 		netConn, err := dialer.Dial("udp", hostSocketAddr)
 		if err != nil {
 			return conn, err
 		}
+		conn.stats.connected(started)
 
 		var ok bool
 		if conn.socket, ok = netConn.(*net.UDPConn); !ok {
@@ -151,10 +155,14 @@ func (conn *UDPConnection) CloseActive() error {
 // Send sends binary data over the connection. A response is not waited for.
 func (conn *UDPConnection) Send(data []byte) error {
 	if conn.closed {
-		return fmt.Errorf("this connection has been closed and can no longer be used to send")
+		err := fmt.Errorf("this connection has been closed and can no longer be used to send")
+		conn.stats.sent(0, err)
+		return err
 	}
 	if !conn.Ready() {
-		return fmt.Errorf("this connection doesn't yet have a remote host to communicate with")
+		err := fmt.Errorf("this connection doesn't yet have a remote host to communicate with")
+		conn.stats.sent(0, err)
+		return err
 	}
 
 	var n int
@@ -164,6 +172,7 @@ func (conn *UDPConnection) Send(data []byte) error {
 	} else {
 		n, err = conn.socket.Write(data)
 	}
+	conn.stats.sent(n, err)
 	if err != nil {
 		return fmt.Errorf("After writing %d byte(s), got error in write: %v", n, err)
 	}
@@ -197,6 +206,11 @@ func (conn *UDPConnection) GotTimeout() bool {
 	return conn.timedOut
 }
 
+// Stats returns a snapshot of the activity on the connection.
+func (conn *UDPConnection) Stats() Stats {
+	return conn.stats.snapshot()
+}
+
 func (conn *UDPConnection) startReaderThread() {
 	go func() {
 		defer close(conn.doneSignal)
@@ -239,6 +253,7 @@ func (conn *UDPConnection) startReaderThread() {
 					conn.log.debugCb("first client has connected from %v", remoteAddr)
 					conn.firstConnected = remoteAddr
 					conn.hname = conn.firstConnected.String()
+					conn.stats.connected(time.Now())
 				}
 
 				if !conn.firstConnected.IP.Equal(remoteAddr.IP) || conn.firstConnected.Zone != remoteAddr.Zone || conn.firstConnected.Port != remoteAddr.Port {
@@ -255,6 +270,7 @@ func (conn *UDPConnection) startReaderThread() {
 			}
 
 			if n > 0 {
+				conn.stats.received(n)
 				dataBytes := make([]byte, n)
 				copy(dataBytes, buf[:n])
 
@@ -0,0 +1,169 @@
+// Package events writes a machine-readable record of a netkk session, for use
+// by programs that run netkk with --output json instead of reading the text
+// that it normally shows.
+//
+// Each event is written as a single JSON object followed by a newline. Every
+// event has these fields:
+//
+//	"v"      the version of the schema; currently always 1
+//	"time"   when the event occurred, in RFC 3339 format with nanoseconds
+//	"event"  the type of the event, which determines what other fields it has
+//
+// The types of events and the other fields that each one has are:
+//
+//	"connected"  a connection to a peer was established
+//	             "peer": address of the remote side
+//	             "local": address of the local side
+//	"sent"       bytes were sent
+//	             "peer": address they were sent to
+//	             "length": number of bytes
+//	             "hex": the bytes as lowercase hex with no separators
+//	             "base64": the bytes in standard base64 with padding
+//	"received"   bytes were received; has the same fields as "sent", with
+//	             "peer" being the address they were received from
+//	"result"     a command was executed successfully
+//	             "command": the command as it was given
+//	             "output": what the command showed, if anything
+//	"message"    an informational message
+//	             "level": the name of its level, such as "info" or "debug"
+//	             "message": the text of the message
+//	"warning"    a warning; has "message"
+//	"error"      an error; has "message", and "status" if the error ends
+//	             netkk, which is the exit status it ends with
+//	"exit"       netkk is ending; always the last event
+//	             "status": the exit status
+//
+// Fields that are empty are omitted, with the exception of "length", which is
+// always present in "sent" and "received" events, and "status", which is
+// always present in "exit" events. New types of events and new fields may be
+// added without changing the version; existing ones are only changed along
+// with the version.
+package events
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"dekarrin/netkarkat/internal/verbosity"
+)
+
+// SchemaVersion is the version of the schema of the events that are written.
+const SchemaVersion = 1
+
+// types of events.
+const (
+	TypeConnected = "connected"
+	TypeSent      = "sent"
+	TypeReceived  = "received"
+	TypeResult    = "result"
+	TypeMessage   = "message"
+	TypeWarning   = "warning"
+	TypeError     = "error"
+	TypeExit      = "exit"
+)
+
+// Event is a single event as it is written.
+type Event struct {
+	Version int       `json:"v"`
+	Time    time.Time `json:"time"`
+	Type    string    `json:"event"`
+	Peer    string    `json:"peer,omitempty"`
+	Local   string    `json:"local,omitempty"`
+	Length  *int      `json:"length,omitempty"`
+	Hex     string    `json:"hex,omitempty"`
+	Base64  string    `json:"base64,omitempty"`
+	Command string    `json:"command,omitempty"`
+	Output  string    `json:"output,omitempty"`
+	Level   string    `json:"level,omitempty"`
+	Message string    `json:"message,omitempty"`
+	Status  *int      `json:"status,omitempty"`
+}
+
+// Writer writes events. It is safe to use from multiple goroutines. All
+// methods of a nil *Writer do nothing, so that a session that does not record
+// events can use a nil Writer.
+type Writer struct {
+	mtx sync.Mutex
+	enc *json.Encoder
+
+	// now gives the time of an event; it is replaced in tests.
+	now func() time.Time
+}
+
+// NewWriter creates a Writer that writes events to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{enc: json.NewEncoder(w), now: time.Now}
+}
+
+// Connected writes an event for a connection to a peer being established.
+func (ew *Writer) Connected(peer, local string) {
+	ew.write(Event{Type: TypeConnected, Peer: peer, Local: local})
+}
+
+// Sent writes an event for bytes being sent to a peer.
+func (ew *Writer) Sent(peer string, data []byte) {
+	ew.write(dataEvent(TypeSent, peer, data))
+}
+
+// Received writes an event for bytes being received from a peer.
+func (ew *Writer) Received(peer string, data []byte) {
+	ew.write(dataEvent(TypeReceived, peer, data))
+}
+
+// Result writes an event for a command that was executed successfully.
+func (ew *Writer) Result(command, output string) {
+	ew.write(Event{Type: TypeResult, Command: command, Output: strings.TrimRight(output, "\n")})
+}
+
+// Message writes an event for a message of the given level. Warnings and
+// errors are written as their own types of events.
+func (ew *Writer) Message(lv verbosity.Level, message string) {
+	message = strings.TrimRight(message, "\n")
+	switch {
+	case lv == verbosity.Warn:
+		ew.write(Event{Type: TypeWarning, Message: message})
+	case lv.Priority() >= verbosity.Error.Priority():
+		ew.write(Event{Type: TypeError, Message: message})
+	default:
+		ew.write(Event{Type: TypeMessage, Level: strings.ToLower(lv.Name()), Message: message})
+	}
+}
+
+// Error writes an event for an error that ends netkk with the given exit
+// status.
+func (ew *Writer) Error(err error, status int) {
+	ew.write(Event{Type: TypeError, Message: err.Error(), Status: &status})
+}
+
+// Exit writes the event for netkk ending with the given exit status.
+func (ew *Writer) Exit(status int) {
+	ew.write(Event{Type: TypeExit, Status: &status})
+}
+
+func (ew *Writer) write(e Event) {
+	if ew == nil {
+		return
+	}
+	ew.mtx.Lock()
+	defer ew.mtx.Unlock()
+	e.Version = SchemaVersion
+	e.Time = ew.now()
+	// there is nothing that could be done about a failed write
+	ew.enc.Encode(e)
+}
+
+func dataEvent(eventType, peer string, data []byte) Event {
+	length := len(data)
+	return Event{
+		Type:   eventType,
+		Peer:   peer,
+		Length: &length,
+		Hex:    hex.EncodeToString(data),
+		Base64: base64.StdEncoding.EncodeToString(data),
+	}
+}
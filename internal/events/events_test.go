@@ -0,0 +1,95 @@
+package events
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"dekarrin/netkarkat/internal/verbosity"
+)
+
+func Test_Writer(t *testing.T) {
+	const ts = `"v":1,"time":"2020-01-02T03:04:05.000000006Z"`
+
+	testCases := []struct {
+		name     string
+		write    func(ew *Writer)
+		expected string
+	}{
+		{
+			name:     "connected",
+			write:    func(ew *Writer) { ew.Connected("10.0.0.1:502", "10.0.0.2:41000") },
+			expected: `{` + ts + `,"event":"connected","peer":"10.0.0.1:502","local":"10.0.0.2:41000"}`,
+		},
+		{
+			name:     "sent",
+			write:    func(ew *Writer) { ew.Sent("10.0.0.1:502", []byte{0x01, 0xfe, 'h', 'i'}) },
+			expected: `{` + ts + `,"event":"sent","peer":"10.0.0.1:502","length":4,"hex":"01fe6869","base64":"Af5oaQ=="}`,
+		},
+		{
+			name:     "received nothing",
+			write:    func(ew *Writer) { ew.Received("10.0.0.1:502", nil) },
+			expected: `{` + ts + `,"event":"received","peer":"10.0.0.1:502","length":0}`,
+		},
+		{
+			name:     "result",
+			write:    func(ew *Writer) { ew.Result("FORMAT", "Bytes are displayed as hex\n") },
+			expected: `{` + ts + `,"event":"result","command":"FORMAT","output":"Bytes are displayed as hex"}`,
+		},
+		{
+			name:     "info message",
+			write:    func(ew *Writer) { ew.Message(verbosity.Info, "connecting\n") },
+			expected: `{` + ts + `,"event":"message","level":"info","message":"connecting"}`,
+		},
+		{
+			name:     "warning message",
+			write:    func(ew *Writer) { ew.Message(verbosity.Warn, "careful") },
+			expected: `{` + ts + `,"event":"warning","message":"careful"}`,
+		},
+		{
+			name:     "error message",
+			write:    func(ew *Writer) { ew.Message(verbosity.Error, "broken") },
+			expected: `{` + ts + `,"event":"error","message":"broken"}`,
+		},
+		{
+			name:     "fatal error",
+			write:    func(ew *Writer) { ew.Error(fmt.Errorf("command #1: bad"), 2) },
+			expected: `{` + ts + `,"event":"error","message":"command #1: bad","status":2}`,
+		},
+		{
+			name:     "exit",
+			write:    func(ew *Writer) { ew.Exit(0) },
+			expected: `{` + ts + `,"event":"exit","status":0}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			sut := NewWriter(&buf)
+			sut.now = func() time.Time {
+				return time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+			}
+
+			tc.write(sut)
+
+			if actual := buf.String(); actual != tc.expected+"\n" {
+				t.Errorf("expected:\n%s\nbut got:\n%s", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_Writer_nil(t *testing.T) {
+	var sut *Writer
+
+	// none of these should panic
+	sut.Connected("peer", "local")
+	sut.Sent("peer", []byte{0x01})
+	sut.Received("peer", []byte{0x01})
+	sut.Result("cmd", "output")
+	sut.Message(verbosity.Info, "message")
+	sut.Error(fmt.Errorf("error"), 1)
+	sut.Exit(1)
+}
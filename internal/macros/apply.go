@@ -4,7 +4,6 @@ import (
 	"dekarrin/netkarkat/internal/stack"
 	"fmt"
 	"math"
-	"sort"
 	"strings"
 	"unicode/utf8"
 )
@@ -43,11 +42,15 @@ func (a sortableMacroList) Less(i, j int) bool {
 // any point during a recursion a macro is encountered that has already been
 // encountered, it is considered a loop, and the replacement will immediately
 // terminate.
+//
+// Macros with parameters must be called with an argument list. The arguments
+// are evaluated first, and then the macro's content is evaluated with each
+// parameter replaced by its argument.
 func (set macroset) Apply(text string) (string, error) {
 	var stack stack.StringStack
 	stack.Normalize = strings.ToUpper
 
-	replaced, err := set.executeMacros(text, &stack, 0)
+	replaced, err := macroScope{set: set}.expand(text, &stack, 0)
 	if err != nil {
 		return "", err
 	}
@@ -56,57 +59,101 @@ func (set macroset) Apply(text string) (string, error) {
 
 // Apply does replacement of all available macros. Returns an error if a loop is
 // detected.
+//
+// The macros available are those in the current macroset, those that it
+// inherits from its parent macrosets, and those in any other macroset when
+// they are referred to by the name of the macroset and the name of the macro
+// separated by a dot, such as "common.HEADER". Macros in the content of an
+// inherited macro are looked up starting from the current macroset, so they
+// can be overridden by it; macros in the content of a macro referred to by
+// macroset are looked up starting from that macroset.
 func (mc MacroCollection) Apply(text string) (replaced string, err error) {
 	if mc.sets == nil {
 		return text, nil
 	}
-	if set, ok := mc.sets[mc.cur]; ok {
-		return set.Apply(text)
+	// the current macroset may not exist yet, but macros in other macrosets
+	// can still be referred to from it
+	stack := stack.StringStack{Normalize: strings.ToUpper}
+	return macroScope{mc: &mc, set: mc.sets[mc.cur]}.expand(text, &stack, 0)
+}
+
+// ApplyIn does replacement of all macros available in the given macroset,
+// regardless of which macroset is current. Returns an error if a loop is
+// detected.
+func (mc MacroCollection) ApplyIn(setName, text string) (replaced string, err error) {
+	if mc.sets == nil {
+		return text, nil
 	}
-	return text, nil
+	stack := stack.StringStack{Normalize: strings.ToUpper}
+	return macroScope{mc: &mc, set: mc.sets[strings.ToUpper(setName)]}.expand(text, &stack, 0)
+}
+
+// LoopError is returned when a macro includes itself, either directly or
+// through other macros.
+type LoopError struct {
+	// Chain is the names of the macros that were being expanded when the loop
+	// was found, starting with the outermost one and ending with the macro that
+	// was included again. Macros are given the same way as the Name of an
+	// Expansion.
+	Chain []string
 }
 
-// returns true if there is a loop for the given case-insensitive macro name
-// returns false if the given macro is not a currently defined macro.
-func (set macroset) causesLoop(macro string) bool {
+func (le LoopError) Error() string {
+	return fmt.Sprintf("macro %q includes itself in a loop: %s", le.Chain[len(le.Chain)-1], strings.Join(le.Chain, " -> "))
+}
+
+func newLoopError(macrosUsed *stack.StringStack, name string) LoopError {
+	return LoopError{Chain: append(macrosUsed.Items(), name)}
+}
+
+// returns the error from expanding the given case-insensitive macro name if it
+// causes a loop. returns nil if the given macro is not a currently defined
+// macro.
+func (set macroset) checkLoop(macro string) error {
 	if set.IsDefined(macro) {
 		stack := stack.StringStack{Normalize: strings.ToUpper}
-		_, err := set.executeMacros(strings.ToUpper(macro), &stack, 0)
-		return err != nil
+		_, err := macroScope{set: set}.expand(set.GetSignature(macro), &stack, 0)
+		return err
 	}
-	return false
+	return nil
 }
 
-func (set macroset) executeMacros(text string, macrosUsed *stack.StringStack, level int) (parsed string, err error) {
-	allMacros := set.GetAll()
-
-	// we must go through in length order, descending.
+// expand replaces every macro visible in the scope that is in text.
+func (sc macroScope) expand(text string, macrosUsed *stack.StringStack, level int) (parsed string, err error) {
+	// visible gives the macros in length order, descending.
 	// otherwise longer words would get obscured by them containing
 	// a macro inside of them (e.g. we need to evaluate a macro called
 	// "OrgTeam" before we evaluate a macro called "Org" or "Team".
 	//
 	// EDIT: the above will probably not apply since we are using a regex
-	// with \b at both ends to find the macros. Do the sort anyways because
-	// it is good defensive coding and it shouldn't have issues with
-	// runtime at any reasonable number of macros.
-	sort.Sort(sortableMacroList(allMacros))
+	// with \b at both ends to find the macros. It does still matter for
+	// references to macros in other macrosets, as "common.HEADER" must be
+	// evaluated before "HEADER" is.
+	allMacros := sc.visible(text)
 
 	workingText := text
 	// for each macro...
-	for _, name := range allMacros {
-		m := set.macros[strings.ToUpper(name)]
-		matches := m.regex.FindAllStringIndex(workingText, -1)
+	for _, vm := range allMacros {
+		matches := vm.regex.FindAllStringIndex(workingText, -1)
 		if matches == nil {
 			continue
 		}
 
 		// if it is one we have seen, break out, we're in a cycle
-		if macrosUsed.Contains(name) {
-			return "", fmt.Errorf("macro %q includes itself in a loop", name)
+		if macrosUsed.Contains(vm.key()) {
+			return "", newLoopError(macrosUsed, vm.key())
+		}
+
+		if len(vm.params) > 0 {
+			workingText, err = sc.expandCalls(vm, workingText, matches, macrosUsed, level)
+			if err != nil {
+				return "", err
+			}
+			continue
 		}
 
-		macrosUsed.Push(name)
-		replacement, err := set.executeMacros(m.content, macrosUsed, level+1)
+		macrosUsed.Push(vm.key())
+		replacement, err := vm.scope.expand(vm.content, macrosUsed, level+1)
 		if err != nil {
 			return "", err
 		}
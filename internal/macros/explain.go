@@ -0,0 +1,114 @@
+package macros
+
+import (
+	"dekarrin/netkarkat/internal/misc"
+	"dekarrin/netkarkat/internal/stack"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Expansion is a record of a single use of a macro in some text and what it
+// was expanded to.
+type Expansion struct {
+	// Name is the name of the macro as it was defined. If the macro is defined
+	// in a macroset other than the default one, the name of the macroset is
+	// given before it, separated by a dot. If the macro is inherited, the
+	// macroset it was used in is given after it in parentheses.
+	Name string
+
+	// Call is the text that used the macro, including the argument list for
+	// macros with parameters.
+	Call string
+
+	// Content is the content of the macro with the arguments of the call
+	// substituted for its parameters, before any macros in it are expanded.
+	Content string
+
+	// Result is the text that the macro was fully expanded to.
+	Result string
+
+	// Children is the expansions of the macros used in Content.
+	Children []Expansion
+}
+
+type macroUse struct {
+	vm         visibleMacro
+	start, end int
+}
+
+// Explain gives the expansion of every macro used in text, along with the
+// expansions of the macros used by those, and so on. Expansions are given in
+// the order they appear in text. Returns a LoopError if a loop is detected.
+func (mc MacroCollection) Explain(text string) ([]Expansion, error) {
+	if mc.sets == nil {
+		return nil, nil
+	}
+	stack := stack.StringStack{Normalize: strings.ToUpper}
+	return macroScope{mc: &mc, set: mc.sets[mc.cur]}.explain(text, &stack)
+}
+
+func (sc macroScope) explain(text string, macrosUsed *stack.StringStack) ([]Expansion, error) {
+	var uses []macroUse
+	for _, vm := range sc.visible(text) {
+		for _, match := range vm.regex.FindAllStringIndex(text, -1) {
+			uses = append(uses, macroUse{vm: vm, start: match[0], end: match[1]})
+		}
+	}
+	sort.SliceStable(uses, func(i, j int) bool {
+		return uses[i].start < uses[j].start
+	})
+
+	var expansions []Expansion
+	prevEnd := 0
+	for _, use := range uses {
+		if use.start < prevEnd {
+			// inside of a macro that was already explained, such as in the
+			// argument list of a call
+			continue
+		}
+		vm := use.vm
+		if macrosUsed.Contains(vm.key()) {
+			return nil, newLoopError(macrosUsed, vm.key())
+		}
+		exp := Expansion{Name: vm.key(), Content: vm.content}
+
+		if len(vm.params) > 0 {
+			args, callEnd, err := parseCallArgs(text, use.end)
+			if err != nil {
+				return nil, fmt.Errorf("macro %q: %v; call it as %s", vm.ref, err, vm.signature())
+			}
+			if len(args) != len(vm.params) {
+				return nil, fmt.Errorf("macro %q takes %s but was given %d", vm.ref, misc.CountOf("argument", "arguments", len(vm.params)), len(args))
+			}
+			use.end = callEnd
+			exp.Content = vm.substituteParams(func(idx int) string {
+				return args[idx]
+			})
+		}
+		exp.Call = text[use.start:use.end]
+
+		// arguments are expanded in this scope but the rest of the content is
+		// expanded in the scope of the macro. They are the same unless the
+		// macro was referred to by macroset, so the children are found in
+		// the scope of the macro.
+		macrosUsed.Push(vm.key())
+		children, err := vm.scope.explain(exp.Content, macrosUsed)
+		if err != nil {
+			return nil, err
+		}
+		macrosUsed.Pop()
+		exp.Children = children
+
+		// the result is found by expanding the call on its own so that it is
+		// exactly what Apply would give for it
+		exp.Result, err = sc.expand(exp.Call, macrosUsed, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		expansions = append(expansions, exp)
+		prevEnd = use.end
+	}
+	return expansions, nil
+}
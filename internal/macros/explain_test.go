@@ -0,0 +1,102 @@
+package macros
+
+import (
+	"dekarrin/netkarkat/internal/stack"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_macroset_explain(t *testing.T) {
+	sut := testMacrosetWithMacros(t, map[string]string{
+		"HEADER":      "\\x7e VERSION",
+		"VERSION":     "\\x02",
+		"wrap(inner)": "[inner]",
+	})
+
+	testCases := []struct {
+		name      string
+		input     string
+		expected  []Expansion
+		expectErr bool
+	}{
+		{name: "no macros", input: "hello", expected: nil},
+		{
+			name:  "single macro",
+			input: "VERSION x",
+			expected: []Expansion{
+				{Name: "VERSION", Call: "VERSION", Content: "\\x02", Result: "\\x02"},
+			},
+		},
+		{
+			name:  "nested macros",
+			input: "HEADER",
+			expected: []Expansion{
+				{Name: "HEADER", Call: "HEADER", Content: "\\x7e VERSION", Result: "\\x7e \\x02", Children: []Expansion{
+					{Name: "VERSION", Call: "VERSION", Content: "\\x02", Result: "\\x02"},
+				}},
+			},
+		},
+		{
+			name:  "in order of appearance",
+			input: "VERSION HEADER",
+			expected: []Expansion{
+				{Name: "VERSION", Call: "VERSION", Content: "\\x02", Result: "\\x02"},
+				{Name: "HEADER", Call: "HEADER", Content: "\\x7e VERSION", Result: "\\x7e \\x02", Children: []Expansion{
+					{Name: "VERSION", Call: "VERSION", Content: "\\x02", Result: "\\x02"},
+				}},
+			},
+		},
+		{
+			name:  "call with macro in arg",
+			input: "wrap(VERSION)",
+			expected: []Expansion{
+				{Name: "wrap", Call: "wrap(VERSION)", Content: "[VERSION]", Result: "[\\x02]", Children: []Expansion{
+					{Name: "VERSION", Call: "VERSION", Content: "\\x02", Result: "\\x02"},
+				}},
+			},
+		},
+		{name: "bad call", input: "wrap(a, b)", expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := macroScope{set: sut}.explain(tc.input, &stack.StringStack{Normalize: strings.ToUpper})
+
+			// check for error
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				t.Fatalf("expected an error but nil error was returned")
+			}
+
+			// check the value
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Fatalf("expected %+v but got: %+v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_macroset_Apply_loopChain(t *testing.T) {
+	sut := testMacrosetWithMacros(t, map[string]string{
+		"BBB": "x",
+		"AAA": "1 BBB",
+	})
+
+	// definitions that would cause a loop are rejected, so create one
+	// directly
+	m := sut.macros["BBB"]
+	m.content = "2 AAA"
+	sut.macros["BBB"] = m
+
+	_, err := sut.Apply("AAA")
+	loopErr, ok := err.(LoopError)
+	if !ok {
+		t.Fatalf("expected a LoopError but got: %v", err)
+	}
+	expected := []string{"AAA", "BBB", "AAA"}
+	if !reflect.DeepEqual(expected, loopErr.Chain) {
+		t.Fatalf("expected chain %q but got: %q", expected, loopErr.Chain)
+	}
+}
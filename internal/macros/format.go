@@ -0,0 +1,326 @@
+package macros
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// SchemaVersion is the version of the structure of JSON and YAML macros files
+// that is written by Export. Files with older versions are migrated to it when
+// they are read.
+const SchemaVersion = 1
+
+// FileFormat is a format that macros can be exported to and imported from.
+type FileFormat int
+
+const (
+	// FormatLegacy is the original line-based format. Each macroset starts
+	// with its name in square brackets, and each macro is given on its own
+	// line as its name followed by its contents. It cannot hold macros whose
+	// contents have line breaks, macro descriptions and tags, or the parents
+	// of macrosets, so that older versions of netkk can still read it.
+	FormatLegacy FileFormat = iota
+
+	// FormatJSON is a JSON document that gives the schema version and each
+	// macroset along with its macros.
+	FormatJSON
+
+	// FormatYAML is the same as FormatJSON but in YAML.
+	FormatYAML
+)
+
+// String gives the name of the format.
+func (format FileFormat) String() string {
+	switch format {
+	case FormatLegacy:
+		return "legacy"
+	case FormatJSON:
+		return "JSON"
+	case FormatYAML:
+		return "YAML"
+	default:
+		return fmt.Sprintf("FileFormat(%d)", int(format))
+	}
+}
+
+// FileFormatOf gives the format of a macros file based on its extension.
+// Files ending in .json are JSON, files ending in .yaml or .yml are YAML, and
+// all others are in the legacy format.
+func FileFormatOf(filename string) FileFormat {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return FormatJSON
+	case ".yaml", ".yml":
+		return FormatYAML
+	default:
+		return FormatLegacy
+	}
+}
+
+// migrations upgrade a decoded file from the schema version it is keyed by to
+// the next one. When the structure of the file changes, SchemaVersion is
+// increased and a migration from the previous version is added here.
+var migrations = map[int]func(doc map[string]interface{}) error{}
+
+// macrosDocument is the structure of a JSON or YAML macros file.
+type macrosDocument struct {
+	Version   int                `json:"version" yaml:"version"`
+	Macrosets []macrosetDocument `json:"macrosets" yaml:"macrosets"`
+}
+
+type macrosetDocument struct {
+	// Name is empty for the default macroset.
+	Name     string          `json:"name" yaml:"name"`
+	Inherits []string        `json:"inherits,omitempty" yaml:"inherits,omitempty"`
+	Macros   []macroDocument `json:"macros" yaml:"macros"`
+}
+
+type macroDocument struct {
+	Name        string   `json:"name" yaml:"name"`
+	Params      []string `json:"params,omitempty" yaml:"params,omitempty"`
+	Content     string   `json:"content" yaml:"content"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+}
+
+// ExportFormat exports the given macrosets to the given writer in the given
+// format. If no macrosets are given, all of them are exported. Macrosets that
+// have no macros and inherit from nothing are skipped.
+func (mc *MacroCollection) ExportFormat(w io.Writer, format FileFormat, setNames ...string) (setsExported int, macrosExported int, err error) {
+	if format == FormatLegacy {
+		if len(setNames) < 1 {
+			return mc.Export(w)
+		}
+		for _, name := range setNames {
+			if !mc.IsDefinedMacroset(name) && len(mc.GetParents(name)) < 1 {
+				continue
+			}
+			setCount, macroCount, err := mc.ExportSet(name, w)
+			if err != nil {
+				return 0, 0, err
+			}
+			setsExported += setCount
+			macrosExported += macroCount
+		}
+		return setsExported, macrosExported, nil
+	}
+
+	if len(setNames) < 1 {
+		setNames = mc.GetSetNames()
+	}
+	doc := macrosDocument{Version: SchemaVersion, Macrosets: []macrosetDocument{}}
+	for _, name := range setNames {
+		if !mc.macrosetExists(name) {
+			continue
+		}
+		set := mc.sets[strings.ToUpper(name)]
+		if set.Len() < 1 && len(set.parents) < 1 {
+			continue
+		}
+		setDoc := macrosetDocument{
+			Name:     set.name,
+			Inherits: append([]string(nil), set.parents...),
+			Macros:   []macroDocument{},
+		}
+		for _, macroName := range set.GetAll() {
+			m := set.macros[strings.ToUpper(macroName)]
+			setDoc.Macros = append(setDoc.Macros, macroDocument{
+				Name:        m.name,
+				Params:      append([]string(nil), m.params...),
+				Content:     m.content,
+				Description: m.description,
+				Tags:        append([]string(nil), m.tags...),
+			})
+		}
+		doc.Macrosets = append(doc.Macrosets, setDoc)
+		setsExported++
+		macrosExported += set.Len()
+	}
+
+	// the default macroset always goes first, as it does in the legacy format
+	sort.SliceStable(doc.Macrosets, func(i, j int) bool {
+		return doc.Macrosets[i].Name == "" && doc.Macrosets[j].Name != ""
+	})
+
+	if format == FormatYAML {
+		data, err := yaml.Marshal(doc)
+		if err != nil {
+			return 0, 0, fmt.Errorf("could not encode YAML: %v", err)
+		}
+		if _, err := w.Write(data); err != nil {
+			return 0, 0, err
+		}
+		return setsExported, macrosExported, nil
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return 0, 0, fmt.Errorf("could not encode JSON: %v", err)
+	}
+	return setsExported, macrosExported, nil
+}
+
+// ImportFormat reads macroset definitions in the given format from the given
+// reader and applies them to the collection the same as Import does.
+func (mc *MacroCollection) ImportFormat(r io.Reader, format FileFormat) (setsLoaded int, macrosLoaded int, err error) {
+	plan, err := mc.PlanImport(r, format)
+	if err != nil {
+		return 0, 0, err
+	}
+	items, err := mc.ApplyImport(plan, ImportOptions{Mode: MergeOverwrite})
+	if err != nil {
+		return 0, 0, err
+	}
+	sets := map[string]bool{}
+	for _, item := range items {
+		sets[strings.ToUpper(item.Set)] = true
+		if item.Macro != "" {
+			macrosLoaded++
+		}
+	}
+	return len(sets), macrosLoaded, nil
+}
+
+// Convert reads macros in one format and writes the same macros in another.
+func Convert(r io.Reader, from FileFormat, w io.Writer, to FileFormat) error {
+	parsed, err := parseFile(r, from, 0)
+	if err != nil {
+		return err
+	}
+	_, _, err = parsed.ExportFormat(w, to)
+	return err
+}
+
+// parseFile reads macroset definitions in the given format from the given
+// reader into a new MacroCollection.
+func parseFile(r io.Reader, format FileFormat, minLength int) (MacroCollection, error) {
+	if format == FormatLegacy {
+		return parseMacrosFile(r, minLength)
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return MacroCollection{}, fmt.Errorf("problem reading input: %v", err)
+	}
+	var raw interface{}
+	if format == FormatYAML {
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return MacroCollection{}, fmt.Errorf("could not decode YAML: %v", err)
+		}
+		raw = jsonCompatible(raw)
+	} else {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return MacroCollection{}, fmt.Errorf("could not decode JSON: %v", err)
+		}
+	}
+	obj, ok := raw.(map[string]interface{})
+	if !ok {
+		return MacroCollection{}, fmt.Errorf("file must contain a mapping with a version and macrosets")
+	}
+	if err := migrate(obj); err != nil {
+		return MacroCollection{}, err
+	}
+
+	// the migrated file is decoded again into its structure, which makes the
+	// same checks for both JSON and YAML
+	migrated, err := json.Marshal(obj)
+	if err != nil {
+		return MacroCollection{}, fmt.Errorf("could not re-encode migrated file: %v", err)
+	}
+	var doc macrosDocument
+	dec := json.NewDecoder(bytes.NewReader(migrated))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return MacroCollection{}, fmt.Errorf("file is not in the macros %s format: %v", format, err)
+	}
+
+	parsed := MacroCollection{MinLength: minLength}
+	for _, setDoc := range doc.Macrosets {
+		if setDoc.Name != "" {
+			if err := validateName(setDoc.Name, "macroset", macroset{MinLength: minLength}.getMinLength()); err != nil {
+				return MacroCollection{}, err
+			}
+		}
+		if len(setDoc.Inherits) > 0 {
+			if err := parsed.SetParents(setDoc.Name, setDoc.Inherits); err != nil {
+				return MacroCollection{}, fmt.Errorf("macroset %q: %v", setDoc.Name, err)
+			}
+		}
+		for _, m := range setDoc.Macros {
+			signature := m.Name
+			if len(m.Params) > 0 {
+				signature += "(" + strings.Join(m.Params, ",") + ")"
+			}
+			if err := parsed.DefineIn(setDoc.Name, signature, m.Content); err != nil {
+				return MacroCollection{}, fmt.Errorf("macro %q: %v", QualifiedName(setDoc.Name, m.Name), err)
+			}
+			set := parsed.sets[strings.ToUpper(setDoc.Name)]
+			if err := set.SetInfo(m.Name, Info{Description: m.Description, Tags: m.Tags}); err != nil {
+				return MacroCollection{}, fmt.Errorf("macro %q: %v", QualifiedName(setDoc.Name, m.Name), err)
+			}
+		}
+	}
+	return parsed, nil
+}
+
+// migrate upgrades a decoded file to the current schema version.
+func migrate(doc map[string]interface{}) error {
+	var version int
+	switch v := doc["version"].(type) {
+	case nil:
+		return fmt.Errorf("file does not give a schema version")
+	case float64:
+		version = int(v)
+		if float64(version) != v {
+			return fmt.Errorf("schema version must be a whole number")
+		}
+	case int:
+		version = v
+	default:
+		return fmt.Errorf("schema version must be a number")
+	}
+
+	if version < 1 {
+		return fmt.Errorf("schema version %d is not valid", version)
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("file has schema version %d, but only versions up to %d can be read; it was probably written by a newer version of netkk", version, SchemaVersion)
+	}
+	for ; version < SchemaVersion; version++ {
+		if err := migrations[version](doc); err != nil {
+			return fmt.Errorf("migrating from schema version %d: %v", version, err)
+		}
+	}
+	doc["version"] = SchemaVersion
+	return nil
+}
+
+// jsonCompatible converts the maps decoded from YAML, which can have keys of
+// any type, into maps with string keys that can be encoded as JSON.
+func jsonCompatible(v interface{}) interface{} {
+	switch typed := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(typed))
+		for key, value := range typed {
+			m[fmt.Sprintf("%v", key)] = jsonCompatible(value)
+		}
+		return m
+	case []interface{}:
+		for idx := range typed {
+			typed[idx] = jsonCompatible(typed[idx])
+		}
+		return typed
+	default:
+		return v
+	}
+}
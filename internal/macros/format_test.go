@@ -0,0 +1,176 @@
+package macros
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_Convert_roundTrip(t *testing.T) {
+	legacy := "" +
+		"# description: start of frame\n" +
+		"# tags: framing, v1\n" +
+		"HEADER \\x7e VERSION\n" +
+		"VERSION \\x01\n" +
+		"\n" +
+		"[device]\n" +
+		"@inherit common\n" +
+		"login(user,pass) \\x01 user \\x00 pass\n" +
+		"\n"
+
+	testCases := []struct {
+		name      string
+		format    FileFormat
+		expectErr bool
+	}{
+		{name: "JSON", format: FormatJSON},
+		{name: "YAML", format: FormatYAML},
+		{name: "legacy cannot hold info or parents", format: FormatLegacy, expectErr: true},
+	}
+
+	var expected bytes.Buffer
+	if err := Convert(strings.NewReader(legacy), FormatLegacy, &expected, FormatJSON); err != nil {
+		t.Fatalf("prep step: converting to JSON returned an error: %v", err)
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var converted bytes.Buffer
+			err := Convert(strings.NewReader(legacy), FormatLegacy, &converted, tc.format)
+
+			// check for error
+			if err != nil && !tc.expectErr {
+				t.Fatalf("converting from legacy returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				t.Fatalf("expected an error but nil error was returned")
+			}
+			if tc.expectErr {
+				return
+			}
+
+			// check the value
+			var back bytes.Buffer
+			if err := Convert(&converted, tc.format, &back, FormatJSON); err != nil {
+				t.Fatalf("converting back returned an error: %v", err)
+			}
+			if back.String() != expected.String() {
+				t.Errorf("expected round trip to give:\n%s\nbut got:\n%s", expected.String(), back.String())
+			}
+		})
+	}
+}
+
+func Test_MacroCollection_ImportFormat(t *testing.T) {
+	testCases := []struct {
+		name      string
+		format    FileFormat
+		input     string
+		macro     string
+		expected  string
+		expectErr bool
+	}{
+		{
+			name:     "JSON",
+			format:   FormatJSON,
+			input:    `{"version": 1, "macrosets": [{"name": "", "macros": [{"name": "BODY", "content": "\\x01"}]}]}`,
+			macro:    "BODY",
+			expected: "\\x01",
+		},
+		{
+			name:     "YAML",
+			format:   FormatYAML,
+			input:    "version: 1\nmacrosets:\n- name: \"\"\n  macros:\n  - name: BODY\n    content: '\\x01'\n",
+			macro:    "BODY",
+			expected: "\\x01",
+		},
+		{
+			name:     "content with line breaks",
+			format:   FormatJSON,
+			input:    `{"version": 1, "macrosets": [{"name": "", "macros": [{"name": "BODY", "content": "\\x01\n\\x02"}]}]}`,
+			macro:    "BODY",
+			expected: "\\x01\n\\x02",
+		},
+		{
+			name:      "no version",
+			format:    FormatJSON,
+			input:     `{"macrosets": []}`,
+			expectErr: true,
+		},
+		{
+			name:      "newer version",
+			format:    FormatYAML,
+			input:     "version: 99\nmacrosets: []\n",
+			expectErr: true,
+		},
+		{
+			name:      "unknown field",
+			format:    FormatJSON,
+			input:     `{"version": 1, "macrosets": [{"name": "", "macros": [{"name": "BODY", "contents": "\\x01"}]}]}`,
+			expectErr: true,
+		},
+		{
+			name:      "invalid macro name",
+			format:    FormatJSON,
+			input:     `{"version": 1, "macrosets": [{"name": "", "macros": [{"name": "BAD NAME", "content": "\\x01"}]}]}`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sut := &MacroCollection{}
+			_, _, err := sut.ImportFormat(strings.NewReader(tc.input), tc.format)
+
+			// check for error
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				t.Fatalf("expected an error but nil error was returned")
+			}
+
+			// check the value
+			if actual := sut.Get(tc.macro); !tc.expectErr && actual != tc.expected {
+				t.Errorf("expected %q to be %q but got: %q", tc.macro, tc.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_MacroCollection_Export_lineBreaks(t *testing.T) {
+	sut := &MacroCollection{}
+	if err := sut.Define("BODY", "\\x01\n\\x02"); err != nil {
+		t.Fatalf("prep step: pre-test macro definition failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, _, err := sut.ExportFormat(&buf, FormatLegacy); err == nil {
+		t.Errorf("expected an error exporting to the legacy format but nil error was returned")
+	}
+	buf.Reset()
+	if _, _, err := sut.ExportFormat(&buf, FormatJSON); err != nil {
+		t.Errorf("exporting to JSON returned an error: %v", err)
+	}
+}
+
+func Test_FileFormatOf(t *testing.T) {
+	testCases := []struct {
+		filename string
+		expected FileFormat
+	}{
+		{filename: "macros.m", expected: FormatLegacy},
+		{filename: "macros", expected: FormatLegacy},
+		{filename: "macros.json", expected: FormatJSON},
+		{filename: "dir.yaml/macros.JSON", expected: FormatJSON},
+		{filename: "macros.yaml", expected: FormatYAML},
+		{filename: "macros.yml", expected: FormatYAML},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.filename, func(t *testing.T) {
+			actual := FileFormatOf(tc.filename)
+			if actual != tc.expected {
+				t.Errorf("expected %v but got: %v", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package macros
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// the keys of the comment lines in a legacy macros file that give the info of
+// the macro defined after them. They are only read; files in the legacy format
+// are not written with them, since versions of netkk without macro info
+// cannot read them.
+const (
+	descriptionKey = "description"
+	tagsKey        = "tags"
+)
+
+// Info is the documentation of a macro. It has no effect on how the macro is
+// expanded.
+type Info struct {
+	// Description is a short explanation of what the macro is for.
+	Description string
+
+	// Tags are words that the macro can be found by.
+	Tags []string
+}
+
+// ParseTags splits text into tags. Tags are separated by commas or whitespace,
+// and any that are given more than once (in any case) are only included once.
+func ParseTags(text string) []string {
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	var tags []string
+	seen := map[string]bool{}
+	for _, f := range fields {
+		if seen[strings.ToUpper(f)] {
+			continue
+		}
+		seen[strings.ToUpper(f)] = true
+		tags = append(tags, f)
+	}
+	return tags
+}
+
+// GetInfo gets the info of the given macro. If it is not defined, an empty Info
+// is returned. Macro name is not case sensitive.
+func (set macroset) GetInfo(macro string) Info {
+	if !set.IsDefined(macro) {
+		return Info{}
+	}
+	m := set.macros[strings.ToUpper(macro)]
+	return Info{Description: m.description, Tags: append([]string(nil), m.tags...)}
+}
+
+// SetInfo replaces the info of the given macro. Macro name is not case
+// sensitive.
+func (set *macroset) SetInfo(macro string, info Info) error {
+	if !set.IsDefined(macro) {
+		return fmt.Errorf("no macro named %q exists", macro)
+	}
+	if strings.ContainsAny(info.Description, "\r\n") {
+		return fmt.Errorf("description cannot contain line breaks")
+	}
+	m := set.macros[strings.ToUpper(macro)]
+	m.description = strings.TrimSpace(info.Description)
+	m.tags = ParseTags(strings.Join(info.Tags, " "))
+	set.macros[strings.ToUpper(macro)] = m
+	return nil
+}
+
+// GetInfo gets the info of a macro in the current macroset. The name is case
+// insensitive. If the macro does not exist, an empty Info is returned.
+func (mc *MacroCollection) GetInfo(macro string) Info {
+	return mc.GetInfoIn(mc.GetCurrentMacroset(), macro)
+}
+
+// GetInfoIn gets the info of a macro in the given macroset. The names are case
+// insensitive. If the macro does not exist, an empty Info is returned.
+func (mc *MacroCollection) GetInfoIn(setName, macro string) Info {
+	if !mc.macrosetExists(setName) {
+		return Info{}
+	}
+	return mc.sets[strings.ToUpper(setName)].GetInfo(macro)
+}
+
+// SetInfo replaces the info of a macro in the current macroset. The name is
+// case insensitive.
+func (mc *MacroCollection) SetInfo(macro string, info Info) error {
+	defer mc.record("describe " + QualifiedName(mc.GetCurrentMacroset(), macro))()
+
+	if !mc.IsDefined(macro) {
+		return fmt.Errorf("no macro named %q exists", macro)
+	}
+	set := mc.sets[mc.cur]
+	return set.SetInfo(macro, info)
+}
+
+// SearchResult is a macro that matched a search.
+type SearchResult struct {
+	// Set is the name of the macroset the macro is in.
+	Set string
+
+	// Name is the name of the macro.
+	Name string
+
+	// Fields is what about the macro matched, such as "name" or "content".
+	Fields []string
+}
+
+// Search finds every macro in every macroset whose name, description, tags,
+// or content contain the query. The search is case-insensitive. Results are
+// in the order of the macroset names and then the macro names.
+func (mc MacroCollection) Search(query string) []SearchResult {
+	query = strings.ToUpper(query)
+	var results []SearchResult
+	for _, setName := range mc.GetSetNames() {
+		if !mc.macrosetExists(setName) {
+			continue
+		}
+		set := mc.sets[strings.ToUpper(setName)]
+		for _, name := range set.GetAll() {
+			m := set.macros[strings.ToUpper(name)]
+			var fields []string
+			if strings.Contains(strings.ToUpper(m.name), query) {
+				fields = append(fields, "name")
+			}
+			if strings.Contains(strings.ToUpper(m.description), query) {
+				fields = append(fields, "description")
+			}
+			for _, t := range m.tags {
+				if strings.Contains(strings.ToUpper(t), query) {
+					fields = append(fields, "tag")
+					break
+				}
+			}
+			if strings.Contains(strings.ToUpper(m.content), query) {
+				fields = append(fields, "content")
+			}
+			if len(fields) > 0 {
+				results = append(results, SearchResult{Set: setName, Name: m.name, Fields: fields})
+			}
+		}
+	}
+	return results
+}
+
+// parseInfoLine reads a comment line from a macros file into the info that
+// will be given to the next macro defined. Returns false if the line is not a
+// comment. Comments that do not give info are ignored.
+func parseInfoLine(line string, pending *Info) bool {
+	if !strings.HasPrefix(line, "#") {
+		return false
+	}
+	comment := strings.TrimSpace(line[1:])
+	colon := strings.IndexRune(comment, ':')
+	if colon < 0 {
+		return true
+	}
+	key := strings.ToLower(strings.TrimSpace(comment[:colon]))
+	value := strings.TrimSpace(comment[colon+1:])
+	switch key {
+	case descriptionKey:
+		pending.Description = value
+	case tagsKey:
+		pending.Tags = ParseTags(value)
+	}
+	return true
+}
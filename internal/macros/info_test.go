@@ -0,0 +1,123 @@
+package macros
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_MacroCollection_Import_info(t *testing.T) {
+	input := strings.Join([]string{
+		"# just a comment",
+		"# description: sends the handshake",
+		"# tags: auth, handshake",
+		"HANDSHAKE \\x01\\x02",
+		"PLAIN \\x03",
+		"",
+		"[device]",
+		"# tags: dev dev DEV other",
+		"login(user) \\x04 user",
+	}, "\n")
+
+	var sut MacroCollection
+	if _, _, err := sut.Import(strings.NewReader(input)); err != nil {
+		t.Fatalf("import returned an error: %v", err)
+	}
+
+	testCases := []struct {
+		set      string
+		macro    string
+		expected Info
+	}{
+		{set: "", macro: "HANDSHAKE", expected: Info{Description: "sends the handshake", Tags: []string{"auth", "handshake"}}},
+		{set: "", macro: "PLAIN", expected: Info{}},
+		{set: "device", macro: "login", expected: Info{Tags: []string{"dev", "other"}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.macro, func(t *testing.T) {
+			actual := sut.GetInfoIn(tc.set, tc.macro)
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Fatalf("expected %+v but got: %+v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_MacroCollection_Export_info(t *testing.T) {
+	var sut MacroCollection
+	if err := sut.Define("HANDSHAKE", "\\x01"); err != nil {
+		t.Fatalf("prep step: define failed: %v", err)
+	}
+	if err := sut.SetInfo("HANDSHAKE", Info{Description: "sends it", Tags: []string{"a", "b"}}); err != nil {
+		t.Fatalf("prep step: set info failed: %v", err)
+	}
+
+	// the legacy format is left readable by versions of netkk without info
+	var buf bytes.Buffer
+	if _, _, err := sut.Export(&buf); err == nil {
+		t.Fatalf("expected an error but nil error was returned")
+	}
+
+	buf.Reset()
+	if _, _, err := sut.ExportFormat(&buf, FormatJSON); err != nil {
+		t.Fatalf("JSON export returned an error: %v", err)
+	}
+	var imported MacroCollection
+	if _, _, err := imported.ImportFormat(&buf, FormatJSON); err != nil {
+		t.Fatalf("import returned an error: %v", err)
+	}
+	expected := Info{Description: "sends it", Tags: []string{"a", "b"}}
+	if actual := imported.GetInfo("HANDSHAKE"); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected %+v but got: %+v", expected, actual)
+	}
+}
+
+func Test_MacroCollection_Search(t *testing.T) {
+	var sut MacroCollection
+	defs := []struct {
+		set, signature, content string
+		info                    Info
+	}{
+		{set: "", signature: "HANDSHAKE", content: "\\x01", info: Info{Description: "Sends the greeting", Tags: []string{"auth"}}},
+		{set: "", signature: "LOGOUT", content: "bye"},
+		{set: "dev", signature: "GREETING", content: "hello"},
+	}
+	for _, d := range defs {
+		if err := sut.DefineIn(d.set, d.signature, d.content); err != nil {
+			t.Fatalf("prep step: define failed: %v", err)
+		}
+		set := sut.sets[strings.ToUpper(d.set)]
+		if err := set.SetInfo(d.signature, d.info); err != nil {
+			t.Fatalf("prep step: set info failed: %v", err)
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		query    string
+		expected []SearchResult
+	}{
+		{name: "no match", query: "nothing", expected: nil},
+		{name: "by content", query: "BYE", expected: []SearchResult{{Set: "", Name: "LOGOUT", Fields: []string{"content"}}}},
+		{name: "by tag", query: "auth", expected: []SearchResult{{Set: "", Name: "HANDSHAKE", Fields: []string{"tag"}}}},
+		{
+			name:  "across macrosets",
+			query: "greet",
+			expected: []SearchResult{
+				{Set: "", Name: "HANDSHAKE", Fields: []string{"description"}},
+				{Set: "dev", Name: "GREETING", Fields: []string{"name"}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := sut.Search(tc.query)
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Fatalf("expected %+v but got: %+v", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package macros
+
+import (
+	"dekarrin/netkarkat/internal/stack"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// macroScope is the macroset that text is being expanded in. When the
+// macroset is a part of a MacroCollection, the macros it inherits from its
+// parents and qualified references to macros in other macrosets are visible in
+// addition to its own.
+type macroScope struct {
+	// mc is nil when a macroset is used on its own, in which case only the
+	// macros in set are visible.
+	mc  *MacroCollection
+	set macroset
+}
+
+// visibleMacro is a macro as it can be used in a particular scope.
+type visibleMacro struct {
+	macro
+
+	// ref is the text that refers to the macro. It is the name of the macro,
+	// or for a qualified reference, the name of the macroset and the name of
+	// the macro separated by a dot. The regex of the embedded macro matches
+	// ref.
+	ref string
+
+	// from is the name of the macroset that defines the macro.
+	from string
+
+	// scope is the scope that the content of the macro is expanded in.
+	scope macroScope
+}
+
+// key gives the name that the macro is tracked by on the stack during
+// expansion. It includes the macroset the macro is defined in so that loops
+// are detected across macrosets. An inherited macro can expand differently
+// than it does in the macroset that defines it, so the scope it is expanded in
+// is included as well when they are not the same.
+func (vm visibleMacro) key() string {
+	key := vm.name
+	if vm.from != "" {
+		key = vm.from + "." + vm.name
+	}
+	if !strings.EqualFold(vm.from, vm.scope.set.name) {
+		scopeName := vm.scope.set.name
+		if scopeName == "" {
+			scopeName = "default macroset"
+		}
+		key += " (in " + scopeName + ")"
+	}
+	return key
+}
+
+// lineage gives the macroset of the scope followed by all of the macrosets it
+// inherits from, in the order that they are searched for macros: each parent
+// in the order it was given, with each parent's own parents searched right
+// after it. Parents that do not exist are skipped, and each macroset is only
+// given once even if it is inherited more than once.
+func (sc macroScope) lineage() []macroset {
+	sets := []macroset{sc.set}
+	if sc.mc == nil {
+		return sets
+	}
+	visited := map[string]bool{strings.ToUpper(sc.set.name): true}
+	var visit func(set macroset)
+	visit = func(set macroset) {
+		for _, p := range set.parents {
+			if visited[strings.ToUpper(p)] {
+				continue
+			}
+			visited[strings.ToUpper(p)] = true
+			parent, ok := sc.mc.sets[strings.ToUpper(p)]
+			if !ok {
+				continue
+			}
+			sets = append(sets, parent)
+			visit(parent)
+		}
+	}
+	visit(sc.set)
+	return sets
+}
+
+// visible gives every macro that can be used in text in the scope, ordered so
+// that the longest references come first. Qualified references are only
+// included for macrosets whose names appear in text.
+func (sc macroScope) visible(text string) []visibleMacro {
+	var vis []visibleMacro
+	seen := map[string]bool{}
+	for _, set := range sc.lineage() {
+		for nameUpper, m := range set.macros {
+			if seen[nameUpper] {
+				continue
+			}
+			seen[nameUpper] = true
+			vis = append(vis, visibleMacro{macro: m, ref: m.name, from: set.name, scope: sc})
+		}
+	}
+
+	if sc.mc != nil && text != "" {
+		upperText := strings.ToUpper(text)
+		for setUpper, other := range sc.mc.sets {
+			if setUpper == "" || !strings.Contains(upperText, setUpper+".") {
+				continue
+			}
+			otherScope := macroScope{mc: sc.mc, set: other}
+			for _, vm := range otherScope.visible("") {
+				vm.ref = other.name + "." + vm.name
+				vm.regex = regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(vm.ref) + `\b`)
+				vis = append(vis, vm)
+			}
+		}
+	}
+
+	sort.SliceStable(vis, func(i, j int) bool {
+		return sortableMacroList{vis[i].ref, vis[j].ref}.Less(0, 1)
+	})
+	return vis
+}
+
+// lookup finds the macro that ref refers to in the scope. ref is either the
+// name of a macro or a qualified reference.
+func (sc macroScope) lookup(ref string) (visibleMacro, bool) {
+	for _, vm := range sc.visible(ref) {
+		if strings.EqualFold(vm.ref, ref) {
+			return vm, true
+		}
+	}
+	return visibleMacro{}, false
+}
+
+// checkLoopsFor returns an error if using the given macro in any macroset it
+// is visible in causes a loop.
+func (mc *MacroCollection) checkLoopsFor(macro string) error {
+	for _, set := range mc.sets {
+		sc := macroScope{mc: mc, set: set}
+		vm, ok := sc.lookup(macro)
+		if !ok {
+			continue
+		}
+		stack := stack.StringStack{Normalize: strings.ToUpper}
+		if _, err := sc.expand(vm.signature(), &stack, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkLoopsIn returns an error if using any macro visible in the given
+// macroset or in any macroset that inherits from it causes a loop.
+func (mc *MacroCollection) checkLoopsIn(setName string) error {
+	for _, set := range mc.sets {
+		sc := macroScope{mc: mc, set: set}
+		inherits := false
+		for _, s := range sc.lineage() {
+			if strings.EqualFold(s.name, setName) {
+				inherits = true
+				break
+			}
+		}
+		if !inherits {
+			continue
+		}
+		for _, vm := range sc.visible("") {
+			stack := stack.StringStack{Normalize: strings.ToUpper}
+			if _, err := sc.expand(vm.signature(), &stack, 0); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SetParents sets the macrosets that the given macroset inherits macros from.
+// Macros in the macroset override ones with the same name in its parents, and
+// earlier parents override later ones. Parents do not need to exist; until
+// they do, they add no macros. Giving no parents removes all of them. The
+// names are case-insensitive.
+func (mc *MacroCollection) SetParents(setName string, parents []string) error {
+	defer mc.record(fmt.Sprintf("set parents of macroset %q", setName))()
+
+	seen := map[string]bool{}
+	var cleaned []string
+	for _, p := range parents {
+		if err := validateName(p, "macroset", macroset{MinLength: mc.MinLength}.getMinLength()); err != nil {
+			return err
+		}
+		if strings.EqualFold(p, setName) {
+			return fmt.Errorf("macroset %q cannot inherit from itself", p)
+		}
+		if seen[strings.ToUpper(p)] {
+			continue
+		}
+		seen[strings.ToUpper(p)] = true
+		cleaned = append(cleaned, p)
+	}
+
+	if mc.sets == nil {
+		mc.sets = make(map[string]macroset)
+	}
+	set, exists := mc.sets[strings.ToUpper(setName)]
+	if !exists {
+		set = macroset{
+			name:      setName,
+			MinLength: mc.MinLength,
+		}
+	}
+	oldParents := set.parents
+	set.parents = cleaned
+	mc.sets[strings.ToUpper(setName)] = set
+
+	if err := mc.checkLoopsIn(setName); err != nil {
+		set.parents = oldParents
+		mc.sets[strings.ToUpper(setName)] = set
+		if loopErr, ok := err.(LoopError); ok {
+			return fmt.Errorf("inheriting causes a loop: %s", strings.Join(loopErr.Chain, " -> "))
+		}
+		return err
+	}
+	return nil
+}
+
+// GetParents gives the macrosets that the given macroset inherits from, in the
+// order they are searched.
+func (mc *MacroCollection) GetParents(setName string) []string {
+	if !mc.macrosetExists(setName) {
+		return nil
+	}
+	return append([]string(nil), mc.sets[strings.ToUpper(setName)].parents...)
+}
+
+// Resolve finds the macro that ref refers to from the current macroset. ref is
+// either the name of a macro, which may be inherited, or the name of a
+// macroset and the name of a macro in it separated by a dot. Returns the name
+// of the macroset that the macro is defined in and the name of the macro, or
+// false if ref does not refer to a macro.
+func (mc *MacroCollection) Resolve(ref string) (setName string, macroName string, ok bool) {
+	if !mc.macrosetExists(mc.cur) {
+		return "", "", false
+	}
+	vm, ok := macroScope{mc: mc, set: mc.sets[mc.cur]}.lookup(ref)
+	if !ok {
+		return "", "", false
+	}
+	return vm.from, vm.name, true
+}
+
+// GetVisibleNamesIn gives a list of all macros that can be used in the given
+// macroset, including those it inherits. For each, the name of the macroset
+// that defines it is also given.
+func (mc *MacroCollection) GetVisibleNamesIn(setName string) (names []string, from []string) {
+	if !mc.macrosetExists(setName) {
+		return nil, nil
+	}
+	vis := macroScope{mc: mc, set: mc.sets[strings.ToUpper(setName)]}.visible("")
+	sort.Slice(vis, func(i, j int) bool {
+		return vis[i].name < vis[j].name
+	})
+	for _, vm := range vis {
+		names = append(names, vm.name)
+		from = append(from, vm.from)
+	}
+	return names, from
+}
+
+// GetIn gets the contents of a macro in the given macroset. The names are case
+// insensitive. Inherited macros are not included. If the macro does not exist,
+// the empty string is returned.
+func (mc *MacroCollection) GetIn(setName, macro string) string {
+	if !mc.macrosetExists(setName) {
+		return ""
+	}
+	return mc.sets[strings.ToUpper(setName)].Get(macro)
+}
+
+// inheritDirective begins the line in a legacy macros file that gives the
+// parents of the macroset whose section it is in. It is only read; files in
+// the legacy format are not written with it, since versions of netkk without
+// inheritance cannot read them.
+const inheritDirective = "@inherit"
+
+// checkLegacyParents returns an error if a macroset with the given parents
+// cannot be saved in the legacy format.
+func checkLegacyParents(parents []string) error {
+	if len(parents) > 0 {
+		return fmt.Errorf("it inherits from other macrosets, so it can only be saved in the JSON or YAML format")
+	}
+	return nil
+}
+
+// parseInheritLine reads the parents out of a line in a legacy macros file.
+// Returns false if the line does not give parents.
+func parseInheritLine(line string) (parents []string, ok bool) {
+	if len(line) < len(inheritDirective) || !strings.EqualFold(line[:len(inheritDirective)], inheritDirective) {
+		return nil, false
+	}
+	rest := line[len(inheritDirective):]
+	if rest != "" && !unicode.IsSpace(rune(rest[0])) {
+		return nil, false
+	}
+	return strings.FieldsFunc(rest, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	}), true
+}
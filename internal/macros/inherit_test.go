@@ -0,0 +1,187 @@
+package macros
+
+import (
+	"bytes"
+	"testing"
+)
+
+func testCollectionWithSets(t *testing.T, defs map[string]map[string]string, parents map[string][]string) *MacroCollection {
+	mc := &MacroCollection{}
+	for setName, p := range parents {
+		if err := mc.SetParents(setName, p); err != nil {
+			t.Fatalf("prep step: setting parents of %q failed: %v", setName, err)
+		}
+	}
+	for setName, macroDefs := range defs {
+		for name, content := range macroDefs {
+			if err := mc.DefineIn(setName, name, content); err != nil {
+				t.Fatalf("prep step: pre-test macro definition for %q in %q failed: %v", name, setName, err)
+			}
+		}
+	}
+	return mc
+}
+
+func Test_MacroCollection_Apply_inheritance(t *testing.T) {
+	mc := testCollectionWithSets(t,
+		map[string]map[string]string{
+			"common": {
+				"HEADER":     "\\x7e VERSION",
+				"VERSION":    "\\x01",
+				"wrap(data)": "[data]",
+			},
+			"extra": {
+				"TRAILER": "\\x7f",
+				"VERSION": "\\x09",
+			},
+			"device": {
+				"VERSION": "\\x02",
+				"BOTH":    "common.HEADER HEADER",
+			},
+		},
+		map[string][]string{
+			"device": {"common", "extra"},
+		},
+	)
+
+	testCases := []struct {
+		name      string
+		set       string
+		input     string
+		expected  string
+		expectErr bool
+	}{
+		{name: "own macro", set: "device", input: "VERSION", expected: "\\x02"},
+		{name: "inherited macro uses override", set: "device", input: "HEADER", expected: "\\x7e \\x02"},
+		{name: "inherited from second parent", set: "device", input: "TRAILER", expected: "\\x7f"},
+		{name: "inherited call", set: "device", input: "wrap(VERSION)", expected: "[\\x02]"},
+		{name: "qualified reference uses its own macroset", set: "device", input: "common.HEADER", expected: "\\x7e \\x01"},
+		{name: "qualified and inherited together", set: "device", input: "BOTH", expected: "\\x7e \\x01 \\x7e \\x02"},
+		{name: "qualified reference from default macroset", set: "", input: "extra.VERSION common.wrap(x)", expected: "\\x09 [x]"},
+		{name: "qualified reference to inherited macro", set: "", input: "device.TRAILER", expected: "\\x7f"},
+		{name: "parent does not see child", set: "common", input: "BOTH", expected: "BOTH"},
+		{name: "unknown qualified reference", set: "", input: "common.NOPE", expected: "common.NOPE"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := mc.ApplyIn(tc.set, tc.input)
+
+			// check for error
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				t.Fatalf("expected an error but nil error was returned")
+			}
+
+			// check the value
+			if tc.expected != actual {
+				t.Fatalf("expected %q but got: %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_MacroCollection_loopAcrossSets(t *testing.T) {
+	testCases := []struct {
+		name      string
+		parents   map[string][]string
+		defs      [][3]string
+		expectErr bool
+	}{
+		{
+			name:      "qualified references in a loop",
+			defs:      [][3]string{{"first", "AAA", "x"}, {"second", "BBB", "first.AAA"}, {"first", "AAA", "second.BBB"}},
+			expectErr: true,
+		},
+		{
+			name:      "inherited macro uses child macro that uses it",
+			parents:   map[string][]string{"child": {"parent"}},
+			defs:      [][3]string{{"parent", "AAA", "BBB"}, {"child", "BBB", "AAA"}},
+			expectErr: true,
+		},
+		{
+			name:    "inherited macro used through a qualified reference",
+			parents: map[string][]string{"child": {"parent"}},
+			defs:    [][3]string{{"parent", "AAA", "\\x7e BBB"}, {"child", "BBB", "x"}, {"parent", "BBB", "child.AAA"}},
+		},
+		{
+			name:    "override calls the macro it overrides",
+			parents: map[string][]string{"child": {"parent"}},
+			defs:    [][3]string{{"parent", "AAA", "x"}, {"child", "AAA", "parent.AAA y"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mc := testCollectionWithSets(t, nil, tc.parents)
+			var err error
+			for _, def := range tc.defs {
+				if err = mc.DefineIn(def[0], def[1], def[2]); err != nil {
+					break
+				}
+			}
+
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				t.Fatalf("expected an error but nil error was returned")
+			}
+		})
+	}
+}
+
+func Test_MacroCollection_SetParents(t *testing.T) {
+	mc := testCollectionWithSets(t,
+		map[string]map[string]string{
+			"parent": {"AAA": "BBB"},
+			"child":  {"BBB": "AAA"},
+		},
+		nil,
+	)
+
+	if err := mc.SetParents("child", []string{"child"}); err == nil {
+		t.Errorf("inheriting from itself: expected an error but nil error was returned")
+	}
+	if err := mc.SetParents("child", []string{"parent"}); err == nil {
+		t.Errorf("inheriting into a loop: expected an error but nil error was returned")
+	}
+	if parents := mc.GetParents("child"); len(parents) != 0 {
+		t.Errorf("expected parents to be unchanged after error but got: %q", parents)
+	}
+}
+
+func Test_MacroCollection_Export_inheritance(t *testing.T) {
+	mc := testCollectionWithSets(t,
+		map[string]map[string]string{
+			"common": {"HEADER": "\\x7e"},
+			"device": {"BODY": "HEADER"},
+		},
+		map[string][]string{
+			"device": {"common"},
+		},
+	)
+
+	// the legacy format is left readable by versions of netkk without
+	// inheritance
+	var buf bytes.Buffer
+	if _, _, err := mc.Export(&buf); err == nil {
+		t.Fatalf("expected an error but nil error was returned")
+	}
+
+	buf.Reset()
+	if _, _, err := mc.ExportFormat(&buf, FormatJSON); err != nil {
+		t.Fatalf("JSON export returned an error: %v", err)
+	}
+	var imported MacroCollection
+	if _, _, err := imported.ImportFormat(&buf, FormatJSON); err != nil {
+		t.Fatalf("import returned an error: %v", err)
+	}
+	actual, err := imported.ApplyIn("device", "BODY")
+	if err != nil {
+		t.Fatalf("apply returned an error: %v", err)
+	}
+	if actual != "\\x7e" {
+		t.Fatalf("expected %q but got: %q", "\\x7e", actual)
+	}
+}
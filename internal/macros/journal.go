@@ -0,0 +1,385 @@
+package macros
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxJournalEntries is the number of entries a journal keeps. Once it is
+// reached, the oldest entry is dropped each time a new one is added.
+const maxJournalEntries = 500
+
+// MacroRecord is the definition of a macro at some point in time. The zero
+// value is a macro that is not defined.
+type MacroRecord struct {
+	// Signature is the name of the macro along with its parameter list, if it
+	// has one.
+	Signature string
+
+	// Content is the content of the macro.
+	Content string
+
+	// Info is the documentation of the macro.
+	Info Info
+}
+
+// Defined returns whether the record is of a macro that is defined.
+func (rec MacroRecord) Defined() bool {
+	return rec.Signature != ""
+}
+
+// JournalChange is a change to a single macro or to the parents of a single
+// macroset.
+type JournalChange struct {
+	// Set is the name of the macroset that was changed.
+	Set string
+
+	// Macro is the name of the macro that was changed. It is empty if the
+	// parents of the macroset were changed instead.
+	Macro string
+
+	// Before and After are the macro before and after the change.
+	Before, After MacroRecord
+
+	// ParentsBefore and ParentsAfter are the parents of the macroset before
+	// and after the change. They are only set if Macro is empty.
+	ParentsBefore, ParentsAfter []string
+}
+
+// JournalEntry is a single change made to a MacroCollection, such as a
+// definition or a rename. It contains every macro that the change affected.
+type JournalEntry struct {
+	// Time is when the change was made.
+	Time time.Time
+
+	// Action is a description of what was done.
+	Action string
+
+	// Changes is each macro and macroset that was changed.
+	Changes []JournalChange
+
+	// CurrentBefore and CurrentAfter are the name of the current macroset
+	// before and after the change.
+	CurrentBefore, CurrentAfter string
+}
+
+// journal is the record of the changes made to a MacroCollection.
+type journal struct {
+	entries []JournalEntry
+
+	// applied is the number of entries that have not been undone. Entries
+	// after it can be redone.
+	applied int
+
+	// depth is the number of changes being recorded at once. Only the
+	// outermost one is added to the journal; the others are a part of it.
+	depth  int
+	action string
+	before map[string]setSnapshot
+	cur    string
+}
+
+// journalFile is what is written when a journal is exported.
+type journalFile struct {
+	Entries []JournalEntry
+	Applied int
+
+	// MacrosHash is the SHA-256 hash of the exported macros that the journal
+	// was written with.
+	MacrosHash []byte
+}
+
+// setSnapshot is the state of a macroset at some point in time.
+type setSnapshot struct {
+	name    string
+	parents []string
+	macros  map[string]MacroRecord
+}
+
+// StartJournal begins recording changes to the collection in a new, empty
+// journal so that they can be undone. Until it is called, changes are not
+// recorded.
+func (mc *MacroCollection) StartJournal() {
+	mc.journal = &journal{}
+}
+
+// ExportJournal writes the journal to the given writer so that it can be
+// loaded with ImportJournal. macros is the export of the collection that is
+// being saved along with the journal; a hash of it is written so that a
+// journal that no longer matches the macros can be told apart.
+func (mc *MacroCollection) ExportJournal(w io.Writer, macros []byte) error {
+	sum := sha256.Sum256(macros)
+	jf := journalFile{MacrosHash: sum[:]}
+	if mc.journal != nil {
+		jf.Entries = mc.journal.entries
+		jf.Applied = mc.journal.applied
+	}
+	if err := gob.NewEncoder(w).Encode(jf); err != nil {
+		return fmt.Errorf("could not encode journal: %v", err)
+	}
+	return nil
+}
+
+// ImportJournal replaces the journal with one read from the given reader and
+// begins recording changes in it. macros is the export of the collection
+// that the journal is being loaded for. If the journal was not written with
+// the same export, such as when the macros were changed without it, an error
+// is returned and the journal is not changed.
+func (mc *MacroCollection) ImportJournal(r io.Reader, macros []byte) error {
+	var jf journalFile
+	if err := gob.NewDecoder(r).Decode(&jf); err != nil {
+		return fmt.Errorf("could not decode journal: %v", err)
+	}
+	if jf.Applied < 0 || jf.Applied > len(jf.Entries) {
+		return fmt.Errorf("journal is corrupted")
+	}
+	if sum := sha256.Sum256(macros); !bytes.Equal(jf.MacrosHash, sum[:]) {
+		return fmt.Errorf("journal is for macros that have since been changed")
+	}
+	mc.journal = &journal{entries: jf.Entries, applied: jf.Applied}
+	return nil
+}
+
+// History gives every entry in the journal, oldest first, along with the
+// number of them that are applied. Entries after those have been undone and
+// can be redone.
+func (mc *MacroCollection) History() (entries []JournalEntry, applied int) {
+	if mc.journal == nil {
+		return nil, 0
+	}
+	return append([]JournalEntry(nil), mc.journal.entries...), mc.journal.applied
+}
+
+// BeginChange starts a change described by action. Every edit made until the
+// returned function is called is recorded in the journal as a single entry,
+// so that they are undone together.
+func (mc *MacroCollection) BeginChange(action string) (end func()) {
+	return mc.record(action)
+}
+
+// Undo reverts the most recent change that has not already been undone and
+// returns it.
+func (mc *MacroCollection) Undo() (JournalEntry, error) {
+	j := mc.journal
+	if j == nil || j.applied < 1 {
+		return JournalEntry{}, fmt.Errorf("nothing to undo")
+	}
+	entry := j.entries[j.applied-1]
+	mc.restore(entry, true)
+	j.applied--
+	return entry, nil
+}
+
+// Redo makes the most recently undone change again and returns it.
+func (mc *MacroCollection) Redo() (JournalEntry, error) {
+	j := mc.journal
+	if j == nil || j.applied >= len(j.entries) {
+		return JournalEntry{}, fmt.Errorf("nothing to redo")
+	}
+	entry := j.entries[j.applied]
+	mc.restore(entry, false)
+	j.applied++
+	return entry, nil
+}
+
+// record begins recording a change described by action and returns the
+// function that finishes it. Anything after the applied entries is discarded
+// when the change is added, so undone changes can no longer be redone. Nothing
+// is added if the change turned out to have no effect, such as when it failed.
+func (mc *MacroCollection) record(action string) (end func()) {
+	j := mc.journal
+	if j == nil {
+		return func() {}
+	}
+	j.depth++
+	if j.depth == 1 {
+		j.action = action
+		j.before = mc.snapshot()
+		j.cur = mc.GetCurrentMacroset()
+	}
+	return func() {
+		j.depth--
+		if j.depth > 0 {
+			return
+		}
+		entry := JournalEntry{
+			Time:          time.Now(),
+			Action:        j.action,
+			Changes:       diffSnapshots(j.before, mc.snapshot()),
+			CurrentBefore: j.cur,
+			CurrentAfter:  mc.GetCurrentMacroset(),
+		}
+		j.before = nil
+		if len(entry.Changes) < 1 && entry.CurrentBefore == entry.CurrentAfter {
+			return
+		}
+		j.entries = append(j.entries[:j.applied], entry)
+		if len(j.entries) > maxJournalEntries {
+			j.entries = j.entries[len(j.entries)-maxJournalEntries:]
+		}
+		j.applied = len(j.entries)
+	}
+}
+
+// snapshot gives the state of every macroset in the collection.
+func (mc *MacroCollection) snapshot() map[string]setSnapshot {
+	snap := map[string]setSnapshot{}
+	for key, set := range mc.sets {
+		ss := setSnapshot{
+			name:    set.name,
+			parents: append([]string(nil), set.parents...),
+			macros:  map[string]MacroRecord{},
+		}
+		for nameUpper, m := range set.macros {
+			ss.macros[nameUpper] = set.record(m.name)
+		}
+		snap[key] = ss
+	}
+	return snap
+}
+
+// diffSnapshots gives every change between two snapshots, in the order of the
+// macroset names and then the macro names.
+func diffSnapshots(before, after map[string]setSnapshot) []JournalChange {
+	setKeys := map[string]bool{}
+	for key := range before {
+		setKeys[key] = true
+	}
+	for key := range after {
+		setKeys[key] = true
+	}
+	var sortedSetKeys []string
+	for key := range setKeys {
+		sortedSetKeys = append(sortedSetKeys, key)
+	}
+	sort.Strings(sortedSetKeys)
+
+	var changes []JournalChange
+	for _, setKey := range sortedSetKeys {
+		b, a := before[setKey], after[setKey]
+		setName := a.name
+		if _, ok := after[setKey]; !ok {
+			setName = b.name
+		}
+
+		if !equalNames(b.parents, a.parents) {
+			changes = append(changes, JournalChange{
+				Set:           setName,
+				ParentsBefore: b.parents,
+				ParentsAfter:  a.parents,
+			})
+		}
+
+		macroKeys := map[string]bool{}
+		for key := range b.macros {
+			macroKeys[key] = true
+		}
+		for key := range a.macros {
+			macroKeys[key] = true
+		}
+		var sortedMacroKeys []string
+		for key := range macroKeys {
+			sortedMacroKeys = append(sortedMacroKeys, key)
+		}
+		sort.Strings(sortedMacroKeys)
+
+		for _, macroKey := range sortedMacroKeys {
+			bm, am := b.macros[macroKey], a.macros[macroKey]
+			if reflect.DeepEqual(bm, am) {
+				continue
+			}
+			name := strings.SplitN(am.Signature, "(", 2)[0]
+			if !am.Defined() {
+				name = strings.SplitN(bm.Signature, "(", 2)[0]
+			}
+			changes = append(changes, JournalChange{Set: setName, Macro: name, Before: bm, After: am})
+		}
+	}
+	return changes
+}
+
+// equalNames returns whether two lists of names are the same. Case is
+// significant so that a change to only the case of a name is recorded.
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+// restore puts every macro changed in entry back to how it was before the
+// change if before is true, or to how it was after the change otherwise. The
+// restore itself is not recorded. Macrosets that the change left empty are
+// removed unless they are the current or the default macroset.
+func (mc *MacroCollection) restore(entry JournalEntry, before bool) {
+	if mc.sets == nil {
+		mc.sets = map[string]macroset{"": {MinLength: mc.MinLength}}
+	}
+
+	touched := map[string]bool{}
+	for _, c := range entry.Changes {
+		key := strings.ToUpper(c.Set)
+		touched[key] = true
+		set, ok := mc.sets[key]
+		if !ok {
+			set = macroset{name: c.Set, MinLength: mc.MinLength}
+		}
+
+		if c.Macro == "" {
+			parents := c.ParentsAfter
+			if before {
+				parents = c.ParentsBefore
+			}
+			set.parents = append([]string(nil), parents...)
+		} else {
+			rec := c.After
+			if before {
+				rec = c.Before
+			}
+			delete(set.macros, strings.ToUpper(c.Macro))
+			if rec.Defined() {
+				// the record was valid when it was made, and every other
+				// macro it could refer to is being restored along with it
+				_ = set.define(rec.Signature, rec.Content, false)
+				_ = set.SetInfo(c.Macro, rec.Info)
+			}
+		}
+		mc.sets[key] = set
+	}
+
+	cur := entry.CurrentAfter
+	if before {
+		cur = entry.CurrentBefore
+	}
+	if entry.CurrentBefore != entry.CurrentAfter {
+		_ = mc.SetCurrentMacroset(cur)
+	}
+
+	for key := range touched {
+		set := mc.sets[key]
+		if key != "" && key != mc.cur && set.Len() < 1 && len(set.parents) < 1 {
+			delete(mc.sets, key)
+		}
+	}
+}
+
+// QualifiedName gives the name of a macro preceded by the name of its
+// macroset, unless it is in the default macroset.
+func QualifiedName(setName, macro string) string {
+	if setName == "" {
+		return macro
+	}
+	return setName + "." + macro
+}
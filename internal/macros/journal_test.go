@@ -0,0 +1,264 @@
+package macros
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_MacroCollection_Undo(t *testing.T) {
+	testCases := []struct {
+		name      string
+		edit      func(mc *MacroCollection) error
+		undos     int
+		redos     int
+		expected  string
+		expectErr bool
+	}{
+		{
+			name: "undo new definition",
+			edit: func(mc *MacroCollection) error {
+				return mc.Define("TRAILER", "\\x7f")
+			},
+			undos:    1,
+			expected: "BODY \\x01\nHEADER \\x7e BODY\n",
+		},
+		{
+			name: "undo redefinition",
+			edit: func(mc *MacroCollection) error {
+				return mc.Define("BODY", "\\x02")
+			},
+			undos:    1,
+			expected: "BODY \\x01\nHEADER \\x7e BODY\n",
+		},
+		{
+			name: "undo undefine that replaced uses",
+			edit: func(mc *MacroCollection) error {
+				mc.Undefine("BODY", true)
+				return nil
+			},
+			undos:    1,
+			expected: "BODY \\x01\nHEADER \\x7e BODY\n",
+		},
+		{
+			name: "undo rename that replaced uses",
+			edit: func(mc *MacroCollection) error {
+				return mc.Rename("BODY", "PAYLOAD", true)
+			},
+			undos:    1,
+			expected: "BODY \\x01\nHEADER \\x7e BODY\n",
+		},
+		{
+			name: "undo and redo",
+			edit: func(mc *MacroCollection) error {
+				mc.Undefine("BODY", true)
+				return nil
+			},
+			undos:    1,
+			redos:    1,
+			expected: "HEADER \\x7e \\x01\n",
+		},
+		{
+			name: "undo twice",
+			edit: func(mc *MacroCollection) error {
+				if err := mc.Define("BODY", "\\x02"); err != nil {
+					return err
+				}
+				return mc.Define("BODY", "\\x03")
+			},
+			undos:    2,
+			expected: "BODY \\x01\nHEADER \\x7e BODY\n",
+		},
+		{
+			name: "undo one of two",
+			edit: func(mc *MacroCollection) error {
+				if err := mc.Define("BODY", "\\x02"); err != nil {
+					return err
+				}
+				return mc.Define("BODY", "\\x03")
+			},
+			undos:    1,
+			expected: "BODY \\x02\nHEADER \\x7e BODY\n",
+		},
+		{
+			name: "grouped change is undone together",
+			edit: func(mc *MacroCollection) error {
+				end := mc.BeginChange("define TRAILER")
+				defer end()
+				if err := mc.Define("TRAILER", "\\x7f"); err != nil {
+					return err
+				}
+				return mc.SetInfo("TRAILER", Info{Description: "end of frame"})
+			},
+			undos:    1,
+			expected: "BODY \\x01\nHEADER \\x7e BODY\n",
+		},
+		{
+			name: "undo info change",
+			edit: func(mc *MacroCollection) error {
+				return mc.SetInfo("BODY", Info{Description: "the body"})
+			},
+			undos:    1,
+			expected: "BODY \\x01\nHEADER \\x7e BODY\n",
+		},
+		{
+			name: "undo renaming a macroset",
+			edit: func(mc *MacroCollection) error {
+				if err := mc.DefineIn("common", "VERSION", "\\x09"); err != nil {
+					return err
+				}
+				return mc.RenameSet("common", "shared")
+			},
+			undos:    1,
+			expected: "BODY \\x01\nHEADER \\x7e BODY\n\n[common]\nVERSION \\x09\n",
+		},
+		{
+			name: "undo parents",
+			edit: func(mc *MacroCollection) error {
+				return mc.SetParents("", []string{"common"})
+			},
+			undos:    1,
+			expected: "BODY \\x01\nHEADER \\x7e BODY\n",
+		},
+		{
+			name: "failed change is not recorded",
+			edit: func(mc *MacroCollection) error {
+				if err := mc.Define("BODY", "\\x02"); err != nil {
+					return err
+				}
+				if err := mc.Define("BODY", "HEADER"); err == nil {
+					t.Fatalf("prep step: loop was not detected")
+				}
+				return nil
+			},
+			undos:    1,
+			expected: "BODY \\x01\nHEADER \\x7e BODY\n",
+		},
+		{
+			name: "nothing to undo",
+			edit: func(mc *MacroCollection) error {
+				return nil
+			},
+			undos:     1,
+			expectErr: true,
+		},
+		{
+			name: "nothing to redo",
+			edit: func(mc *MacroCollection) error {
+				return mc.Define("BODY", "\\x02")
+			},
+			redos:     1,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sut := &MacroCollection{}
+			if err := sut.Define("BODY", "\\x01"); err != nil {
+				t.Fatalf("prep step: pre-test macro definition failed: %v", err)
+			}
+			if err := sut.Define("HEADER", "\\x7e BODY"); err != nil {
+				t.Fatalf("prep step: pre-test macro definition failed: %v", err)
+			}
+			sut.StartJournal()
+			if err := tc.edit(sut); err != nil {
+				t.Fatalf("prep step: edit failed: %v", err)
+			}
+
+			var err error
+			for i := 0; i < tc.undos && err == nil; i++ {
+				_, err = sut.Undo()
+			}
+			for i := 0; i < tc.redos && err == nil; i++ {
+				_, err = sut.Redo()
+			}
+
+			// check for error
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				t.Fatalf("expected an error but nil error was returned")
+			}
+			if tc.expectErr {
+				return
+			}
+
+			// check the value
+			var buf bytes.Buffer
+			if _, _, err := sut.Export(&buf); err != nil {
+				t.Fatalf("export failed: %v", err)
+			}
+			actual := strings.TrimRight(buf.String(), "\n") + "\n"
+			if actual != tc.expected {
+				t.Errorf("expected export:\n%s\nbut got:\n%s", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_MacroCollection_ImportJournal(t *testing.T) {
+	sut := &MacroCollection{}
+	sut.StartJournal()
+	if err := sut.Define("BODY", "\\x01"); err != nil {
+		t.Fatalf("prep step: pre-test macro definition failed: %v", err)
+	}
+	if err := sut.Define("BODY", "\\x02"); err != nil {
+		t.Fatalf("prep step: pre-test macro definition failed: %v", err)
+	}
+	if _, err := sut.Undo(); err != nil {
+		t.Fatalf("prep step: undo failed: %v", err)
+	}
+
+	var exported bytes.Buffer
+	if _, _, err := sut.Export(&exported); err != nil {
+		t.Fatalf("prep step: macros export failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := sut.ExportJournal(&buf, exported.Bytes()); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	loaded := &MacroCollection{}
+	if err := loaded.Define("BODY", "\\x01"); err != nil {
+		t.Fatalf("prep step: pre-test macro definition failed: %v", err)
+	}
+	if err := loaded.ImportJournal(&buf, exported.Bytes()); err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	entries, applied := loaded.History()
+	if len(entries) != 2 || applied != 1 {
+		t.Fatalf("expected 2 entries with 1 applied but got %d with %d applied", len(entries), applied)
+	}
+	if entries[1].Action != "define BODY" {
+		t.Errorf("expected action %q but got %q", "define BODY", entries[1].Action)
+	}
+	if _, err := loaded.Redo(); err != nil {
+		t.Fatalf("redo failed: %v", err)
+	}
+	if actual := loaded.Get("BODY"); actual != "\\x02" {
+		t.Errorf("expected BODY to be %q after redo but got %q", "\\x02", actual)
+	}
+}
+
+func Test_MacroCollection_ImportJournal_changedMacros(t *testing.T) {
+	sut := &MacroCollection{}
+	sut.StartJournal()
+	if err := sut.Define("BODY", "\\x01"); err != nil {
+		t.Fatalf("prep step: pre-test macro definition failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := sut.ExportJournal(&buf, []byte("BODY \\x01\n")); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+
+	loaded := &MacroCollection{}
+	loaded.StartJournal()
+	err := loaded.ImportJournal(&buf, []byte("BODY \\x02\n"))
+	if err == nil {
+		t.Fatalf("expected an error but nil error was returned")
+	}
+	if entries, _ := loaded.History(); len(entries) != 0 {
+		t.Errorf("expected journal to not be changed but it has %d entries", len(entries))
+	}
+}
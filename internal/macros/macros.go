@@ -42,12 +42,26 @@ type macro struct {
 	name    string
 	content string
 	regex   *regexp.Regexp
+
+	// params is the names of the parameters of the macro, if it has any.
+	// paramRegex will match any of them in the content.
+	params     []string
+	paramRegex *regexp.Regexp
+
+	// description and tags document the macro. They are kept when the macro
+	// is redefined.
+	description string
+	tags        []string
 }
 
 type macroset struct {
 	name   string
 	macros map[string]macro
 
+	// parents is the names of the macrosets that this one inherits macros
+	// from. It is only used when the macroset is in a MacroCollection.
+	parents []string
+
 	// MinLength is the same as MinLength in MacroCollection.
 	MinLength int
 }
@@ -103,7 +117,13 @@ func (set macroset) Export(w io.Writer) error {
 	// alphabetize them
 	macroNames := set.GetAll()
 	for _, name := range macroNames {
-		if _, err := bufW.WriteString(name); err != nil {
+		if strings.ContainsAny(set.Get(name), "\r\n") {
+			return fmt.Errorf("macro %q has line breaks in its contents, so it can only be saved in the JSON or YAML format", name)
+		}
+		if info := set.GetInfo(name); info.Description != "" || len(info.Tags) > 0 {
+			return fmt.Errorf("macro %q has a description or tags, so it can only be saved in the JSON or YAML format", name)
+		}
+		if _, err := bufW.WriteString(set.GetSignature(name)); err != nil {
 			return err
 		}
 		if _, err := bufW.WriteRune(' '); err != nil {
@@ -137,10 +157,11 @@ func (set *macroset) Import(r io.Reader) error {
 	scan := bufio.NewScanner(r)
 
 	lineNo := 0
+	var info Info
 	for scan.Scan() {
 		lineNo++
 		line := strings.TrimSpace(scan.Text())
-		if line == "" {
+		if line == "" || parseInfoLine(line, &info) {
 			continue
 		}
 		name, content, err := parseMacroImportLine(line)
@@ -150,6 +171,10 @@ func (set *macroset) Import(r io.Reader) error {
 		if err := set.Define(name, content); err != nil {
 			return err
 		}
+		if err := set.SetInfo(strings.SplitN(name, "(", 2)[0], info); err != nil {
+			return err
+		}
+		info = Info{}
 	}
 	if err := scan.Err(); err != nil {
 		return fmt.Errorf("problem reading input: %v", err)
@@ -157,16 +182,12 @@ func (set *macroset) Import(r io.Reader) error {
 	return nil
 }
 
-func parseMacroImportLine(line string) (name string, content string, err error) {
-	parts := strings.SplitN(line, " ", 2)
-	if len(parts) < 1 {
+func parseMacroImportLine(line string) (signature string, content string, err error) {
+	signature, content = SplitDefinition(line)
+	if signature == "" {
 		return "", "", fmt.Errorf("blank definition not allowed")
 	}
-	name = parts[0]
-	if len(parts) >= 2 {
-		content = parts[1]
-	}
-	return name, content, nil
+	return signature, content, nil
 }
 
 // Get gets the contents of the given macro. If it is not defined, empty string
@@ -178,6 +199,16 @@ func (set macroset) Get(macro string) string {
 	return set.macros[strings.ToUpper(macro)].content
 }
 
+// GetSignature gets the name of the given macro along with its parameter list,
+// if it has one. If it is not defined, empty string is returned. Macro name is
+// not case sensitive.
+func (set macroset) GetSignature(macro string) string {
+	if !set.IsDefined(macro) {
+		return ""
+	}
+	return set.macros[strings.ToUpper(macro)].signature()
+}
+
 // GetAll gets a list of all currently-defined macros.
 func (set macroset) GetAll() []string {
 	if set.macros == nil {
@@ -194,6 +225,11 @@ func (set macroset) GetAll() []string {
 // Rename changes the name of a macro from one definition to another. If replace is given,
 // also updates all usages of the macro's name in all other macros to match.
 func (set *macroset) Rename(oldName string, newName string, replace bool) error {
+	return set.rename(oldName, newName, replace, true)
+}
+
+// rename does a Rename. standalone is the same as in define.
+func (set *macroset) rename(oldName string, newName string, replace bool, standalone bool) error {
 	if set.macros == nil || !set.IsDefined(oldName) {
 		return fmt.Errorf("no macro named %q exists", oldName)
 	}
@@ -206,7 +242,14 @@ func (set *macroset) Rename(oldName string, newName string, replace bool) error
 	}
 
 	oldMacro := set.macros[strings.ToUpper(oldName)]
-	if err := set.Define(newName, oldMacro.content); err != nil {
+	newSignature := newName
+	if len(oldMacro.params) > 0 {
+		newSignature += "(" + strings.Join(oldMacro.params, ",") + ")"
+	}
+	if err := set.define(newSignature, oldMacro.content, standalone); err != nil {
+		return err
+	}
+	if err := set.SetInfo(newName, set.GetInfo(oldName)); err != nil {
 		return err
 	}
 	set.Undefine(oldName, false)
@@ -214,8 +257,23 @@ func (set *macroset) Rename(oldName string, newName string, replace bool) error
 }
 
 // Define creates a new definition for a macro of the given name. The name is
-// case-insensitive.
-func (set *macroset) Define(name string, content string) error {
+// case-insensitive. To define a macro that takes arguments, give a list of
+// parameter names in parentheses after the name, such as "login(user, pass)";
+// each parameter in the content is replaced with the matching argument when
+// the macro is called.
+func (set *macroset) Define(signature string, content string) error {
+	return set.define(signature, content, true)
+}
+
+// define does a Define. If standalone is false, the macroset is a part of a
+// MacroCollection that checks the definition for loops across all of its
+// macrosets, so the checks that only consider this macroset are skipped; they
+// would not understand references to macros in other macrosets.
+func (set *macroset) define(signature string, content string, standalone bool) error {
+	name, params, err := parseSignature(signature)
+	if err != nil {
+		return err
+	}
 	if err := validateName(name, "macro", set.getMinLength()); err != nil {
 		return err
 	}
@@ -226,11 +284,13 @@ func (set *macroset) Define(name string, content string) error {
 		return fmt.Errorf("empty macros are not allowed; use UNDEFINE if you are trying to remove the macro")
 	}
 	newMacro := macro{
-		name:    name,
-		content: content,
-		regex:   regexp.MustCompile(`(?i)\b` + strings.ReplaceAll(name, "$", `\$`) + `\b`),
+		name:       name,
+		content:    content,
+		regex:      regexp.MustCompile(`(?i)\b` + strings.ReplaceAll(name, "$", `\$`) + `\b`),
+		params:     params,
+		paramRegex: makeParamRegex(params),
 	}
-	if newMacro.regex.MatchString(newMacro.content) {
+	if standalone && newMacro.regex.MatchString(newMacro.content) {
 		return fmt.Errorf("content includes the macro itself; circular definitions are not allowed")
 	}
 
@@ -241,13 +301,21 @@ func (set *macroset) Define(name string, content string) error {
 	if set.IsDefined(name) {
 		oldMacro = new(macro)
 		*oldMacro = set.macros[strings.ToUpper(name)]
+		newMacro.description = oldMacro.description
+		newMacro.tags = oldMacro.tags
 	}
 	set.macros[strings.ToUpper(name)] = newMacro
-	if set.causesLoop(name) {
+	if !standalone {
+		return nil
+	}
+	if err := set.checkLoop(name); err != nil {
 		delete(set.macros, strings.ToUpper(name))
 		if oldMacro != nil {
 			set.macros[strings.ToUpper(name)] = *oldMacro
 		}
+		if loopErr, ok := err.(LoopError); ok {
+			return fmt.Errorf("definition causes a loop: %s", strings.Join(loopErr.Chain, " -> "))
+		}
 		return fmt.Errorf("definition causes a loop")
 	}
 	return nil
@@ -265,7 +333,11 @@ func (set *macroset) Undefine(macro string, replace bool) (existed bool) {
 	}
 	if set.IsDefined(macro) {
 		if replace {
-			set.replaceAllMacro(macro, set.macros[strings.ToUpper(macro)].content)
+			if m := set.macros[strings.ToUpper(macro)]; len(m.params) > 0 {
+				set.inlineAllCalls(m)
+			} else {
+				set.replaceAllMacro(macro, m.content)
+			}
 		}
 		delete(set.macros, strings.ToUpper(macro))
 		return true
@@ -295,11 +367,20 @@ func (set *macroset) replaceAllMacro(name string, replacement string) {
 		}
 		oldMacro := set.macros[nameUpper]
 		newContent := staleMacro.regex.ReplaceAllString(oldMacro.content, replacement)
-		set.macros[nameUpper] = macro{
-			name:    oldMacro.name,
-			content: newContent,
-			regex:   oldMacro.regex,
+		oldMacro.content = newContent
+		set.macros[nameUpper] = oldMacro
+	}
+}
+
+// inlineAllCalls replaces every call to m in all other macros with the content
+// of m.
+func (set *macroset) inlineAllCalls(m macro) {
+	for nameUpper, other := range set.macros {
+		if nameUpper == strings.ToUpper(m.name) {
+			continue
 		}
+		other.content = m.inlineCalls(other.content)
+		set.macros[nameUpper] = other
 	}
 }
 
@@ -319,6 +400,9 @@ type MacroCollection struct {
 	// to be. If set to 0, it falls back to the default of DefaultMinLength in the macro
 	// package.
 	MinLength int
+
+	// journal is nil until StartJournal is called.
+	journal *journal
 }
 
 // IsDefined returns whether the given macro is defined in the current
@@ -343,6 +427,8 @@ func (mc *MacroCollection) Define(macro, content string) error {
 // macroset. The names are case-insensitive. If the macroset doesn't yet exist,
 // it is created. The current macroset remains unchanged.
 func (mc *MacroCollection) DefineIn(setName, macroName, content string) error {
+	defer mc.record("define " + QualifiedName(setName, macroName))()
+
 	if mc.sets == nil {
 		mc.sets = make(map[string]macroset)
 		mc.sets[""] = macroset{
@@ -360,10 +446,25 @@ func (mc *MacroCollection) DefineIn(setName, macroName, content string) error {
 		}
 	}
 
-	if err := set.Define(macroName, content); err != nil {
+	name, _, _ := parseSignature(macroName)
+	oldMacro, existed := set.macros[strings.ToUpper(name)]
+	if err := set.define(macroName, content, false); err != nil {
 		return err
 	}
 	mc.sets[strings.ToUpper(setName)] = set
+
+	// the macro may be visible in other macrosets that inherit from this one
+	// or refer to it, so make sure it does not cause a loop in any of them
+	if err := mc.checkLoopsFor(name); err != nil {
+		delete(set.macros, strings.ToUpper(name))
+		if existed {
+			set.macros[strings.ToUpper(name)] = oldMacro
+		}
+		if loopErr, ok := err.(LoopError); ok {
+			return fmt.Errorf("definition causes a loop: %s", strings.Join(loopErr.Chain, " -> "))
+		}
+		return err
+	}
 	return nil
 }
 
@@ -376,11 +477,37 @@ func (mc *MacroCollection) Get(macro string) string {
 	return mc.sets[mc.cur].Get(macro)
 }
 
+// GetSignature gets the name of a macro along with its parameter list, if it
+// has one. The name is case insensitive. If the macro does not exist, the empty
+// string is returned.
+func (mc *MacroCollection) GetSignature(macro string) string {
+	if !mc.IsDefined(macro) {
+		return ""
+	}
+	return mc.sets[mc.cur].GetSignature(macro)
+}
+
+// GetSignatureIn gets the name of a macro in the given macroset along with its
+// parameter list, if it has one. The names are case insensitive. If the macro
+// does not exist, the empty string is returned.
+func (mc *MacroCollection) GetSignatureIn(setName, macro string) string {
+	if !mc.macrosetExists(setName) {
+		return ""
+	}
+	return mc.sets[strings.ToUpper(setName)].GetSignature(macro)
+}
+
 // Undefine removes a definition for a macro of the given name in the current
 // macroset. The name is case-insensitive. If replace is set to true, all
 // macros that currently reference this one will be replaced with the contents
 // of the macro before it is deleted.
 func (mc *MacroCollection) Undefine(macro string, replace bool) bool {
+	action := "undefine " + QualifiedName(mc.GetCurrentMacroset(), macro)
+	if replace {
+		action += ", replacing its uses"
+	}
+	defer mc.record(action)()
+
 	if mc.sets == nil {
 		return false
 	}
@@ -440,6 +567,8 @@ func (mc *MacroCollection) GetCurrentMacroset() string {
 // set "" is renamed, it is copied to the new name and a new
 // default set is created.
 func (mc *MacroCollection) RenameSet(oldName, newName string) error {
+	defer mc.record(fmt.Sprintf("rename macroset %q to %q", oldName, newName))()
+
 	// special case for renaming the default macroset when it is empty
 	// (which is allowed)
 	if oldName == "" {
@@ -466,6 +595,16 @@ func (mc *MacroCollection) RenameSet(oldName, newName string) error {
 	mc.sets[new] = set
 	delete(mc.sets, old)
 
+	// keep every macroset that inherited from the old name inheriting from it
+	for key, other := range mc.sets {
+		for idx, p := range other.parents {
+			if strings.ToUpper(p) == old {
+				other.parents[idx] = newName
+			}
+		}
+		mc.sets[key] = other
+	}
+
 	if mc.cur == old {
 		mc.cur = new
 	}
@@ -475,11 +614,17 @@ func (mc *MacroCollection) RenameSet(oldName, newName string) error {
 // Rename changes the name of a macro in the current macroset. If replace is given,
 // also updates all usages of the macro's name in all other macros to match.
 func (mc *MacroCollection) Rename(oldName string, newName string, replace bool) error {
+	action := "rename " + QualifiedName(mc.GetCurrentMacroset(), oldName) + " to " + newName
+	if replace {
+		action += ", replacing its uses"
+	}
+	defer mc.record(action)()
+
 	if !mc.IsDefined(oldName) {
 		return fmt.Errorf("no macro named %q exists", oldName)
 	}
 	set := mc.sets[mc.cur]
-	if err := set.Rename(oldName, newName, replace); err != nil {
+	if err := set.rename(oldName, newName, replace, false); err != nil {
 		return err
 	}
 	mc.sets[mc.cur] = set
@@ -561,7 +706,7 @@ func (mc *MacroCollection) ExportSet(setName string, w io.Writer) (setsExported
 
 	bufW := bufio.NewWriter(w)
 
-	if set.Len() > 0 {
+	if set.Len() > 0 || len(set.parents) > 0 {
 		if set.name != "" {
 			// write section header
 			if _, err := bufW.WriteRune('['); err != nil {
@@ -580,6 +725,10 @@ func (mc *MacroCollection) ExportSet(setName string, w io.Writer) (setsExported
 			}
 		}
 
+		if err := checkLegacyParents(set.parents); err != nil {
+			return 0, 0, fmt.Errorf("while exporting macroset %q: %v", setName, err)
+		}
+
 		// then write actual macros
 		if err := set.Export(w); err != nil {
 			return 0, 0, fmt.Errorf("while exporting macroset %q: %v", setName, err)
@@ -605,8 +754,11 @@ func (mc *MacroCollection) Export(w io.Writer) (setsExported int, macrosExported
 	bufW := bufio.NewWriter(w)
 
 	// always do the default one first, and only if it has definitions
-	if mc.IsDefinedMacroset("") {
+	if mc.IsDefinedMacroset("") || len(mc.GetParents("")) > 0 {
 		set := mc.sets[""]
+		if err := checkLegacyParents(set.parents); err != nil {
+			return 0, 0, fmt.Errorf("while exporting the default macroset: %v", err)
+		}
 		if err := set.Export(w); err != nil {
 			return 0, 0, fmt.Errorf("while exporting the default macroset: %v", err)
 		}
@@ -647,6 +799,9 @@ func (mc *MacroCollection) Export(w io.Writer) (setsExported int, macrosExported
 
 		// then write actual macros
 		set := mc.sets[strings.ToUpper(name)]
+		if err := checkLegacyParents(set.parents); err != nil {
+			return 0, 0, fmt.Errorf("while exporting macroset %q: %v", name, err)
+		}
 		if err := set.Export(w); err != nil {
 			return 0, 0, fmt.Errorf("while exporting macroset %q: %v", name, err)
 		}
@@ -664,63 +819,62 @@ func (mc *MacroCollection) Export(w io.Writer) (setsExported int, macrosExported
 }
 
 // Import reads macroset definitions from the given writer and applies them
-// to the current macro collection. They are added rather than removed entirely.
+// to the current macro collection. They are added rather than removed entirely;
+// macros that are already defined are replaced by the ones that are read.
 func (mc *MacroCollection) Import(r io.Reader) (setsLoaded int, macrosLoaded int, err error) {
-	// so we dont go into a weird state on error, make all changes
-	// to a new macrocollection to validate then destroy the extra MacroCollection
-	dummy := MacroCollection{MinLength: mc.MinLength}
+	return mc.ImportFormat(r, FormatLegacy)
+}
+
+// parseMacrosFile reads macroset definitions in the legacy format from the
+// given reader into a new MacroCollection.
+func parseMacrosFile(r io.Reader, minLength int) (MacroCollection, error) {
+	parsed := MacroCollection{MinLength: minLength}
 
 	scan := bufio.NewScanner(r)
 	lineNo := 0
+	var info Info
 	for scan.Scan() {
 		lineNo++
 		line := strings.TrimSpace(scan.Text())
-		if line == "" {
+		if line == "" || parseInfoLine(line, &info) {
 			continue
 		}
 
 		if setSectionRegex.MatchString(line) {
 			secName := strings.Trim(line, "[]")
-			if err := dummy.SetCurrentMacroset(secName); err != nil {
-				return 0, 0, fmt.Errorf("on line %d: %v", lineNo, err)
+			if err := parsed.SetCurrentMacroset(secName); err != nil {
+				return MacroCollection{}, fmt.Errorf("on line %d: %v", lineNo, err)
+			}
+		} else if parents, ok := parseInheritLine(line); ok {
+			if err := parsed.SetParents(parsed.GetCurrentMacroset(), parents); err != nil {
+				return MacroCollection{}, fmt.Errorf("on line %d: %v", lineNo, err)
 			}
 		} else {
 			// parse as a macro
 			macroName, macroContent, err := parseMacroImportLine(line)
 			if err != nil {
-				return 0, 0, fmt.Errorf("on line %d: %v", lineNo, err)
+				return MacroCollection{}, fmt.Errorf("on line %d: %v", lineNo, err)
+			}
+			if err := parsed.Define(macroName, macroContent); err != nil {
+				return MacroCollection{}, fmt.Errorf("on line %d: %v", lineNo, err)
 			}
-			if err := dummy.Define(macroName, macroContent); err != nil {
-				return 0, 0, fmt.Errorf("on line %d: %v", lineNo, err)
+			if err := parsed.SetInfo(strings.SplitN(macroName, "(", 2)[0], info); err != nil {
+				return MacroCollection{}, fmt.Errorf("on line %d: %v", lineNo, err)
 			}
+			info = Info{}
 		}
 	}
 	if err := scan.Err(); err != nil {
-		return 0, 0, fmt.Errorf("problem reading input: %v", err)
-	}
-
-	// everything is now fully loaded. time to merge the two.
-	for _, setName := range dummy.GetSetNames() {
-		if dummy.IsDefinedMacroset(setName) {
-			dummySet := dummy.sets[strings.ToUpper(setName)]
-			for _, macroName := range dummySet.GetAll() {
-				macroContent := dummySet.Get(macroName)
-				if err := mc.DefineIn(setName, macroName, macroContent); err != nil {
-					// should never happen
-					return 0, 0, fmt.Errorf("got problem copying from dummy mc to new one: %v", err)
-				}
-				macrosLoaded++
-			}
-			setsLoaded++
-		}
+		return MacroCollection{}, fmt.Errorf("problem reading input: %v", err)
 	}
-
-	return setsLoaded, macrosLoaded, nil
+	return parsed, nil
 }
 
 // Clear removes all currently-defined macrosets as well as their definitions.
 // The current set remains as it was prior to the clear, even if that set is cleared.
 func (mc *MacroCollection) Clear() {
+	defer mc.record("clear all macros")()
+
 	if mc.sets == nil {
 		return
 	}
@@ -729,6 +883,7 @@ func (mc *MacroCollection) Clear() {
 		if setName != "" || mc.IsDefinedMacroset("") {
 			set := mc.sets[strings.ToUpper(setName)]
 			set.Clear()
+			set.parents = nil
 			mc.sets[strings.ToUpper(setName)] = set
 			// dont remove the entry if it's the current
 			// or if it's the default
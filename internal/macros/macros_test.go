@@ -122,3 +122,88 @@ func testMacrosetWithMacros(t *testing.T, macroDefs map[string]string) macroset
 	}
 	return sut
 }
+
+func Test_macroset_Apply_parameterized(t *testing.T) {
+	sut := testMacrosetWithMacros(t, map[string]string{
+		"login(user, pass)":   "\\x01 user \\x00 pass",
+		"wrap(inner)":         "[inner]",
+		"pair(first, second)": "second first",
+		"MACRO":               "<macrofill 1>",
+		"shadow(MACRO)":       "(MACRO)",
+		"uses_login(name)":    "login(name, MACRO)",
+	})
+
+	testCases := []struct {
+		name      string
+		input     string
+		expected  string
+		expectErr bool
+	}{
+		{name: "basic call", input: "login(alice, bob)", expected: "\\x01 alice \\x00 bob"},
+		{name: "case insensitive", input: "LOGIN(alice,bob)", expected: "\\x01 alice \\x00 bob"},
+		{name: "quoted args", input: `login("a, b", "(c")`, expected: "\\x01 a, b \\x00 (c"},
+		{name: "surrounding text", input: "x wrap(y) z", expected: "x [y] z"},
+		{name: "multiple calls", input: "wrap(a)wrap(b)", expected: "[a][b]"},
+		{name: "nested call", input: "wrap(wrap(a))", expected: "[[a]]"},
+		{name: "args not re-substituted", input: "pair(second, first)", expected: "first second"},
+		{name: "macro in arg", input: "wrap(MACRO)", expected: "[<macrofill 1>]"},
+		{name: "param shadows macro", input: "shadow(x)", expected: "(x)"},
+		{name: "call in content", input: "uses_login(carol)", expected: "\\x01 carol \\x00 <macrofill 1>"},
+		{name: "too few args", input: "login(alice)", expectErr: true},
+		{name: "too many args", input: "wrap(a, b)", expectErr: true},
+		{name: "no arg list", input: "wrap", expectErr: true},
+		{name: "unclosed arg list", input: "wrap(a", expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := sut.Apply(tc.input)
+
+			// check for error
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				t.Fatalf("expected an error but nil error was returned")
+			}
+
+			// check the value
+			if tc.expected != actual {
+				t.Fatalf("expected %q but got: %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_macroset_Define_parameterized(t *testing.T) {
+	testCases := []struct {
+		name      string
+		defs      [][2]string
+		expectErr bool
+	}{
+		{name: "valid", defs: [][2]string{{"login(user, pass)", "user pass"}}},
+		{name: "empty param list", defs: [][2]string{{"login()", "content"}}, expectErr: true},
+		{name: "duplicate param", defs: [][2]string{{"login(a, A)", "a"}}, expectErr: true},
+		{name: "param named after macro", defs: [][2]string{{"login(login)", "x"}}, expectErr: true},
+		{name: "invalid param name", defs: [][2]string{{"login(1a)", "x"}}, expectErr: true},
+		{name: "calls itself", defs: [][2]string{{"login(a)", "login(a)"}}, expectErr: true},
+		{name: "loop through call", defs: [][2]string{{"first(a)", "a"}, {"second(a)", "first(a)"}, {"first(a)", "second(a)"}}, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var sut macroset
+			var err error
+			for _, def := range tc.defs {
+				if err = sut.Define(def[0], def[1]); err != nil {
+					break
+				}
+			}
+
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				t.Fatalf("expected an error but nil error was returned")
+			}
+		})
+	}
+}
@@ -0,0 +1,473 @@
+package macros
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultRenameSuffix is added to the name of an imported macro that conflicts
+// with an existing one when MergeRename is used and no other suffix is given.
+const DefaultRenameSuffix = "_imported"
+
+// MergeMode is how a macro being imported is merged with an existing macro of
+// the same name that has different contents.
+type MergeMode int
+
+const (
+	// MergeOverwrite replaces the existing macro with the imported one.
+	MergeOverwrite MergeMode = iota
+
+	// MergeKeepExisting keeps the existing macro and skips the imported one.
+	MergeKeepExisting
+
+	// MergeRename keeps the existing macro and imports the new one with a
+	// suffix added to its name. Macros imported from the file that refer to
+	// it are changed to use the new name; macros in the file that are the
+	// same as existing ones are not imported, so they keep using the existing
+	// macro. Parents cannot be renamed, so conflicting parents of a macroset
+	// are kept as they are.
+	MergeRename
+
+	// MergeAsk calls ImportOptions.Ask to decide for each conflict.
+	MergeAsk
+)
+
+// String gives the name of the mode as it is given to ParseMergeMode.
+func (mode MergeMode) String() string {
+	switch mode {
+	case MergeOverwrite:
+		return "overwrite"
+	case MergeKeepExisting:
+		return "keep"
+	case MergeRename:
+		return "rename"
+	case MergeAsk:
+		return "ask"
+	default:
+		return fmt.Sprintf("MergeMode(%d)", int(mode))
+	}
+}
+
+// ParseMergeMode gives the MergeMode with the given name. The name is case
+// insensitive.
+func ParseMergeMode(s string) (MergeMode, error) {
+	for _, mode := range []MergeMode{MergeOverwrite, MergeKeepExisting, MergeRename, MergeAsk} {
+		if strings.EqualFold(s, mode.String()) {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("merge mode must be one of overwrite, keep, rename, or ask")
+}
+
+// ImportItem is a macro in a macros file being imported, or the parents given
+// for a macroset in it.
+type ImportItem struct {
+	// Set is the name of the macroset the item is in.
+	Set string
+
+	// Macro is the name of the macro. It is empty if the item is the parents
+	// of the macroset instead.
+	Macro string
+
+	// Existing and Incoming are the macro that is currently defined and the
+	// one in the file. In the items returned by ApplyImport, Incoming has
+	// references to macros renamed by MergeRename changed to their new names.
+	Existing, Incoming MacroRecord
+
+	// ExistingParents and IncomingParents are the current parents of the
+	// macroset and the ones in the file. They are only set if Macro is empty.
+	ExistingParents, IncomingParents []string
+
+	// Conflict is whether the item already exists and is different from the
+	// one in the file.
+	Conflict bool
+
+	// Resolution is how a conflict is merged. It is only set on the items
+	// returned by ApplyImport that have a conflict.
+	Resolution MergeMode
+
+	// ImportedAs is the name the macro is imported with if Resolution is
+	// MergeRename.
+	ImportedAs string
+}
+
+// IsNew returns whether the item does not exist before it is imported.
+func (item ImportItem) IsNew() bool {
+	if item.Macro == "" {
+		return len(item.ExistingParents) < 1
+	}
+	return !item.Existing.Defined()
+}
+
+// Imported returns whether the item is imported, given how it was resolved.
+// Items without a conflict are always imported, even if they are unchanged.
+func (item ImportItem) Imported() bool {
+	if !item.Conflict {
+		return true
+	}
+	return item.Resolution == MergeOverwrite || item.ImportedAs != ""
+}
+
+// ImportPlan is what importing a macros file would change. It is created with
+// PlanImport and carried out with ApplyImport.
+type ImportPlan struct {
+	// Items is every macro and every list of parents in the file, in the
+	// order of the macroset names and then the macro names. The parents of a
+	// macroset come before its macros.
+	Items []ImportItem
+
+	parsed MacroCollection
+}
+
+// ImportOptions are the options for ApplyImport.
+type ImportOptions struct {
+	// Mode is how conflicts are merged.
+	Mode MergeMode
+
+	// Suffix is added to the names of macros renamed by MergeRename. If it is
+	// empty, DefaultRenameSuffix is used.
+	Suffix string
+
+	// Ask is called for each conflict when Mode is MergeAsk, before anything
+	// is imported. It gives how to merge that conflict, which must not be
+	// MergeAsk. If it returns an error, nothing is imported.
+	Ask func(item ImportItem) (MergeMode, error)
+
+	// DryRun makes ApplyImport check the import and return the items without
+	// changing anything. Ask is not called; conflicts that would be asked
+	// about are given a Resolution of MergeAsk and treated as kept.
+	DryRun bool
+}
+
+// PlanImport reads macroset definitions in the given format from the given
+// reader and compares them to the ones in the collection. Nothing is changed.
+func (mc *MacroCollection) PlanImport(r io.Reader, format FileFormat) (ImportPlan, error) {
+	parsed, err := parseFile(r, format, mc.MinLength)
+	if err != nil {
+		return ImportPlan{}, err
+	}
+
+	plan := ImportPlan{parsed: parsed}
+	for _, setName := range parsed.GetSetNames() {
+		if !parsed.macrosetExists(setName) {
+			continue
+		}
+		set := parsed.sets[strings.ToUpper(setName)]
+		var existingSet macroset
+		if mc.macrosetExists(setName) {
+			existingSet = mc.sets[strings.ToUpper(setName)]
+		}
+
+		if len(set.parents) > 0 {
+			item := ImportItem{
+				Set:             setName,
+				ExistingParents: append([]string(nil), existingSet.parents...),
+				IncomingParents: append([]string(nil), set.parents...),
+			}
+			item.Conflict = !item.IsNew() && !strings.EqualFold(strings.Join(item.ExistingParents, ","), strings.Join(item.IncomingParents, ","))
+			plan.Items = append(plan.Items, item)
+		}
+
+		names := set.GetAll()
+		sort.Strings(names)
+		for _, name := range names {
+			item := ImportItem{
+				Set:      setName,
+				Macro:    name,
+				Incoming: set.record(name),
+			}
+			if existingSet.IsDefined(name) {
+				item.Existing = existingSet.record(name)
+			}
+			item.Conflict = !item.IsNew() && !reflect.DeepEqual(item.Existing, item.Incoming)
+			plan.Items = append(plan.Items, item)
+		}
+	}
+	return plan, nil
+}
+
+// ApplyImport imports everything in the plan, merging conflicts as given in
+// opts, and returns the items of the plan with their resolutions. The result
+// is checked for loops before anything is changed; if there are any, an error
+// is returned and nothing is imported.
+func (mc *MacroCollection) ApplyImport(plan ImportPlan, opts ImportOptions) ([]ImportItem, error) {
+	suffix := opts.Suffix
+	if suffix == "" {
+		suffix = DefaultRenameSuffix
+	}
+
+	items := append([]ImportItem(nil), plan.Items...)
+	takenNames := map[string]bool{}
+	for idx := range items {
+		item := &items[idx]
+		if !item.Conflict {
+			continue
+		}
+		mode := opts.Mode
+		if mode == MergeAsk {
+			if opts.DryRun {
+				item.Resolution = MergeAsk
+				continue
+			}
+			if opts.Ask == nil {
+				return nil, fmt.Errorf("no way to ask how to merge conflicts was given")
+			}
+			var err error
+			mode, err = opts.Ask(*item)
+			if err != nil {
+				return nil, err
+			}
+			if mode == MergeAsk {
+				return nil, fmt.Errorf("conflict in %s was not resolved", QualifiedName(item.Set, item.Macro))
+			}
+		}
+		item.Resolution = mode
+
+		if mode == MergeRename && item.Macro != "" {
+			newName, err := mc.importedName(plan.parsed, *item, suffix, takenNames)
+			if err != nil {
+				return nil, err
+			}
+			item.ImportedAs = newName
+		}
+	}
+
+	// the file refers to the renamed macros by their old names
+	renamed := map[string]string{}
+	for _, item := range items {
+		if item.ImportedAs != "" {
+			renamed[strings.ToUpper(QualifiedName(item.Set, item.Macro))] = item.ImportedAs
+		}
+	}
+	if len(renamed) > 0 {
+		for idx := range items {
+			item := &items[idx]
+			if item.Macro == "" || !item.Imported() || (!item.Conflict && !item.IsNew()) {
+				continue
+			}
+			item.Incoming.Content = plan.parsed.renameRefs(item.Set, item.Macro, renamed)
+		}
+	}
+
+	result := mc.clone()
+	for _, item := range items {
+		if !item.Imported() {
+			continue
+		}
+		set := result.editableSet(item.Set)
+		if item.Macro == "" {
+			set.parents = append([]string(nil), item.IncomingParents...)
+		} else {
+			name, signature := item.Macro, item.Incoming.Signature
+			if item.ImportedAs != "" {
+				name = item.ImportedAs
+				signature = name + signature[len(item.Macro):]
+			}
+			if err := set.define(signature, item.Incoming.Content, false); err != nil {
+				return nil, fmt.Errorf("macro %q: %v", QualifiedName(item.Set, name), err)
+			}
+			if err := set.SetInfo(name, item.Incoming.Info); err != nil {
+				return nil, fmt.Errorf("macro %q: %v", QualifiedName(item.Set, name), err)
+			}
+		}
+		result.sets[strings.ToUpper(item.Set)] = set
+	}
+
+	// individual macros are only checked once everything is in place, since
+	// the order they are imported in could have a loop partway through that
+	// is gone by the end
+	if err := result.checkAllLoops(); err != nil {
+		if loopErr, ok := err.(LoopError); ok {
+			return nil, fmt.Errorf("importing causes a loop: %s", strings.Join(loopErr.Chain, " -> "))
+		}
+		return nil, err
+	}
+	if opts.DryRun {
+		return items, nil
+	}
+
+	defer mc.record("import macros")()
+	mc.sets = result.sets
+	return items, nil
+}
+
+// Rebase merges the changes made to the collection with those made to another
+// copy of it. base is the macros that both copies started from, and other is
+// the other copy. Every change made to the collection since base is made to
+// other, and the collection is then replaced with the result; where both
+// copies changed the same macro or the parents of the same macroset, the
+// change made to this collection is kept. The current macroset is not changed.
+//
+// Rebasing is not recorded in the journal. If the result has a loop, an error
+// is returned and nothing is changed.
+func (mc *MacroCollection) Rebase(base, other *MacroCollection) error {
+	changes := diffSnapshots(base.snapshot(), mc.snapshot())
+
+	result := other.clone()
+	result.cur = mc.cur
+	result.restore(JournalEntry{Changes: changes}, false)
+	if _, ok := result.sets[mc.cur]; !ok {
+		result.sets[mc.cur] = macroset{name: mc.sets[mc.cur].name, MinLength: mc.MinLength}
+	}
+
+	if err := result.checkAllLoops(); err != nil {
+		if loopErr, ok := err.(LoopError); ok {
+			return fmt.Errorf("merging causes a loop: %s", strings.Join(loopErr.Chain, " -> "))
+		}
+		return err
+	}
+	mc.sets = result.sets
+	return nil
+}
+
+// renameRefs gives the content of a macro in the collection with every
+// reference to a renamed macro changed to use its new name. renamed maps the
+// upper-case qualified name of each renamed macro to its new name. References
+// are found the same way they are when the macro is expanded, so parameters
+// and longer references that contain the old name are left as they are.
+func (mc *MacroCollection) renameRefs(setName, macroName string, renamed map[string]string) string {
+	set := mc.sets[strings.ToUpper(setName)]
+	m := set.macros[strings.ToUpper(macroName)]
+	text := m.content
+
+	var found [][]int
+	if m.paramRegex != nil {
+		found = m.paramRegex.FindAllStringIndex(text, -1)
+	}
+	type edit struct {
+		start, end int
+		name       string
+	}
+	var edits []edit
+	for _, vm := range (macroScope{mc: mc, set: set}).visible(text) {
+		newName, isRenamed := renamed[strings.ToUpper(QualifiedName(vm.from, vm.name))]
+		for _, match := range vm.regex.FindAllStringIndex(text, -1) {
+			if overlapsAny(found, match) {
+				continue
+			}
+			found = append(found, match)
+			if isRenamed {
+				nameStart := match[0] + strings.LastIndex(text[match[0]:match[1]], ".") + 1
+				edits = append(edits, edit{start: nameStart, end: match[1], name: newName})
+			}
+		}
+	}
+	if len(edits) < 1 {
+		return text
+	}
+
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].start < edits[j].start
+	})
+	var sb strings.Builder
+	prevEnd := 0
+	for _, e := range edits {
+		sb.WriteString(text[prevEnd:e.start])
+		sb.WriteString(e.name)
+		prevEnd = e.end
+	}
+	sb.WriteString(text[prevEnd:])
+	return sb.String()
+}
+
+// overlapsAny returns whether the span overlaps any of the given spans.
+func overlapsAny(spans [][]int, span []int) bool {
+	for _, other := range spans {
+		if span[0] < other[1] && other[0] < span[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// importedName gives the name that a macro renamed by MergeRename is imported
+// with. The suffix is added to its name, followed by a number if that is
+// already the name of a macro in the macroset or the file.
+func (mc *MacroCollection) importedName(parsed MacroCollection, item ImportItem, suffix string, taken map[string]bool) (string, error) {
+	var existingSet, parsedSet macroset
+	if mc.macrosetExists(item.Set) {
+		existingSet = mc.sets[strings.ToUpper(item.Set)]
+	}
+	if parsed.macrosetExists(item.Set) {
+		parsedSet = parsed.sets[strings.ToUpper(item.Set)]
+	}
+
+	for n := 1; ; n++ {
+		name := item.Macro + suffix
+		if n > 1 {
+			name += strconv.Itoa(n)
+		}
+		if err := validateName(name, "macro", existingSet.getMinLength()); err != nil {
+			return "", fmt.Errorf("cannot rename %q: %v", QualifiedName(item.Set, item.Macro), err)
+		}
+		key := strings.ToUpper(QualifiedName(item.Set, name))
+		if existingSet.IsDefined(name) || parsedSet.IsDefined(name) || taken[key] {
+			continue
+		}
+		taken[key] = true
+		return name, nil
+	}
+}
+
+// record gives the current definition of the given macro.
+func (set macroset) record(macro string) MacroRecord {
+	return MacroRecord{
+		Signature: set.GetSignature(macro),
+		Content:   set.Get(macro),
+		Info:      set.GetInfo(macro),
+	}
+}
+
+// clone gives a copy of the collection that can be changed without affecting
+// it. The copy has no journal.
+func (mc *MacroCollection) clone() MacroCollection {
+	c := MacroCollection{cur: mc.cur, MinLength: mc.MinLength}
+	if mc.sets == nil {
+		return c
+	}
+	c.sets = make(map[string]macroset, len(mc.sets))
+	for key, set := range mc.sets {
+		set.parents = append([]string(nil), set.parents...)
+		macros := make(map[string]macro, len(set.macros))
+		for name, m := range set.macros {
+			macros[name] = m
+		}
+		set.macros = macros
+		c.sets[key] = set
+	}
+	return c
+}
+
+// editableSet gives the macroset with the given name so that it can be changed
+// and then stored back in the collection. If it does not exist, a new one is
+// given.
+func (mc *MacroCollection) editableSet(setName string) macroset {
+	if mc.sets == nil {
+		mc.sets = make(map[string]macroset)
+		mc.sets[""] = macroset{
+			MinLength: mc.MinLength,
+		}
+	}
+	if set, ok := mc.sets[strings.ToUpper(setName)]; ok {
+		return set
+	}
+	return macroset{
+		name:      setName,
+		MinLength: mc.MinLength,
+	}
+}
+
+// checkAllLoops returns an error if using any macro in any macroset causes a
+// loop.
+func (mc *MacroCollection) checkAllLoops() error {
+	for _, set := range mc.sets {
+		if err := mc.checkLoopsIn(set.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package macros
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func Test_MacroCollection_ApplyImport(t *testing.T) {
+	existing := "BODY \\x01\nHEADER \\x7e BODY\n"
+
+	testCases := []struct {
+		name      string
+		input     string
+		opts      ImportOptions
+		expected  string
+		expectErr bool
+	}{
+		{
+			name:     "overwrite",
+			input:    "BODY \\x02\nTRAILER \\x7f\n",
+			opts:     ImportOptions{Mode: MergeOverwrite},
+			expected: "BODY \\x02\nHEADER \\x7e BODY\nTRAILER \\x7f\n",
+		},
+		{
+			name:     "keep existing",
+			input:    "BODY \\x02\nTRAILER \\x7f\n",
+			opts:     ImportOptions{Mode: MergeKeepExisting},
+			expected: "BODY \\x01\nHEADER \\x7e BODY\nTRAILER \\x7f\n",
+		},
+		{
+			name:     "rename incoming",
+			input:    "BODY \\x02\n",
+			opts:     ImportOptions{Mode: MergeRename},
+			expected: "BODY \\x01\nBODY_imported \\x02\nHEADER \\x7e BODY\n",
+		},
+		{
+			name:     "rename incoming with custom suffix",
+			input:    "BODY \\x02\n",
+			opts:     ImportOptions{Mode: MergeRename, Suffix: "_v2"},
+			expected: "BODY \\x01\nBODY_v2 \\x02\nHEADER \\x7e BODY\n",
+		},
+		{
+			name:     "rename skips names in use",
+			input:    "BODY \\x02\nBODY_imported \\x03\n",
+			opts:     ImportOptions{Mode: MergeRename},
+			expected: "BODY \\x01\nBODY_imported \\x03\nBODY_imported2 \\x02\nHEADER \\x7e BODY\n",
+		},
+		{
+			name:     "rename changes references in the file",
+			input:    "BODY \\x02\nTRAILER \\x7f BODY\n",
+			opts:     ImportOptions{Mode: MergeRename},
+			expected: "BODY \\x01\nBODY_imported \\x02\nHEADER \\x7e BODY\nTRAILER \\x7f BODY_imported\n",
+		},
+		{
+			name:     "rename does not change parameters",
+			input:    "BODY \\x02\nWRAP(body) \\x7f body BODY_X\n",
+			opts:     ImportOptions{Mode: MergeRename},
+			expected: "BODY \\x01\nBODY_imported \\x02\nHEADER \\x7e BODY\nWRAP(body) \\x7f body BODY_X\n",
+		},
+		{
+			name:     "rename does not change macros that are the same",
+			input:    "BODY \\x02\nHEADER \\x7e BODY\n",
+			opts:     ImportOptions{Mode: MergeRename},
+			expected: "BODY \\x01\nBODY_imported \\x02\nHEADER \\x7e BODY\n",
+		},
+		{
+			name:  "ask",
+			input: "BODY \\x02\nHEADER \\x7d BODY\n",
+			opts: ImportOptions{Mode: MergeAsk, Ask: func(item ImportItem) (MergeMode, error) {
+				if item.Macro == "BODY" {
+					return MergeKeepExisting, nil
+				}
+				return MergeOverwrite, nil
+			}},
+			expected: "BODY \\x01\nHEADER \\x7d BODY\n",
+		},
+		{
+			name:  "ask aborted",
+			input: "BODY \\x02\n",
+			opts: ImportOptions{Mode: MergeAsk, Ask: func(item ImportItem) (MergeMode, error) {
+				return 0, fmt.Errorf("aborted")
+			}},
+			expectErr: true,
+		},
+		{
+			name:     "dry run changes nothing",
+			input:    "BODY \\x02\nTRAILER \\x7f\n",
+			opts:     ImportOptions{Mode: MergeOverwrite, DryRun: true},
+			expected: existing,
+		},
+		{
+			name:      "loop with existing macro",
+			input:     "BODY HEADER\n",
+			opts:      ImportOptions{Mode: MergeOverwrite},
+			expectErr: true,
+		},
+		{
+			name:      "loop is found in dry run",
+			input:     "BODY HEADER\n",
+			opts:      ImportOptions{Mode: MergeOverwrite, DryRun: true},
+			expectErr: true,
+		},
+		{
+			name:     "no loop when kept",
+			input:    "BODY HEADER\n",
+			opts:     ImportOptions{Mode: MergeKeepExisting},
+			expected: existing,
+		},
+		{
+			name:     "loop partway through is allowed",
+			input:    "BODY \\x05\nHEADER BODY\n",
+			opts:     ImportOptions{Mode: MergeOverwrite},
+			expected: "BODY \\x05\nHEADER BODY\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sut := &MacroCollection{}
+			if _, _, err := sut.Import(strings.NewReader(existing)); err != nil {
+				t.Fatalf("prep step: pre-test import failed: %v", err)
+			}
+
+			plan, err := sut.PlanImport(strings.NewReader(tc.input), FormatLegacy)
+			if err != nil {
+				t.Fatalf("prep step: planning import failed: %v", err)
+			}
+			_, err = sut.ApplyImport(plan, tc.opts)
+
+			// check for error
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				t.Fatalf("expected an error but nil error was returned")
+			}
+			if tc.expectErr {
+				tc.expected = existing
+			}
+
+			// check the value
+			var buf bytes.Buffer
+			if _, _, err := sut.Export(&buf); err != nil {
+				t.Fatalf("export failed: %v", err)
+			}
+			actual := strings.TrimRight(buf.String(), "\n") + "\n"
+			if actual != tc.expected {
+				t.Errorf("expected export:\n%s\nbut got:\n%s", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_MacroCollection_ApplyImport_renameQualified(t *testing.T) {
+	sut := &MacroCollection{}
+	if _, _, err := sut.Import(strings.NewReader("[net]\nBODY \\x01\n")); err != nil {
+		t.Fatalf("prep step: pre-test import failed: %v", err)
+	}
+	input := "[net]\nBODY \\x02\n[other]\nTRAILER \\x7f net.BODY BODY\n"
+	plan, err := sut.PlanImport(strings.NewReader(input), FormatLegacy)
+	if err != nil {
+		t.Fatalf("prep step: planning import failed: %v", err)
+	}
+	if _, err := sut.ApplyImport(plan, ImportOptions{Mode: MergeRename}); err != nil {
+		t.Fatalf("returned an error: %v", err)
+	}
+
+	expected := "\\x7f net.BODY_imported BODY"
+	if actual := sut.GetIn("other", "TRAILER"); actual != expected {
+		t.Errorf("expected TRAILER to be %q but got: %q", expected, actual)
+	}
+}
+
+func Test_MacroCollection_PlanImport(t *testing.T) {
+	sut := &MacroCollection{}
+	if _, _, err := sut.Import(strings.NewReader("BODY \\x01\nHEADER \\x7e BODY\n")); err != nil {
+		t.Fatalf("prep step: pre-test import failed: %v", err)
+	}
+
+	plan, err := sut.PlanImport(strings.NewReader("BODY \\x02\nHEADER \\x7e BODY\n[common]\n@inherit base\nVERSION \\x09\n"), FormatLegacy)
+	if err != nil {
+		t.Fatalf("returned an error: %v", err)
+	}
+
+	type status struct {
+		name     string
+		isNew    bool
+		conflict bool
+	}
+	expected := []status{
+		{name: "BODY", conflict: true},
+		{name: "HEADER"},
+		{name: "common.", isNew: true},
+		{name: "common.VERSION", isNew: true},
+	}
+	if len(plan.Items) != len(expected) {
+		t.Fatalf("expected %d items but got %d: %v", len(expected), len(plan.Items), plan.Items)
+	}
+	for idx, item := range plan.Items {
+		actual := status{name: item.Macro, isNew: item.IsNew(), conflict: item.Conflict}
+		if item.Set != "" {
+			actual.name = item.Set + "." + item.Macro
+		}
+		if actual != expected[idx] {
+			t.Errorf("item %d: expected %+v but got %+v", idx, expected[idx], actual)
+		}
+	}
+}
+
+func Test_MacroCollection_Rebase(t *testing.T) {
+	base := "BODY \\x01\nHEADER \\x7e\n"
+
+	testCases := []struct {
+		name      string
+		edit      func(mc *MacroCollection) error
+		other     string
+		expected  string
+		expectErr bool
+	}{
+		{
+			name:     "different macros changed",
+			edit:     func(mc *MacroCollection) error { return mc.Define("BODY", "\\x02") },
+			other:    "BODY \\x01\nHEADER \\x7d\n",
+			expected: "BODY \\x02\nHEADER \\x7d\n",
+		},
+		{
+			name:     "same macro changed keeps ours",
+			edit:     func(mc *MacroCollection) error { return mc.Define("BODY", "\\x02") },
+			other:    "BODY \\x03\nHEADER \\x7e\n",
+			expected: "BODY \\x02\nHEADER \\x7e\n",
+		},
+		{
+			name: "undefined here and changed in other",
+			edit: func(mc *MacroCollection) error {
+				mc.Undefine("BODY", false)
+				return nil
+			},
+			other:    "BODY \\x03\nHEADER \\x7e\n",
+			expected: "HEADER \\x7e\n",
+		},
+		{
+			name:     "added in other",
+			edit:     func(mc *MacroCollection) error { return nil },
+			other:    "BODY \\x01\nHEADER \\x7e\nTRAILER \\x7f\n",
+			expected: "BODY \\x01\nHEADER \\x7e\nTRAILER \\x7f\n",
+		},
+		{
+			name:     "removed in other",
+			edit:     func(mc *MacroCollection) error { return mc.Define("TRAILER", "\\x7f") },
+			other:    "HEADER \\x7e\n",
+			expected: "HEADER \\x7e\nTRAILER \\x7f\n",
+		},
+		{
+			name:      "loop between changes",
+			edit:      func(mc *MacroCollection) error { return mc.Define("BODY", "HEADER") },
+			other:     "BODY \\x01\nHEADER BODY\n",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			baseMC := &MacroCollection{}
+			otherMC := &MacroCollection{}
+			sut := &MacroCollection{}
+			if _, _, err := baseMC.Import(strings.NewReader(base)); err != nil {
+				t.Fatalf("prep step: pre-test import of base failed: %v", err)
+			}
+			if _, _, err := otherMC.Import(strings.NewReader(tc.other)); err != nil {
+				t.Fatalf("prep step: pre-test import of other failed: %v", err)
+			}
+			if _, _, err := sut.Import(strings.NewReader(base)); err != nil {
+				t.Fatalf("prep step: pre-test import failed: %v", err)
+			}
+			if err := tc.edit(sut); err != nil {
+				t.Fatalf("prep step: pre-test edit failed: %v", err)
+			}
+			var before bytes.Buffer
+			if _, _, err := sut.Export(&before); err != nil {
+				t.Fatalf("prep step: pre-test export failed: %v", err)
+			}
+
+			err := sut.Rebase(baseMC, otherMC)
+
+			// check for error
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				t.Fatalf("expected an error but nil error was returned")
+			}
+			if tc.expectErr {
+				tc.expected = strings.TrimRight(before.String(), "\n") + "\n"
+			}
+
+			// check the value
+			var buf bytes.Buffer
+			if _, _, err := sut.Export(&buf); err != nil {
+				t.Fatalf("export failed: %v", err)
+			}
+			actual := strings.TrimRight(buf.String(), "\n") + "\n"
+			if actual != tc.expected {
+				t.Errorf("expected export:\n%s\nbut got:\n%s", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package macros
+
+import (
+	"dekarrin/netkarkat/internal/misc"
+	"dekarrin/netkarkat/internal/stack"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// placeholderMarker begins the text that temporarily stands in for a parameter
+// in a macro's content while the content is being expanded. It and the rune
+// after it are from the private use area, so they cannot be a part of an
+// identifier and will never be matched as a macro.
+const placeholderMarker = '\uF8FF'
+
+// SplitDefinition splits the text that defines a macro into the signature of
+// the macro and its content. The signature is either just the name of the
+// macro or, for macros with parameters, the name followed by the parameter
+// list in parentheses, such as "login(user, pass)". The parameter list may
+// contain spaces.
+func SplitDefinition(text string) (signature string, content string) {
+	text = strings.TrimLeftFunc(text, unicode.IsSpace)
+	nameEnd := strings.IndexFunc(text, func(r rune) bool {
+		return r == '(' || unicode.IsSpace(r)
+	})
+	if nameEnd < 0 {
+		return text, ""
+	}
+	sigEnd := nameEnd
+	if text[nameEnd] == '(' {
+		closeIdx := strings.IndexRune(text[nameEnd:], ')')
+		if closeIdx < 0 {
+			return text, ""
+		}
+		sigEnd = nameEnd + closeIdx + 1
+	}
+	return text[:sigEnd], strings.TrimSpace(text[sigEnd:])
+}
+
+// parseSignature gets the name and parameter names out of a macro signature.
+// params will be nil if the signature does not have a parameter list.
+func parseSignature(signature string) (name string, params []string, err error) {
+	openIdx := strings.IndexRune(signature, '(')
+	if openIdx < 0 {
+		return signature, nil, nil
+	}
+	name = signature[:openIdx]
+	if !strings.HasSuffix(signature, ")") {
+		return "", nil, fmt.Errorf("parameter list for macro %q is missing closing parenthesis", name)
+	}
+	paramList := strings.TrimSpace(signature[openIdx+1 : len(signature)-1])
+	if paramList == "" {
+		return "", nil, fmt.Errorf("parameter list for macro %q is empty; leave out the parentheses to define a macro without parameters", name)
+	}
+
+	seen := map[string]bool{}
+	for _, p := range strings.Split(paramList, ",") {
+		p = strings.TrimSpace(p)
+		if err := validateName(p, "parameter", 1); err != nil {
+			return "", nil, fmt.Errorf("macro %q: %v", name, err)
+		}
+		if strings.EqualFold(p, name) {
+			return "", nil, fmt.Errorf("macro %q: parameter cannot have the same name as the macro", name)
+		}
+		if seen[strings.ToUpper(p)] {
+			return "", nil, fmt.Errorf("macro %q: parameter %q is given more than once", name, p)
+		}
+		seen[strings.ToUpper(p)] = true
+		params = append(params, p)
+	}
+	return name, params, nil
+}
+
+// signature gives the text that defines the name and parameters of the macro.
+func (m macro) signature() string {
+	if len(m.params) < 1 {
+		return m.name
+	}
+	return m.name + "(" + strings.Join(m.params, ",") + ")"
+}
+
+func makeParamRegex(params []string) *regexp.Regexp {
+	if len(params) < 1 {
+		return nil
+	}
+	var escaped []string
+	for _, p := range params {
+		escaped = append(escaped, strings.ReplaceAll(p, "$", `\$`))
+	}
+	return regexp.MustCompile(`(?i)\b(?:` + strings.Join(escaped, "|") + `)\b`)
+}
+
+// substituteParams replaces each parameter in the macro's content with the
+// string that replace gives for the index of that parameter.
+func (m macro) substituteParams(replace func(paramIdx int) string) string {
+	return m.paramRegex.ReplaceAllStringFunc(m.content, func(found string) string {
+		for idx, p := range m.params {
+			if strings.EqualFold(p, found) {
+				return replace(idx)
+			}
+		}
+		return found
+	})
+}
+
+// paramPlaceholder gives the placeholder for a parameter of a macro that is
+// being expanded at the given level of recursion. Including the level keeps
+// the placeholders of a call unique from those of any calls made within it.
+func paramPlaceholder(level int, idx int) string {
+	return string([]rune{placeholderMarker, rune(0xE000 + level), rune(0xE000 + idx)})
+}
+
+// parseCallArgs parses the argument list of a call to a macro that starts at
+// text[start]. Returns the arguments and the index in text immediately after
+// the closing parenthesis.
+//
+// Arguments are separated by commas and have surrounding whitespace removed.
+// An argument can be enclosed in double quotes to include commas or unbalanced
+// parentheses; the quotes are not included in the argument.
+func parseCallArgs(text string, start int) (args []string, end int, err error) {
+	i := start
+	for i < len(text) && (text[i] == ' ' || text[i] == '\t' || text[i] == '\n' || text[i] == '\r') {
+		i++
+	}
+	if i >= len(text) || text[i] != '(' {
+		return nil, 0, fmt.Errorf("no argument list given")
+	}
+	i++
+
+	var cur strings.Builder
+	depth := 0
+	inQuote := false
+	finishArg := func() {
+		arg := strings.TrimSpace(cur.String())
+		if len(arg) >= 2 && strings.HasPrefix(arg, `"`) && strings.HasSuffix(arg, `"`) {
+			arg = arg[1 : len(arg)-1]
+		}
+		args = append(args, arg)
+		cur.Reset()
+	}
+	for ; i < len(text); i++ {
+		ch := text[i]
+		switch {
+		case ch == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case ch == '(':
+			depth++
+		case ch == ')' && depth > 0:
+			depth--
+		case ch == ')':
+			if len(args) > 0 || strings.TrimSpace(cur.String()) != "" {
+				finishArg()
+			}
+			return args, i + 1, nil
+		case ch == ',' && depth == 0:
+			finishArg()
+			continue
+		}
+		cur.WriteByte(ch)
+	}
+	if inQuote {
+		return nil, 0, fmt.Errorf("unterminated quote in argument list")
+	}
+	return nil, 0, fmt.Errorf("argument list is missing closing parenthesis")
+}
+
+// expandCalls replaces every call to the parameterized macro vm in text. The
+// arguments of each call are fully expanded in the scope before they are
+// substituted for the parameters, and the content of the macro is then
+// expanded with vm on the stack so that loops through calls can be detected.
+func (sc macroScope) expandCalls(vm visibleMacro, text string, matches [][]int, macrosUsed *stack.StringStack, level int) (string, error) {
+	var sb strings.Builder
+	prevEnd := 0
+	for _, match := range matches {
+		if match[0] < prevEnd {
+			// inside the argument list of a call that was already replaced
+			continue
+		}
+		args, callEnd, err := parseCallArgs(text, match[1])
+		if err != nil {
+			return "", fmt.Errorf("macro %q: %v; call it as %s", vm.ref, err, vm.signature())
+		}
+		if len(args) != len(vm.params) {
+			return "", fmt.Errorf("macro %q takes %s but was given %d", vm.ref, misc.CountOf("argument", "arguments", len(vm.params)), len(args))
+		}
+
+		expandedArgs := make([]string, len(args))
+		for idx, a := range args {
+			expandedArgs[idx], err = sc.expand(a, macrosUsed, level+1)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		body := vm.substituteParams(func(idx int) string {
+			return paramPlaceholder(level, idx)
+		})
+		macrosUsed.Push(vm.key())
+		body, err = vm.scope.expand(body, macrosUsed, level+1)
+		if err != nil {
+			return "", err
+		}
+		macrosUsed.Pop()
+		for idx, a := range expandedArgs {
+			body = strings.ReplaceAll(body, paramPlaceholder(level, idx), a)
+		}
+
+		sb.WriteString(text[prevEnd:match[0]])
+		sb.WriteString(body)
+		prevEnd = callEnd
+	}
+	sb.WriteString(text[prevEnd:])
+	return sb.String(), nil
+}
+
+// inlineCalls replaces every call to the parameterized macro m in text with
+// the content of m, with the arguments of the call substituted as they were
+// given. No other expansion is done. Calls that cannot be parsed are left as
+// they are.
+func (m macro) inlineCalls(text string) string {
+	matches := m.regex.FindAllStringIndex(text, -1)
+	var sb strings.Builder
+	prevEnd := 0
+	for _, match := range matches {
+		if match[0] < prevEnd {
+			continue
+		}
+		args, callEnd, err := parseCallArgs(text, match[1])
+		if err != nil || len(args) != len(m.params) {
+			continue
+		}
+		sb.WriteString(text[prevEnd:match[0]])
+		sb.WriteString(m.substituteParams(func(idx int) string {
+			return args[idx]
+		}))
+		prevEnd = callEnd
+	}
+	sb.WriteString(text[prevEnd:])
+	return sb.String()
+}
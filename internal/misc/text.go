@@ -1,6 +1,7 @@
 package misc
 
 import (
+	"encoding/hex"
 	"fmt"
 	"regexp"
 	"strings"
@@ -162,6 +163,74 @@ func RepeatText(s string, rep int) string {
 	return sb.String()
 }
 
+// FormatHexBytes gives the given bytes as a space-separated list of 0x-prefixed
+// hex values, such as "0x68 0x69".
+func FormatHexBytes(data []byte) string {
+	var sb strings.Builder
+	for idx, b := range data {
+		if idx > 0 {
+			sb.WriteRune(' ')
+		}
+		sb.WriteString("0x")
+		sb.WriteString(hex.EncodeToString([]byte{b}))
+	}
+	return sb.String()
+}
+
+// FormatTextBytes gives the given bytes as text. Printable ASCII characters are
+// shown as themselves, a backslash is shown as two backslashes, and all other
+// bytes are shown as \x escapes.
+func FormatTextBytes(data []byte) string {
+	var sb strings.Builder
+	for _, b := range data {
+		switch {
+		case b == '\\':
+			sb.WriteString(`\\`)
+		case b >= 0x20 && b < 0x7f:
+			sb.WriteByte(b)
+		default:
+			sb.WriteString(fmt.Sprintf("\\x%02x", b))
+		}
+	}
+	return sb.String()
+}
+
+// FormatDumpBytes gives the given bytes as a canonical hex dump with 16 bytes
+// per line. Each line starts with the offset of its first byte and ends with
+// the printable ASCII characters of the line, with a '.' for all other bytes.
+func FormatDumpBytes(data []byte) string {
+	var lines []string
+	for offset := 0; offset < len(data); offset += 16 {
+		end := offset + 16
+		if end > len(data) {
+			end = len(data)
+		}
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf("%08x ", offset))
+		for idx := offset; idx < offset+16; idx++ {
+			if idx%16 == 8 {
+				sb.WriteRune(' ')
+			}
+			if idx < end {
+				sb.WriteString(fmt.Sprintf(" %02x", data[idx]))
+			} else {
+				sb.WriteString("   ")
+			}
+		}
+		sb.WriteString("  |")
+		for _, b := range data[offset:end] {
+			if b >= 0x20 && b < 0x7f {
+				sb.WriteByte(b)
+			} else {
+				sb.WriteRune('.')
+			}
+		}
+		sb.WriteRune('|')
+		lines = append(lines, sb.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
 func appendWordToLine(lines []string, curWord []rune, curLine []rune, width int) (newLines []string, newCurLine []rune) {
 	//originalWord := string(curWord)
 	for len(curWord) > 0 {
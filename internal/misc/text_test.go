@@ -37,3 +37,65 @@ func Test_CollapseWhitespace(t *testing.T) {
 		})
 	}
 }
+
+func Test_FormatTextBytes(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    []byte
+		expected string
+	}{
+		{name: "empty input", input: []byte{}, expected: ""},
+		{name: "printable", input: []byte("hello there"), expected: "hello there"},
+		{name: "backslash", input: []byte("a\\b"), expected: "a\\\\b"},
+		{name: "control chars", input: []byte("a\r\n"), expected: "a\\x0d\\x0a"},
+		{name: "high bytes", input: []byte{0x7f, 0xff}, expected: "\\x7f\\xff"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			actual := FormatTextBytes(tc.input)
+
+			if actual != tc.expected {
+				t.Fatalf("expected %q but got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_FormatDumpBytes(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    []byte
+		expected string
+	}{
+		{name: "empty input", input: []byte{}, expected: ""},
+		{
+			name:     "partial line",
+			input:    []byte("hi\x00"),
+			expected: "00000000  68 69 00                                          |hi.|",
+		},
+		{
+			name:     "full line",
+			input:    []byte("0123456789abcdef"),
+			expected: "00000000  30 31 32 33 34 35 36 37  38 39 61 62 63 64 65 66  |0123456789abcdef|",
+		},
+		{
+			name:  "multiple lines",
+			input: []byte("0123456789abcdefZ"),
+			expected: "00000000  30 31 32 33 34 35 36 37  38 39 61 62 63 64 65 66  |0123456789abcdef|\n" +
+				"00000010  5a                                                |Z|",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			actual := FormatDumpBytes(tc.input)
+
+			if actual != tc.expected {
+				t.Fatalf("expected %q but got %q", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package persist
+
+import (
+	"fmt"
+	"io"
+)
+
+// docBuffer holds the entire contents of a Document in memory along with the
+// current position in it. It is used by Documents that are not written to
+// their backing store until they are flushed.
+type docBuffer struct {
+	data  []byte
+	pos   int64
+	dirty bool // whether data has changed since it was last flushed
+}
+
+func (buf *docBuffer) read(b []byte) (int, error) {
+	if buf.pos >= int64(len(buf.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, buf.data[buf.pos:])
+	buf.pos += int64(n)
+	return n, nil
+}
+
+// write writes b at the current position, or at the end if appending is true.
+func (buf *docBuffer) write(b []byte, appending bool) (int, error) {
+	if appending {
+		buf.pos = int64(len(buf.data))
+	}
+	end := buf.pos + int64(len(b))
+	if end > int64(len(buf.data)) {
+		buf.data = append(buf.data, make([]byte, end-int64(len(buf.data)))...)
+	}
+	copy(buf.data[buf.pos:], b)
+	buf.pos = end
+	buf.dirty = true
+	return len(b), nil
+}
+
+func (buf *docBuffer) seek(offset int64, whence int) (int64, error) {
+	var n int64
+	switch whence {
+	case io.SeekStart:
+		n = offset
+	case io.SeekCurrent:
+		n = buf.pos + offset
+	case io.SeekEnd:
+		n = int64(len(buf.data)) + offset
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("cannot seek to negative position %d", n)
+	}
+	buf.pos = n
+	return n, nil
+}
+
+func (buf *docBuffer) truncate(size int64) error {
+	if size < 0 {
+		return fmt.Errorf("cannot truncate to negative size %d", size)
+	}
+	if size <= int64(len(buf.data)) {
+		buf.data = buf.data[:size]
+	} else {
+		buf.data = append(buf.data, make([]byte, size-int64(len(buf.data)))...)
+	}
+	buf.dirty = true
+	return nil
+}
@@ -3,6 +3,8 @@ package persist
 import (
 	"encoding/gob"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"reflect"
 )
 
@@ -79,23 +81,168 @@ type Codec interface {
 	Finalize() error
 }
 
+// layerCodec is a Codec that transforms the bytes of a Document instead of
+// encoding values in it, such as by compressing or encrypting them. Another
+// Codec can come after it in a codec pipeline.
+type layerCodec interface {
+	Codec
+
+	// layer gives a Document whose reads and writes are transformed by the
+	// Codec. The next Codec in the pipeline is prepared with it. It is only
+	// valid after Prepare() has been called.
+	layer() Document
+}
+
+// codecUserMixin holds the codec pipeline of a Document and provides the
+// Encode, Decode, and Discard methods that use it. It is embedded in each
+// implementation of Document.
+//
+// The first codec in the pipeline reads and writes the Document itself, and
+// each codec after it reads and writes the bytes transformed by the one before
+// it, so every codec but the last must transform bytes. Values given to Encode
+// and Decode are handled by the last codec. Codecs are prepared on the first
+// call to Encode, Decode, or Discard, and are finalized in reverse order when
+// the Document is closed.
 type codecUserMixin struct {
-	codecs []Codec
+	doc      Document // the document the mixin is embedded in
+	codecs   []Codec
+	prepared int
+}
+
+// addCodec adds the given codec to the end of the pipeline.
+func (mix *codecUserMixin) addCodec(c Codec) {
+	mix.codecs = append(mix.codecs, c)
+}
+
+// pipeline prepares any codecs that have not yet been prepared and returns
+// the last one.
+func (mix *codecUserMixin) pipeline() (Codec, error) {
+	if len(mix.codecs) < 1 {
+		return nil, fmt.Errorf("no codecs to use; call UseCodec() first")
+	}
+	for mix.prepared < len(mix.codecs) {
+		stream := mix.doc
+		if mix.prepared > 0 {
+			prev := mix.codecs[mix.prepared-1]
+			layer, ok := prev.(layerCodec)
+			if !ok {
+				return nil, fmt.Errorf("%s codec encodes values and cannot be followed by another codec", prev.Format())
+			}
+			stream = layer.layer()
+		}
+		if err := mix.codecs[mix.prepared].Prepare(stream); err != nil {
+			return nil, err
+		}
+		mix.prepared++
+	}
+	return mix.codecs[len(mix.codecs)-1], nil
+}
+
+// Encode encodes the given value using the codec pipeline.
+func (mix *codecUserMixin) Encode(v interface{}) error {
+	c, err := mix.pipeline()
+	if err != nil {
+		return err
+	}
+	return c.Encode(v)
+}
+
+// Decode decodes the next value using the codec pipeline.
+func (mix *codecUserMixin) Decode(v interface{}) error {
+	c, err := mix.pipeline()
+	if err != nil {
+		return err
+	}
+	return c.Decode(v)
+}
+
+// Discard skips the next value using the codec pipeline.
+func (mix *codecUserMixin) Discard() error {
+	c, err := mix.pipeline()
+	if err != nil {
+		return err
+	}
+	return c.Discard()
+}
+
+// finalizeCodecs finalizes every codec that was prepared, starting with the
+// last, so that each one's final output is transformed by the codecs before
+// it. The first error is returned, but all codecs are finalized regardless.
+func (mix *codecUserMixin) finalizeCodecs() error {
+	var firstErr error
+	for idx := mix.prepared - 1; idx >= 0; idx-- {
+		if err := mix.codecs[idx].Finalize(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("finalizing %s codec: %v", mix.codecs[idx].Format(), err)
+		}
+	}
+	mix.prepared = 0
+	return firstErr
+}
+
+// layerDocument is the Document that a layerCodec gives to the next codec in a
+// pipeline. Reads and writes go through the codec; everything else is answered
+// by the Document that the codec itself is prepared on.
+type layerDocument struct {
+	Document
+	read  func(b []byte) (int, error)
+	write func(b []byte) (int, error)
 }
 
-// UseCodec begins using the given codex for future calls to Encode, Decode, and
-// Discard.
-func (mix *codecUserMixin) UseCodec(c Codec) {
-	mix.codecs = append(fDoc.codecs, c)
-	return fDoc
+func (ld *layerDocument) Read(b []byte) (int, error) {
+	return ld.read(b)
 }
 
-// Encode encodes the given interface value using the current set of codecs.
-func (fDoc *fileDocument) Encode(i interface{}) error {
-	if len(fDoc.codecs) < 1 {
-		return fmt.Errorf("no codecs to encode with; call UseCodec() first")
+func (ld *layerDocument) Write(b []byte) (int, error) {
+	return ld.write(b)
+}
+
+func (ld *layerDocument) Seek(offset int64, whence int) (int64, error) {
+	return 0, fmt.Errorf("cannot seek in data transformed by a codec")
+}
+
+func (ld *layerDocument) Truncate(size int64) error {
+	return fmt.Errorf("cannot truncate data transformed by a codec")
+}
+
+// Flush has no effect; the codec that gave the layerDocument is finalized
+// when the original Document is closed.
+func (ld *layerDocument) Flush() error {
+	return nil
+}
+
+// Close has no effect; the original Document must be closed instead.
+func (ld *layerDocument) Close() error {
+	return nil
+}
+
+// encodeBytes writes v, which must be a []byte, to w. It is the Encode of
+// codecs that transform bytes.
+func encodeBytes(w io.Writer, v interface{}, format string) error {
+	data, ok := v.([]byte)
+	if !ok {
+		return fmt.Errorf("%s format can only encode []byte, not %T", format, v)
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// decodeBytes reads everything remaining in r into v, which must be a
+// *[]byte. It is the Decode of codecs that transform bytes. If nothing
+// remains, io.EOF is returned and v is not modified.
+func decodeBytes(r io.Reader, v interface{}, format string) error {
+	ptr, ok := v.(*[]byte)
+	if !ok || ptr == nil {
+		return fmt.Errorf("%s format can only decode to a non-nil *[]byte, not %T", format, v)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
 	}
-	return fDoc
+	if len(data) < 1 {
+		return io.EOF
+	}
+	*ptr = data
+	return nil
 }
 
 // GobCodec is used to work with Gob-formated data in a Document. The zero value
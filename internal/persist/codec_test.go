@@ -0,0 +1,284 @@
+package persist
+
+import (
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type codecTestRecord struct {
+	Name  string
+	Bytes []byte
+}
+
+func Test_Document_codecPipeline(t *testing.T) {
+	values := []codecTestRecord{
+		{Name: "first", Bytes: []byte{0x01, 0x02}},
+		{Name: "second", Bytes: []byte{0x7e}},
+	}
+
+	testCases := []struct {
+		name   string
+		codecs func() []Codec
+	}{
+		{
+			name:   "gob",
+			codecs: func() []Codec { return []Codec{&GobCodec{}} },
+		},
+		{
+			name:   "JSON-lines",
+			codecs: func() []Codec { return []Codec{&JSONLinesCodec{}} },
+		},
+		{
+			name:   "gzip then JSON-lines",
+			codecs: func() []Codec { return []Codec{&GzipCodec{}, &JSONLinesCodec{}} },
+		},
+		{
+			name:   "AES-GCM then gzip then gob",
+			codecs: func() []Codec { return []Codec{&AESGCMCodec{Passphrase: "hunter2"}, &GzipCodec{}, &GobCodec{}} },
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sut, cleanup := newTestStore(t)
+			defer cleanup()
+
+			doc, err := sut.Create("doc")
+			if err != nil {
+				t.Fatalf("prep step: couldn't create document: %v", err)
+			}
+			for _, c := range tc.codecs() {
+				doc.UseCodec(c)
+			}
+			for _, v := range values {
+				if err := doc.Encode(v); err != nil {
+					t.Fatalf("encode returned an error: %v", err)
+				}
+			}
+			if err := doc.Close(); err != nil {
+				t.Fatalf("close returned an error: %v", err)
+			}
+
+			doc, err = sut.Open("doc")
+			if err != nil {
+				t.Fatalf("couldn't open document: %v", err)
+			}
+			defer doc.Close()
+			for _, c := range tc.codecs() {
+				doc.UseCodec(c)
+			}
+			for idx, expected := range values {
+				var actual codecTestRecord
+				if err := doc.Decode(&actual); err != nil {
+					t.Fatalf("decode of value %d returned an error: %v", idx, err)
+				}
+				if actual.Name != expected.Name || hex.EncodeToString(actual.Bytes) != hex.EncodeToString(expected.Bytes) {
+					t.Errorf("expected value %d to be %+v but got: %+v", idx, expected, actual)
+				}
+			}
+			var extra codecTestRecord
+			if err := doc.Decode(&extra); err != io.EOF {
+				t.Errorf("expected io.EOF after last value but got: %v", err)
+			}
+		})
+	}
+}
+
+func Test_AESGCMCodec_Decode(t *testing.T) {
+	testCases := []struct {
+		name      string
+		stored    []byte
+		encrypt   bool
+		codec     *AESGCMCodec
+		expected  string
+		expectErr bool
+	}{
+		{
+			name:     "correct passphrase",
+			stored:   []byte("secret"),
+			encrypt:  true,
+			codec:    &AESGCMCodec{Passphrase: "hunter2"},
+			expected: "secret",
+		},
+		{
+			name:      "wrong passphrase",
+			stored:    []byte("secret"),
+			encrypt:   true,
+			codec:     &AESGCMCodec{Passphrase: "hunter3"},
+			expectErr: true,
+		},
+		{
+			name:      "plaintext not allowed",
+			stored:    []byte("secret"),
+			codec:     &AESGCMCodec{Passphrase: "hunter2"},
+			expectErr: true,
+		},
+		{
+			name:     "plaintext allowed",
+			stored:   []byte("secret"),
+			codec:    &AESGCMCodec{Passphrase: "hunter2", AllowPlaintext: true},
+			expected: "secret",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store, cleanup := newTestStore(t)
+			defer cleanup()
+
+			doc, err := store.Create("doc")
+			if err != nil {
+				t.Fatalf("prep step: couldn't create document: %v", err)
+			}
+			if tc.encrypt {
+				err = doc.UseCodec(&AESGCMCodec{Passphrase: "hunter2"}).Encode(tc.stored)
+			} else {
+				_, err = doc.Write(tc.stored)
+			}
+			if err != nil {
+				t.Fatalf("prep step: couldn't write document: %v", err)
+			}
+			if err := doc.Close(); err != nil {
+				t.Fatalf("prep step: couldn't close document: %v", err)
+			}
+
+			doc, err = store.Open("doc")
+			if err != nil {
+				t.Fatalf("couldn't open document: %v", err)
+			}
+			defer doc.Close()
+			var actual []byte
+			err = doc.UseCodec(tc.codec).Decode(&actual)
+
+			// check for error
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				t.Fatalf("expected an error but nil error was returned")
+			}
+
+			// check the value
+			if !tc.expectErr && string(actual) != tc.expected {
+				t.Errorf("expected %q but got: %q", tc.expected, string(actual))
+			}
+		})
+	}
+}
+
+func Test_Update_withCodecs(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	for _, line := range []string{"first\n", "second\n"} {
+		err := Update(store, "doc", "", func(current []byte) ([]byte, error) {
+			return append(current, line...), nil
+		}, &AESGCMCodec{Passphrase: "hunter2"})
+		if err != nil {
+			t.Fatalf("update returned an error: %v", err)
+		}
+	}
+
+	onDisk, err := ioutil.ReadFile(filepath.Join(store.(*fsSourceStore).dir, "doc"))
+	if err != nil {
+		t.Fatalf("couldn't read document file: %v", err)
+	}
+	if !IsEncrypted(onDisk) {
+		t.Errorf("expected document file to be encrypted")
+	}
+
+	doc, err := store.Open("doc")
+	if err != nil {
+		t.Fatalf("couldn't open document: %v", err)
+	}
+	defer doc.Close()
+	var actual []byte
+	if err := doc.UseCodec(&AESGCMCodec{Passphrase: "hunter2"}).Decode(&actual); err != nil {
+		t.Fatalf("decode returned an error: %v", err)
+	}
+	if string(actual) != "first\nsecond\n" {
+		t.Errorf("expected %q but got: %q", "first\nsecond\n", string(actual))
+	}
+}
+
+func Test_pbkdf2SHA256(t *testing.T) {
+	// test vectors from RFC 7914, section 11
+	testCases := []struct {
+		name       string
+		password   string
+		salt       string
+		iterations int
+		expected   string
+	}{
+		{
+			name:       "one iteration",
+			password:   "passwd",
+			salt:       "salt",
+			iterations: 1,
+			expected:   "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783",
+		},
+		{
+			name:       "many iterations",
+			password:   "Password",
+			salt:       "NaCl",
+			iterations: 80000,
+			expected:   "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := hex.EncodeToString(pbkdf2SHA256([]byte(tc.password), []byte(tc.salt), tc.iterations, 64))
+			if actual != tc.expected {
+				t.Errorf("expected %s but got: %s", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_KeyCache(t *testing.T) {
+	keys := &KeyCache{}
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	var sealed [][]byte
+	for _, key := range []string{"first", "second"} {
+		err := Update(store, key, "", func(current []byte) ([]byte, error) {
+			return []byte("data"), nil
+		}, &AESGCMCodec{Passphrase: "hunter2", Keys: keys})
+		if err != nil {
+			t.Fatalf("update returned an error: %v", err)
+		}
+		onDisk, err := ioutil.ReadFile(filepath.Join(store.(*fsSourceStore).dir, key))
+		if err != nil {
+			t.Fatalf("couldn't read document file: %v", err)
+		}
+		sealed = append(sealed, onDisk)
+	}
+
+	header := len(encryptedMagic) + 1 + saltSize
+	if string(sealed[0][:header]) != string(sealed[1][:header]) {
+		t.Errorf("expected documents written with the same KeyCache to have the same salt")
+	}
+	if len(keys.keys) != 1 {
+		t.Errorf("expected 1 key to be derived but got %d", len(keys.keys))
+	}
+}
+
+// newTestStore gives a Store in a new temporary directory and a function that
+// removes the directory.
+func newTestStore(t *testing.T) (Store, func()) {
+	dir, err := ioutil.TempDir("", "persist-test")
+	if err != nil {
+		t.Fatalf("prep step: couldn't create temp dir: %v", err)
+	}
+	store, err := NewFilesystemStore(dir, nil, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("prep step: couldn't create store: %v", err)
+	}
+	return store, func() { os.RemoveAll(dir) }
+}
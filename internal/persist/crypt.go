@@ -0,0 +1,311 @@
+package persist
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// encryptedMagic begins every Document encrypted by AESGCMCodec. It is
+	// followed by the version of the encrypted format.
+	encryptedMagic   = "NKKENC"
+	encryptedVersion = 1
+
+	saltSize = 16
+
+	// kdfIterations is the number of PBKDF2-HMAC-SHA256 iterations used to
+	// derive a key from a passphrase.
+	kdfIterations = 600000
+
+	// maxCachedKeys is the number of derived keys that a KeyCache holds.
+	maxCachedKeys = 16
+)
+
+// AESGCMCodec encrypts the data in a Document with AES-256 in GCM mode, which
+// also detects whether the data has been changed. The key is derived from
+// Passphrase with PBKDF2-HMAC-SHA256 and a random salt that is stored in the
+// Document.
+//
+// Like GzipCodec, it transforms bytes rather than encoding values, so it is
+// typically followed by another codec in the codec pipeline; on its own, it
+// encodes a []byte and decodes everything in the Document into a *[]byte.
+// Since the whole of the data must be encrypted at once, everything written is
+// kept in memory until Finalize() is called.
+type AESGCMCodec struct {
+	// Passphrase is what the key is derived from. It must not be empty.
+	Passphrase string
+
+	// AllowPlaintext makes a Document that is not encrypted be read as it is
+	// instead of causing an error. It is encrypted the next time it is
+	// written, so this can be used to begin encrypting existing Documents.
+	AllowPlaintext bool
+
+	// Keys holds the keys derived from Passphrase so that they can be used
+	// again by other AESGCMCodecs. If it is nil, a key is derived every time
+	// a Document is read or written.
+	Keys *KeyCache
+
+	doc   Document
+	plain *bytes.Reader
+	out   bytes.Buffer
+	wrote bool
+
+	// the salt of the Document that was read, which is used again when it is
+	// written
+	salt []byte
+}
+
+// KeyCache holds keys derived by AESGCMCodecs, keyed by the passphrase and
+// salt they were derived from. Deriving a key is deliberately slow, and the
+// same Documents are typically read and written many times, so a KeyCache is
+// meant to be shared by every AESGCMCodec used in a session. It also holds a
+// single salt that is used for every Document that is written without having
+// been read first, so that they all share one key.
+//
+// At most 16 keys are held; once that many are, one is dropped each time
+// another is added. The zero value is ready to use, and a KeyCache is safe
+// for concurrent use.
+type KeyCache struct {
+	mtx  sync.Mutex
+	salt []byte
+	keys map[string][]byte
+}
+
+// newSalt gives the salt for a Document that is written without having been
+// read first.
+func (kc *KeyCache) newSalt() ([]byte, error) {
+	if kc == nil {
+		return randomSalt()
+	}
+	kc.mtx.Lock()
+	defer kc.mtx.Unlock()
+	if kc.salt == nil {
+		salt, err := randomSalt()
+		if err != nil {
+			return nil, err
+		}
+		kc.salt = salt
+	}
+	return kc.salt, nil
+}
+
+// key gives the key derived from the passphrase and salt, deriving it if it
+// is not already held.
+func (kc *KeyCache) key(passphrase string, salt []byte) []byte {
+	if kc == nil {
+		return pbkdf2SHA256([]byte(passphrase), salt, kdfIterations, 32)
+	}
+	cacheKey := string(salt) + "\x00" + passphrase
+	kc.mtx.Lock()
+	defer kc.mtx.Unlock()
+	if key, ok := kc.keys[cacheKey]; ok {
+		return key
+	}
+	key := pbkdf2SHA256([]byte(passphrase), salt, kdfIterations, 32)
+	if kc.keys == nil {
+		kc.keys = map[string][]byte{}
+	}
+	if len(kc.keys) >= maxCachedKeys {
+		for k := range kc.keys {
+			delete(kc.keys, k)
+			break
+		}
+	}
+	kc.keys[cacheKey] = key
+	return key
+}
+
+// randomSalt gives a new random salt.
+func randomSalt() ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("couldn't generate salt: %v", err)
+	}
+	return salt, nil
+}
+
+// IsEncrypted returns whether the given data is a Document that was encrypted
+// by AESGCMCodec.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedMagic))
+}
+
+// Format returns "AES-GCM", the name of the format that the AESGCMCodec works
+// with.
+func (aesgcm *AESGCMCodec) Format() string {
+	return "AES-GCM"
+}
+
+// Prepare readies the AESGCMCodec for use with the given Document.
+func (aesgcm *AESGCMCodec) Prepare(doc Document) error {
+	if aesgcm.Passphrase == "" {
+		return fmt.Errorf("no passphrase to encrypt with")
+	}
+	aesgcm.doc = doc
+	aesgcm.plain = nil
+	aesgcm.salt = nil
+	aesgcm.out.Reset()
+	aesgcm.wrote = false
+	return nil
+}
+
+// Decode decrypts the rest of the Document into v, which must be a *[]byte.
+func (aesgcm *AESGCMCodec) Decode(v interface{}) error {
+	if aesgcm.doc == nil {
+		return fmt.Errorf("no document to decode; call Prepare() first")
+	}
+	return decodeBytes(readerFunc(aesgcm.read), v, aesgcm.Format())
+}
+
+// Encode adds v, which must be a []byte, to the data that is encrypted into
+// the Document when Finalize() is called.
+func (aesgcm *AESGCMCodec) Encode(v interface{}) error {
+	if aesgcm.doc == nil {
+		return fmt.Errorf("no document to encode to; call Prepare() first")
+	}
+	return encodeBytes(writerFunc(aesgcm.write), v, aesgcm.Format())
+}
+
+// Discard decrypts and discards the rest of the Document.
+func (aesgcm *AESGCMCodec) Discard() error {
+	var skipped []byte
+	return aesgcm.Decode(&skipped)
+}
+
+// Finalize encrypts everything that was encoded and writes it to the Document,
+// and disassociates from the Document passed in Prepare().
+func (aesgcm *AESGCMCodec) Finalize() error {
+	if aesgcm.doc == nil {
+		return nil
+	}
+	var err error
+	if aesgcm.wrote {
+		err = aesgcm.seal()
+	}
+	aesgcm.doc = nil
+	aesgcm.plain = nil
+	aesgcm.out.Reset()
+	aesgcm.wrote = false
+	return err
+}
+
+func (aesgcm *AESGCMCodec) layer() Document {
+	return &layerDocument{Document: aesgcm.doc, read: aesgcm.read, write: aesgcm.write}
+}
+
+func (aesgcm *AESGCMCodec) read(b []byte) (int, error) {
+	if aesgcm.plain == nil {
+		data, err := ioutil.ReadAll(aesgcm.doc)
+		if err != nil {
+			return 0, err
+		}
+		plain, err := aesgcm.open(data)
+		if err != nil {
+			return 0, err
+		}
+		aesgcm.plain = bytes.NewReader(plain)
+	}
+	return aesgcm.plain.Read(b)
+}
+
+func (aesgcm *AESGCMCodec) write(b []byte) (int, error) {
+	aesgcm.wrote = true
+	return aesgcm.out.Write(b)
+}
+
+// encryptedHeader gives the bytes that begin an encrypted Document with the
+// given salt. They are authenticated along with the encrypted data.
+func encryptedHeader(salt []byte) []byte {
+	header := append([]byte(encryptedMagic), encryptedVersion)
+	return append(header, salt...)
+}
+
+// open decrypts the contents of a Document.
+func (aesgcm *AESGCMCodec) open(data []byte) ([]byte, error) {
+	if len(data) < 1 {
+		return nil, nil
+	}
+	if !IsEncrypted(data) {
+		if aesgcm.AllowPlaintext {
+			return data, nil
+		}
+		return nil, fmt.Errorf("document is not encrypted")
+	}
+
+	data = data[len(encryptedMagic):]
+	if len(data) < 1 || data[0] != encryptedVersion {
+		return nil, fmt.Errorf("document is encrypted with an unsupported version of the format")
+	}
+	data = data[1:]
+	if len(data) < saltSize {
+		return nil, fmt.Errorf("encrypted document is truncated")
+	}
+	salt := data[:saltSize]
+	data = data[saltSize:]
+
+	aesgcm.salt = append([]byte(nil), salt...)
+	gcm, err := aesgcm.cipher(salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted document is truncated")
+	}
+	nonce, sealed := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, sealed, encryptedHeader(salt))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decrypt document; the passphrase is wrong or the document is corrupted")
+	}
+	return plain, nil
+}
+
+// seal encrypts everything that was written and writes it to the Document. The
+// salt of the Document that was read is kept, if there was one, and otherwise
+// the one held by Keys is used; a new nonce is used every time.
+func (aesgcm *AESGCMCodec) seal() error {
+	salt := aesgcm.salt
+	if salt == nil {
+		var err error
+		if salt, err = aesgcm.Keys.newSalt(); err != nil {
+			return err
+		}
+	}
+	gcm, err := aesgcm.cipher(salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("couldn't generate nonce: %v", err)
+	}
+
+	header := encryptedHeader(salt)
+	out := append(append([]byte(nil), header...), nonce...)
+	out = gcm.Seal(out, nonce, aesgcm.out.Bytes(), header)
+	_, err = aesgcm.doc.Write(out)
+	return err
+}
+
+// cipher gives the AEAD for the key derived from the passphrase and the given
+// salt.
+func (aesgcm *AESGCMCodec) cipher(salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(aesgcm.Keys.key(aesgcm.Passphrase, salt))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2SHA256 derives a key of keyLen bytes from the password and salt using
+// PBKDF2 as given in RFC 8018 with HMAC-SHA256 as the pseudorandom function.
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	return pbkdf2.Key(password, salt, iterations, keyLen, sha256.New)
+}
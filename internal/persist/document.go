@@ -14,6 +14,10 @@ type Document interface {
 	// Flush immediately commits all pending writes. Not used if in synchronous mode.
 	Flush() error
 
+	// Truncate changes the size of the Document to the given size. It does not
+	// change the current seek position.
+	Truncate(size int64) error
+
 	// Mode gives the DocumentMode that this Document was created with.
 	Mode() DocumentMode
 
@@ -30,10 +34,25 @@ type Document interface {
 
 	// UseCodec begins using the given codec in the Document. If any codecs are
 	// already being used, the new codec will be the new end of the codec
-	// pipeline.
+	// pipeline; every codec before it must be one that transforms bytes, such
+	// as GzipCodec or AESGCMCodec.
 	//
 	// The Document itself is returned.
 	UseCodec(c Codec) Document
+
+	// Encode encodes the given value into the Document using the codec
+	// pipeline. It returns an error if no codecs are being used.
+	Encode(v interface{}) error
+
+	// Decode decodes the next value in the Document using the codec pipeline
+	// and stores it in the value pointed to by v. It returns io.EOF if there
+	// are no more values, and an error if no codecs are being used.
+	Decode(v interface{}) error
+
+	// Discard skips the next value in the Document using the codec pipeline.
+	// It returns an error if no codecs are being used or if the last codec in
+	// the pipeline does not support it.
+	Discard() error
 }
 
 // AllowedOperations is a number that specifies which operations
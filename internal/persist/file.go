@@ -1,33 +1,46 @@
 package persist
 
 import (
-	"bufio"
-	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 // fsSourceStore is a store that can open files on the filesystem in a particular directory;
 // all keys are paths relative to that directory. Optionally, its fully-qualified
 // alt key can be used to specifify an absolute path to another file on disk.
+//
+// Documents that are not opened in Synchronous mode are read entirely into
+// memory when opened, and are written by writing a temporary file next to the
+// original and renaming it over the original. A crash part-way through a write
+// therefore leaves either the old or the new contents on disk, never a mix.
+//
+// Documents that can be written to hold an advisory lock on a lock file next
+// to the document (the path of the document followed by ".lock") from the time
+// they are opened until they are closed, so only one process at a time can
+// have a given document open for writing.
 type fsSourceStore struct {
 	dir          string
 	newFilePerms os.FileMode
 }
 
 type fileDocument struct {
-	f      *os.File
+	codecUserMixin
+
+	f      *os.File // only used in synchronous mode
+	lock   *os.File // only held if writable
 	closed bool
 	mode   DocumentMode
 
+	path  string
+	perms os.FileMode // used if the file is created
+
 	key  string
 	fqak bool
 
-	readBuf  *bufio.Reader
-	writeBuf bytes.Buffer
-
-	codecs []Codec
+	buf docBuffer // only used in asynchronous mode
 }
 
 // Read reads bytes from the file.
@@ -41,7 +54,7 @@ func (fDoc *fileDocument) Read(b []byte) (n int, err error) {
 	if fDoc.mode.Synchronous {
 		return fDoc.f.Read(b)
 	}
-	return fDoc.readBuf.Read(b)
+	return fDoc.buf.read(b)
 }
 
 // Write writes bytes to the document.
@@ -55,7 +68,7 @@ func (fDoc *fileDocument) Write(b []byte) (n int, err error) {
 	if fDoc.mode.Synchronous {
 		return fDoc.f.Write(b)
 	}
-	return fDoc.writeBuf.Write(b)
+	return fDoc.buf.write(b, fDoc.mode.Append)
 }
 
 // Seek moves the cursor position to the given offset. If opened in Append mode,
@@ -64,8 +77,25 @@ func (fDoc *fileDocument) Seek(offset int64, whence int) (n int64, err error) {
 	if fDoc.closed {
 		return 0, fmt.Errorf("Document has been closed and cannot perform further operations")
 	}
+	if fDoc.mode.Synchronous {
+		return fDoc.f.Seek(offset, whence)
+	}
+	return fDoc.buf.seek(offset, whence)
+}
 
-	return fDoc.f.Seek(offset, whence)
+// Truncate changes the size of the document. The cursor position is not
+// changed.
+func (fDoc *fileDocument) Truncate(size int64) error {
+	if fDoc.closed {
+		return fmt.Errorf("Document has been closed and cannot perform further operations")
+	}
+	if fDoc.mode.AllowedOperations == ReadOnly {
+		return fmt.Errorf("Document opened in read-only mode and cannot perform writes")
+	}
+	if fDoc.mode.Synchronous {
+		return fDoc.f.Truncate(size)
+	}
+	return fDoc.buf.truncate(size)
 }
 
 // Close flushes all currently written to the Document to the actual
@@ -80,14 +110,24 @@ func (fDoc *fileDocument) Close() (err error) {
 		return nil // already closed, don't need to do it again
 	}
 
-	var flushErr error
-	if fDoc.mode.AllowedOperations != ReadOnly && !fDoc.mode.Synchronous {
+	// codecs may have final output to write before the flush
+	flushErr := fDoc.finalizeCodecs()
+	if fDoc.mode.AllowedOperations != ReadOnly && !fDoc.mode.Synchronous && flushErr == nil {
 		// do not need to flush writes if it's read only (where there will not
 		// be any valid writes) or synchronous (which auto flushes).
 		flushErr = fDoc.Flush()
 	}
 
-	closeErr := fDoc.f.Close()
+	var closeErr error
+	if fDoc.f != nil {
+		closeErr = fDoc.f.Close()
+	}
+	if fDoc.lock != nil {
+		// closing the lock file releases the lock
+		if err := fDoc.lock.Close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
 
 	fDoc.closed = true
 	if closeErr != nil && flushErr != nil {
@@ -99,7 +139,8 @@ func (fDoc *fileDocument) Close() (err error) {
 	return closeErr
 }
 
-// Flush flushes all pending writes.
+// Flush writes the contents of the document to a temporary file and then
+// replaces the file of the document with it.
 func (fDoc *fileDocument) Flush() (err error) {
 	if fDoc.closed {
 		return fmt.Errorf("Document has been closed and cannot perform further operations")
@@ -112,22 +153,15 @@ func (fDoc *fileDocument) Flush() (err error) {
 		// all writes are unbuffered; nothing to flush
 		return nil
 	}
-	if fDoc.writeBuf.Len() < 1 {
+	if !fDoc.buf.dirty {
 		// nothing to flush
 		return nil
 	}
 
-	fileWriter := bufio.NewWriter(fDoc.f)
-	n, err := fileWriter.Write(fDoc.writeBuf.Bytes())
-	if err != nil {
-		return fmt.Errorf("after writing %d bytes to secondary buffer, got: %v", n, err)
-	}
-
-	if err := fileWriter.Flush(); err != nil {
+	if err := writeFileAtomic(fDoc.path, fDoc.buf.data, fDoc.perms); err != nil {
 		return err
 	}
-
-	fDoc.writeBuf = bytes.Buffer{}
+	fDoc.buf.dirty = false
 	return nil
 }
 
@@ -148,6 +182,13 @@ func (fDoc *fileDocument) UsesAlternativeKey() bool {
 	return fDoc.fqak
 }
 
+// UseCodec adds the given codec to the end of the codec pipeline of the
+// document.
+func (fDoc *fileDocument) UseCodec(c Codec) Document {
+	fDoc.addCodec(c)
+	return fDoc
+}
+
 // NewFilesystemStore creates and returns a new Store that reads/writes Documents
 // as files on the filesystem, all relative to a given directory. The given directory
 // will be created if needed.
@@ -158,7 +199,7 @@ func (fDoc *fileDocument) UsesAlternativeKey() bool {
 // Only the permissions portion is used; all other aspects of os.FileMode are
 // ignored. Both of these values can be set a default by the caller setting them
 // to nil. If dirPerm is set to nil, the newly created directed is created with
-// permissions mask 0666. If newDocPerm is set to nil, newly-created
+// permissions mask 0777. If newDocPerm is set to nil, newly-created
 // document files are created with permissions mask 0666.
 //
 // err will be non-nil when the directory could not be accessed or created.
@@ -171,9 +212,10 @@ func NewFilesystemStore(directory string, dirPerm, newDocPerm *os.FileMode) (sto
 		fsStore.newFilePerms = newDocPerm.Perm()
 	}
 
-	if info, err := os.Stat(directory); err != nil {
+	info, err := os.Stat(directory)
+	if err != nil {
 		if os.IsNotExist(err) {
-			dirMode := os.ModeDir | 0666
+			dirMode := os.ModeDir | 0777
 			if dirPerm != nil {
 				dirMode = os.ModeDir | dirPerm.Perm()
 			}
@@ -182,11 +224,12 @@ func NewFilesystemStore(directory string, dirPerm, newDocPerm *os.FileMode) (sto
 			}
 			return fsStore, nil
 		}
-		if !info.IsDir() {
-			return nil, fmt.Errorf("path exists and is not a directory")
-		}
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("path exists and is not a directory")
 	}
-	return store, nil
+	return fsStore, nil
 }
 
 // NewUserHomeDirStore creates and returns a new Store that reads/writes Documents
@@ -217,31 +260,57 @@ func (fsStore *fsSourceStore) Create(key string) (doc Document, err error) {
 
 func (fsStore *fsSourceStore) OpenDocumentAlt(key, fqAltKey string, mode DocumentMode) (doc Document, err error) {
 	fDoc := &fileDocument{
-		mode: mode,
-		key:  key,
+		mode:  mode,
+		key:   key,
+		perms: fsStore.newFilePerms,
 	}
+	fDoc.codecUserMixin.doc = fDoc
 
-	path := fqAltKey
-	if path != "" {
+	fDoc.path = fqAltKey
+	if fDoc.path != "" {
 		fDoc.fqak = true
 		fDoc.key = fqAltKey
 	} else {
-		path = filepath.Join(fsStore.dir, key)
+		fDoc.path = filepath.Join(fsStore.dir, key)
 	}
 
-	flags := fileFlagsFromDocumentMode(mode)
-	fDoc.f, err = os.OpenFile(path, flags, fsStore.newFilePerms)
-	if err != nil {
-		return nil, err
+	if mode.AllowedOperations != ReadOnly {
+		fDoc.lock, err = lockPath(fDoc.path, fsStore.newFilePerms)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	if !mode.Synchronous {
-		fDoc.readBuf = bufio.NewReader(fDoc.f)
+	if mode.Synchronous {
+		flags := fileFlagsFromDocumentMode(mode)
+		fDoc.f, err = os.OpenFile(fDoc.path, flags, fsStore.newFilePerms)
+		if err != nil {
+			fDoc.unlock()
+			return nil, err
+		}
+		return fDoc, nil
 	}
 
+	if err := fDoc.load(); err != nil {
+		fDoc.unlock()
+		return nil, err
+	}
 	return fDoc, nil
 }
 
+// modTime gives when the Document with the given key was last changed.
+func (fsStore *fsSourceStore) modTime(key, fqAltKey string) (time.Time, error) {
+	path := fqAltKey
+	if path == "" {
+		path = filepath.Join(fsStore.dir, key)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
 func (fsStore *fsSourceStore) OpenAlt(key, fqAltKey string) (doc Document, err error) {
 	return fsStore.OpenDocumentAlt(key, fqAltKey, BasicOpenMode)
 }
@@ -250,6 +319,102 @@ func (fsStore *fsSourceStore) CreateAlt(key, fqAltKey string) (doc Document, err
 	return fsStore.OpenDocumentAlt(key, fqAltKey, BasicCreateMode)
 }
 
+// load reads the file of the document into memory, following the Create,
+// Exclusive, and Truncate flags of its mode the same way that opening it with
+// os.OpenFile would.
+func (fDoc *fileDocument) load() error {
+	info, err := os.Stat(fDoc.path)
+	if err != nil {
+		if !os.IsNotExist(err) || !fDoc.mode.Create || fDoc.mode.AllowedOperations == ReadOnly {
+			return err
+		}
+		// the file is created when the document is flushed
+		fDoc.buf.dirty = true
+		return nil
+	}
+	if info.IsDir() {
+		return &os.PathError{Op: "open", Path: fDoc.path, Err: fmt.Errorf("is a directory")}
+	}
+	if fDoc.mode.Create && fDoc.mode.Exclusive {
+		return &os.PathError{Op: "open", Path: fDoc.path, Err: os.ErrExist}
+	}
+
+	if fDoc.mode.Truncate && fDoc.mode.AllowedOperations != ReadOnly {
+		fDoc.buf.dirty = info.Size() > 0
+		return nil
+	}
+	fDoc.buf.data, err = ioutil.ReadFile(fDoc.path)
+	return err
+}
+
+// unlock releases the lock held by the document, if any.
+func (fDoc *fileDocument) unlock() {
+	if fDoc.lock != nil {
+		fDoc.lock.Close()
+		fDoc.lock = nil
+	}
+}
+
+// lockPath opens the lock file of the file at the given path and waits until
+// an exclusive lock on it is obtained. The lock is released by closing the
+// returned file.
+func lockPath(path string, perms os.FileMode) (*os.File, error) {
+	lockFile, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, perms)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFileExclusive(lockFile); err != nil {
+		lockFile.Close()
+		return nil, fmt.Errorf("couldn't lock %s: %v", path, err)
+	}
+	return lockFile, nil
+}
+
+// writeFileAtomic replaces the file at the given path with one that has the
+// given contents. The contents are written to a temporary file in the same
+// directory, which is then renamed to the path.
+func writeFileAtomic(path string, data []byte, perms os.FileMode) error {
+	dir, base := filepath.Split(path)
+
+	var tmp *os.File
+	var err error
+	for attempt := 0; tmp == nil; attempt++ {
+		tmpName := filepath.Join(dir, fmt.Sprintf(".%s.%d.%d.tmp", base, os.Getpid(), time.Now().UnixNano()))
+		tmp, err = os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perms)
+		if err != nil && (!os.IsExist(err) || attempt >= 10) {
+			return err
+		}
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	// the umask only applies to the new file, so the permissions of a file
+	// being replaced are copied explicitly
+	if info, err := os.Stat(path); err == nil {
+		if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
 func fileFlagsFromDocumentMode(mode DocumentMode) int {
 	var flags int
 
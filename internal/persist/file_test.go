@@ -0,0 +1,159 @@
+package persist
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_fsSourceStore_OpenDocument(t *testing.T) {
+	testCases := []struct {
+		name      string
+		existing  *string
+		mode      DocumentMode
+		write     string
+		expected  string
+		expectErr bool
+	}{
+		{
+			name:     "create new",
+			mode:     BasicCreateMode,
+			write:    "data",
+			expected: "data",
+		},
+		{
+			name:     "create truncates existing",
+			existing: strPtr("old contents"),
+			mode:     BasicCreateMode,
+			write:    "new",
+			expected: "new",
+		},
+		{
+			name:     "append",
+			existing: strPtr("old"),
+			mode:     DocumentMode{AllowedOperations: WriteOnly, Append: true},
+			write:    "new",
+			expected: "oldnew",
+		},
+		{
+			name:     "overwrite start",
+			existing: strPtr("old contents"),
+			mode:     DocumentMode{AllowedOperations: ReadAndWrite},
+			write:    "new",
+			expected: "new contents",
+		},
+		{
+			name:      "open missing without create",
+			mode:      DocumentMode{AllowedOperations: ReadAndWrite},
+			expectErr: true,
+		},
+		{
+			name:      "exclusive create of existing",
+			existing:  strPtr("old"),
+			mode:      BasicCreateMode.WithExclusive(true),
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "persist-test")
+			if err != nil {
+				t.Fatalf("prep step: couldn't create temp dir: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			sut, err := NewFilesystemStore(dir, nil, nil)
+			if err != nil {
+				t.Fatalf("prep step: couldn't create store: %v", err)
+			}
+			path := filepath.Join(dir, "doc")
+			if tc.existing != nil {
+				if err := ioutil.WriteFile(path, []byte(*tc.existing), 0666); err != nil {
+					t.Fatalf("prep step: couldn't write existing file: %v", err)
+				}
+			}
+
+			doc, err := sut.OpenDocument("doc", tc.mode)
+
+			// check for error
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				doc.Close()
+				t.Fatalf("expected an error but nil error was returned")
+			}
+			if tc.expectErr {
+				return
+			}
+
+			if _, err := doc.Write([]byte(tc.write)); err != nil {
+				t.Fatalf("write failed: %v", err)
+			}
+			if tc.existing != nil {
+				// nothing is written to the file until the document is closed
+				if actual, _ := ioutil.ReadFile(path); string(actual) != *tc.existing {
+					t.Errorf("expected file to be unchanged before close but got: %q", string(actual))
+				}
+			}
+			if err := doc.Close(); err != nil {
+				t.Fatalf("close failed: %v", err)
+			}
+
+			// check the value
+			actual, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatalf("couldn't read written file: %v", err)
+			}
+			if string(actual) != tc.expected {
+				t.Errorf("expected file to contain %q but got: %q", tc.expected, string(actual))
+			}
+		})
+	}
+}
+
+func Test_Update_concurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "persist-test")
+	if err != nil {
+		t.Fatalf("prep step: couldn't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	sut, err := NewFilesystemStore(dir, nil, nil)
+	if err != nil {
+		t.Fatalf("prep step: couldn't create store: %v", err)
+	}
+
+	const writers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- Update(sut, "doc", "", func(current []byte) ([]byte, error) {
+				return append(current, []byte("line\n")...), nil
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("update returned an error: %v", err)
+		}
+	}
+
+	actual, err := ioutil.ReadFile(filepath.Join(dir, "doc"))
+	if err != nil {
+		t.Fatalf("couldn't read written file: %v", err)
+	}
+	if count := strings.Count(string(actual), "line\n"); count != writers {
+		t.Errorf("expected %d lines but got %d", writers, count)
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,106 @@
+package persist
+
+import (
+	"compress/gzip"
+	"fmt"
+)
+
+// GzipCodec compresses the data in a Document with gzip. It transforms bytes
+// rather than encoding values, so it is typically followed by another codec in
+// the codec pipeline; on its own, it encodes a []byte and decodes everything
+// in the Document into a *[]byte. The zero value is ready to use.
+type GzipCodec struct {
+	doc Document
+	r   *gzip.Reader
+	w   *gzip.Writer
+}
+
+// Format returns "gzip", the name of the format that the GzipCodec works with.
+func (gz *GzipCodec) Format() string {
+	return "gzip"
+}
+
+// Prepare readies the GzipCodec for use with the given Document.
+func (gz *GzipCodec) Prepare(doc Document) error {
+	gz.doc = doc
+	gz.r = nil
+	gz.w = nil
+	return nil
+}
+
+// Decode decompresses the rest of the Document into v, which must be a
+// *[]byte.
+func (gz *GzipCodec) Decode(v interface{}) error {
+	if gz.doc == nil {
+		return fmt.Errorf("no document to decode; call Prepare() first")
+	}
+	return decodeBytes(readerFunc(gz.read), v, gz.Format())
+}
+
+// Encode compresses v, which must be a []byte, into the Document. The
+// compressed data is not complete until Finalize() is called.
+func (gz *GzipCodec) Encode(v interface{}) error {
+	if gz.doc == nil {
+		return fmt.Errorf("no document to encode to; call Prepare() first")
+	}
+	return encodeBytes(writerFunc(gz.write), v, gz.Format())
+}
+
+// Discard decompresses and discards the rest of the Document.
+func (gz *GzipCodec) Discard() error {
+	var skipped []byte
+	return gz.Decode(&skipped)
+}
+
+// Finalize writes the end of the compressed data if anything was encoded and
+// disassociates from the Document passed in Prepare().
+func (gz *GzipCodec) Finalize() error {
+	var err error
+	if gz.w != nil {
+		err = gz.w.Close()
+	}
+	if gz.r != nil {
+		gz.r.Close()
+	}
+	gz.doc = nil
+	gz.r = nil
+	gz.w = nil
+	return err
+}
+
+func (gz *GzipCodec) layer() Document {
+	return &layerDocument{Document: gz.doc, read: gz.read, write: gz.write}
+}
+
+func (gz *GzipCodec) read(b []byte) (int, error) {
+	if gz.r == nil {
+		// an empty Document has no gzip header, and holds no data
+		r, err := gzip.NewReader(gz.doc)
+		if err != nil {
+			return 0, err
+		}
+		gz.r = r
+	}
+	return gz.r.Read(b)
+}
+
+func (gz *GzipCodec) write(b []byte) (int, error) {
+	if gz.w == nil {
+		gz.w = gzip.NewWriter(gz.doc)
+	}
+	return gz.w.Write(b)
+}
+
+// readerFunc is a function that implements io.Reader.
+type readerFunc func(b []byte) (int, error)
+
+func (f readerFunc) Read(b []byte) (int, error) {
+	return f(b)
+}
+
+// writerFunc is a function that implements io.Writer.
+type writerFunc func(b []byte) (int, error)
+
+func (f writerFunc) Write(b []byte) (int, error) {
+	return f(b)
+}
@@ -0,0 +1,67 @@
+package persist
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// JSONLinesCodec is used to work with JSON-lines formatted data in a Document,
+// where each value is a JSON document on its own line. The zero value is ready
+// to use.
+type JSONLinesCodec struct {
+	doc Document
+	dec *json.Decoder
+}
+
+// Format returns "JSON-lines", the name of the format that the JSONLinesCodec
+// works with.
+func (jl *JSONLinesCodec) Format() string {
+	return "JSON-lines"
+}
+
+// Prepare readies the JSONLinesCodec for use with the given Document.
+func (jl *JSONLinesCodec) Prepare(doc Document) error {
+	jl.doc = doc
+	jl.dec = json.NewDecoder(doc)
+	return nil
+}
+
+// Decode decodes the next line of the Document as JSON.
+func (jl *JSONLinesCodec) Decode(v interface{}) error {
+	if jl.dec == nil {
+		return fmt.Errorf("no document to decode; call Prepare() first")
+	}
+	if v == nil {
+		return fmt.Errorf("cannot decode to nil; use Discard() if trying to discard")
+	}
+	return jl.dec.Decode(v)
+}
+
+// Encode encodes data to the Document as a line of JSON.
+func (jl *JSONLinesCodec) Encode(v interface{}) error {
+	if jl.doc == nil {
+		return fmt.Errorf("no document to encode to; call Prepare() first")
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = jl.doc.Write(append(data, '\n'))
+	return err
+}
+
+// Discard skips the next line of JSON in the Document.
+func (jl *JSONLinesCodec) Discard() error {
+	if jl.dec == nil {
+		return fmt.Errorf("no document to decode; call Prepare() first")
+	}
+	var skipped json.RawMessage
+	return jl.dec.Decode(&skipped)
+}
+
+// Finalize disassociates from the Document passed in Prepare().
+func (jl *JSONLinesCodec) Finalize() error {
+	jl.doc = nil
+	jl.dec = nil
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package persist
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFileExclusive waits until an exclusive advisory lock is obtained on the
+// given file. The lock is released when the file is closed.
+func lockFileExclusive(f *os.File) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
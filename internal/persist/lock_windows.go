@@ -0,0 +1,16 @@
+package persist
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFileExclusive waits until an exclusive lock is obtained on the given
+// file. The lock is released when the file is closed.
+func lockFileExclusive(f *os.File) error {
+	// the whole file is locked; the range only has to cover the same bytes in
+	// every process that locks it
+	ol := new(windows.Overlapped)
+	return windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, ol)
+}
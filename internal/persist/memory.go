@@ -0,0 +1,233 @@
+package persist
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// memStore is a Store that keeps all Documents in memory. Fully-qualified alt
+// keys have no special meaning to it, but are kept apart from normal keys.
+//
+// Like the Documents of fsSourceStore, Documents that can be written to are
+// locked from the time they are opened until they are closed, so only one at a
+// time can be open for writing with a given key.
+type memStore struct {
+	mu    sync.Mutex
+	docs  map[memKey][]byte
+	locks map[memKey]*sync.Mutex
+}
+
+type memKey struct {
+	key  string
+	fqak bool
+}
+
+type memDocument struct {
+	codecUserMixin
+
+	store  *memStore
+	id     memKey
+	lock   *sync.Mutex // only held if writable
+	closed bool
+	mode   DocumentMode
+	buf    docBuffer
+}
+
+// NewMemoryStore creates and returns a new Store that keeps Documents in
+// memory. It starts out empty, and nothing in it is kept after the program
+// exits.
+func NewMemoryStore() Store {
+	return &memStore{
+		docs:  make(map[memKey][]byte),
+		locks: make(map[memKey]*sync.Mutex),
+	}
+}
+
+func (ms *memStore) OpenDocument(key string, mode DocumentMode) (doc Document, err error) {
+	return ms.OpenDocumentAlt(key, "", mode)
+}
+
+func (ms *memStore) Open(key string) (doc Document, err error) {
+	return ms.OpenDocument(key, BasicOpenMode)
+}
+
+func (ms *memStore) Create(key string) (doc Document, err error) {
+	return ms.OpenDocument(key, BasicCreateMode)
+}
+
+func (ms *memStore) OpenAlt(key, fqAltKey string) (doc Document, err error) {
+	return ms.OpenDocumentAlt(key, fqAltKey, BasicOpenMode)
+}
+
+func (ms *memStore) CreateAlt(key, fqAltKey string) (doc Document, err error) {
+	return ms.OpenDocumentAlt(key, fqAltKey, BasicCreateMode)
+}
+
+func (ms *memStore) OpenDocumentAlt(key, fqAltKey string, mode DocumentMode) (doc Document, err error) {
+	mDoc := &memDocument{
+		store: ms,
+		id:    memKey{key: key},
+		mode:  mode,
+	}
+	if fqAltKey != "" {
+		mDoc.id = memKey{key: fqAltKey, fqak: true}
+	}
+	mDoc.codecUserMixin.doc = mDoc
+
+	if mode.AllowedOperations != ReadOnly {
+		ms.mu.Lock()
+		lock, ok := ms.locks[mDoc.id]
+		if !ok {
+			lock = &sync.Mutex{}
+			ms.locks[mDoc.id] = lock
+		}
+		ms.mu.Unlock()
+		lock.Lock()
+		mDoc.lock = lock
+	}
+
+	ms.mu.Lock()
+	data, exists := ms.docs[mDoc.id]
+	ms.mu.Unlock()
+
+	pathErr := func(err error) error {
+		mDoc.unlock()
+		return &os.PathError{Op: "open", Path: mDoc.id.key, Err: err}
+	}
+	if !exists {
+		if !mode.Create || mode.AllowedOperations == ReadOnly {
+			return nil, pathErr(os.ErrNotExist)
+		}
+		// the document is added to the store when it is flushed
+		mDoc.buf.dirty = true
+		return mDoc, nil
+	}
+	if mode.Create && mode.Exclusive {
+		return nil, pathErr(os.ErrExist)
+	}
+	if mode.Truncate && mode.AllowedOperations != ReadOnly {
+		mDoc.buf.dirty = len(data) > 0
+		return mDoc, nil
+	}
+	mDoc.buf.data = append([]byte(nil), data...)
+	return mDoc, nil
+}
+
+// Read reads bytes from the document.
+func (mDoc *memDocument) Read(b []byte) (n int, err error) {
+	if mDoc.closed {
+		return 0, fmt.Errorf("Document has been closed and cannot perform further operations")
+	}
+	if mDoc.mode.AllowedOperations == WriteOnly {
+		return 0, fmt.Errorf("Document opened in write-only mode and cannot perform reads")
+	}
+	return mDoc.buf.read(b)
+}
+
+// Write writes bytes to the document. In synchronous mode, they are stored
+// immediately.
+func (mDoc *memDocument) Write(b []byte) (n int, err error) {
+	if mDoc.closed {
+		return 0, fmt.Errorf("Document has been closed and cannot perform further operations")
+	}
+	if mDoc.mode.AllowedOperations == ReadOnly {
+		return 0, fmt.Errorf("Document opened in read-only mode and cannot perform writes")
+	}
+	n, err = mDoc.buf.write(b, mDoc.mode.Append)
+	if err == nil && mDoc.mode.Synchronous {
+		err = mDoc.Flush()
+	}
+	return n, err
+}
+
+// Seek moves the cursor position to the given offset. If opened in Append mode,
+// this will have no effect on future writes.
+func (mDoc *memDocument) Seek(offset int64, whence int) (n int64, err error) {
+	if mDoc.closed {
+		return 0, fmt.Errorf("Document has been closed and cannot perform further operations")
+	}
+	return mDoc.buf.seek(offset, whence)
+}
+
+// Truncate changes the size of the document. The cursor position is not
+// changed.
+func (mDoc *memDocument) Truncate(size int64) error {
+	if mDoc.closed {
+		return fmt.Errorf("Document has been closed and cannot perform further operations")
+	}
+	if mDoc.mode.AllowedOperations == ReadOnly {
+		return fmt.Errorf("Document opened in read-only mode and cannot perform writes")
+	}
+	err := mDoc.buf.truncate(size)
+	if err == nil && mDoc.mode.Synchronous {
+		err = mDoc.Flush()
+	}
+	return err
+}
+
+// Close stores everything written to the document and releases its lock. It
+// will not be able to be used after Close has been called, regardless of
+// whether error is non-nil.
+//
+// Every call to Close() after the first will have no effect and will return
+// a nil error.
+func (mDoc *memDocument) Close() error {
+	if mDoc.closed {
+		return nil
+	}
+	err := mDoc.finalizeCodecs()
+	if mDoc.mode.AllowedOperations != ReadOnly && err == nil {
+		err = mDoc.Flush()
+	}
+	mDoc.unlock()
+	mDoc.closed = true
+	return err
+}
+
+// Flush stores the contents of the document.
+func (mDoc *memDocument) Flush() error {
+	if mDoc.closed {
+		return fmt.Errorf("Document has been closed and cannot perform further operations")
+	}
+	if !mDoc.buf.dirty {
+		return nil
+	}
+	mDoc.store.mu.Lock()
+	mDoc.store.docs[mDoc.id] = append([]byte(nil), mDoc.buf.data...)
+	mDoc.store.mu.Unlock()
+	mDoc.buf.dirty = false
+	return nil
+}
+
+// Mode gets the DocumentMode that the memDocument was opened with.
+func (mDoc *memDocument) Mode() DocumentMode {
+	return mDoc.mode
+}
+
+// Key gets the key of the document. If it is a fully-qualified alternative
+// key, UsesAlternativeKey() returns true.
+func (mDoc *memDocument) Key() string {
+	return mDoc.id.key
+}
+
+// UsesAlternativeKey returns whether the key returned by Key() is a
+// fully-qualified alternative key.
+func (mDoc *memDocument) UsesAlternativeKey() bool {
+	return mDoc.id.fqak
+}
+
+// UseCodec adds the given codec to the end of the codec pipeline of the
+// document.
+func (mDoc *memDocument) UseCodec(c Codec) Document {
+	mDoc.addCodec(c)
+	return mDoc
+}
+
+// unlock releases the lock held by the document, if any.
+func (mDoc *memDocument) unlock() {
+	if mDoc.lock != nil {
+		mDoc.lock.Unlock()
+		mDoc.lock = nil
+	}
+}
@@ -0,0 +1,144 @@
+package persist
+
+import (
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_memStore_OpenDocument(t *testing.T) {
+	testCases := []struct {
+		name      string
+		existing  *string
+		mode      DocumentMode
+		write     string
+		expected  string
+		expectErr bool
+	}{
+		{
+			name:     "create new",
+			mode:     BasicCreateMode,
+			write:    "data",
+			expected: "data",
+		},
+		{
+			name:     "create truncates existing",
+			existing: strPtr("old contents"),
+			mode:     BasicCreateMode,
+			write:    "new",
+			expected: "new",
+		},
+		{
+			name:     "append",
+			existing: strPtr("old"),
+			mode:     DocumentMode{AllowedOperations: WriteOnly, Append: true},
+			write:    "new",
+			expected: "oldnew",
+		},
+		{
+			name:     "synchronous",
+			existing: strPtr("old contents"),
+			mode:     DocumentMode{AllowedOperations: ReadAndWrite, Synchronous: true},
+			write:    "new",
+			expected: "new contents",
+		},
+		{
+			name:      "open missing without create",
+			mode:      DocumentMode{AllowedOperations: ReadAndWrite},
+			expectErr: true,
+		},
+		{
+			name:      "exclusive create of existing",
+			existing:  strPtr("old"),
+			mode:      BasicCreateMode.WithExclusive(true),
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sut := NewMemoryStore()
+			if tc.existing != nil {
+				writeTestDoc(t, sut, "doc", *tc.existing)
+			}
+
+			doc, err := sut.OpenDocument("doc", tc.mode)
+
+			// check for error
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				doc.Close()
+				t.Fatalf("expected an error but nil error was returned")
+			}
+			if tc.expectErr {
+				return
+			}
+
+			if _, err := doc.Write([]byte(tc.write)); err != nil {
+				t.Fatalf("write failed: %v", err)
+			}
+			if err := doc.Close(); err != nil {
+				t.Fatalf("close failed: %v", err)
+			}
+
+			// check the value
+			if actual := readTestDoc(t, sut, "doc"); actual != tc.expected {
+				t.Errorf("expected document to contain %q but got: %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_memStore_Update_concurrent(t *testing.T) {
+	sut := NewMemoryStore()
+
+	const writers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := Update(sut, "doc", "", func(current []byte) ([]byte, error) {
+				return append(current, []byte("line\n")...), nil
+			})
+			if err != nil {
+				t.Errorf("update returned an error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if count := strings.Count(readTestDoc(t, sut, "doc"), "line\n"); count != writers {
+		t.Errorf("expected %d lines but got %d", writers, count)
+	}
+}
+
+// writeTestDoc replaces the contents of the Document with the given key.
+func writeTestDoc(t *testing.T, store Store, key, contents string) {
+	doc, err := store.Create(key)
+	if err != nil {
+		t.Fatalf("prep step: couldn't create %q: %v", key, err)
+	}
+	if _, err := doc.Write([]byte(contents)); err != nil {
+		t.Fatalf("prep step: couldn't write %q: %v", key, err)
+	}
+	if err := doc.Close(); err != nil {
+		t.Fatalf("prep step: couldn't close %q: %v", key, err)
+	}
+}
+
+// readTestDoc gives the contents of the Document with the given key.
+func readTestDoc(t *testing.T, store Store, key string) string {
+	doc, err := store.Open(key)
+	if err != nil {
+		t.Fatalf("couldn't open %q: %v", key, err)
+	}
+	defer doc.Close()
+	data, err := ioutil.ReadAll(doc)
+	if err != nil {
+		t.Fatalf("couldn't read %q: %v", key, err)
+	}
+	return string(data)
+}
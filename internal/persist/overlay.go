@@ -0,0 +1,161 @@
+package persist
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// overlayStore is a Store that layers one Store over another. Documents are
+// read from the top Store if they are in it and from the base Store otherwise,
+// and all writes go to the top Store; nothing is ever written to the base
+// Store. A Document that is only in the base Store is copied into the top
+// Store when it is opened for writing, unless it is being truncated.
+//
+// Once a Document has been copied into the top Store, that copy is always
+// used, so later changes to the Document in the base Store are not seen.
+// BaseIsNewer tells when that has happened.
+//
+// Keys and fully-qualified alt keys are given to both Stores unchanged.
+type overlayStore struct {
+	base Store
+	top  Store
+}
+
+// NewOverlayStore creates and returns a new Store that reads Documents from top
+// if they are there and from base otherwise, and that writes Documents only to
+// top. It is typically used to let a read-only Store, such as a directory
+// shared by several users, give the starting contents of Documents that are
+// then changed in a Store of a single user.
+func NewOverlayStore(base, top Store) Store {
+	return &overlayStore{base: base, top: top}
+}
+
+func (ov *overlayStore) OpenDocument(key string, mode DocumentMode) (doc Document, err error) {
+	return ov.OpenDocumentAlt(key, "", mode)
+}
+
+func (ov *overlayStore) Open(key string) (doc Document, err error) {
+	return ov.OpenDocument(key, BasicOpenMode)
+}
+
+func (ov *overlayStore) Create(key string) (doc Document, err error) {
+	return ov.OpenDocument(key, BasicCreateMode)
+}
+
+func (ov *overlayStore) OpenAlt(key, fqAltKey string) (doc Document, err error) {
+	return ov.OpenDocumentAlt(key, fqAltKey, BasicOpenMode)
+}
+
+func (ov *overlayStore) CreateAlt(key, fqAltKey string) (doc Document, err error) {
+	return ov.OpenDocumentAlt(key, fqAltKey, BasicCreateMode)
+}
+
+func (ov *overlayStore) OpenDocumentAlt(key, fqAltKey string, mode DocumentMode) (doc Document, err error) {
+	if mode.AllowedOperations == ReadOnly {
+		doc, err := ov.top.OpenDocumentAlt(key, fqAltKey, mode)
+		if err == nil || !os.IsNotExist(err) {
+			return doc, err
+		}
+		return ov.base.OpenDocumentAlt(key, fqAltKey, mode)
+	}
+
+	inTop, err := exists(ov.top, key, fqAltKey)
+	if err != nil {
+		return nil, err
+	}
+	if inTop {
+		return ov.top.OpenDocumentAlt(key, fqAltKey, mode)
+	}
+	baseData, err := readAll(ov.base, key, fqAltKey)
+	if os.IsNotExist(err) {
+		return ov.top.OpenDocumentAlt(key, fqAltKey, mode)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if mode.Create && mode.Exclusive {
+		path := key
+		if fqAltKey != "" {
+			path = fqAltKey
+		}
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrExist}
+	}
+	doc, err = ov.top.OpenDocumentAlt(key, fqAltKey, mode.WithCreate(true).WithExclusive(false))
+	if err != nil || mode.Truncate {
+		return doc, err
+	}
+
+	// the document could have been created in the top store since it was
+	// checked; only copy it from the base store if that did not happen
+	size, err := doc.Seek(0, io.SeekEnd)
+	if err == nil && size == 0 {
+		_, err = doc.Write(baseData)
+	}
+	if err == nil {
+		_, err = doc.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		doc.Close()
+		return nil, err
+	}
+	return doc, nil
+}
+
+// modTimer is implemented by Stores that can give when a Document was last
+// changed.
+type modTimer interface {
+	modTime(key, fqAltKey string) (time.Time, error)
+}
+
+// BaseIsNewer returns whether store is one created by NewOverlayStore, or is
+// layered over one, in which the Document with the given key has been copied
+// into the top Store and then changed in the base Store after the copy was
+// last written. If it cannot be told when either was changed, false is
+// returned.
+func BaseIsNewer(store Store, key, fqAltKey string) bool {
+	ov, ok := store.(*overlayStore)
+	if !ok {
+		return false
+	}
+	if BaseIsNewer(ov.base, key, fqAltKey) || BaseIsNewer(ov.top, key, fqAltKey) {
+		return true
+	}
+	top, topOK := ov.top.(modTimer)
+	base, baseOK := ov.base.(modTimer)
+	if !topOK || !baseOK {
+		return false
+	}
+	topTime, err := top.modTime(key, fqAltKey)
+	if err != nil {
+		return false
+	}
+	baseTime, err := base.modTime(key, fqAltKey)
+	if err != nil {
+		return false
+	}
+	return baseTime.After(topTime)
+}
+
+// exists returns whether the Document with the given key exists in the store.
+func exists(store Store, key, fqAltKey string) (bool, error) {
+	doc, err := store.OpenAlt(key, fqAltKey)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, doc.Close()
+}
+
+// readAll gives the entire contents of the Document with the given key.
+func readAll(store Store, key, fqAltKey string) ([]byte, error) {
+	doc, err := store.OpenAlt(key, fqAltKey)
+	if err != nil {
+		return nil, err
+	}
+	defer doc.Close()
+	return ioutil.ReadAll(doc)
+}
@@ -0,0 +1,180 @@
+package persist
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_overlayStore_OpenDocument(t *testing.T) {
+	testCases := []struct {
+		name         string
+		inBase       *string
+		inTop        *string
+		mode         DocumentMode
+		write        string
+		expectedRead string
+		expectedTop  *string
+		expectErr    bool
+	}{
+		{
+			name:         "read from top",
+			inBase:       strPtr("base"),
+			inTop:        strPtr("top"),
+			mode:         BasicOpenMode,
+			expectedRead: "top",
+			expectedTop:  strPtr("top"),
+		},
+		{
+			name:         "read falls through to base",
+			inBase:       strPtr("base"),
+			mode:         BasicOpenMode,
+			expectedRead: "base",
+		},
+		{
+			name:      "read missing from both",
+			mode:      BasicOpenMode,
+			expectErr: true,
+		},
+		{
+			name:         "write copies base into top",
+			inBase:       strPtr("base contents"),
+			mode:         DocumentMode{AllowedOperations: ReadAndWrite, Append: true},
+			write:        " and more",
+			expectedRead: "base contents",
+			expectedTop:  strPtr("base contents and more"),
+		},
+		{
+			name:        "create does not copy base",
+			inBase:      strPtr("base contents"),
+			mode:        BasicCreateMode,
+			write:       "new",
+			expectedTop: strPtr("new"),
+		},
+		{
+			name:        "write to top",
+			inBase:      strPtr("base"),
+			inTop:       strPtr("top"),
+			mode:        DocumentMode{AllowedOperations: WriteOnly, Append: true},
+			write:       " and more",
+			expectedTop: strPtr("top and more"),
+		},
+		{
+			name:      "exclusive create of document in base",
+			inBase:    strPtr("base"),
+			mode:      BasicCreateMode.WithExclusive(true),
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			base := NewMemoryStore()
+			top := NewMemoryStore()
+			if tc.inBase != nil {
+				writeTestDoc(t, base, "doc", *tc.inBase)
+			}
+			if tc.inTop != nil {
+				writeTestDoc(t, top, "doc", *tc.inTop)
+			}
+			sut := NewOverlayStore(base, top)
+
+			doc, err := sut.OpenDocument("doc", tc.mode)
+
+			// check for error
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				doc.Close()
+				t.Fatalf("expected an error but nil error was returned")
+			}
+			if tc.expectErr {
+				return
+			}
+
+			// check the value
+			if tc.mode.AllowedOperations != WriteOnly {
+				actual := make([]byte, 100)
+				n, _ := doc.Read(actual)
+				if string(actual[:n]) != tc.expectedRead {
+					t.Errorf("expected to read %q but got: %q", tc.expectedRead, string(actual[:n]))
+				}
+			}
+			if tc.write != "" {
+				if _, err := doc.Write([]byte(tc.write)); err != nil {
+					t.Fatalf("write failed: %v", err)
+				}
+			}
+			if err := doc.Close(); err != nil {
+				t.Fatalf("close failed: %v", err)
+			}
+
+			if tc.expectedTop == nil {
+				if _, err := top.Open("doc"); !os.IsNotExist(err) {
+					t.Errorf("expected document to not be in top store")
+				}
+			} else if actual := readTestDoc(t, top, "doc"); actual != *tc.expectedTop {
+				t.Errorf("expected top store to contain %q but got: %q", *tc.expectedTop, actual)
+			}
+			if tc.inBase != nil {
+				if actual := readTestDoc(t, base, "doc"); actual != *tc.inBase {
+					t.Errorf("expected base store to be unchanged but it contains: %q", actual)
+				}
+			}
+		})
+	}
+}
+
+func Test_BaseIsNewer(t *testing.T) {
+	testCases := []struct {
+		name      string
+		inTop     bool
+		baseDelta time.Duration // added to the modification time of the top copy
+		expected  bool
+	}{
+		{name: "not copied into top", expected: false},
+		{name: "base older than top", inTop: true, baseDelta: -time.Hour, expected: false},
+		{name: "base newer than top", inTop: true, baseDelta: time.Hour, expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			base, cleanupBase := newTestStore(t)
+			defer cleanupBase()
+			top, cleanupTop := newTestStore(t)
+			defer cleanupTop()
+			writeTestDoc(t, base, "doc", "base")
+			sut := NewOverlayStore(base, top)
+
+			if tc.inTop {
+				err := Update(sut, "doc", "", func(current []byte) ([]byte, error) {
+					return append(current, " and more"...), nil
+				})
+				if err != nil {
+					t.Fatalf("prep step: update failed: %v", err)
+				}
+				topTime, err := top.(*fsSourceStore).modTime("doc", "")
+				if err != nil {
+					t.Fatalf("prep step: couldn't get modification time: %v", err)
+				}
+				baseTime := topTime.Add(tc.baseDelta)
+				if err := os.Chtimes(filepath.Join(base.(*fsSourceStore).dir, "doc"), baseTime, baseTime); err != nil {
+					t.Fatalf("prep step: couldn't set modification time: %v", err)
+				}
+			}
+
+			actual := BaseIsNewer(sut, "doc", "")
+
+			// check the value
+			if actual != tc.expected {
+				t.Errorf("expected %v but got: %v", tc.expected, actual)
+			}
+
+			// layering another store over it is still seen through
+			if actual := BaseIsNewer(NewOverlayStore(sut, NewMemoryStore()), "doc", ""); actual != tc.expected {
+				t.Errorf("expected %v through another overlay but got: %v", tc.expected, actual)
+			}
+		})
+	}
+}
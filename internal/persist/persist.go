@@ -20,7 +20,11 @@
 // All IO itself is buffered unless Synchronous is passed to the OpenDocument function;
 // in that case all IO will be directly to the file. This is likely to be extremely slow.
 //
-// Concurrent usage is not currently safe.
+// Concurrent usage of a single Document is not currently safe. Documents that
+// are opened for writing are locked so that other Documents, including those in
+// other processes, cannot be opened for writing with the same key until the
+// first is closed; Update uses this to let several processes change the same
+// Document without losing each other's changes.
 //
 //
 //
@@ -30,6 +34,11 @@
 // (probs yagni but fuck it this is my house and my house shall be tidy)
 package persist
 
+import (
+	"io"
+	"io/ioutil"
+)
+
 // Store for all persistence documents. Each document has a key associated with it,
 // usually this is a path or a path-like string referring to the document. Note that
 // not all persistence is necessarily file-system based and depends on how the source
@@ -93,3 +102,64 @@ type Store interface {
 	// codec.
 	CreateAlt(key, fqAltKey string) (Document, error)
 }
+
+// Update replaces the contents of the Document at the given key with what
+// merge returns when given its current contents. The Document is created if it
+// does not already exist, in which case merge is given empty contents. If
+// fqAltKey is non-empty, it is used instead of key the same as in
+// OpenDocumentAlt.
+//
+// The Document stays open for writing from before it is read until after it is
+// written, so any other process that updates the same Document waits for this
+// one to finish and then merges its changes with the ones made here instead of
+// overwriting them.
+//
+// If merge returns a non-nil error, Update returns it and the contents of the
+// Document are not changed.
+//
+// If any codecs are given, they are used as the codec pipeline of the Document
+// and must all be ones that transform bytes, such as GzipCodec or AESGCMCodec;
+// merge is given and returns the transformed bytes.
+func Update(store Store, key, fqAltKey string, merge func(current []byte) ([]byte, error), codecs ...Codec) error {
+	doc, err := store.OpenDocumentAlt(key, fqAltKey, DocumentMode{AllowedOperations: ReadAndWrite, Create: true})
+	if err != nil {
+		return err
+	}
+	defer doc.Close()
+	for _, c := range codecs {
+		doc.UseCodec(c)
+	}
+
+	var current []byte
+	if len(codecs) > 0 {
+		err = doc.Decode(&current)
+		if err == io.EOF {
+			err = nil
+		}
+	} else {
+		current, err = ioutil.ReadAll(doc)
+	}
+	if err != nil {
+		return err
+	}
+	updated, err := merge(current)
+	if err != nil {
+		return err
+	}
+
+	if _, err := doc.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := doc.Truncate(0); err != nil {
+		return err
+	}
+	if len(codecs) > 0 {
+		err = doc.Encode(updated)
+	} else {
+		_, err = doc.Write(updated)
+	}
+	if err != nil {
+		return err
+	}
+	return doc.Close()
+}
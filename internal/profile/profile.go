@@ -0,0 +1,293 @@
+// Package profile reads the named profiles that bundle the options netkk is
+// started with, so that a profile can be given as `netkk @name` in place of
+// a long list of flags.
+//
+// Profiles are defined in YAML config files. Every setting in a profile has the
+// same name as the command-line flag that it stands in for:
+//
+//	profiles:
+//	  modbus:
+//	    description: Modbus gateway in the lab
+//	    remote: 10.0.4.20:502
+//	    timeout: 5
+//	    macrofile: modbus.yaml
+//	    macroset: gateway
+//	    format: dump
+//
+// Relative paths in a profile are relative to the directory that the config
+// file is in.
+package profile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Profile is a set of options that netkk can be started with. The zero value
+// of a setting means that the profile does not give it.
+type Profile struct {
+	// Description is shown along with the name of the profile when profiles
+	// are listed.
+	Description string `yaml:"description,omitempty"`
+
+	// connection settings
+
+	Protocol           string   `yaml:"protocol,omitempty"`
+	Remote             string   `yaml:"remote,omitempty"`
+	Listen             string   `yaml:"listen,omitempty"`
+	Timeout            int      `yaml:"timeout,omitempty"`
+	TLS                bool     `yaml:"tls,omitempty"`
+	InsecureSkipVerify bool     `yaml:"insecure-skip-verify,omitempty"`
+	TrustChain         string   `yaml:"trustchain,omitempty"`
+	ServerCert         string   `yaml:"server-cert,omitempty"`
+	ServerKey          string   `yaml:"server-key,omitempty"`
+	CertCommonName     string   `yaml:"cert-common-name,omitempty"`
+	CertIPs            []string `yaml:"cert-ips,omitempty"`
+	NoKeepalives       bool     `yaml:"no-keepalives,omitempty"`
+
+	// console settings
+
+	Macrofile string `yaml:"macrofile,omitempty"`
+	Multiline bool   `yaml:"multiline,omitempty"`
+	NoPrompt  bool   `yaml:"no-prompt,omitempty"`
+
+	// Macroset is the macroset that is made current when the profile is used.
+	// It has no command-line flag.
+	Macroset string `yaml:"macroset,omitempty"`
+
+	// Format is the format that bytes are displayed in when the profile is
+	// used; one of "hex", "text", or "dump", as given to the FORMAT command.
+	// It has no command-line flag.
+	Format string `yaml:"format,omitempty"`
+}
+
+// ApplyTo gives base with every setting that the Profile gives replacing the
+// one in base, except for those whose names are in keep. This is used to have
+// flags given on the command line take precedence over the Profile.
+func (p Profile) ApplyTo(base Profile, keep map[string]bool) Profile {
+	applyString := func(name string, dest *string, val string) {
+		if val != "" && !keep[name] {
+			*dest = val
+		}
+	}
+	applyBool := func(name string, dest *bool, val bool) {
+		if val && !keep[name] {
+			*dest = val
+		}
+	}
+
+	applyString("protocol", &base.Protocol, p.Protocol)
+	applyString("remote", &base.Remote, p.Remote)
+	applyString("listen", &base.Listen, p.Listen)
+	if p.Timeout != 0 && !keep["timeout"] {
+		base.Timeout = p.Timeout
+	}
+	applyBool("tls", &base.TLS, p.TLS)
+	applyBool("insecure-skip-verify", &base.InsecureSkipVerify, p.InsecureSkipVerify)
+	applyString("trustchain", &base.TrustChain, p.TrustChain)
+	applyString("server-cert", &base.ServerCert, p.ServerCert)
+	applyString("server-key", &base.ServerKey, p.ServerKey)
+	applyString("cert-common-name", &base.CertCommonName, p.CertCommonName)
+	if len(p.CertIPs) > 0 && !keep["cert-ips"] {
+		base.CertIPs = p.CertIPs
+	}
+	applyBool("no-keepalives", &base.NoKeepalives, p.NoKeepalives)
+	applyString("macrofile", &base.Macrofile, p.Macrofile)
+	applyBool("multiline", &base.Multiline, p.Multiline)
+	applyBool("no-prompt", &base.NoPrompt, p.NoPrompt)
+	applyString("macroset", &base.Macroset, p.Macroset)
+	applyString("format", &base.Format, p.Format)
+	base.Description = p.Description
+	return base
+}
+
+// Validate checks that every setting in the Profile is valid and that every
+// file it refers to exists.
+func (p Profile) Validate() error {
+	if p.Protocol != "" && p.Protocol != "tcp" && p.Protocol != "udp" {
+		return fmt.Errorf("protocol must be one of tcp or udp")
+	}
+	if p.Timeout < 0 {
+		return fmt.Errorf("timeout cannot be negative")
+	}
+	for _, ip := range p.CertIPs {
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("cert-ips: %q is not an IP address", ip)
+		}
+	}
+	if p.Format != "" && p.Format != "hex" && p.Format != "text" && p.Format != "dump" {
+		return fmt.Errorf("format must be one of hex, text, or dump")
+	}
+	files := []struct {
+		name string
+		path string
+	}{
+		{"trustchain", p.TrustChain},
+		{"server-cert", p.ServerCert},
+		{"server-key", p.ServerKey},
+		{"macrofile", p.Macrofile},
+	}
+	for _, f := range files {
+		if f.path == "" {
+			continue
+		}
+		info, err := os.Stat(f.path)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.name, err)
+		}
+		if info.IsDir() {
+			return fmt.Errorf("%s: %q is a directory", f.name, f.path)
+		}
+	}
+	return nil
+}
+
+// ParsedCertIPs gives the IP addresses in CertIPs. It must only be called on
+// a Profile that has been validated.
+func (p Profile) ParsedCertIPs() []net.IP {
+	var ips []net.IP
+	for _, ip := range p.CertIPs {
+		ips = append(ips, net.ParseIP(ip))
+	}
+	return ips
+}
+
+// Target gives a short description of what the Profile connects to, for use
+// when listing profiles.
+func (p Profile) Target() string {
+	var target string
+	if p.Remote != "" {
+		target = p.Remote
+	} else if p.Listen != "" {
+		target = "listen on " + p.Listen
+	}
+	if target != "" && p.Protocol != "" {
+		target = strings.ToUpper(p.Protocol) + " " + target
+	}
+	return target
+}
+
+// resolvePaths makes every relative path in the Profile relative to dir.
+func (p *Profile) resolvePaths(dir string) {
+	for _, path := range []*string{&p.TrustChain, &p.ServerCert, &p.ServerKey, &p.Macrofile} {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+}
+
+// Config is the contents of one or more config files.
+type Config struct {
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+// Parse reads a Config from the YAML in data. Settings that are not known
+// cause an error, so that misspelled settings are not silently ignored.
+func Parse(data []byte) (Config, error) {
+	var c Config
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		return Config{}, err
+	}
+	for name := range c.Profiles {
+		if name == "" || strings.ContainsAny(name, " \t@") {
+			return Config{}, fmt.Errorf("invalid profile name %q; names cannot be blank or contain spaces or @", name)
+		}
+	}
+	return c, nil
+}
+
+// Merge gives a Config with the profiles of both c and other. Profiles in other
+// replace those in c that have the same name.
+func (c Config) Merge(other Config) Config {
+	merged := Config{Profiles: map[string]Profile{}}
+	for name, p := range c.Profiles {
+		merged.Profiles[name] = p
+	}
+	for name, p := range other.Profiles {
+		merged.Profiles[name] = p
+	}
+	return merged
+}
+
+// Names gives the names of all profiles in the Config in sorted order.
+func (c Config) Names() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get gives the profile with the given name.
+func (c Config) Get(name string) (Profile, error) {
+	p, ok := c.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("no profile named %q", name)
+	}
+	return p, nil
+}
+
+// LoadFile reads the Config in the file at path. Relative paths in its
+// profiles are made relative to the directory that the file is in. If the
+// file does not exist, an empty Config is returned.
+func LoadFile(path string) (Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Config{}, nil
+		}
+		return Config{}, err
+	}
+	c, err := Parse(data)
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: %v", path, err)
+	}
+	dir := filepath.Dir(path)
+	for name, p := range c.Profiles {
+		p.resolvePaths(dir)
+		c.Profiles[name] = p
+	}
+	return c, nil
+}
+
+// LoadDefault reads the profiles of the current user. They are read from
+// "config" in sharedDir (if it is not empty), then ~/.netkk/config, then
+// .netkk/config in the current directory, with profiles in each replacing
+// those of the same name in the ones before it.
+func LoadDefault(sharedDir string) (Config, error) {
+	var paths []string
+	if sharedDir != "" {
+		paths = append(paths, filepath.Join(sharedDir, "config"))
+	}
+	if homedir, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(homedir, ".netkk", "config"))
+	}
+	if projectPath, err := filepath.Abs(filepath.Join(".netkk", "config")); err == nil {
+		paths = append(paths, projectPath)
+	}
+
+	var c Config
+	read := map[string]bool{}
+	for _, path := range paths {
+		// the current directory could be the home directory
+		if read[path] {
+			continue
+		}
+		read[path] = true
+
+		fileConfig, err := LoadFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+		c = c.Merge(fileConfig)
+	}
+	return c, nil
+}
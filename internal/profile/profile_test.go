@@ -0,0 +1,179 @@
+package profile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_Profile_ApplyTo(t *testing.T) {
+	testCases := []struct {
+		name     string
+		p        Profile
+		base     Profile
+		keep     map[string]bool
+		expected Profile
+	}{
+		{
+			name:     "empty profile leaves base",
+			p:        Profile{},
+			base:     Profile{Protocol: "tcp", Timeout: 30},
+			expected: Profile{Protocol: "tcp", Timeout: 30},
+		},
+		{
+			name:     "profile replaces base",
+			p:        Profile{Protocol: "udp", Remote: "10.0.0.1:502", TLS: true, Macroset: "gateway"},
+			base:     Profile{Protocol: "tcp", Timeout: 30},
+			expected: Profile{Protocol: "udp", Remote: "10.0.0.1:502", Timeout: 30, TLS: true, Macroset: "gateway"},
+		},
+		{
+			name:     "kept settings are not replaced",
+			p:        Profile{Protocol: "udp", Remote: "10.0.0.1:502", Timeout: 5},
+			base:     Profile{Protocol: "tcp", Remote: "localhost:6379", Timeout: 30},
+			keep:     map[string]bool{"remote": true, "timeout": true},
+			expected: Profile{Protocol: "udp", Remote: "localhost:6379", Timeout: 30},
+		},
+		{
+			name:     "cert ips replace all of base",
+			p:        Profile{CertIPs: []string{"10.0.0.1"}},
+			base:     Profile{CertIPs: []string{"127.0.0.1", "10.0.0.2"}},
+			expected: Profile{CertIPs: []string{"10.0.0.1"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := tc.p.ApplyTo(tc.base, tc.keep)
+
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("expected %+v but got: %+v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_Profile_Validate(t *testing.T) {
+	testCases := []struct {
+		name      string
+		p         Profile
+		expectErr bool
+	}{
+		{name: "empty", p: Profile{}},
+		{name: "valid", p: Profile{Protocol: "udp", Timeout: 5, CertIPs: []string{"10.0.0.1", "::1"}, Format: "dump"}},
+		{name: "bad protocol", p: Profile{Protocol: "sctp"}, expectErr: true},
+		{name: "negative timeout", p: Profile{Timeout: -1}, expectErr: true},
+		{name: "bad cert ip", p: Profile{CertIPs: []string{"localhost"}}, expectErr: true},
+		{name: "bad format", p: Profile{Format: "octal"}, expectErr: true},
+		{name: "missing file", p: Profile{Macrofile: "does-not-exist.yaml"}, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.p.Validate()
+
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				t.Fatalf("expected an error but nil error was returned")
+			}
+		})
+	}
+}
+
+func Test_Parse(t *testing.T) {
+	testCases := []struct {
+		name      string
+		input     string
+		expected  Config
+		expectErr bool
+	}{
+		{
+			name:     "empty",
+			input:    "",
+			expected: Config{},
+		},
+		{
+			name: "profiles",
+			input: "profiles:\n" +
+				"  redis:\n" +
+				"    remote: localhost:6379\n" +
+				"    format: text\n" +
+				"  modbus:\n" +
+				"    remote: 10.0.4.20:502\n" +
+				"    tls: true\n" +
+				"    cert-ips: [10.0.0.1]\n",
+			expected: Config{Profiles: map[string]Profile{
+				"redis":  {Remote: "localhost:6379", Format: "text"},
+				"modbus": {Remote: "10.0.4.20:502", TLS: true, CertIPs: []string{"10.0.0.1"}},
+			}},
+		},
+		{
+			name:      "unknown setting",
+			input:     "profiles:\n  redis:\n    remtoe: localhost:6379\n",
+			expectErr: true,
+		},
+		{
+			name:      "name with space",
+			input:     "profiles:\n  \"my redis\":\n    remote: localhost:6379\n",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := Parse([]byte(tc.input))
+
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				t.Fatalf("expected an error but nil error was returned")
+			}
+			if tc.expectErr {
+				return
+			}
+
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("expected %+v but got: %+v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_LoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netkk-profile")
+	if err != nil {
+		t.Fatalf("prep step: couldn't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config")
+	contents := "profiles:\n" +
+		"  relative:\n" +
+		"    macrofile: macros.yaml\n" +
+		"  absolute:\n" +
+		"    macrofile: /etc/netkk/macros.yaml\n"
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("prep step: couldn't write config: %v", err)
+	}
+
+	actual, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("returned an error: %v", err)
+	}
+	if expected := filepath.Join(dir, "macros.yaml"); actual.Profiles["relative"].Macrofile != expected {
+		t.Errorf("expected relative macrofile to be %q but got: %q", expected, actual.Profiles["relative"].Macrofile)
+	}
+	if expected := "/etc/netkk/macros.yaml"; actual.Profiles["absolute"].Macrofile != expected {
+		t.Errorf("expected absolute macrofile to be %q but got: %q", expected, actual.Profiles["absolute"].Macrofile)
+	}
+
+	missing, err := LoadFile(filepath.Join(dir, "does-not-exist"))
+	if err != nil {
+		t.Errorf("missing file returned an error: %v", err)
+	}
+	if len(missing.Profiles) != 0 {
+		t.Errorf("expected missing file to give no profiles but got: %v", missing.Profiles)
+	}
+}
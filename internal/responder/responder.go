@@ -0,0 +1,296 @@
+// Package responder automatically replies to received data that matches a set
+// of rules. It is used to have netkk act as a stand-in for a real service.
+package responder
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// replyKeywordRegex matches the keyword that separates the pattern of a rule
+// from its reply.
+var replyKeywordRegex = regexp.MustCompile(`(?i)(^|\s)REPLY(\s|$)`)
+
+// Rule is a single pattern to look for in received data and the reply to send
+// when it is found.
+type Rule struct {
+	// ID is the number that identifies the rule. It is assigned when the rule
+	// is added to a Responder.
+	ID int
+
+	// Pattern is the bytes that received data must contain for the rule to
+	// match. It is not used if Regex is set.
+	Pattern []byte
+
+	// Regex is the regular expression that received data must match for the
+	// rule to match.
+	Regex *regexp.Regexp
+
+	// Reply is the bytes that are sent when the rule matches.
+	Reply []byte
+
+	// Delay is how long to wait after a match before sending the reply.
+	Delay time.Duration
+
+	// Limit is the number of times the rule can match before it is removed.
+	// If it is 0, there is no limit.
+	Limit int
+
+	// Matched is the number of times the rule has matched.
+	Matched int
+
+	// Text is the definition of the rule as it was given.
+	Text string
+}
+
+// Matches returns whether the data matches the rule.
+func (r Rule) Matches(data []byte) bool {
+	if r.Regex != nil {
+		return r.Regex.Match(data)
+	}
+	return bytes.Contains(data, r.Pattern)
+}
+
+// Describe gives a one-line description of the rule.
+func (r Rule) Describe() string {
+	desc := fmt.Sprintf("%d: %s (matched %d", r.ID, r.Text, r.Matched)
+	if r.Limit > 0 {
+		desc += fmt.Sprintf(" of %d", r.Limit)
+	}
+	if r.Matched == 1 {
+		desc += " time)"
+	} else {
+		desc += " times)"
+	}
+	return desc
+}
+
+// Responder holds the rules that received data is checked against. It is safe
+// for concurrent use. The zero value is a Responder with no rules.
+type Responder struct {
+	mtx    sync.Mutex
+	rules  []*Rule
+	lastID int
+
+	send  func(Rule)
+	delay func() time.Duration
+
+	// replies for matches made before there was a send function
+	unsent []Rule
+
+	// replies that are waiting for their delay, along with the ID of the rule
+	// that each is for
+	pending map[*time.Timer]int
+}
+
+// SetSender sets the function that sends the reply of a rule that matched in
+// Respond. If delay is not nil, each reply waits for the amount of time it
+// gives in addition to the rule's own Delay. Replies for matches made before
+// SetSender was first called are sent now.
+func (resp *Responder) SetSender(send func(Rule), delay func() time.Duration) {
+	resp.mtx.Lock()
+	resp.send = send
+	resp.delay = delay
+	var now []Rule
+	for _, r := range resp.unsent {
+		if resp.schedule(r) {
+			now = append(now, r)
+		}
+	}
+	resp.unsent = nil
+	resp.mtx.Unlock()
+
+	for _, r := range now {
+		send(r)
+	}
+}
+
+// Add adds a rule to the Responder and returns the ID assigned to it. Rules
+// are checked in the order they were added.
+func (resp *Responder) Add(r Rule) int {
+	resp.mtx.Lock()
+	defer resp.mtx.Unlock()
+	resp.lastID++
+	r.ID = resp.lastID
+	r.Matched = 0
+	resp.rules = append(resp.rules, &r)
+	return r.ID
+}
+
+// Remove removes the rule with the given ID. Returns false if there is no
+// such rule.
+func (resp *Responder) Remove(id int) bool {
+	resp.mtx.Lock()
+	defer resp.mtx.Unlock()
+	for idx, r := range resp.rules {
+		if r.ID == id {
+			resp.rules = append(resp.rules[:idx], resp.rules[idx+1:]...)
+			resp.cancelPending(id)
+			return true
+		}
+	}
+	return false
+}
+
+// Clear removes all rules and returns the number that were removed.
+func (resp *Responder) Clear() int {
+	resp.mtx.Lock()
+	defer resp.mtx.Unlock()
+	count := len(resp.rules)
+	resp.rules = nil
+	resp.cancelPending(0)
+	return count
+}
+
+// Len returns the number of rules.
+func (resp *Responder) Len() int {
+	resp.mtx.Lock()
+	defer resp.mtx.Unlock()
+	return len(resp.rules)
+}
+
+// Rules gives a copy of every rule, in order of ID.
+func (resp *Responder) Rules() []Rule {
+	resp.mtx.Lock()
+	defer resp.mtx.Unlock()
+	var rules []Rule
+	for _, r := range resp.rules {
+		rules = append(rules, *r)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].ID < rules[j].ID
+	})
+	return rules
+}
+
+// Match finds the first rule that matches the data and counts the match
+// against it. If the rule has reached its limit, it is removed. Returns a
+// copy of the rule as it was after the match, or ok will be false if no rule
+// matched.
+func (resp *Responder) Match(data []byte) (r Rule, ok bool) {
+	resp.mtx.Lock()
+	defer resp.mtx.Unlock()
+	for idx, rule := range resp.rules {
+		if !rule.Matches(data) {
+			continue
+		}
+		rule.Matched++
+		if rule.Limit > 0 && rule.Matched >= rule.Limit {
+			resp.rules = append(resp.rules[:idx], resp.rules[idx+1:]...)
+		}
+		return *rule, true
+	}
+	return Rule{}, false
+}
+
+// Respond finds the first rule that matches the data the same way as Match
+// and sends its reply with the function given to SetSender, after the rule's
+// Delay. If SetSender has not been called yet, the reply is sent once it is.
+// Returns a copy of the rule as it was after the match, or ok will be false if
+// no rule matched.
+func (resp *Responder) Respond(data []byte) (r Rule, ok bool) {
+	r, ok = resp.Match(data)
+	if !ok {
+		return r, false
+	}
+
+	resp.mtx.Lock()
+	if resp.send == nil {
+		resp.unsent = append(resp.unsent, r)
+		resp.mtx.Unlock()
+		return r, true
+	}
+	send := resp.send
+	sendNow := resp.schedule(r)
+	resp.mtx.Unlock()
+
+	if sendNow {
+		send(r)
+	}
+	return r, true
+}
+
+// schedule starts the wait before the reply of r is sent. Returns true if
+// there is no wait, in which case the caller must send it. resp.mtx must be
+// held.
+func (resp *Responder) schedule(r Rule) (sendNow bool) {
+	d := r.Delay
+	if resp.delay != nil {
+		d += resp.delay()
+	}
+	if d <= 0 {
+		return true
+	}
+
+	send := resp.send
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		resp.mtx.Lock()
+		_, waiting := resp.pending[timer]
+		delete(resp.pending, timer)
+		resp.mtx.Unlock()
+		if waiting {
+			send(r)
+		}
+	})
+	if resp.pending == nil {
+		resp.pending = make(map[*time.Timer]int)
+	}
+	resp.pending[timer] = r.ID
+	return false
+}
+
+// CancelReplies stops every reply that has not been sent yet from being sent
+// and returns the number that were stopped.
+func (resp *Responder) CancelReplies() int {
+	resp.mtx.Lock()
+	defer resp.mtx.Unlock()
+	return resp.cancelPending(0)
+}
+
+// cancelPending stops the replies that have not been sent yet for the rule
+// with the given ID, or for every rule if id is 0. resp.mtx must be held.
+func (resp *Responder) cancelPending(id int) int {
+	count := 0
+	for timer, ruleID := range resp.pending {
+		if id == 0 || ruleID == id {
+			timer.Stop()
+			delete(resp.pending, timer)
+			count++
+		}
+	}
+	var kept []Rule
+	for _, r := range resp.unsent {
+		if id == 0 || r.ID == id {
+			count++
+		} else {
+			kept = append(kept, r)
+		}
+	}
+	resp.unsent = kept
+	return count
+}
+
+// SplitRule splits the text of a rule into the pattern and the reply, which
+// are separated by the keyword REPLY. The keyword is case-insensitive and
+// must be surrounded by whitespace.
+func SplitRule(text string) (pattern string, reply string, err error) {
+	loc := replyKeywordRegex.FindStringIndex(text)
+	if loc == nil {
+		return "", "", fmt.Errorf("rule must be of the form: pattern REPLY bytes")
+	}
+	pattern = strings.TrimSpace(text[:loc[0]])
+	reply = strings.TrimSpace(text[loc[1]:])
+	if pattern == "" {
+		return "", "", fmt.Errorf("need to give pattern before REPLY")
+	}
+	if reply == "" {
+		return "", "", fmt.Errorf("need to give bytes to reply with after REPLY")
+	}
+	return pattern, reply, nil
+}
@@ -0,0 +1,211 @@
+package responder
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Responder_Match(t *testing.T) {
+	testCases := []struct {
+		name        string
+		rules       []Rule
+		data        []string
+		expectedIDs []int
+	}{
+		{
+			name:        "no rules",
+			data:        []string{"PING"},
+			expectedIDs: []int{0},
+		},
+		{
+			name:        "contains pattern",
+			rules:       []Rule{{Pattern: []byte("PING")}},
+			data:        []string{"xxPINGxx", "PONG"},
+			expectedIDs: []int{1, 0},
+		},
+		{
+			name:        "first matching rule wins",
+			rules:       []Rule{{Pattern: []byte("A")}, {Pattern: []byte("AB")}},
+			data:        []string{"AB", "B"},
+			expectedIDs: []int{1, 0},
+		},
+		{
+			name:        "regex",
+			rules:       []Rule{{Regex: regexp.MustCompile(`^GET\s`)}},
+			data:        []string{"GET /", "xGET /"},
+			expectedIDs: []int{1, 0},
+		},
+		{
+			name:        "limit removes rule",
+			rules:       []Rule{{Pattern: []byte("A"), Limit: 2}, {Pattern: []byte("A")}},
+			data:        []string{"A", "A", "A"},
+			expectedIDs: []int{1, 1, 2},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sut := &Responder{}
+			for _, r := range tc.rules {
+				sut.Add(r)
+			}
+
+			for idx, d := range tc.data {
+				actual, ok := sut.Match([]byte(d))
+				if !ok {
+					actual.ID = 0
+				}
+				if actual.ID != tc.expectedIDs[idx] {
+					t.Errorf("data %q: expected rule %d to match but got rule %d", d, tc.expectedIDs[idx], actual.ID)
+				}
+			}
+		})
+	}
+}
+
+func Test_Responder_Respond(t *testing.T) {
+	delayed := func(pattern, reply string) Rule {
+		return Rule{Pattern: []byte(pattern), Reply: []byte(reply), Delay: 50 * time.Millisecond}
+	}
+
+	testCases := []struct {
+		name       string
+		rules      []Rule
+		data       []string
+		senderLate bool                  // SetSender is called after the data is received and then is run
+		then       func(resp *Responder) // run after the data is received
+		expected   []string
+	}{
+		{
+			name:     "reply right away",
+			rules:    []Rule{{Pattern: []byte("A"), Reply: []byte("x")}},
+			data:     []string{"A", "B", "A"},
+			expected: []string{"x", "x"},
+		},
+		{
+			name:     "delayed reply",
+			rules:    []Rule{delayed("A", "x")},
+			data:     []string{"A"},
+			expected: []string{"x"},
+		},
+		{
+			name:       "reply waits for sender",
+			rules:      []Rule{{Pattern: []byte("A"), Reply: []byte("x")}},
+			data:       []string{"A"},
+			senderLate: true,
+			expected:   []string{"x"},
+		},
+		{
+			name:  "removing rule cancels its delayed reply",
+			rules: []Rule{delayed("A", "x"), delayed("B", "y")},
+			data:  []string{"A", "B"},
+			then: func(resp *Responder) {
+				resp.Remove(2)
+			},
+			expected: []string{"x"},
+		},
+		{
+			name:  "clearing rules cancels delayed replies",
+			rules: []Rule{delayed("A", "x"), delayed("B", "y")},
+			data:  []string{"A", "B"},
+			then: func(resp *Responder) {
+				resp.Clear()
+			},
+		},
+		{
+			name:  "cancel delayed replies",
+			rules: []Rule{delayed("A", "x")},
+			data:  []string{"A"},
+			then: func(resp *Responder) {
+				resp.CancelReplies()
+			},
+		},
+		{
+			name:       "cancel replies waiting for sender",
+			rules:      []Rule{{Pattern: []byte("A"), Reply: []byte("x")}},
+			data:       []string{"A"},
+			senderLate: true,
+			then: func(resp *Responder) {
+				resp.CancelReplies()
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var mtx sync.Mutex
+			var actual []string
+			send := func(r Rule) {
+				mtx.Lock()
+				defer mtx.Unlock()
+				actual = append(actual, string(r.Reply))
+			}
+
+			sut := &Responder{}
+			for _, r := range tc.rules {
+				sut.Add(r)
+			}
+			if !tc.senderLate {
+				sut.SetSender(send, nil)
+			}
+
+			for _, d := range tc.data {
+				sut.Respond([]byte(d))
+			}
+			if tc.then != nil {
+				tc.then(sut)
+			}
+			if tc.senderLate {
+				sut.SetSender(send, nil)
+			}
+			time.Sleep(150 * time.Millisecond)
+
+			// check the value
+			mtx.Lock()
+			defer mtx.Unlock()
+			if strings.Join(actual, ",") != strings.Join(tc.expected, ",") {
+				t.Errorf("expected replies %q but got: %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_SplitRule(t *testing.T) {
+	testCases := []struct {
+		input           string
+		expectedPattern string
+		expectedReply   string
+		expectErr       bool
+	}{
+		{input: "PING REPLY PONG", expectedPattern: "PING", expectedReply: "PONG"},
+		{input: "a b reply c d", expectedPattern: "a b", expectedReply: "c d"},
+		{input: "REPLYING REPLY x", expectedPattern: "REPLYING", expectedReply: "x"},
+		{input: "PING PONG", expectErr: true},
+		{input: "REPLY PONG", expectErr: true},
+		{input: "PING REPLY", expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			pattern, reply, err := SplitRule(tc.input)
+
+			// check for error
+			if err != nil && !tc.expectErr {
+				t.Fatalf("returned an error: %v", err)
+			} else if err == nil && tc.expectErr {
+				t.Fatalf("expected an error but nil error was returned")
+			}
+
+			// check the value
+			if pattern != tc.expectedPattern {
+				t.Errorf("expected pattern %q but got: %q", tc.expectedPattern, pattern)
+			}
+			if reply != tc.expectedReply {
+				t.Errorf("expected reply %q but got: %q", tc.expectedReply, reply)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+// Package scrollback keeps a bounded in-memory record of the data that has been
+// sent and received over a connection so that it can be reviewed, searched, and
+// saved after it has scrolled off of the screen.
+//
+// A Buffer is bounded by the total number of data bytes it holds rather than by
+// the number of entries in it; once that bound is exceeded, the oldest entries
+// are evicted first. Every entry is given an index when it is added, and that
+// index never changes nor is it reused, so it can be used to refer to the same
+// entry for as long as it remains in the Buffer.
+package scrollback
+
+import (
+	"bytes"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// DefaultMaxBytes is the number of data bytes that a Buffer will hold if its
+// MaxBytes is not set.
+const DefaultMaxBytes = 4 * 1024 * 1024
+
+// Direction is which way the data in an Entry traveled.
+type Direction int
+
+const (
+	// Sent is data that was sent to the remote host.
+	Sent Direction = iota
+
+	// Received is data that was received from the remote host.
+	Received
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Sent:
+		return "SENT"
+	case Received:
+		return "RECV"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Entry is a single chunk of data that was sent or received.
+type Entry struct {
+	// Index is the sequence number of the Entry within its Buffer. The first
+	// Entry added to a Buffer has an Index of 1.
+	Index int
+
+	// Time is when the Entry was added to the Buffer.
+	Time time.Time
+
+	// Direction is whether the data was sent or received.
+	Direction Direction
+
+	// Peer is the name of the remote host that the data was sent to or
+	// received from. It may be empty if it was not known at the time.
+	Peer string
+
+	// Data is the bytes that were sent or received. It must not be modified.
+	Data []byte
+}
+
+// Match is a location within an Entry that matched a search.
+type Match struct {
+	Entry  Entry
+	Offset int
+	Length int
+}
+
+// Buffer holds entries of sent and received data. It is safe for concurrent
+// use.
+//
+// The zero value for Buffer is ready to be used.
+type Buffer struct {
+	// MaxBytes is the maximum number of data bytes that the Buffer holds before
+	// it begins to evict the oldest entries. If set to 0 or less, it falls back
+	// to DefaultMaxBytes. The most recent Entry is never evicted, even if it is
+	// larger than MaxBytes on its own.
+	MaxBytes int
+
+	mtx       sync.Mutex
+	entries   []Entry
+	size      int
+	lastIndex int
+	subs      []*Subscription
+}
+
+// Add records new data in the Buffer. The data is copied, so the caller is free
+// to modify the passed-in slice afterwards. The Entry that was created for the
+// data is returned.
+func (buf *Buffer) Add(dir Direction, peer string, data []byte) Entry {
+	buf.mtx.Lock()
+	defer buf.mtx.Unlock()
+
+	dataCopy := make([]byte, len(data))
+	copy(dataCopy, data)
+
+	buf.lastIndex++
+	e := Entry{
+		Index:     buf.lastIndex,
+		Time:      time.Now(),
+		Direction: dir,
+		Peer:      peer,
+		Data:      dataCopy,
+	}
+	buf.entries = append(buf.entries, e)
+	buf.size += len(dataCopy)
+	buf.evict()
+	for _, sub := range buf.subs {
+		sub.deliver(e)
+	}
+	return e
+}
+
+// evict removes entries from the front of the buffer until it is back under
+// its size limit. buf.mtx must be held by the caller.
+func (buf *Buffer) evict() {
+	max := buf.MaxBytes
+	if max <= 0 {
+		max = DefaultMaxBytes
+	}
+	evictCount := 0
+	for buf.size > max && len(buf.entries)-evictCount > 1 {
+		buf.size -= len(buf.entries[evictCount].Data)
+		evictCount++
+	}
+	if evictCount > 0 {
+		// copy to a new slice so the evicted entries can be garbage collected
+		buf.entries = append([]Entry(nil), buf.entries[evictCount:]...)
+	}
+}
+
+// Len returns the number of entries currently in the Buffer.
+func (buf *Buffer) Len() int {
+	buf.mtx.Lock()
+	defer buf.mtx.Unlock()
+	return len(buf.entries)
+}
+
+// Size returns the total number of data bytes currently held in the Buffer.
+func (buf *Buffer) Size() int {
+	buf.mtx.Lock()
+	defer buf.mtx.Unlock()
+	return buf.size
+}
+
+// Bounds returns the indexes of the oldest and the newest entries currently in
+// the Buffer. If the Buffer is empty, both will be 0.
+func (buf *Buffer) Bounds() (first int, last int) {
+	buf.mtx.Lock()
+	defer buf.mtx.Unlock()
+	if len(buf.entries) < 1 {
+		return 0, 0
+	}
+	return buf.entries[0].Index, buf.entries[len(buf.entries)-1].Index
+}
+
+// Range returns all entries whose index is between first and last, inclusive.
+// Indexes outside of the bounds of the Buffer are clamped to them.
+func (buf *Buffer) Range(first, last int) []Entry {
+	buf.mtx.Lock()
+	defer buf.mtx.Unlock()
+
+	var found []Entry
+	for _, e := range buf.entries {
+		if e.Index >= first && e.Index <= last {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// Last returns the n most recent entries in the Buffer.
+func (buf *Buffer) Last(n int) []Entry {
+	buf.mtx.Lock()
+	defer buf.mtx.Unlock()
+
+	if n > len(buf.entries) {
+		n = len(buf.entries)
+	}
+	if n < 1 {
+		return nil
+	}
+	return append([]Entry(nil), buf.entries[len(buf.entries)-n:]...)
+}
+
+// Clear removes all entries from the Buffer. Indexes of entries added after
+// the Clear continue on from the ones that were removed.
+func (buf *Buffer) Clear() {
+	buf.mtx.Lock()
+	defer buf.mtx.Unlock()
+	buf.entries = nil
+	buf.size = 0
+}
+
+// FindBytes searches every Entry in the given list for the given sequence of
+// bytes and returns the location of every non-overlapping instance of it.
+func FindBytes(entries []Entry, seq []byte) []Match {
+	if len(seq) < 1 {
+		return nil
+	}
+	var matches []Match
+	for _, e := range entries {
+		offset := 0
+		for {
+			idx := bytes.Index(e.Data[offset:], seq)
+			if idx < 0 {
+				break
+			}
+			matches = append(matches, Match{Entry: e, Offset: offset + idx, Length: len(seq)})
+			offset += idx + len(seq)
+		}
+	}
+	return matches
+}
+
+// FindRegexp searches every Entry in the given list for the given regular
+// expression and returns the location of every match.
+func FindRegexp(entries []Entry, re *regexp.Regexp) []Match {
+	var matches []Match
+	for _, e := range entries {
+		for _, loc := range re.FindAllIndex(e.Data, -1) {
+			matches = append(matches, Match{Entry: e, Offset: loc[0], Length: loc[1] - loc[0]})
+		}
+	}
+	return matches
+}
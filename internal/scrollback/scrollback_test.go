@@ -0,0 +1,97 @@
+package scrollback
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Buffer_Add_evictsOldest(t *testing.T) {
+	testCases := []struct {
+		name          string
+		maxBytes      int
+		chunks        []string
+		expectedFirst int
+		expectedLast  int
+	}{
+		{name: "no limit", maxBytes: 0, chunks: []string{"aaaa", "bbbb", "cccc"}, expectedFirst: 1, expectedLast: 3},
+		{name: "under limit", maxBytes: 100, chunks: []string{"aaaa", "bbbb", "cccc"}, expectedFirst: 1, expectedLast: 3},
+		{name: "exactly at limit", maxBytes: 8, chunks: []string{"aaaa", "bbbb"}, expectedFirst: 1, expectedLast: 2},
+		{name: "over limit drops oldest", maxBytes: 8, chunks: []string{"aaaa", "bbbb", "cccc"}, expectedFirst: 2, expectedLast: 3},
+		{name: "single chunk over limit is kept", maxBytes: 2, chunks: []string{"aaaa", "bbbb"}, expectedFirst: 2, expectedLast: 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := &Buffer{MaxBytes: tc.maxBytes}
+			for _, c := range tc.chunks {
+				buf.Add(Sent, "", []byte(c))
+			}
+
+			first, last := buf.Bounds()
+
+			if first != tc.expectedFirst || last != tc.expectedLast {
+				t.Fatalf("expected bounds (%d, %d) but got (%d, %d)", tc.expectedFirst, tc.expectedLast, first, last)
+			}
+		})
+	}
+}
+
+func Test_FindBytes(t *testing.T) {
+	testCases := []struct {
+		name            string
+		chunks          []string
+		seq             string
+		expectedOffsets []int
+	}{
+		{name: "no match", chunks: []string{"hello"}, seq: "xyz", expectedOffsets: nil},
+		{name: "single match", chunks: []string{"hello"}, seq: "ll", expectedOffsets: []int{2}},
+		{name: "matches across entries", chunks: []string{"hello", "yellow"}, seq: "ll", expectedOffsets: []int{2, 2}},
+		{name: "non-overlapping matches", chunks: []string{"aaaa"}, seq: "aa", expectedOffsets: []int{0, 2}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := &Buffer{}
+			for _, c := range tc.chunks {
+				buf.Add(Received, "", []byte(c))
+			}
+
+			actual := FindBytes(buf.Range(0, buf.Len()), []byte(tc.seq))
+
+			if len(actual) != len(tc.expectedOffsets) {
+				t.Fatalf("expected %d matches but got %d", len(tc.expectedOffsets), len(actual))
+			}
+			for idx := range actual {
+				if actual[idx].Offset != tc.expectedOffsets[idx] || actual[idx].Length != len(tc.seq) {
+					t.Fatalf("match %d: expected offset %d, length %d but got offset %d, length %d", idx, tc.expectedOffsets[idx], len(tc.seq), actual[idx].Offset, actual[idx].Length)
+				}
+			}
+		})
+	}
+}
+
+func Test_Subscription_Next(t *testing.T) {
+	buf := &Buffer{}
+	buf.Add(Received, "", []byte("before"))
+
+	sub := buf.Subscribe(Received)
+	defer sub.Close()
+
+	buf.Add(Sent, "", []byte("sent"))
+	buf.Add(Received, "", []byte("first"))
+	buf.Add(Received, "", []byte("second"))
+
+	for _, expected := range []string{"first", "second"} {
+		e, ok := sub.Next(time.Second)
+		if !ok {
+			t.Fatalf("expected entry %q but Next timed out", expected)
+		}
+		if string(e.Data) != expected {
+			t.Fatalf("expected entry %q but got %q", expected, string(e.Data))
+		}
+	}
+
+	if e, ok := sub.Next(10 * time.Millisecond); ok {
+		t.Fatalf("expected Next to time out but got entry %q", string(e.Data))
+	}
+}
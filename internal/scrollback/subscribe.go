@@ -0,0 +1,121 @@
+package scrollback
+
+import (
+	"sync"
+	"time"
+)
+
+// Subscription receives every Entry that is added to a Buffer after the
+// Subscription was created, regardless of whether the Entry has since been
+// evicted from the Buffer. Entries are queued until they are retrieved with
+// Next, so a Subscription that is no longer needed must be closed with Close.
+type Subscription struct {
+	buf    *Buffer
+	dirs   []Direction
+	mtx    sync.Mutex
+	queue  []Entry
+	notify chan struct{}
+	closed bool
+}
+
+// Subscribe creates a new Subscription to the Buffer. If any directions are
+// given, only entries in one of those directions are delivered to it.
+func (buf *Buffer) Subscribe(dirs ...Direction) *Subscription {
+	sub := &Subscription{
+		buf:    buf,
+		dirs:   dirs,
+		notify: make(chan struct{}, 1),
+	}
+
+	buf.mtx.Lock()
+	defer buf.mtx.Unlock()
+	buf.subs = append(buf.subs, sub)
+	return sub
+}
+
+// Next returns the next Entry delivered to the Subscription. If there are none
+// waiting, it blocks until one arrives or until timeout has elapsed, whichever
+// comes first; a timeout of 0 or less waits forever. ok will be false if the
+// timeout elapsed or the Subscription was closed before an Entry arrived.
+func (sub *Subscription) Next(timeout time.Duration) (e Entry, ok bool) {
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	for {
+		sub.mtx.Lock()
+		if len(sub.queue) > 0 {
+			e = sub.queue[0]
+			sub.queue = sub.queue[1:]
+			sub.mtx.Unlock()
+			return e, true
+		}
+		if sub.closed {
+			sub.mtx.Unlock()
+			return Entry{}, false
+		}
+		sub.mtx.Unlock()
+
+		select {
+		case <-sub.notify:
+		case <-timeoutCh:
+			return Entry{}, false
+		}
+	}
+}
+
+// Close stops delivery of entries to the Subscription and discards any that
+// have not yet been retrieved. Any call to Next that is blocked waiting for an
+// Entry returns immediately.
+func (sub *Subscription) Close() {
+	sub.buf.mtx.Lock()
+	for idx := range sub.buf.subs {
+		if sub.buf.subs[idx] == sub {
+			sub.buf.subs = append(sub.buf.subs[:idx], sub.buf.subs[idx+1:]...)
+			break
+		}
+	}
+	sub.buf.mtx.Unlock()
+
+	sub.mtx.Lock()
+	sub.closed = true
+	sub.queue = nil
+	sub.mtx.Unlock()
+	sub.wake()
+}
+
+// deliver queues the Entry for the Subscription if it is in one of the
+// directions the Subscription is for.
+func (sub *Subscription) deliver(e Entry) {
+	if len(sub.dirs) > 0 {
+		wanted := false
+		for _, d := range sub.dirs {
+			if d == e.Direction {
+				wanted = true
+				break
+			}
+		}
+		if !wanted {
+			return
+		}
+	}
+
+	sub.mtx.Lock()
+	if sub.closed {
+		sub.mtx.Unlock()
+		return
+	}
+	sub.queue = append(sub.queue, e)
+	sub.mtx.Unlock()
+	sub.wake()
+}
+
+func (sub *Subscription) wake() {
+	select {
+	case sub.notify <- struct{}{}:
+	default:
+	}
+}
@@ -161,6 +161,16 @@ func (sstack *StringStack) PeekFrontIfOk() (s string, ok bool) {
 	return sstack.PeekFront(), true
 }
 
+// Items returns every item in the stack, starting from the bottom. Items are
+// returned in the same form that Pop would return them in.
+func (sstack StringStack) Items() []string {
+	items := make([]string, sstack.Len())
+	for idx := range items {
+		items[idx] = sstack.getDenormIfDefined(idx)
+	}
+	return items
+}
+
 func (sstack StringStack) normalizeIfDefined(s string) string {
 	if sstack.Normalize != nil {
 		return sstack.Normalize(s)
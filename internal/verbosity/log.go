@@ -0,0 +1,122 @@
+package verbosity
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogFormat is how the entries in a log are written.
+type LogFormat int
+
+const (
+	// LogText writes each entry as a line of text giving its time, level,
+	// subsystem, and message.
+	LogText LogFormat = iota
+
+	// LogJSON writes each entry as a JSON object on its own line with the
+	// fields "time", "level", "subsystem" (omitted if there is none), and
+	// "message".
+	LogJSON
+)
+
+// ParseLogFormat gives the LogFormat with the given name, which is either
+// "text" or "json".
+func ParseLogFormat(name string) (LogFormat, error) {
+	switch strings.ToLower(name) {
+	case "text":
+		return LogText, nil
+	case "json":
+		return LogJSON, nil
+	default:
+		return LogText, fmt.Errorf("unknown log format %q", name)
+	}
+}
+
+// ParseLevel gives the predefined Level with the given name. Case is ignored.
+func ParseLevel(name string) (Level, error) {
+	for _, lv := range []Level{Trace, Debug, Info, Warn, Critical, Error} {
+		if strings.EqualFold(name, lv.name) {
+			return lv, nil
+		}
+	}
+	return Level{}, fmt.Errorf("unknown level %q", name)
+}
+
+// logTimeFormat is the format of the time of each log entry.
+const logTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// Logger writes timestamped entries to a log. Entries below its minimum level
+// are discarded; this is independent of the Verbosity of any OutputWriter
+// that logs to it. It is safe to use from multiple goroutines. A nil *Logger
+// discards all entries.
+type Logger struct {
+	mtx    sync.Mutex
+	w      io.Writer
+	level  Level
+	format LogFormat
+
+	// now gives the time of an entry; it is replaced in tests.
+	now func() time.Time
+}
+
+// NewLogger creates a Logger that writes the entries at or above level to w in
+// the given format.
+func NewLogger(w io.Writer, level Level, format LogFormat) *Logger {
+	return &Logger{w: w, level: level, format: format, now: time.Now}
+}
+
+// Log writes an entry for a message from the given subsystem. A trailing
+// newline in the message is not included.
+func (lg *Logger) Log(sub Subsystem, lv Level, message string) {
+	if lg == nil || lv.priority < lg.level.priority {
+		return
+	}
+	message = strings.TrimRight(message, "\n")
+
+	lg.mtx.Lock()
+	defer lg.mtx.Unlock()
+
+	t := lg.now()
+	var entry []byte
+	if lg.format == LogJSON {
+		var err error
+		entry, err = json.Marshal(struct {
+			Time      string    `json:"time"`
+			Level     string    `json:"level"`
+			Subsystem Subsystem `json:"subsystem,omitempty"`
+			Message   string    `json:"message"`
+		}{t.Format(logTimeFormat), lv.name, sub, message})
+		if err != nil {
+			// only possible with a message that is not valid UTF-8, which
+			// Marshal replaces anyways
+			return
+		}
+		entry = append(entry, '\n')
+	} else {
+		tag := ""
+		if sub != "" {
+			tag = "[" + string(sub) + "] "
+		}
+		entry = []byte(fmt.Sprintf("%s %-8s %s%s\n", t.Format(logTimeFormat), lv.name, tag, message))
+	}
+
+	// there is nothing that could be done about a failed write
+	lg.w.Write(entry)
+}
+
+// Close closes the writer of the Logger if it is an io.Closer.
+func (lg *Logger) Close() error {
+	if lg == nil {
+		return nil
+	}
+	lg.mtx.Lock()
+	defer lg.mtx.Unlock()
+	if c, ok := lg.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
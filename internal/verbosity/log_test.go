@@ -0,0 +1,254 @@
+package verbosity
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func Test_Logger_Log(t *testing.T) {
+	testCases := []struct {
+		name     string
+		level    Level
+		format   LogFormat
+		sub      Subsystem
+		lv       Level
+		message  string
+		expected string
+	}{
+		{
+			name:     "text",
+			level:    Trace,
+			format:   LogText,
+			sub:      SubsystemDriver,
+			lv:       Debug,
+			message:  "sent 4 bytes\n",
+			expected: "2020-01-02T03:04:05.006Z DEBUG    [driver] sent 4 bytes\n",
+		},
+		{
+			name:     "text without subsystem",
+			level:    Trace,
+			format:   LogText,
+			lv:       Error,
+			message:  "connection refused",
+			expected: "2020-01-02T03:04:05.006Z ERROR    connection refused\n",
+		},
+		{
+			name:     "json",
+			level:    Trace,
+			format:   LogJSON,
+			sub:      SubsystemPersist,
+			lv:       Warn,
+			message:  "couldn't write \"history\"\n",
+			expected: `{"time":"2020-01-02T03:04:05.006Z","level":"WARN","subsystem":"persist","message":"couldn't write \"history\""}` + "\n",
+		},
+		{
+			name:     "json without subsystem",
+			level:    Trace,
+			format:   LogJSON,
+			lv:       Info,
+			message:  "connecting",
+			expected: `{"time":"2020-01-02T03:04:05.006Z","level":"INFO","message":"connecting"}` + "\n",
+		},
+		{
+			name:     "below minimum level",
+			level:    Info,
+			format:   LogText,
+			sub:      SubsystemConsole,
+			lv:       Debug,
+			message:  "ignoring empty input",
+			expected: "",
+		},
+		{
+			name:     "same priority as minimum level",
+			level:    Info,
+			format:   LogText,
+			sub:      SubsystemMacros,
+			lv:       Warn,
+			message:  "no such macroset",
+			expected: "2020-01-02T03:04:05.006Z WARN     [macros] no such macroset\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			sut := NewLogger(&buf, tc.level, tc.format)
+			sut.now = func() time.Time {
+				return time.Date(2020, 1, 2, 3, 4, 5, 6000000, time.UTC)
+			}
+
+			sut.Log(tc.sub, tc.lv, tc.message)
+
+			if actual := buf.String(); actual != tc.expected {
+				t.Errorf("expected %q but got: %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_RotatingFile(t *testing.T) {
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := []struct {
+		name          string
+		opts          RotationOptions
+		writes        []string
+		step          time.Duration
+		expectCurrent string
+		expectOld     []string
+	}{
+		{
+			name:          "no rotation",
+			writes:        []string{"aaaa", "bbbb", "cccc"},
+			step:          time.Hour,
+			expectCurrent: "aaaabbbbcccc",
+		},
+		{
+			name:          "by size",
+			opts:          RotationOptions{MaxSize: 8},
+			writes:        []string{"aaaa", "bbbb", "cccc", "dd"},
+			step:          time.Second,
+			expectCurrent: "ccccdd",
+			expectOld:     []string{"aaaabbbb"},
+		},
+		{
+			name:          "write larger than max size",
+			opts:          RotationOptions{MaxSize: 4},
+			writes:        []string{"aa", "bbbbbbbb", "cc"},
+			step:          time.Second,
+			expectCurrent: "cc",
+			expectOld:     []string{"aa", "bbbbbbbb"},
+		},
+		{
+			name:          "by time",
+			opts:          RotationOptions{Every: time.Hour},
+			writes:        []string{"aaaa", "bbbb", "cccc"},
+			step:          40 * time.Minute,
+			expectCurrent: "cccc",
+			expectOld:     []string{"aaaabbbb"},
+		},
+		{
+			name:          "keeps only newest",
+			opts:          RotationOptions{MaxSize: 4, Keep: 2},
+			writes:        []string{"aaaa", "bbbb", "cccc", "dddd"},
+			step:          time.Second,
+			expectCurrent: "dddd",
+			expectOld:     []string{"bbbb", "cccc"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "verbosity-test")
+			if err != nil {
+				t.Fatalf("prep step: couldn't create temp dir: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "netkk.log")
+
+			now := start
+			sut, err := OpenRotatingFile(path, tc.opts)
+			if err != nil {
+				t.Fatalf("unexpected error opening file: %v", err)
+			}
+			sut.now = func() time.Time { return now }
+
+			for _, w := range tc.writes {
+				if _, err := sut.Write([]byte(w)); err != nil {
+					t.Fatalf("unexpected error writing: %v", err)
+				}
+				now = now.Add(tc.step)
+			}
+			if err := sut.Close(); err != nil {
+				t.Fatalf("unexpected error closing: %v", err)
+			}
+
+			// check the files
+			current, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatalf("couldn't read current file: %v", err)
+			}
+			if string(current) != tc.expectCurrent {
+				t.Errorf("expected current file to contain %q but got: %q", tc.expectCurrent, string(current))
+			}
+			oldPaths, _ := filepath.Glob(path + ".*")
+			sort.Strings(oldPaths)
+			var old []string
+			for _, p := range oldPaths {
+				data, err := ioutil.ReadFile(p)
+				if err != nil {
+					t.Fatalf("couldn't read rotated file: %v", err)
+				}
+				old = append(old, string(data))
+			}
+			if len(old) != len(tc.expectOld) {
+				t.Fatalf("expected rotated files %q but got: %q", tc.expectOld, old)
+			}
+			for idx := range old {
+				if old[idx] != tc.expectOld[idx] {
+					t.Errorf("expected rotated files %q but got: %q", tc.expectOld, old)
+					break
+				}
+			}
+		})
+	}
+}
+
+func Test_RotatingFile_rotateFails(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verbosity-test")
+	if err != nil {
+		t.Fatalf("prep step: couldn't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "netkk.log")
+
+	// a file cannot be renamed to a directory that is not empty
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	blocked := path + "." + now.Format(rotatedSuffixFormat)
+	if err := os.MkdirAll(filepath.Join(blocked, "sub"), 0755); err != nil {
+		t.Fatalf("prep step: couldn't create directory: %v", err)
+	}
+
+	sut, err := OpenRotatingFile(path, RotationOptions{MaxSize: 4})
+	if err != nil {
+		t.Fatalf("unexpected error opening file: %v", err)
+	}
+	defer sut.Close()
+	sut.now = func() time.Time { return now }
+
+	if _, err := sut.Write([]byte("aaaa")); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	n, err := sut.Write([]byte("bbbb"))
+	if err == nil {
+		t.Errorf("expected an error when the file could not be rotated but nil error was returned")
+	}
+	if n != 4 {
+		t.Errorf("expected write to still be made but got %d bytes written", n)
+	}
+
+	// rotating is tried again on the next write
+	now = now.Add(time.Second)
+	if _, err := sut.Write([]byte("cccc")); err != nil {
+		t.Fatalf("unexpected error writing after rotation failed: %v", err)
+	}
+	current, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("couldn't read current file: %v", err)
+	}
+	if string(current) != "cccc" {
+		t.Errorf("expected current file to contain %q but got: %q", "cccc", string(current))
+	}
+	rotated, err := ioutil.ReadFile(path + "." + now.Format(rotatedSuffixFormat))
+	if err != nil {
+		t.Fatalf("couldn't read rotated file: %v", err)
+	}
+	if string(rotated) != "aaaabbbb" {
+		t.Errorf("expected rotated file to contain %q but got: %q", "aaaabbbb", string(rotated))
+	}
+}
@@ -0,0 +1,192 @@
+package verbosity
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rotatedSuffixFormat is the format of the time added to the name of a log
+// file when it is rotated.
+const rotatedSuffixFormat = "20060102T150405.000000000"
+
+// RotationOptions gives when a RotatingFile is rotated and how many of the
+// rotated files are kept.
+type RotationOptions struct {
+	// MaxSize is the size in bytes that the file is rotated at. If zero, the
+	// file is not rotated because of its size.
+	MaxSize int64
+
+	// Every is how often the file is rotated. Periods start at multiples of it
+	// since the zero time in UTC, so for instance 24h rotates the file at
+	// midnight UTC. A file that was last written to in an earlier period is
+	// rotated when it is first written to. If zero, the file is not rotated
+	// because of time.
+	Every time.Duration
+
+	// Keep is the number of rotated files to keep; the oldest are removed
+	// first. If zero, all rotated files are kept.
+	Keep int
+}
+
+// RotatingFile is an io.WriteCloser that appends to a file and, based on its
+// RotationOptions, moves it aside to start a new one. A rotated file is named
+// after the original with the time it was rotated added on, such as
+// "netkk.log.20200102T030405.000000000". It is safe to use from multiple
+// goroutines.
+type RotatingFile struct {
+	mtx    sync.Mutex
+	path   string
+	opts   RotationOptions
+	file   *os.File
+	size   int64
+	period time.Time
+
+	// now gives the current time; it is replaced in tests.
+	now func() time.Time
+}
+
+// OpenRotatingFile opens the file at path for appending, creating it if it
+// does not already exist.
+func OpenRotatingFile(path string, opts RotationOptions) (*RotatingFile, error) {
+	if opts.MaxSize < 0 || opts.Every < 0 || opts.Keep < 0 {
+		return nil, fmt.Errorf("rotation options cannot be negative")
+	}
+	rf := &RotatingFile{path: path, opts: opts, now: time.Now}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// Write appends p to the file, first rotating it if needed. An entry is never
+// split across files, so a single write larger than MaxSize is written to a
+// file by itself. If the file cannot be rotated, p is still written to it and
+// the error is returned; rotating is tried again on the next write.
+func (rf *RotatingFile) Write(p []byte) (n int, err error) {
+	rf.mtx.Lock()
+	defer rf.mtx.Unlock()
+
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+	var rotateErr error
+	if rf.shouldRotate(len(p)) {
+		rotateErr = rf.rotate()
+		if rf.file == nil {
+			return 0, rotateErr
+		}
+	}
+	if rf.size < 1 {
+		rf.period = rf.periodOf(rf.now())
+	}
+	n, err = rf.file.Write(p)
+	rf.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+// Close closes the file.
+func (rf *RotatingFile) Close() error {
+	rf.mtx.Lock()
+	defer rf.mtx.Unlock()
+
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.file = f
+	rf.size = info.Size()
+
+	// the period of an empty file is that of the first write to it
+	rf.period = rf.periodOf(info.ModTime())
+	return nil
+}
+
+func (rf *RotatingFile) periodOf(t time.Time) time.Time {
+	if rf.opts.Every == 0 {
+		return time.Time{}
+	}
+	return t.UTC().Truncate(rf.opts.Every)
+}
+
+func (rf *RotatingFile) shouldRotate(writeLen int) bool {
+	if rf.size < 1 {
+		return false
+	}
+	if rf.opts.MaxSize > 0 && rf.size+int64(writeLen) > rf.opts.MaxSize {
+		return true
+	}
+	return rf.opts.Every > 0 && !rf.periodOf(rf.now()).Equal(rf.period)
+}
+
+// rotate moves the file aside and opens a new one in its place. If it cannot be
+// moved, the file is opened again so that it can still be written to. rf.file
+// is only nil afterwards if neither file could be opened.
+func (rf *RotatingFile) rotate() error {
+	err := rf.file.Close()
+	if err == nil {
+		rotatedPath := rf.path + "." + rf.now().UTC().Format(rotatedSuffixFormat)
+		err = os.Rename(rf.path, rotatedPath)
+	}
+	if err != nil {
+		if reopenErr := rf.open(); reopenErr != nil {
+			rf.file = nil
+			return fmt.Errorf("couldn't rotate log file: %v; couldn't reopen it: %v", err, reopenErr)
+		}
+		return fmt.Errorf("couldn't rotate log file: %v", err)
+	}
+	if err := rf.open(); err != nil {
+		rf.file = nil
+		return err
+	}
+	return rf.removeOld()
+}
+
+// removeOld removes the oldest rotated files so that no more than Keep
+// remain.
+func (rf *RotatingFile) removeOld() error {
+	if rf.opts.Keep < 1 {
+		return nil
+	}
+	rotated, err := filepath.Glob(rf.path + ".*")
+	if err != nil {
+		return err
+	}
+	var ours []string
+	for _, p := range rotated {
+		suffix := p[len(rf.path)+1:]
+		if _, err := time.Parse(rotatedSuffixFormat, suffix); err == nil {
+			ours = append(ours, p)
+		}
+	}
+
+	// the suffix format sorts the same as the times do
+	sort.Strings(ours)
+	for len(ours) > rf.opts.Keep {
+		if err := os.Remove(ours[0]); err != nil {
+			return fmt.Errorf("couldn't remove old log file: %v", err)
+		}
+		ours = ours[1:]
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package verbosity
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Subsystem is the part of a program that a message comes from. It is
+// included in every log entry so that entries from one part can be picked out
+// from the others, and each one can be given its own Verbosity with a
+// SubsystemVerbosity.
+type Subsystem string
+
+// Subsystems of netkk. A message that is not from any of these has the
+// zero-value Subsystem.
+const (
+	SubsystemDriver  Subsystem = "driver"
+	SubsystemConsole Subsystem = "console"
+	SubsystemMacros  Subsystem = "macros"
+	SubsystemPersist Subsystem = "persist"
+)
+
+// Subsystems is every predefined Subsystem, in alphabetical order.
+var Subsystems = []Subsystem{SubsystemConsole, SubsystemDriver, SubsystemMacros, SubsystemPersist}
+
+// verbosityNames are the names of the Verbosities that can be given by name,
+// with each Verbosity's own name first. Each is named after the lowest Level
+// it allows. Warn and Critical have the same priorities as Info and Error, so
+// their names give the same Verbosities as those do.
+var verbosityNames = []struct {
+	name string
+	verb Verbosity
+}{
+	{"silent", Silent},
+	{"error", Quiet},
+	{"critical", Quiet},
+	{"info", Normal},
+	{"warn", Normal},
+	{"debug", Verbose},
+	{"trace", SuperVerbose},
+	{"all", FullyVerbose},
+}
+
+// ParseVerbosity gives the Verbosity that allows the Level with the given
+// name and every Level above it. Because Verbosities only tell apart Levels
+// with different priorities, "warn" gives the same Verbosity as "info", and
+// "critical" the same as "error". The name can also be "silent" for Silent, or
+// "all" for FullyVerbose. Case is ignored.
+func ParseVerbosity(name string) (Verbosity, error) {
+	for _, vn := range verbosityNames {
+		if strings.EqualFold(name, vn.name) {
+			return vn.verb, nil
+		}
+	}
+	return Normal, fmt.Errorf("unknown level %q", name)
+}
+
+// String gives the name of the Verbosity as accepted by ParseVerbosity().
+func (ver Verbosity) String() string {
+	for _, vn := range verbosityNames {
+		if vn.verb == ver {
+			return vn.name
+		}
+	}
+	return fmt.Sprintf("verbosity(%d)", int(ver))
+}
+
+// SubsystemVerbosity holds Verbosities that are used in place of the
+// Verbosity of an OutputWriter. One can be given for all Subsystems, and one
+// for each individual Subsystem, which takes precedence. It is shared by every
+// copy of an OutputWriter that it is set on, so changes made to it apply to
+// all of them. It is safe to use from multiple goroutines.
+//
+// The zero-value is ready to use and holds no Verbosities.
+type SubsystemVerbosity struct {
+	mtx    sync.RWMutex
+	all    *Verbosity
+	bySubs map[Subsystem]Verbosity
+}
+
+// Get gives the Verbosity for the Subsystem, if there is one.
+func (sv *SubsystemVerbosity) Get(sub Subsystem) (Verbosity, bool) {
+	sv.mtx.RLock()
+	defer sv.mtx.RUnlock()
+
+	if v, ok := sv.bySubs[sub]; ok {
+		return v, true
+	}
+	if sv.all != nil {
+		return *sv.all, true
+	}
+	return 0, false
+}
+
+// Set applies a spec of Verbosities. The spec is a comma-separated list of
+// items in SUBSYSTEM=LEVEL form, where LEVEL is anything accepted by
+// ParseVerbosity(). An item can also be just LEVEL, which applies to all
+// Subsystems and replaces any that were given for individual ones. LEVEL can
+// also be "default" to remove the Verbosity instead. If any item is invalid,
+// none are applied.
+//
+// For example, "info,driver=trace,macros=debug" shows trace output from the
+// driver, debug output from macros, and only info output from the rest.
+func (sv *SubsystemVerbosity) Set(spec string) error {
+	type item struct {
+		sub    Subsystem
+		anySub bool
+		verb   *Verbosity
+	}
+	var items []item
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return fmt.Errorf("empty item in %q", spec)
+		}
+		it := item{anySub: true}
+		levelName := part
+		if eq := strings.Index(part, "="); eq >= 0 {
+			it.sub = Subsystem(strings.ToLower(strings.TrimSpace(part[:eq])))
+			it.anySub = false
+			levelName = strings.TrimSpace(part[eq+1:])
+			if !isSubsystem(it.sub) {
+				return fmt.Errorf("unknown subsystem %q; must be one of %s", it.sub, subsystemList())
+			}
+		}
+		if !strings.EqualFold(levelName, "default") {
+			v, err := ParseVerbosity(levelName)
+			if err != nil {
+				return err
+			}
+			it.verb = &v
+		}
+		items = append(items, it)
+	}
+
+	sv.mtx.Lock()
+	defer sv.mtx.Unlock()
+	for _, it := range items {
+		if it.anySub {
+			sv.all = it.verb
+			sv.bySubs = nil
+			continue
+		}
+		if it.verb == nil {
+			delete(sv.bySubs, it.sub)
+			continue
+		}
+		if sv.bySubs == nil {
+			sv.bySubs = map[Subsystem]Verbosity{}
+		}
+		sv.bySubs[it.sub] = *it.verb
+	}
+	return nil
+}
+
+func isSubsystem(sub Subsystem) bool {
+	for _, s := range Subsystems {
+		if s == sub {
+			return true
+		}
+	}
+	return false
+}
+
+func subsystemList() string {
+	var names []string
+	for _, s := range Subsystems {
+		names = append(names, string(s))
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,141 @@
+package verbosity
+
+import (
+	"testing"
+)
+
+func Test_SubsystemVerbosity_Set(t *testing.T) {
+	testCases := []struct {
+		name      string
+		specs     []string
+		expect    map[Subsystem]Verbosity // subsystems not given are expected to not be set
+		expectErr bool
+	}{
+		{
+			name:   "single subsystem",
+			specs:  []string{"driver=trace"},
+			expect: map[Subsystem]Verbosity{SubsystemDriver: SuperVerbose},
+		},
+		{
+			name:   "multiple subsystems",
+			specs:  []string{"driver=trace, Macros=DEBUG"},
+			expect: map[Subsystem]Verbosity{SubsystemDriver: SuperVerbose, SubsystemMacros: Verbose},
+		},
+		{
+			name:  "all subsystems with one overridden",
+			specs: []string{"error,persist=info"},
+			expect: map[Subsystem]Verbosity{
+				"":               Quiet,
+				SubsystemConsole: Quiet,
+				SubsystemDriver:  Quiet,
+				SubsystemMacros:  Quiet,
+				SubsystemPersist: Normal,
+			},
+		},
+		{
+			name:  "all subsystems replaces earlier ones",
+			specs: []string{"driver=trace", "silent"},
+			expect: map[Subsystem]Verbosity{
+				"":               Silent,
+				SubsystemConsole: Silent,
+				SubsystemDriver:  Silent,
+				SubsystemMacros:  Silent,
+				SubsystemPersist: Silent,
+			},
+		},
+		{
+			name:   "default removes",
+			specs:  []string{"driver=trace,macros=debug", "driver=default"},
+			expect: map[Subsystem]Verbosity{SubsystemMacros: Verbose},
+		},
+		{
+			name:   "warn and critical give the same levels as info and error",
+			specs:  []string{"driver=warn,macros=critical"},
+			expect: map[Subsystem]Verbosity{SubsystemDriver: Normal, SubsystemMacros: Quiet},
+		},
+		{
+			name:      "unknown subsystem",
+			specs:     []string{"drivers=trace"},
+			expectErr: true,
+		},
+		{
+			name:      "unknown level",
+			specs:     []string{"driver=loud"},
+			expectErr: true,
+		},
+		{
+			name:      "empty item",
+			specs:     []string{"driver=trace,"},
+			expectErr: true,
+		},
+		{
+			name:      "invalid item applies nothing",
+			specs:     []string{"macros=debug,driver=loud"},
+			expect:    map[Subsystem]Verbosity{},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sut := &SubsystemVerbosity{}
+
+			var err error
+			for _, spec := range tc.specs {
+				if err = sut.Set(spec); err != nil {
+					break
+				}
+			}
+
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected error but got none")
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.expect == nil {
+				return
+			}
+			for _, sub := range append([]Subsystem{""}, Subsystems...) {
+				expected, expectSet := tc.expect[sub]
+				actual, set := sut.Get(sub)
+				if set != expectSet {
+					t.Errorf("expected %q being set to be %v but got: %v", sub, expectSet, set)
+				} else if set && actual != expected {
+					t.Errorf("expected %q to be %v but got: %v", sub, expected, actual)
+				}
+			}
+		})
+	}
+}
+
+func Test_OutputWriter_Allows(t *testing.T) {
+	sv := &SubsystemVerbosity{}
+	if err := sv.Set("driver=trace,macros=silent"); err != nil {
+		t.Fatalf("prep step: couldn't set verbosity: %v", err)
+	}
+	out := OutputWriter{Verbosity: Normal, SubsystemVerbosity: sv}
+
+	testCases := []struct {
+		name     string
+		sub      Subsystem
+		lv       Level
+		expected bool
+	}{
+		{name: "set subsystem allows", sub: SubsystemDriver, lv: Trace, expected: true},
+		{name: "silent subsystem", sub: SubsystemMacros, lv: Critical, expected: false},
+		{name: "unset subsystem uses verbosity", sub: SubsystemConsole, lv: Debug, expected: false},
+		{name: "no subsystem uses verbosity", sub: "", lv: Info, expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := out.For(tc.sub).Allows(tc.lv)
+
+			if actual != tc.expected {
+				t.Errorf("expected %v but got: %v", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -112,30 +112,53 @@ to define output policy for an application and pass it between functions:
 	// this will not be printed:
 	out.Debug("this will not be printed")
 
-Logging can also be enabled on an OutputWriter, and if it is, all messages will
-be logged, even those that are suppressed by the verbosity. To enable logging,
-use the StartLogging() function. Logging can later be disabled with a call to
+Logging can also be enabled on an OutputWriter by giving it a Logger with the
+StartLogging() function, and if it is, messages will be logged even if they are
+suppressed by the verbosity. Which messages are logged is instead decided by
+the minimum Level of the Logger. Logging can later be disabled with a call to
 StopLogging().
 
-	logFile, err := os.Create("logfile.log")
+	logFile, err := OpenRotatingFile("logfile.log", RotationOptions{MaxSize: 1 << 20, Keep: 5})
 	if err != nil {
 		panic("could not open log file for writing")
 	}
-	defer logFile.Close()
+	logger := NewLogger(logFile, Debug, LogText)
+	defer logger.Close()
 
 	var out OutputWriter
 	out.Verbosity = Normal
-	out.StartLogging(logFile)
+	out.StartLogging(logger)
 
 	out.Info("this will be printed, and logged to logfile.log")
 	out.Warn("this will also be printed and logged to logfile.log")
 
 	out.Debug("this will not be printed, but it is still logged to logfile.log")
+	out.Trace("this will not be printed or logged")
 
 	out.StopLogging()
 
 	out.Debug("this will not be printed or logged")
 
+Each log entry has the time it was made and the Subsystem of the OutputWriter
+that made it. To tag messages from one part of a program, use For() to get an
+OutputWriter for its Subsystem:
+
+	driverOut := out.For(SubsystemDriver)
+
+	driverOut.Warn("this is logged with a subsystem of driver")
+
+Each Subsystem can also be given its own Verbosity by setting a
+SubsystemVerbosity on the OutputWriter. It is shared by every copy of the
+OutputWriter, so it can be changed while a program runs:
+
+	var out OutputWriter
+	out.Verbosity = Normal
+	out.SubsystemVerbosity = &SubsystemVerbosity{}
+	out.SubsystemVerbosity.Set("driver=trace")
+
+	out.For(SubsystemDriver).Trace("this will be printed")
+	out.For(SubsystemMacros).Trace("this will not be printed")
+
 It is possible to combine the logging behavior with the Silent Verbosity to make
 calls to an OutputWriter behave like calls to log.Printf():
 
@@ -143,7 +166,7 @@ calls to an OutputWriter behave like calls to log.Printf():
 
 	// setting to Silent disables all typical output:
 	out.Verbosity = Silent
-	out.StartLogging(os.Stderr)
+	out.StartLogging(NewLogger(os.Stderr, Trace, LogText))
 
 	out.Info("this will be logged to stderr")
 	out.Warn("this will be logged to stderr")
@@ -154,8 +177,6 @@ package verbosity
 import (
 	"bytes"
 	"fmt"
-	"io"
-	"log"
 	"os"
 	"strings"
 	"text/template"
@@ -189,13 +210,6 @@ const DefaultStderrTemplateStr = "{{.Level.Name}}: {{.Message}}"
 
 var defaultStderrTemplate = template.Must(template.New("default-stderr").Parse(DefaultStderrTemplateStr))
 
-// DefaultLogTemplateStr defines the default template that will be used for
-// outputting messages to the log. It is used by OutputWriter if its
-// LogTemplate is set to a zero-value.
-const DefaultLogTemplateStr = "{{.Level.Name}}: {{.Message}}"
-
-var defaultLogTemplate = template.Must(template.New("default-log").Parse(DefaultLogTemplateStr))
-
 // DefaultStderrFilter is the default function for checking if a
 // message should be printed to stderr. It is used by VerboseAwareOutputWriter
 // if its StderrFilter is set to a zero-value.
@@ -400,15 +414,6 @@ type OutputWriter struct {
 	// This is ignored by Sprintf functions, which output only "" or the formatted message.
 	StdoutTemplate *template.Template
 
-	// LotTemplate is a template that says what the format is to be when writing
-	// a message to stdout. The template will be executed on instances of OutputMessage.
-	//
-	// If set to its zero-value, it will be assumed to the template defined by
-	// DefaultLogTemplateStr.
-	//
-	// This is not used unless logging has been enabled on this OutputWriter.
-	LogTemplate *template.Template
-
 	// AutoNewline specifies whether newlines should be added to the output if it doesn't
 	// already contain one.
 	//
@@ -422,19 +427,44 @@ type OutputWriter struct {
 	// This is ignored by Sprintf functions.
 	AutoCapitalize bool
 
+	// Redirect is given every message that the Verbosity allows in place of it
+	// being written to stdout or stderr. The message is given as formatted
+	// by the caller; no template is executed on it and AutoNewline and
+	// AutoCapitalize are not applied to it.
+	//
+	// If set to its zero-value, messages are written to stdout and stderr.
+	Redirect func(lv Level, message string)
+
+	// Subsystem is the part of the program that messages sent to the
+	// OutputWriter come from. It is given in their log entries.
+	Subsystem Subsystem
+
+	// SubsystemVerbosity gives the Verbosity to use in place of Verbosity
+	// for the Subsystem of the OutputWriter, if it has one for it.
+	//
+	// If set to its zero-value, Verbosity is always used.
+	SubsystemVerbosity *SubsystemVerbosity
+
 	// note: someone could be asynchronously creating this, so when it is read
 	// in a pointer-receiver func, it should always be copied and the copy read.
-	logger *log.Logger
+	logger *Logger
 }
 
-// StartLogging turns on logging to the given writer for messages sent to the OutputWriter.
-// When logging is enabled, all messages are logged regardless of whether they are suppressed
-// by the verbosity.
+// StartLogging turns on logging to the given Logger for messages sent to the OutputWriter.
+// When logging is enabled, messages are logged regardless of whether they are suppressed
+// by the verbosity; the Logger decides which levels are logged.
 //
 // If logging has already started via a previous call to StartLogging(), the old logging
 // is replaced by the new one.
-func (ow *OutputWriter) StartLogging(writer io.Writer) {
-	ow.logger = log.New(writer, "", log.LstdFlags)
+func (ow *OutputWriter) StartLogging(logger *Logger) {
+	ow.logger = logger
+}
+
+// For returns a copy of the OutputWriter whose messages are from the given
+// Subsystem.
+func (ow OutputWriter) For(sub Subsystem) OutputWriter {
+	ow.Subsystem = sub
+	return ow
 }
 
 // StopLogging stops all logging activity.
@@ -442,6 +472,25 @@ func (ow *OutputWriter) StopLogging() {
 	ow.logger = nil
 }
 
+// EffectiveVerbosity returns the Verbosity that the OutputWriter uses. This
+// is the one for its Subsystem if there is one, and its Verbosity otherwise.
+func (ow OutputWriter) EffectiveVerbosity() Verbosity {
+	if ow.SubsystemVerbosity != nil {
+		if v, ok := ow.SubsystemVerbosity.Get(ow.Subsystem); ok {
+			return v
+		}
+	}
+	return ow.Verbosity
+}
+
+// Allows returns whether the OutputWriter would output a message at the given
+// Level.
+//
+// This is equivalent to a call to EffectiveVerbosity().Allows(lv).
+func (ow OutputWriter) Allows(lv Level) bool {
+	return ow.EffectiveVerbosity().Allows(lv)
+}
+
 // Log writes a message to the log if logging is enabled. Typical output
 // functionality is skipped; if logging is not enabled, calling this function
 // will result in no output at all.
@@ -449,12 +498,7 @@ func (ow OutputWriter) Log(lv Level, format string, a ...interface{}) {
 	if ow.logger == nil {
 		return
 	}
-	t := ow.LogTemplate
-	if t == nil {
-		t = defaultLogTemplate
-	}
-	loggedMessage := ow.formatForOutput(t, lv, format, a...)
-	ow.logger.Print(loggedMessage)
+	ow.logger.Log(ow.Subsystem, lv, fmt.Sprintf(format, a...))
 }
 
 // Output outputs a message if the verbosity for the OutputWriter allows the
@@ -462,7 +506,12 @@ func (ow OutputWriter) Log(lv Level, format string, a ...interface{}) {
 // logged.
 func (ow OutputWriter) Output(lv Level, format string, a ...interface{}) {
 	ow.Log(lv, format, a...)
-	if ow.Verbosity.Allows(lv) {
+	if ow.Allows(lv) {
+		if ow.Redirect != nil {
+			ow.Redirect(lv, fmt.Sprintf(format, a...))
+			return
+		}
+
 		// find out if we are going to stderr or not:
 		stderrFunc := ow.StderrFilter
 		if stderrFunc == nil {
@@ -538,7 +587,7 @@ func (ow OutputWriter) Trace(format string, a ...interface{}) {
 //
 // Calling this function does not cause logging to occur.
 func (ow OutputWriter) Sprintf(lv Level, format string, a ...interface{}) string {
-	if ow.Verbosity.Allows(lv) {
+	if ow.Allows(lv) {
 		return fmt.Sprintf(format, a...)
 	}
 	return ""